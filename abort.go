@@ -0,0 +1,55 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// errClientAborted 表示上传过程中客户端断开了连接
+var errClientAborted = errors.New("client disconnected during upload")
+
+// abortReader 包装上传的请求体：请求的 context 被取消（客户端断开）后立即停止读取，
+// 并记录请求体是否被中途截断，供调用方删除不完整的文件
+type abortReader struct {
+	ctx context.Context
+	r   io.ReadCloser
+	err error
+}
+
+// watchAbort 用 abortReader 替换请求体并返回它
+func watchAbort(r *http.Request) *abortReader {
+	a := &abortReader{ctx: r.Context(), r: r.Body}
+	r.Body = a
+	return a
+}
+
+func (a *abortReader) Read(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.ctx.Err() != nil {
+		a.err = errClientAborted
+		return 0, a.err
+	}
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		// 连接断开时请求体读取返回 unexpected EOF 等错误，统一视为客户端中止；MaxBytesError 等保持原样
+		var tooLarge *http.MaxBytesError
+		if !errors.As(err, &tooLarge) {
+			err = errClientAborted
+		}
+		a.err = err
+	}
+	return n, err
+}
+
+func (a *abortReader) Close() error {
+	return a.r.Close()
+}
+
+// aborted 判断请求体是否因客户端断开而未读完
+func (a *abortReader) aborted() bool {
+	return a.err == errClientAborted
+}
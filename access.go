@@ -0,0 +1,180 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// accessRule 是一条 -access 规则：pattern 为虚拟路径的 glob，匹配路径本身或其上级目录时适用。
+// public 允许未登录读取（列表和下载），upload 允许未登录上传，auth 要求登录（认证模式下的默认行为，用于在公开目录中划出私有部分），
+// hidden 使条目不出现在非管理员的列表中，但仍可按路径访问
+type accessRule struct {
+	pattern string
+	public  bool
+	upload  bool
+	auth    bool
+	hidden  bool
+}
+
+// accessRulesFlag 是可重复的 -access pattern=perm[,perm] 参数
+type accessRulesFlag []accessRule
+
+func (a *accessRulesFlag) String() string {
+	var parts []string
+	for _, rule := range *a {
+		parts = append(parts, rule.pattern)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (a *accessRulesFlag) Set(v string) error {
+	pattern, perms, ok := strings.Cut(v, "=")
+	pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+	if !ok || pattern == "" {
+		return fmt.Errorf("invalid access rule %q, expected pattern=perm[,perm] such as pub=public", v)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	rule := accessRule{pattern: pattern}
+	for _, p := range strings.Split(perms, ",") {
+		switch strings.TrimSpace(p) {
+		case "public":
+			rule.public = true
+		case "upload":
+			rule.upload = true
+		case "auth":
+			rule.auth = true
+		case "hidden":
+			rule.hidden = true
+		default:
+			return fmt.Errorf("unknown permission %q in access rule %q (expected public, upload, auth or hidden)", p, v)
+		}
+	}
+	if rule.auth && (rule.public || rule.upload) {
+		return fmt.Errorf("access rule %q: auth cannot be combined with public or upload", v)
+	}
+	*a = append(*a, rule)
+	return nil
+}
+
+// accessRules 由 -access 设置
+var accessRules accessRulesFlag
+
+// setupAccessRules 检查规则与认证配置是否一致：未启用认证时所有人都能访问，auth 规则无法生效
+func setupAccessRules() error {
+	for _, rule := range accessRules {
+		if rule.auth && !authEnabled() {
+			return fmt.Errorf("-access %s=auth requires users (-user)", rule.pattern)
+		}
+	}
+	return nil
+}
+
+// accessFor 返回适用于虚拟路径 p 的规则：从 p 开始逐级向上，取第一个有规则匹配的层级中最先给出的规则，
+// 因此 pub/private=auth 优先于 pub=public
+func accessFor(p string) (accessRule, bool) {
+	for p = cleanName(p); p != "" && p != "."; p = path.Dir(p) {
+		for _, rule := range accessRules {
+			if ok, _ := path.Match(rule.pattern, p); ok {
+				return rule, true
+			}
+		}
+	}
+	return accessRule{}, false
+}
+
+// publicReadable 判断未登录的请求能否读取虚拟路径 p
+func publicReadable(p string) bool {
+	rule, ok := accessFor(p)
+	return ok && rule.public
+}
+
+// publicWritable 判断未登录的请求能否上传到虚拟目录 p
+func publicWritable(p string) bool {
+	rule, ok := accessFor(p)
+	return ok && rule.upload
+}
+
+// isAnonymous 判断请求是否为按 -access 规则放行的未登录请求
+func isAnonymous(r *http.Request) bool {
+	anon, _ := r.Context().Value(anonymousContextKey).(bool)
+	return anon
+}
+
+// routes 是服务器的路由表，用于判断请求是否落到列表页（静态网站）上
+var routes *http.ServeMux
+
+// anonymousAllowed 判断未携带凭据的请求能否按 -access 规则放行：GET 列表、下载和只读查看（/raw/、/view、/compare、静态网站）公开路径，
+// 向允许上传的目录 POST /upload?dir= 或 PUT /put/
+func anonymousAllowed(r *http.Request) bool {
+	if len(accessRules) == 0 {
+		return false
+	}
+	q := r.URL.Query()
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case read && (r.URL.Path == "/" || r.URL.Path == "/api/v1/list"):
+		return publicReadable(q.Get("dir"))
+	case read && (r.URL.Path == "/download" || r.URL.Path == "/view"):
+		return publicReadable(q.Get("path"))
+	case read && strings.HasPrefix(r.URL.Path, "/raw/"):
+		return publicReadable(strings.TrimPrefix(r.URL.Path, "/raw/"))
+	case read && r.URL.Path == "/compare":
+		return publicReadable(q.Get("a")) && (q.Get("b") == "" || publicReadable(q.Get("b")))
+	case read && staticMode && routes != nil:
+		// 其余路径只有落到列表页时才是静态网站中的文件
+		if _, pattern := routes.Handler(r); pattern == "/" {
+			return publicReadable(cleanName(r.URL.Path))
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/upload":
+		return publicWritable(q.Get("dir"))
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/put/"):
+		return publicWritable(path.Dir(cleanName(strings.TrimPrefix(r.URL.Path, "/put/"))))
+	}
+	return false
+}
+
+// withAnonymous 标记按 -access 规则放行的未登录请求
+func withAnonymous(ctx context.Context) context.Context {
+	return context.WithValue(ctx, anonymousContextKey, true)
+}
+
+// listedFor 判断虚拟路径 p 是否出现在请求的列表中：hidden 的条目只对管理员列出，未登录的请求只看到公开的条目
+func listedFor(r *http.Request, p string) bool {
+	return contextListed(r.Context(), p)
+}
+
+// contextListed 与 listedFor 相同，供 WebDAV、FTP 等只有上下文的协议使用
+func contextListed(ctx context.Context, p string) bool {
+	if len(accessRules) == 0 {
+		return true
+	}
+	rule, ok := accessFor(p)
+	if anon, _ := ctx.Value(anonymousContextKey).(bool); anon {
+		return ok && rule.public && !rule.hidden
+	}
+	return !rule.hidden || contextIsAdmin(ctx)
+}
+
+// errPrivateEntry 用于在遍历时提前结束
+var errPrivateEntry = errors.New("private entry")
+
+// publicTree 判断卷内路径 name 及其下的所有条目是否都公开，未登录时只能打包这样的目录
+func publicTree(vol volume, name string) bool {
+	err := vol.store.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !publicReadable(vol.virtual(p)) {
+			return errPrivateEntry
+		}
+		return nil
+	})
+	return err == nil
+}
@@ -0,0 +1,158 @@
+package fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ageRecipient 是 age 的 X25519 公钥（age1...），下载内容按 age v1 格式加密给它，
+// 接收者用 age -d -i key.txt 解密
+type ageRecipient [curve25519.PointSize]byte
+
+var errAgeRecipient = errors.New("invalid age recipient (expected age1...)")
+
+// parseAgeRecipient 解析 bech32 编码的 age 公钥
+func parseAgeRecipient(s string) (*ageRecipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil || hrp != "age" || len(data) != curve25519.PointSize {
+		return nil, errAgeRecipient
+	}
+	var r ageRecipient
+	copy(r[:], data)
+	return &r, nil
+}
+
+func (a *ageRecipient) ext() string { return ".age" }
+
+// encrypt 写入 age 文件头（一个 X25519 接收者）和 payload nonce，返回加密 payload 的写入器。
+// payload 的分块方式与静态加密相同：64 KiB 一块，nonce 为块序号和最后一块标记
+func (a *ageRecipient) encrypt(w io.Writer) (io.WriteCloser, error) {
+	fileKey := make([]byte, 16)
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	nonce := make([]byte, 16)
+	for _, b := range [][]byte{fileKey, ephemeral, nonce} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, a[:])
+	if err != nil {
+		return nil, errAgeRecipient
+	}
+	wrap, err := chacha20poly1305.New(hkdfKey(shared, append(share, a[:]...), "age-encryption.org/v1/X25519"))
+	if err != nil {
+		return nil, err
+	}
+	b64 := base64.RawStdEncoding
+	var header strings.Builder
+	header.WriteString("age-encryption.org/v1\n")
+	header.WriteString("-> X25519 " + b64.EncodeToString(share) + "\n")
+	header.WriteString(b64.EncodeToString(wrap.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)) + "\n")
+	header.WriteString("---")
+	mac := hmac.New(sha256.New, hkdfKey(fileKey, nil, "header"))
+	mac.Write([]byte(header.String()))
+	header.WriteString(" " + b64.EncodeToString(mac.Sum(nil)) + "\n")
+
+	if _, err := io.WriteString(w, header.String()); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+	payload, err := chacha20poly1305.New(hkdfKey(fileKey, nonce, "payload"))
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: nopWriteCloser{w}, aead: payload}, nil
+}
+
+// hkdfKey 以 HKDF-SHA256 派生 32 字节密钥
+func hkdfKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key)
+	return key
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode 解码 bech32 字符串，返回人类可读部分和 8 位数据
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid character")
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	var out []byte
+	acc, bits := 0, 0
+	for _, v := range values[:len(values)-6] {
+		acc = (acc<<5 | int(v)) & 0xfff
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return "", nil, errors.New("invalid padding")
+	}
+	return hrp, out, nil
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if top>>i&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
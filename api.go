@@ -0,0 +1,323 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// listEntry 是 JSON API 中的单个文件或目录条目
+type listEntry struct {
+	Name     string    `json:"name"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	MimeType string    `json:"mime_type,omitempty"`
+	Mount    bool      `json:"mount,omitempty"`
+	ReadOnly bool      `json:"read_only,omitempty"`
+
+	DownloadCount int64      `json:"download_count"`
+	LastDownload  *time.Time `json:"last_download,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Lock          *fileLock  `json:"lock,omitempty"`
+}
+
+// apiListHandler 以 JSON 格式返回目录内容
+func apiListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	dir := strings.Trim(q.Get("dir"), "/")
+	vol, err := resolveVolume(r, dir)
+	if err == errNotFound {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	if q.Get("recursive") == "true" {
+		filter, err := parseListFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		items := listRecursive(vol, dir == "", filter)
+		if len(accessRules) > 0 {
+			// 名称相对于 dir；列出根目录时挂载点中的条目以别名开头，与虚拟路径一致
+			listed := items[:0]
+			for _, item := range items {
+				if listedFor(r, vol.prefix+item.Name) {
+					listed = append(listed, item)
+				}
+			}
+			items = listed
+		}
+		applyListingCacheControl(w)
+		writeJSON(w, http.StatusOK, items)
+		return
+	}
+	entries, err := vol.store.List("")
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]listEntry, 0, len(entries))
+	if dir == "" {
+		for _, mt := range mounts {
+			if !listedFor(r, mt.alias) {
+				continue
+			}
+			item := listEntry{Name: mt.alias, IsDir: true, Mount: true, ReadOnly: mt.readOnly}
+			if info, err := mt.store.Stat(""); err == nil {
+				item.ModTime = info.ModTime().UTC()
+			}
+			items = append(items, item)
+		}
+	}
+	for _, info := range entries {
+		if info.Name() == metaDirName {
+			continue
+		}
+		if _, shadowed := findMount(info.Name()); shadowed && dir == "" {
+			continue
+		}
+		if !listedFor(r, vol.virtual(info.Name())) {
+			continue
+		}
+		item := listEntry{
+			Name:    info.Name(),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+
+			ReadOnly: vol.readOnly,
+		}
+		if dl := stats.get(vol.virtual(info.Name())); dl.Count > 0 {
+			item.DownloadCount = dl.Count
+			item.LastDownload = &dl.LastDownload
+		}
+		if !info.IsDir() {
+			item.MimeType = detectContentType(vol.store, info.Name())
+		}
+		item.Tags = tags.get(vol.virtual(info.Name()))
+		item.Lock = locks.get(vol.virtual(info.Name()))
+		items = append(items, item)
+	}
+
+	applyListingCacheControl(w)
+	writeJSON(w, http.StatusOK, items)
+}
+
+// listFilter 是递归列表的筛选条件，零值表示不限制
+type listFilter struct {
+	glob    string
+	minSize int64
+	maxSize int64
+	after   time.Time
+	before  time.Time
+	tags    []string
+}
+
+// parseListFilter 解析 glob、min-size、max-size、modified-after、modified-before 和 tag 参数
+func parseListFilter(q url.Values) (listFilter, error) {
+	f := listFilter{glob: q.Get("glob")}
+	for _, v := range q["tag"] {
+		for _, s := range splitTags(v) {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			tag, err := normalizeTag(s)
+			if err != nil {
+				return f, err
+			}
+			f.tags = append(f.tags, tag)
+		}
+	}
+	if f.glob != "" {
+		if _, err := path.Match(f.glob, ""); err != nil {
+			return f, fmt.Errorf("invalid glob %q", f.glob)
+		}
+	}
+	for _, p := range []struct {
+		key string
+		dst *int64
+	}{{"min-size", &f.minSize}, {"max-size", &f.maxSize}} {
+		if v := q.Get(p.key); v != "" {
+			var size byteSize
+			if err := size.Set(v); err != nil {
+				return f, fmt.Errorf("invalid %s %q", p.key, v)
+			}
+			*p.dst = int64(size)
+		}
+	}
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"modified-after", &f.after}, {"modified-before", &f.before}} {
+		if v := q.Get(p.key); v != "" {
+			t, err := parseTimeFilter(v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s %q (use RFC 3339, YYYY-MM-DD or a duration such as 168h)", p.key, v)
+			}
+			*p.dst = t
+		}
+	}
+	return f, nil
+}
+
+// parseTimeFilter 解析 RFC 3339 时间、日期或相对于现在的时长（如 168h 表示一周前）
+func parseTimeFilter(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q", v)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// match 判断文件是否满足筛选条件；glob 不含 "/" 时匹配文件名，否则匹配相对路径
+func (f listFilter) match(rel string, info fs.FileInfo) bool {
+	if f.glob != "" {
+		target := path.Base(rel)
+		if strings.Contains(f.glob, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(f.glob, target); !ok {
+			return false
+		}
+	}
+	if info.Size() < f.minSize || (f.maxSize > 0 && info.Size() > f.maxSize) {
+		return false
+	}
+	if !f.after.IsZero() && !info.ModTime().After(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && !info.ModTime().Before(f.before) {
+		return false
+	}
+	return true
+}
+
+// listRecursive 返回卷内所有满足条件的文件（不含目录），名称为相对于所列目录的路径；
+// root 为 true 时同时列出各挂载点中的文件
+func listRecursive(vol volume, root bool, filter listFilter) []listEntry {
+	items := []listEntry{}
+	walk := func(vol volume, prefix string, root bool) {
+		vol.store.Walk("", func(name string, info fs.FileInfo, err error) error {
+			if err != nil || name == "" {
+				return nil
+			}
+			if name == metaDirName && info.IsDir() {
+				return fs.SkipDir
+			}
+			if _, shadowed := findMount(name); shadowed && root {
+				if info.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || !filter.match(prefix+name, info) {
+				return nil
+			}
+			fileTags := tags.get(vol.virtual(name))
+			if !hasAllTags(fileTags, filter.tags) {
+				return nil
+			}
+			item := listEntry{
+				Name:    prefix + name,
+				Size:    info.Size(),
+				ModTime: info.ModTime().UTC(),
+
+				MimeType: detectContentType(vol.store, name),
+				ReadOnly: vol.readOnly,
+			}
+			if dl := stats.get(vol.virtual(name)); dl.Count > 0 {
+				item.DownloadCount = dl.Count
+				item.LastDownload = &dl.LastDownload
+			}
+			item.Tags = fileTags
+			items = append(items, item)
+			return nil
+		})
+	}
+	walk(vol, "", root)
+	if root {
+		for _, mt := range mounts {
+			walk(mt.volume(), mt.alias+"/", false)
+		}
+	}
+	return items
+}
+
+// apiDeleteHandler 删除 path 指定的文件或目录，路径规则与下载相同
+func apiDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	if vol.readOnly || name == "" {
+		http.Error(w, "Directory is read-only", http.StatusForbidden)
+		return
+	}
+	info, err := vol.store.Stat(name)
+	if os.IsNotExist(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read path", http.StatusInternalServerError)
+		return
+	}
+	if !checkPreconditions(w, r, vol, name) {
+		return
+	}
+	if err := vol.store.Delete(name); err != nil {
+		http.Error(w, "Failed to delete", http.StatusInternalServerError)
+		return
+	}
+
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(name), User: currentUser(r)}
+	if !info.IsDir() {
+		ev.Size = info.Size()
+	}
+	logger.Printf("Deleted: %s", ev.Path)
+	emitEvent(ev)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON 以指定状态码写出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
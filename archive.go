@@ -0,0 +1,286 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// zipCompression 是目录下载 ZIP 的压缩方式：
+// default 为 Deflate 默认级别，1-9 为指定的 Deflate 级别，store 为不压缩，
+// auto 对已压缩的媒体和归档文件不压缩、其余文件使用默认级别
+type zipCompression string
+
+// defaultZipCompression 由 -zip-compression 设置，可被请求参数 compression 覆盖
+var defaultZipCompression zipCompression = "default"
+
+func (c *zipCompression) String() string { return string(*c) }
+
+func (c *zipCompression) Set(v string) error {
+	parsed, err := parseZipCompression(v)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+func parseZipCompression(v string) (zipCompression, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch v {
+	case "default", "store", "auto":
+		return zipCompression(v), nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= flate.BestSpeed && n <= flate.BestCompression {
+		return zipCompression(v), nil
+	}
+	return "", fmt.Errorf("invalid ZIP compression %q (use default, store, auto or 1-9)", v)
+}
+
+// level 返回 Deflate 压缩级别
+func (c zipCompression) level() int {
+	if level, err := strconv.Atoi(string(c)); err == nil {
+		return level
+	}
+	return flate.DefaultCompression
+}
+
+// zipWriter 是按压缩级别配置的 ZIP 写入器；password 非空时文件内容以 WinZip AES-256 加密
+type zipWriter struct {
+	*zip.Writer
+	level    int
+	password string
+}
+
+// newZipWriter 创建按压缩级别配置的 ZIP 写入器，password 为空时不加密
+func (c zipCompression) newZipWriter(w io.Writer, password string) *zipWriter {
+	zw := zip.NewWriter(w)
+	level := c.level()
+	if level != flate.DefaultCompression {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return &zipWriter{Writer: zw, level: level, password: password}
+}
+
+// method 返回文件在 ZIP 中使用的压缩方法
+func (c zipCompression) method(name string) uint16 {
+	switch c {
+	case "store":
+		return zip.Store
+	case "auto":
+		if compressedExts[strings.ToLower(path.Ext(name))] {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// compressedExts 是内容已经压缩、再次 Deflate 几乎不会变小的文件扩展名
+var compressedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true, ".jar": true, ".apk": true,
+}
+
+// zipWorkers 是目录打包时并行压缩文件的协程数，为 1 时按顺序逐个压缩
+var zipWorkers = runtime.NumCPU()
+
+// parallelZipMaxSize 是交给压缩协程在内存中压缩的最大文件大小，更大的文件在写入时流式压缩
+const parallelZipMaxSize = 4 << 20
+
+// zipEntry 是等待写入 ZIP 的一个条目；data 非空时为已由压缩协程压缩好的内容
+type zipEntry struct {
+	header *zip.FileHeader
+	name   string
+	done   chan struct{}
+	data   []byte
+	err    error
+}
+
+var errZipStopped = errors.New("zip stopped")
+
+// zipDir 将存储中的目录打包到 ZIP 写入器，comp 决定每个文件的压缩方法
+// 小文件由多个协程并行压缩，写入协程按遍历顺序依次写入，输出与顺序压缩相同
+func zipDir(zw *zipWriter, store storage, root string, base string, comp zipCompression) error {
+	root = cleanName(root)
+	workers := max(zipWorkers, 1)
+	entries := make(chan *zipEntry, 2*workers)
+	jobs := make(chan *zipEntry)
+	stop := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for e := range jobs {
+				e.data, e.err = compressEntry(store, e, comp.level())
+				close(e.done)
+			}
+		}()
+	}
+
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		defer close(jobs)
+		walkErr <- store.Walk(root, func(name string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if name == metaDirName && info.IsDir() {
+				return fs.SkipDir // 服务器元数据不打包
+			}
+			relPath := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+			if base != "" {
+				relPath = path.Join(base, relPath)
+			}
+			if relPath == "" {
+				return nil // 根目录本身不需要条目
+			}
+
+			// 使用文件信息生成条目头，保留修改时间和权限
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = relPath
+			if info.IsDir() {
+				header.Name += "/"
+				header.Method = zip.Store
+			} else {
+				header.Method = comp.method(name)
+			}
+			e := &zipEntry{header: header, name: name, done: make(chan struct{})}
+			parallel := workers > 1 && !info.IsDir() && header.Method == zip.Deflate && info.Size() <= parallelZipMaxSize
+			if !parallel {
+				close(e.done)
+			}
+			select {
+			case entries <- e:
+			case <-stop:
+				return errZipStopped
+			}
+			if parallel {
+				select {
+				case jobs <- e:
+				case <-stop:
+					return errZipStopped
+				}
+			}
+			return nil
+		})
+	}()
+
+	var err error
+	for e := range entries {
+		if err != nil {
+			continue
+		}
+		<-e.done
+		if err = writeZipEntry(zw, store, e); err != nil {
+			close(stop)
+		}
+	}
+	if werr := <-walkErr; err == nil && werr != errZipStopped {
+		err = werr
+	}
+	return err
+}
+
+// compressEntry 在内存中压缩文件，并填写条目头中的 CRC32 和大小
+func compressEntry(store storage, e *zipEntry, level int) ([]byte, error) {
+	f, err := store.Open(e.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(fw, crc), f)
+	if err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	e.header.CRC32 = crc.Sum32()
+	e.header.UncompressedSize64 = uint64(n)
+	e.header.CompressedSize64 = uint64(buf.Len())
+	return buf.Bytes(), nil
+}
+
+// writeZipEntry 写入一个条目：已压缩的内容原样写入，其余文件在此流式压缩
+func writeZipEntry(zw *zipWriter, store storage, e *zipEntry) error {
+	if e.err != nil {
+		return e.err
+	}
+	if zw.password != "" && !strings.HasSuffix(e.header.Name, "/") {
+		return zw.writeEncrypted(store, e)
+	}
+	if e.data != nil {
+		setRawHeaderFields(e.header)
+		w, err := zw.CreateRaw(e.header)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(e.data); err != nil {
+			return err
+		}
+		return zw.Flush()
+	}
+
+	w, err := zw.CreateHeader(e.header)
+	if err != nil || strings.HasSuffix(e.header.Name, "/") {
+		return err
+	}
+	f, err := store.Open(e.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, 32*1024) // 32KB 缓冲
+	if _, err = io.CopyBuffer(w, f, buf); err != nil {
+		return err
+	}
+	// 每个文件结束后刷新缓冲，使大目录的下载持续向客户端输出
+	return zw.Flush()
+}
+
+// setRawHeaderFields 补上 CreateRaw 不会像 CreateHeader 那样自动设置的 UTF-8 标志和扩展时间戳
+func setRawHeaderFields(h *zip.FileHeader) {
+	if !isASCII(h.Name) && utf8.ValidString(h.Name) {
+		h.Flags |= 0x800
+	}
+	var ext [9]byte
+	binary.LittleEndian.PutUint16(ext[0:], 0x5455)
+	binary.LittleEndian.PutUint16(ext[2:], 5)
+	ext[4] = 1
+	binary.LittleEndian.PutUint32(ext[5:], uint32(h.Modified.Unix()))
+	h.Extra = append(h.Extra, ext[:]...)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// assetFS 是编译进程序的网页资源：favicon 和文件类型图标的 SVG sprite，不依赖外部 CDN，离线也能使用
+//
+//go:embed assets/favicon.ico assets/icons.svg
+var assetFS embed.FS
+
+// assetMaxAge 是静态资源的浏览器缓存时间（秒）
+const assetMaxAge = 7 * 24 * 3600
+
+// assetHandler 处理 /favicon.ico 和 /assets/，无需认证
+func assetHandler(w http.ResponseWriter, r *http.Request) {
+	name := "assets/favicon.ico"
+	if r.URL.Path != "/favicon.ico" {
+		name = "assets/" + strings.TrimPrefix(r.URL.Path, "/assets/")
+	}
+	data, err := assetFS.ReadFile(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", assetMaxAge))
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:8]))
+	if path.Ext(name) == ".svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// archiveExts 是以归档图标显示的扩展名
+var archiveExts = map[string]bool{
+	".zip": true, ".tar": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true,
+	".zst": true, ".7z": true, ".rar": true, ".up": true,
+}
+
+// codeExts 是以源代码图标显示的扩展名
+var codeExts = map[string]bool{
+	".go": true, ".c": true, ".h": true, ".cpp": true, ".rs": true, ".py": true, ".js": true,
+	".ts": true, ".java": true, ".sh": true, ".html": true, ".css": true, ".json": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".sql": true,
+}
+
+// fileIcon 根据扩展名和内容类型返回文件在 icons.svg 中的图标名
+func fileIcon(name, ctype string) string {
+	ext := strings.ToLower(path.Ext(name))
+	// 由 -mime-type 指定类型的扩展名按类型决定图标
+	_, overridden := mimeOverrides[ext]
+	switch {
+	case archiveExts[ext] && !overridden:
+		return "archive"
+	case codeExts[ext] && !overridden:
+		return "code"
+	case strings.HasPrefix(ctype, "image/"):
+		return "image"
+	case strings.HasPrefix(ctype, "audio/"):
+		return "audio"
+	case strings.HasPrefix(ctype, "video/"):
+		return "video"
+	case strings.HasPrefix(ctype, "application/pdf"):
+		return "pdf"
+	case strings.HasPrefix(ctype, "text/"):
+		return "text"
+	}
+	return "file"
+}
+
+// iconHTML 返回引用 icons.svg 中图标的内联 SVG
+func iconHTML(icon string) string {
+	return fmt.Sprintf(`<svg width="16" height="16" style="vertical-align:text-bottom"><use href="/assets/icons.svg#%s"/></svg> `, icon)
+}
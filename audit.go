@@ -0,0 +1,131 @@
+package fileserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditMaxAge 由 -audit-max-age 设置，是审计事件的保留时间，0 表示永久保留
+var auditMaxAge = 90 * 24 * time.Hour
+
+// auditEntry 是审计日志中的一条文件事件
+type auditEntry struct {
+	ID uint64 `json:"id"`
+	fileEvent
+}
+
+// recordAudit 将文件事件追加到元数据库的 audit bucket，键为递增序号
+func recordAudit(ev fileEvent) {
+	if metaDB == nil {
+		return
+	}
+	dbUpdate(bucketAudit, func(b *bolt.Bucket) error {
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		return b.Put(seqKey(id), data)
+	})
+}
+
+// pruneAudit 删除早于 cutoff 的审计事件，返回删除的条数
+func pruneAudit(cutoff time.Time) int {
+	removed := 0
+	if metaDB == nil {
+		return 0
+	}
+	dbUpdate(bucketAudit, func(b *bolt.Bucket) error {
+		// 事件按时间顺序追加，从最早的开始删除，遇到未过期的事件即停止
+		var stale [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var ev fileEvent
+			if err := json.Unmarshal(v, &ev); err == nil && ev.Time.After(cutoff) {
+				break
+			}
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(stale)
+		return nil
+	})
+	return removed
+}
+
+// apiAuditHandler 处理 GET /api/v1/audit，仅管理员可用：按时间倒序返回审计事件。
+// ?limit= 指定数量（默认 100，最多 1000），?before= 返回 ID 小于该值的事件用于翻页，
+// ?user= 和 ?path= 按用户和路径前缀筛选
+func apiAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	var before uint64
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+	user, prefix := q.Get("user"), cleanName(q.Get("path"))
+
+	entries := []auditEntry{}
+	err := metaDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(bucketAudit)).Cursor()
+		k, v := c.Last()
+		if before > 0 {
+			c.Seek(seqKey(before))
+			k, v = c.Prev()
+		}
+		for ; k != nil && len(entries) < limit; k, v = c.Prev() {
+			var e auditEntry
+			if err := json.Unmarshal(v, &e.fileEvent); err != nil {
+				continue
+			}
+			if user != "" && e.User != user {
+				continue
+			}
+			if prefix != "" && e.Path != prefix && !strings.HasPrefix(e.Path, prefix+"/") {
+				continue
+			}
+			e.ID = binary.BigEndian.Uint64(k)
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,162 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// account 是通过 -user 参数配置的用户
+type account struct {
+	password string
+	admin    bool
+}
+
+// accountsFlag 解析 -user name:password[:admin] 参数
+type accountsFlag map[string]account
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+func (a accountsFlag) String() string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (a accountsFlag) Set(v string) error {
+	parts := strings.SplitN(v, ":", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return fmt.Errorf("expected name:password[:admin], got %q", v)
+	}
+	if !validUsername.MatchString(parts[0]) {
+		return fmt.Errorf("invalid username %q", parts[0])
+	}
+	acc := account{password: parts[1]}
+	if len(parts) == 3 {
+		if parts[2] != "admin" {
+			return fmt.Errorf("unknown role %q for user %s", parts[2], parts[0])
+		}
+		acc.admin = true
+	}
+	a[parts[0]] = acc
+	return nil
+}
+
+// 认证配置：配置了用户即启用 HTTP Basic 认证
+var (
+	accounts  = accountsFlag{}
+	userHomes bool
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	// anonymousContextKey 标记按 -access 规则放行的未登录请求
+	anonymousContextKey
+	// requestIDContextKey 是请求 ID 的键
+	requestIDContextKey
+	// guestContextKey 标记通过访客上传链接进入的请求
+	guestContextKey
+)
+
+// identity 是放入请求上下文的已认证用户
+type identity struct {
+	name  string
+	admin bool
+}
+
+// authEnabled 判断是否处于认证模式
+func authEnabled() bool {
+	return len(accounts) > 0 || len(authenticators) > 0
+}
+
+// checkLogin 校验用户名和密码：先查 -user 账户，再依次尝试注册的认证器
+func checkLogin(name, password string) (admin bool, ok bool) {
+	if acc, known := accounts[name]; known {
+		return acc.admin, subtle.ConstantTimeCompare([]byte(password), []byte(acc.password)) == 1
+	}
+	// 用户名会用作主目录名，必须是安全的文件名
+	if !validUsername.MatchString(name) {
+		return false, false
+	}
+	for _, a := range authenticators {
+		if admin, err := a.Authenticate(name, password); err == nil {
+			return admin, true
+		}
+	}
+	return false, false
+}
+
+// authMiddleware 在认证模式下要求 HTTP Basic 认证，并将用户名放入请求上下文
+func authMiddleware(next http.Handler) http.Handler {
+	if !authEnabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) || isSignedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, password, ok := r.BasicAuth()
+		if !ok && anonymousAllowed(r) {
+			next.ServeHTTP(w, r.WithContext(withAnonymous(r.Context())))
+			return
+		}
+		admin, valid := checkLogin(name, password)
+		if !ok || !valid {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fileserver", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withUser(r.Context(), name, admin)))
+	})
+}
+
+// withUser 返回携带已认证用户的上下文
+func withUser(ctx context.Context, name string, admin bool) context.Context {
+	return context.WithValue(ctx, userContextKey, identity{name: name, admin: admin})
+}
+
+// currentUser 返回已认证的用户名，未启用认证时为空
+func currentUser(r *http.Request) string {
+	return contextUser(r.Context())
+}
+
+func contextUser(ctx context.Context) string {
+	id, _ := ctx.Value(userContextKey).(identity)
+	return id.name
+}
+
+// isAdmin 判断当前用户是否为管理员；未启用认证时所有人都视为管理员
+func isAdmin(r *http.Request) bool {
+	return contextIsAdmin(r.Context())
+}
+
+func contextIsAdmin(ctx context.Context) bool {
+	if !authEnabled() {
+		return true
+	}
+	id, _ := ctx.Value(userContextKey).(identity)
+	return id.admin
+}
+
+// rootVolume 返回请求可以访问的默认卷
+// 在多用户模式（-user-homes）下，普通用户被限制在 <root>/<user>/ 中，管理员可以看到所有用户目录；
+// 按 -access 规则放行的未登录请求看到整个目录树，只能访问规则公开的部分
+func rootVolume(ctx context.Context) (volume, error) {
+	if !userHomes || contextIsAdmin(ctx) || contextUser(ctx) == "" {
+		return volume{store: rootStorage, readOnly: replica != nil}, nil
+	}
+	user := contextUser(ctx)
+	if err := rootStorage.Mkdir(user); err != nil {
+		logger.Printf("Error creating home directory for %s: %v", user, err)
+		return volume{}, err
+	}
+	return volume{store: encryptStorage(casStorageFor(newSubStorage(baseStorage(rootStorage), user))), prefix: user + "/", readOnly: replica != nil}, nil
+}
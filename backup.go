@@ -0,0 +1,401 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 定时备份配置：-backup-target 为空时不备份；每隔 -backup-interval 备份一次，保留最近 -backup-keep 个快照
+var (
+	backupTargetURL string
+	backupInterval  = 24 * time.Hour
+	backupKeep      = 7
+)
+
+// backupHistory 是保存的最近备份记录数
+const backupHistory = 20
+
+// backupFile 是上次备份时文件的大小和修改时间；两者都未变的文件从上一个快照复制，不再重新上传
+type backupFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// backupRun 是一次备份的进度和结果
+type backupRun struct {
+	Snapshot string     `json:"snapshot"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Files    int        `json:"files"`
+	Uploaded int        `json:"uploaded"`
+	Copied   int        `json:"copied"`
+	Bytes    int64      `json:"bytes"`
+	Removed  []string   `json:"removed,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// backupState 保存在元数据库的 backups bucket 中，以目标（不含密码）为键；
+// Files 是最近一个完成的快照 Snapshot 中的文件，Runs 为最近的备份，最新的在前
+type backupState struct {
+	Snapshot string                `json:"snapshot,omitempty"`
+	Files    map[string]backupFile `json:"files,omitempty"`
+	Runs     []backupRun           `json:"runs,omitempty"`
+}
+
+// backupScheduler 定期将服务目录增量备份到目标
+type backupScheduler struct {
+	mu      sync.Mutex
+	target  backupTarget
+	state   backupState
+	current *backupRun
+	next    time.Time
+	trigger chan struct{}
+}
+
+// backups 在未配置 -backup-target 时为 nil
+var backups *backupScheduler
+
+// startBackups 解析备份目标，加载上次的状态并启动定时备份。上次备份已超过间隔时在启动一分钟后备份
+func startBackups() error {
+	if backupTargetURL == "" {
+		return nil
+	}
+	if backupInterval <= 0 || backupKeep < 1 {
+		return fmt.Errorf("-backup-interval must be positive and -backup-keep at least 1")
+	}
+	target, err := parseBackupTarget(backupTargetURL)
+	if err != nil {
+		return err
+	}
+	b := &backupScheduler{target: target, trigger: make(chan struct{}, 1)}
+	dbEach(bucketBackups, func(k, v []byte) error {
+		if string(k) == target.String() {
+			if err := json.Unmarshal(v, &b.state); err != nil {
+				logger.Printf("Error parsing backup state of %s: %v", k, err)
+			}
+		}
+		return nil
+	})
+	b.next = time.Now().Add(time.Minute)
+	if len(b.state.Runs) > 0 {
+		if next := b.state.Runs[0].Started.Add(backupInterval); next.After(b.next) {
+			b.next = next
+		}
+	}
+	backups = b
+	logger.Printf("Backing up to %s every %v, keeping %d snapshots; next backup at %s",
+		target, backupInterval, backupKeep, b.next.Local().Format("2006-01-02 15:04"))
+	go b.loop()
+	return nil
+}
+
+func (b *backupScheduler) loop() {
+	for {
+		b.mu.Lock()
+		wait := time.Until(b.next)
+		b.mu.Unlock()
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-timer.C:
+		case <-b.trigger:
+			timer.Stop()
+		}
+		b.run()
+	}
+}
+
+// runNow 请求立即备份，已有备份在进行时返回 false
+func (b *backupScheduler) runNow() bool {
+	b.mu.Lock()
+	running := b.current != nil
+	b.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case b.trigger <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run 执行一次备份，成功后按 -backup-keep 删除旧快照，并保存状态
+func (b *backupScheduler) run() {
+	run := &backupRun{Snapshot: time.Now().UTC().Format(backupSnapshotLayout), Started: time.Now().UTC()}
+	b.mu.Lock()
+	b.current = run
+	prev, prevFiles := b.state.Snapshot, b.state.Files
+	b.mu.Unlock()
+
+	logger.Printf("Backup %s to %s started", run.Snapshot, b.target)
+	ctx := context.Background()
+	files, err := b.backup(ctx, run, prev, prevFiles)
+	var removed []string
+	if err == nil {
+		removed = b.prune(ctx, run.Snapshot)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	finished := time.Now().UTC()
+	run.Finished = &finished
+	run.Removed = removed
+	if err != nil {
+		run.Error = err.Error()
+		logger.Printf("Backup %s to %s failed: %v", run.Snapshot, b.target, err)
+	} else {
+		b.state.Snapshot, b.state.Files = run.Snapshot, files
+		logger.Printf("Backup %s to %s finished: %d files, %d uploaded (%s), %d unchanged",
+			run.Snapshot, b.target, run.Files, run.Uploaded, formatSize(run.Bytes), run.Copied)
+	}
+	b.state.Runs = append([]backupRun{*run}, b.state.Runs...)
+	if len(b.state.Runs) > backupHistory {
+		b.state.Runs = b.state.Runs[:backupHistory]
+	}
+	b.current = nil
+	b.next = finished.Add(backupInterval)
+	dbPut(bucketBackups, []byte(b.target.String()), b.state)
+}
+
+// backup 将服务目录（不含挂载点和 .fileserver）写入新快照：与上一个快照相比大小和修改时间都未变的文件在目标上复制，
+// 其余文件上传；全部完成后写入清单。返回快照中的文件
+func (b *backupScheduler) backup(ctx context.Context, run *backupRun, prev string, prevFiles map[string]backupFile) (map[string]backupFile, error) {
+	files := map[string]backupFile{}
+	var changed, same []string
+	err := rootStorage.Walk("", func(name string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name == metaDirName {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || stagingFilePattern.MatchString(info.Name()) {
+			return nil
+		}
+		f := backupFile{Size: info.Size(), ModTime: info.ModTime().UTC()}
+		files[name] = f
+		if p, ok := prevFiles[name]; ok && prev != "" && p.Size == f.Size && p.ModTime.Equal(f.ModTime) {
+			same = append(same, name)
+		} else {
+			changed = append(changed, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	run.Files = len(files)
+	b.mu.Unlock()
+
+	if len(same) > 0 {
+		failed, err := b.target.copy(ctx, prev, run.Snapshot, same)
+		if err != nil {
+			logger.Printf("Backup: copying unchanged files from snapshot %s failed, uploading them instead: %v", prev, err)
+			failed = same
+		}
+		changed = append(changed, failed...)
+		b.mu.Lock()
+		run.Copied = len(same) - len(failed)
+		b.mu.Unlock()
+	}
+	sort.Strings(changed)
+	for _, name := range changed {
+		f, err := rootStorage.Open(name)
+		if err != nil {
+			// 遍历之后被删除的文件
+			delete(files, name)
+			continue
+		}
+		info := files[name]
+		err = b.target.put(ctx, run.Snapshot, name, f, info.Size, info.ModTime)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		b.mu.Lock()
+		run.Uploaded++
+		run.Bytes += info.Size
+		b.mu.Unlock()
+	}
+
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"snapshot": run.Snapshot, "started": run.Started, "base": prev, "files": len(files), "uploaded": len(changed),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := b.target.commit(ctx, run.Snapshot, manifest); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// prune 删除超出 -backup-keep 的旧快照和中断的快照，返回删除的快照
+func (b *backupScheduler) prune(ctx context.Context, current string) []string {
+	snapshots, err := b.target.list(ctx)
+	if err != nil {
+		logger.Printf("Backup: error listing snapshots on %s: %v", b.target, err)
+		return nil
+	}
+	var complete, stale []string
+	for name, done := range snapshots {
+		switch {
+		case done:
+			complete = append(complete, name)
+		case name != current:
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(complete)
+	if len(complete) > backupKeep {
+		stale = append(stale, complete[:len(complete)-backupKeep]...)
+	}
+	sort.Strings(stale)
+	var removed []string
+	for _, name := range stale {
+		if err := b.target.remove(ctx, name); err != nil {
+			logger.Printf("Backup: error removing snapshot %s from %s: %v", name, b.target, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// backupStatus 是 /api/v1/backup 返回的状态
+type backupStatus struct {
+	Target    string      `json:"target"`
+	Interval  string      `json:"interval"`
+	Keep      int         `json:"keep"`
+	Next      time.Time   `json:"next"`
+	Running   *backupRun  `json:"running,omitempty"`
+	Runs      []backupRun `json:"runs"`
+	Snapshots []string    `json:"snapshots"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// status 返回备份状态；快照列表从目标读取，读取失败时记录在 Error 中
+func (b *backupScheduler) status(ctx context.Context) backupStatus {
+	b.mu.Lock()
+	st := backupStatus{Target: b.target.String(), Interval: backupInterval.String(), Keep: backupKeep, Next: b.next,
+		Runs: append([]backupRun{}, b.state.Runs...), Snapshots: []string{}}
+	if b.current != nil {
+		c := *b.current
+		st.Running = &c
+	}
+	b.mu.Unlock()
+	snapshots, err := b.target.list(ctx)
+	if err != nil {
+		st.Error = err.Error()
+	}
+	for name, done := range snapshots {
+		if done {
+			st.Snapshots = append(st.Snapshots, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(st.Snapshots)))
+	return st
+}
+
+// apiBackupHandler 处理 /api/v1/backup（仅管理员）：GET 返回备份状态，POST 立即开始一次备份
+func apiBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if backups == nil {
+		http.Error(w, "Backups are not configured (-backup-target)", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, backups.status(r.Context()))
+	case http.MethodPost:
+		if !backups.runNow() {
+			http.Error(w, "A backup is already running", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// backupPageHandler 处理 /backup，向管理员展示备份目标、进行中的备份、最近的结果和目标中的快照；POST 立即备份
+func backupPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if backups == nil {
+		http.Error(w, "Backups are not configured (-backup-target)", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		backups.runNow()
+		http.Redirect(w, r, "/backup", http.StatusSeeOther)
+		return
+	}
+	st := backups.status(r.Context())
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Backups</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Backups</h1>
+`)
+	fmt.Fprintf(&sb, "    <p>To %s every %s, keeping %d snapshots. <a href=\"/stats\">Storage</a> | <a href=\"/\">&larr; Back</a></p>\n",
+		html.EscapeString(st.Target), st.Interval, st.Keep)
+	if st.Running != nil {
+		fmt.Fprintf(&sb, "    <p>Backup %s running since %s: %d of %d files uploaded (%s), %d unchanged. <a href=\"/backup\">Refresh</a></p>\n",
+			st.Running.Snapshot, st.Running.Started.Local().Format("15:04"), st.Running.Uploaded, st.Running.Files-st.Running.Copied,
+			formatSize(st.Running.Bytes), st.Running.Copied)
+	} else {
+		fmt.Fprintf(&sb, "    <form method=\"post\" action=\"/backup\">Next backup at %s. <button type=\"submit\">Back up now</button></form>\n",
+			st.Next.Local().Format("2006-01-02 15:04"))
+	}
+	sb.WriteString("    <h2>Recent backups</h2>\n    <table>\n        <tr><th>Snapshot</th><th>Started</th><th>Duration</th><th>Files</th><th>Uploaded</th><th>Unchanged</th><th>Result</th></tr>\n")
+	for _, run := range st.Runs {
+		result := "ok"
+		if run.Error != "" {
+			result = "failed: " + run.Error
+		} else if len(run.Removed) > 0 {
+			result = "ok, removed " + strings.Join(run.Removed, ", ")
+		}
+		duration := ""
+		if run.Finished != nil {
+			duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+		}
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%d (%s)</td><td>%d</td><td>%s</td></tr>\n",
+			run.Snapshot, run.Started.Local().Format("2006-01-02 15:04"), duration, run.Files, run.Uploaded, formatSize(run.Bytes),
+			run.Copied, html.EscapeString(result))
+	}
+	sb.WriteString("    </table>\n    <h2>Snapshots on the target</h2>\n")
+	if st.Error != "" {
+		fmt.Fprintf(&sb, "    <p>Error listing snapshots: %s</p>\n", html.EscapeString(st.Error))
+	}
+	sb.WriteString("    <ul>\n")
+	for _, name := range st.Snapshots {
+		fmt.Fprintf(&sb, "        <li>%s</li>\n", name)
+	}
+	sb.WriteString(`    </ul>
+</body>
+</html>`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
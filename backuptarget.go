@@ -0,0 +1,476 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-server/client"
+)
+
+// -backup-s3-endpoint 和 -backup-s3-region 设置 S3 备份目标的服务地址和区域，
+// 凭据从环境变量 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY 读取
+var (
+	backupS3Endpoint string
+	backupS3Region   = "us-east-1"
+)
+
+// backupSnapshotLayout 是快照名称的时间格式，按名称排序即按时间排序
+const backupSnapshotLayout = "20060102-150405"
+
+// backupSnapshotPattern 匹配快照目录名
+var backupSnapshotPattern = regexp.MustCompile(`^\d{8}-\d{6}$`)
+
+// backupTarget 是备份目标。每个快照是目标中以时间命名的目录，完成后在旁边写入同名的 .json 清单；
+// 没有清单的目录是中断的备份
+type backupTarget interface {
+	// String 返回不含密码的目标描述
+	String() string
+	// list 返回目标中的快照，值表示快照是否已完成
+	list(ctx context.Context) (map[string]bool, error)
+	// put 将文件写入快照
+	put(ctx context.Context, snapshot, name string, r io.Reader, size int64, modTime time.Time) error
+	// copy 将未变化的文件从上一个快照复制到新快照，不必重新传输；返回复制失败、需要重新上传的文件
+	copy(ctx context.Context, from, to string, names []string) ([]string, error)
+	// commit 写入快照的清单，标记快照已完成
+	commit(ctx context.Context, snapshot string, manifest []byte) error
+	// remove 删除快照及其清单
+	remove(ctx context.Context, snapshot string) error
+}
+
+// parseBackupTarget 解析 -backup-target：s3://bucket/prefix、http(s)://user:pass@host:port/folder（另一个 fileserver）或本地目录
+func parseBackupTarget(s string) (backupTarget, error) {
+	switch {
+	case strings.HasPrefix(s, "s3://"):
+		return newS3BackupTarget(s)
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup target %q: %v", s, err)
+		}
+		dir := strings.Trim(u.Path, "/")
+		display := u.Redacted()
+		u.Path, u.RawPath = "", ""
+		c, err := client.New(u.String())
+		if err != nil {
+			return nil, err
+		}
+		return &remoteBackupTarget{c: c, dir: dir, display: display}, nil
+	}
+	dir, err := filepath.Abs(strings.TrimPrefix(s, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	served, err := filepath.Abs(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(served, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("backup target %s is inside the served directory", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &localBackupTarget{dir: dir}, nil
+}
+
+// localBackupTarget 将快照写入本地目录（如挂载的外置硬盘或 NFS）。未变化的文件与上一个快照硬链接，
+// 每个快照都是完整的目录树，但只占用变化部分的空间
+type localBackupTarget struct {
+	dir string
+}
+
+func (t *localBackupTarget) String() string { return t.dir }
+
+func (t *localBackupTarget) list(ctx context.Context) (map[string]bool, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() && backupSnapshotPattern.MatchString(e.Name()) {
+			_, err := os.Stat(filepath.Join(t.dir, e.Name()+".json"))
+			out[e.Name()] = err == nil
+		}
+	}
+	return out, nil
+}
+
+func (t *localBackupTarget) put(ctx context.Context, snapshot, name string, r io.Reader, size int64, modTime time.Time) error {
+	p := filepath.Join(t.dir, snapshot, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, modTime, modTime)
+}
+
+func (t *localBackupTarget) copy(ctx context.Context, from, to string, names []string) ([]string, error) {
+	var failed []string
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		src := filepath.Join(t.dir, from, filepath.FromSlash(name))
+		dst := filepath.Join(t.dir, to, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Link(src, dst); err != nil {
+			failed = append(failed, name)
+		}
+	}
+	return failed, nil
+}
+
+func (t *localBackupTarget) commit(ctx context.Context, snapshot string, manifest []byte) error {
+	return os.WriteFile(filepath.Join(t.dir, snapshot+".json"), manifest, 0644)
+}
+
+func (t *localBackupTarget) remove(ctx context.Context, snapshot string) error {
+	if err := os.Remove(filepath.Join(t.dir, snapshot+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(t.dir, snapshot))
+}
+
+// remoteBackupTarget 将快照上传到另一个 fileserver 的目录中，未变化的文件通过 /api/v1/batch 在对方服务器上复制
+type remoteBackupTarget struct {
+	c       *client.Client
+	dir     string
+	display string
+}
+
+func (t *remoteBackupTarget) String() string { return t.display }
+
+func (t *remoteBackupTarget) list(ctx context.Context) (map[string]bool, error) {
+	entries, err := t.c.ReadDir(ctx, t.dir)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	manifests := map[string]bool{}
+	for _, e := range entries {
+		name := path.Base(e.Path)
+		if e.IsDir && backupSnapshotPattern.MatchString(name) {
+			out[name] = false
+		} else if !e.IsDir && strings.HasSuffix(name, ".json") {
+			manifests[strings.TrimSuffix(name, ".json")] = true
+		}
+	}
+	for name := range out {
+		out[name] = manifests[name]
+	}
+	return out, nil
+}
+
+func (t *remoteBackupTarget) put(ctx context.Context, snapshot, name string, r io.Reader, size int64, modTime time.Time) error {
+	return t.c.Put(ctx, path.Join(t.dir, snapshot, name), r, size, true)
+}
+
+func (t *remoteBackupTarget) copy(ctx context.Context, from, to string, names []string) ([]string, error) {
+	var failed []string
+	for start := 0; start < len(names); start += batchMaxOps {
+		chunk := names[start:min(start+batchMaxOps, len(names))]
+		ops := make([]client.BatchOp, len(chunk))
+		for i, name := range chunk {
+			ops[i] = client.BatchOp{Op: "copy", Path: path.Join(t.dir, from, name), To: path.Join(t.dir, to, name)}
+		}
+		results, err := t.c.Batch(ctx, ops, false, true)
+		if err != nil {
+			return nil, err
+		}
+		for i, res := range results {
+			if res.Status != "ok" {
+				failed = append(failed, chunk[i])
+			}
+		}
+	}
+	return failed, nil
+}
+
+func (t *remoteBackupTarget) commit(ctx context.Context, snapshot string, manifest []byte) error {
+	return t.c.Put(ctx, path.Join(t.dir, snapshot+".json"), bytes.NewReader(manifest), int64(len(manifest)), true)
+}
+
+func (t *remoteBackupTarget) remove(ctx context.Context, snapshot string) error {
+	for _, p := range []string{path.Join(t.dir, snapshot+".json"), path.Join(t.dir, snapshot)} {
+		var apiErr *client.Error
+		if err := t.c.Delete(ctx, p); err != nil && !(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3BackupTarget 将快照上传到 S3 兼容存储的 bucket/prefix 下，未变化的文件用 CopyObject 在服务端复制；
+// 请求使用路径风格的 URL 和 AWS Signature V4 签名
+type s3BackupTarget struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	hc        *http.Client
+}
+
+func newS3BackupTarget(s string) (*s3BackupTarget, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(s, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid backup target %q: missing bucket", s)
+	}
+	endpoint := backupS3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + backupS3Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid -backup-s3-endpoint %q", endpoint)
+	}
+	t := &s3BackupTarget{
+		endpoint: u, bucket: bucket, prefix: strings.Trim(prefix, "/"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"), secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		hc: &http.Client{},
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, errors.New("S3 backup target needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return t, nil
+}
+
+func (t *s3BackupTarget) String() string {
+	return "s3://" + path.Join(t.bucket, t.prefix)
+}
+
+// key 返回快照中文件的对象键
+func (t *s3BackupTarget) key(elem ...string) string {
+	if t.prefix != "" {
+		elem = append([]string{t.prefix}, elem...)
+	}
+	return path.Join(elem...)
+}
+
+// do 发送签名的请求，非 2xx 响应转换为包含 S3 错误信息的 error
+func (t *s3BackupTarget) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := *t.endpoint
+	u.Path = u.Path + "/" + t.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = awsEscape(u.Path, true)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	t.sign(req)
+	resp, err := t.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return nil, fmt.Errorf("S3 %s %s: %s %s %s", method, key, resp.Status, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+// sign 按 AWS Signature V4 签名请求；请求体不参与签名（UNSIGNED-PAYLOAD）
+func (t *s3BackupTarget) sign(req *http.Request) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Host = req.URL.Host
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	names := []string{"host"}
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, true),
+		canonicalQuery(req.URL.Query(), false),
+		canonicalHeaders(req, signed),
+		signed,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := now.Format("20060102") + "/" + backupS3Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, backupS3Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, t.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// listObjects 列出前缀下的对象键和（delimiter 非空时的）公共前缀
+func (t *s3BackupTarget) listObjects(ctx context.Context, prefix, delimiter string) (keys, prefixes []string, err error) {
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := t.do(ctx, http.MethodGet, "", q, nil, 0, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		var res struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range res.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return keys, prefixes, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (t *s3BackupTarget) list(ctx context.Context) (map[string]bool, error) {
+	prefix := ""
+	if t.prefix != "" {
+		prefix = t.prefix + "/"
+	}
+	keys, prefixes, err := t.listObjects(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for _, p := range prefixes {
+		if name := path.Base(p); backupSnapshotPattern.MatchString(name) {
+			out[name] = false
+		}
+	}
+	for _, k := range keys {
+		if name := strings.TrimSuffix(path.Base(k), ".json"); backupSnapshotPattern.MatchString(name) {
+			if _, ok := out[name]; ok {
+				out[name] = true
+			}
+		}
+	}
+	return out, nil
+}
+
+func (t *s3BackupTarget) put(ctx context.Context, snapshot, name string, r io.Reader, size int64, modTime time.Time) error {
+	header := http.Header{"X-Amz-Meta-Mtime": {strconv.FormatInt(modTime.Unix(), 10)}}
+	resp, err := t.do(ctx, http.MethodPut, t.key(snapshot, name), nil, r, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *s3BackupTarget) copy(ctx context.Context, from, to string, names []string) ([]string, error) {
+	var failed []string
+	for _, name := range names {
+		source := "/" + t.bucket + "/" + awsEscape(t.key(from, name), true)
+		resp, err := t.do(ctx, http.MethodPut, t.key(to, name), nil, nil, 0, http.Header{"X-Amz-Copy-Source": {source}})
+		if err != nil {
+			if strings.Contains(err.Error(), "NotImplemented") {
+				// 服务不支持 CopyObject，全部重新上传
+				return nil, err
+			}
+			failed = append(failed, name)
+			continue
+		}
+		// CopyObject 可能在 200 响应中返回错误
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if bytes.Contains(data, []byte("<Error>")) {
+			failed = append(failed, name)
+		}
+	}
+	return failed, nil
+}
+
+func (t *s3BackupTarget) commit(ctx context.Context, snapshot string, manifest []byte) error {
+	resp, err := t.do(ctx, http.MethodPut, t.key(snapshot+".json"), nil, bytes.NewReader(manifest), int64(len(manifest)), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *s3BackupTarget) remove(ctx context.Context, snapshot string) error {
+	keys, _, err := t.listObjects(ctx, t.key(snapshot)+"/", "")
+	if err != nil {
+		return err
+	}
+	for _, k := range append(keys, t.key(snapshot+".json")) {
+		resp, err := t.do(ctx, http.MethodDelete, k, nil, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// showQR 控制启动时是否在终端打印局域网地址的二维码
+var showQR = true
+
+// printBanner 打印访问地址；标准错误是终端时再为首选局域网地址打印二维码，方便手机扫码访问
+func printBanner(port int) {
+	logger.Printf("Server is accessible at http://localhost:%d", port)
+	ips := getLocalIPs()
+	if len(ips) == 0 {
+		return
+	}
+	logger.Println("Also accessible on the local network at:")
+	for _, ip := range ips {
+		logger.Printf("  http://%s:%d", ip, port)
+	}
+
+	if !showQR || !isTerminal(os.Stderr) {
+		return
+	}
+	primary := fmt.Sprintf("http://%s:%d", ips[0], port)
+	qr, err := qrcode.New(primary, qrcode.Low)
+	if err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimRight(qr.ToSmallString(false), "\n"), "\n") {
+		b.WriteString("  " + line + "\n")
+	}
+	fmt.Fprintf(&b, "  Scan to open %s\n\n", primary)
+	fmt.Fprint(os.Stderr, b.String())
+}
+
+// isTerminal 判断文件是否为终端
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// getLocalIPs 返回已启用网卡上的非回环、非链路本地 IPv4 地址，默认路由所在的地址排在最前
+func getLocalIPs() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	primary := outboundIP()
+	var ips []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ipnet.IP.Equal(primary) {
+				ips = append([]string{ipnet.IP.String()}, ips...)
+			} else {
+				ips = append(ips, ipnet.IP.String())
+			}
+		}
+	}
+	return ips
+}
+
+// outboundIP 返回访问外网时使用的本机地址；UDP 连接不会实际发送数据
+func outboundIP() net.IP {
+	conn, err := net.Dial("udp4", "192.0.2.1:80")
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
@@ -0,0 +1,422 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// batchOp 是批量请求中的一个操作：delete 删除 path；move 和 copy 将 path 移动或复制到 to。
+// to 是已存在的目录或以 "/" 结尾时放入该目录（不存在则创建），否则作为新路径
+type batchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	To   string `json:"to,omitempty"`
+}
+
+// batchRequest 是 /api/v1/batch 的请求体。Atomic 为 true 时任何一个操作失败都会撤销已完成的操作；
+// Overwrite 允许 move 和 copy 替换已存在的目标
+type batchRequest struct {
+	Operations []batchOp `json:"operations"`
+	Atomic     bool      `json:"atomic,omitempty"`
+	Overwrite  bool      `json:"overwrite,omitempty"`
+}
+
+// batchResult 是单个操作的结果，Status 为 ok、failed、skipped（原子批次中失败之后的操作）或 rolled back
+type batchResult struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	To     string `json:"to,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchMaxOps 是单个批量请求的最大操作数
+const batchMaxOps = 1000
+
+var errBatchReadOnly = errors.New("read-only")
+
+// batchStep 是已执行的一步：undo 撤销它；trash 不为空时表示条目已改名为隐藏文件，提交时才真正删除
+type batchStep struct {
+	undo  func() error
+	vol   volume
+	trash string
+}
+
+// batchTx 记录批量操作的步骤。被删除或被覆盖的条目先改名为同目录下的隐藏文件，
+// 因此在提交前都可以原样恢复
+type batchTx struct {
+	r     *http.Request
+	steps []batchStep
+	done  []func()
+}
+
+// apiBatchHandler 处理 POST /api/v1/batch，按顺序执行多个删除、移动和复制操作并返回每个操作的结果。
+// 全部成功时返回 200，部分失败时返回 207；原子批次失败时撤销所有操作并返回 409
+func apiBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) == 0 {
+		http.Error(w, "No operations", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > batchMaxOps {
+		http.Error(w, fmt.Sprintf("Too many operations (at most %d)", batchMaxOps), http.StatusBadRequest)
+		return
+	}
+
+	tx := &batchTx{r: r}
+	results := make([]batchResult, len(req.Operations))
+	failed := 0
+	for i, op := range req.Operations {
+		res := batchResult{Op: op.Op, Path: op.Path, To: op.To}
+		if failed > 0 && req.Atomic {
+			res.Status = "skipped"
+			results[i] = res
+			continue
+		}
+		mark := len(tx.steps)
+		to, err := tx.run(op, req.Overwrite)
+		if err != nil {
+			tx.rollback(mark)
+			res.Status, res.Error = "failed", err.Error()
+			failed++
+		} else {
+			res.Status = "ok"
+			if to != "" {
+				res.To = to
+			}
+		}
+		results[i] = res
+	}
+
+	status := http.StatusOK
+	if failed > 0 && req.Atomic {
+		tx.rollback(0)
+		for i := range results {
+			if results[i].Status == "ok" {
+				results[i].Status = "rolled back"
+			}
+		}
+		status = http.StatusConflict
+	} else {
+		tx.commit()
+		if failed > 0 {
+			status = http.StatusMultiStatus
+		}
+	}
+	reqLog(r).Printf("Batch of %d operations by %q: %d failed", len(req.Operations), currentUser(r), failed)
+	writeJSON(w, status, map[string]interface{}{"results": results})
+}
+
+// run 执行一个操作，返回 move 和 copy 的实际目标路径
+func (tx *batchTx) run(op batchOp, overwrite bool) (string, error) {
+	src, name, info, err := tx.resolve(op.Path)
+	if err != nil {
+		return "", err
+	}
+	if src.readOnly && op.Op != "copy" {
+		return "", errBatchReadOnly
+	}
+	user := currentUser(tx.r)
+	switch op.Op {
+	case "delete":
+		if err := tx.trash(src, name); err != nil {
+			return "", err
+		}
+		ev := fileEvent{Event: eventDelete, Path: src.virtual(name), User: user}
+		if !info.IsDir() {
+			ev.Size = info.Size()
+		}
+		tx.done = append(tx.done, func() {
+			reqLog(tx.r).Printf("Deleted: %s", ev.Path)
+			emitEvent(ev)
+		})
+		return "", nil
+	case "move", "copy":
+	default:
+		return "", fmt.Errorf("unknown operation %q (use delete, move or copy)", op.Op)
+	}
+
+	dst, dname, err := tx.destination(op.To, path.Base(name))
+	if err != nil {
+		return "", err
+	}
+	from, to := src.virtual(name), dst.virtual(dname)
+	if dst.readOnly {
+		return "", errBatchReadOnly
+	}
+	if src.prefix == dst.prefix && dname == name {
+		return "", fmt.Errorf("%s is already at %s", from, to)
+	}
+	if src.prefix == dst.prefix && strings.HasPrefix(dname, name+"/") {
+		return "", fmt.Errorf("cannot %s %s into itself", op.Op, from)
+	}
+	if op.Op == "move" && src.prefix != dst.prefix {
+		return "", errCrossVolume
+	}
+	created := true
+	if op.Op == "copy" {
+		// 复制会新建目标及其下的所有条目：目标本身在这里检查，其下的条目由 copyEntry 逐个检查
+		if created, err = checkNewEntry(dst, dname, overwrite); err != nil {
+			return "", err
+		}
+	}
+	if _, err := dst.store.Stat(dname); err == nil {
+		if !overwrite {
+			return "", fmt.Errorf("%s already exists", to)
+		}
+		if err := tx.trash(dst, dname); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.mkdirAll(dst, path.Dir(dname)); err != nil {
+		return "", err
+	}
+
+	if op.Op == "move" {
+		if err := src.store.Rename(name, dname); err != nil {
+			return "", err
+		}
+		tx.steps = append(tx.steps, batchStep{undo: func() error { return src.store.Rename(dname, name) }})
+		tx.done = append(tx.done, func() {
+			reqLog(tx.r).Printf("Moved: %s -> %s", from, to)
+			moveMetadata(from, to)
+			if !watching {
+				changes.add(changeRename, to, from, info.IsDir())
+			}
+		})
+		return to, nil
+	}
+
+	var events []fileEvent
+	n, err := copyEntry(src, name, dst, dname, func(virtual string, size int64) {
+		events = append(events, fileEvent{Event: eventUpload, Path: virtual, Size: size, User: user})
+	})
+	if !created {
+		n--
+	}
+	if err != nil {
+		dst.store.Delete(dname)
+		return "", err
+	}
+	tx.steps = append(tx.steps, batchStep{undo: func() error { return dst.store.Delete(dname) }})
+	tx.done = append(tx.done, func() {
+		reqLog(tx.r).Printf("Copied: %s -> %s", from, to)
+		countNewEntries(n)
+		for _, ev := range events {
+			emitEvent(ev)
+		}
+	})
+	return to, nil
+}
+
+// resolve 解析虚拟路径并确认条目存在；卷的根目录和挂载点本身不能操作
+func (tx *batchTx) resolve(p string) (volume, string, fs.FileInfo, error) {
+	p = cleanName(p)
+	if p == "" || reservedPath(p) {
+		return volume{}, "", nil, fmt.Errorf("invalid path %q", p)
+	}
+	vol, name, err := resolveVirtual(tx.r.Context(), p)
+	if err != nil || name == "" {
+		return volume{}, "", nil, fmt.Errorf("invalid path %q", p)
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil {
+		return volume{}, "", nil, fmt.Errorf("%s not found", p)
+	}
+	return vol, name, info, nil
+}
+
+// destination 解析 move 和 copy 的目标：已存在的目录或以 "/" 结尾的路径表示放入该目录，名称为 base
+func (tx *batchTx) destination(to, base string) (volume, string, error) {
+	if to == "" {
+		return volume{}, "", errors.New("missing destination")
+	}
+	into := strings.HasSuffix(to, "/")
+	to = cleanName(to)
+	if !into {
+		vol, name, err := resolveVirtual(tx.r.Context(), to)
+		if err != nil {
+			return volume{}, "", fmt.Errorf("invalid destination %q", to)
+		}
+		info, err := vol.store.Stat(name)
+		into = err == nil && info.IsDir()
+	}
+	if into {
+		to = path.Join(to, base)
+	}
+	if to == "" || reservedPath(to) {
+		return volume{}, "", fmt.Errorf("invalid destination %q", to)
+	}
+	vol, name, err := resolveVirtual(tx.r.Context(), to)
+	if err != nil || name == "" {
+		return volume{}, "", fmt.Errorf("invalid destination %q", to)
+	}
+	return vol, name, nil
+}
+
+// trash 将条目改名为同目录下的隐藏文件，撤销时改回原名
+func (tx *batchTx) trash(vol volume, name string) error {
+	tmp, err := stagingName(name, "batch")
+	if err != nil {
+		return err
+	}
+	if err := vol.store.Rename(name, tmp); err != nil {
+		return err
+	}
+	tx.steps = append(tx.steps, batchStep{undo: func() error { return vol.store.Rename(tmp, name) }, vol: vol, trash: tmp})
+	return nil
+}
+
+// mkdirAll 创建目标的父目录，撤销时删除新建的最上层目录
+func (tx *batchTx) mkdirAll(vol volume, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	top := ""
+	for d := dir; d != "."; d = path.Dir(d) {
+		info, err := vol.store.Stat(d)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", vol.virtual(d))
+			}
+			break
+		}
+		top = d
+	}
+	if top == "" {
+		return nil
+	}
+	if err := vol.store.Mkdir(dir); err != nil {
+		return err
+	}
+	tx.steps = append(tx.steps, batchStep{undo: func() error { return vol.store.Delete(top) }})
+	return nil
+}
+
+// rollback 倒序撤销 mark 之后的步骤
+func (tx *batchTx) rollback(mark int) {
+	for i := len(tx.steps) - 1; i >= mark; i-- {
+		if err := tx.steps[i].undo(); err != nil {
+			reqLog(tx.r).Printf("Error rolling back batch operation: %v", err)
+		}
+	}
+	tx.steps = tx.steps[:mark]
+}
+
+// commit 删除被替换和删除的条目，然后发出文件事件
+func (tx *batchTx) commit() {
+	for _, s := range tx.steps {
+		if s.trash != "" {
+			if err := s.vol.store.Delete(s.trash); err != nil {
+				reqLog(tx.r).Printf("Error removing %s: %v", s.vol.virtual(s.trash), err)
+			}
+		}
+	}
+	for _, f := range tx.done {
+		f()
+	}
+}
+
+// copyEntry 将文件或整个目录从一个卷复制到另一个卷（可以是同一个卷），每复制一个文件调用一次 copied。
+// dname 以下的条目在新建前按条目数上限检查，返回新建的条目数（包括 dname 本身）
+func copyEntry(src volume, name string, dst volume, dname string, copied func(virtual string, size int64)) (int64, error) {
+	budget := newTreeBudget(dst, dname)
+	var n int64
+	err := src.store.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := dname
+		if p != name {
+			target = path.Join(dname, strings.TrimPrefix(p, name+"/"))
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := budget.add(target); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := dst.store.Mkdir(target); err != nil {
+				return err
+			}
+			n++
+			return nil
+		}
+		if c, ok := dst.store.(cloner); ok {
+			err := c.Clone(src.store, p, target)
+			if err == nil {
+				n++
+				copied(dst.virtual(target), info.Size())
+				return nil
+			}
+			if !errors.Is(err, errors.ErrUnsupported) {
+				return err
+			}
+		}
+		in, err := src.store.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := dst.store.Create(target)
+		if err != nil {
+			return err
+		}
+		size, err := io.Copy(out, in)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		copied(dst.virtual(target), size)
+		return nil
+	})
+	return n, err
+}
+
+// batchScript 让列表页的“删除所选”和“移动所选”按钮通过一次批量请求完成操作
+const batchScript = `    <script>
+    document.querySelectorAll("button.batch").forEach(function (b) {
+        b.addEventListener("click", function () {
+            var paths = Array.prototype.map.call(document.querySelectorAll("input[form=archive][name=path]:checked"), function (c) { return c.value; });
+            if (!paths.length) return;
+            var op = b.dataset.op, to;
+            if (op === "move") {
+                to = prompt("Move " + paths.length + " item(s) to folder:", b.dataset.dir);
+                if (to === null) return;
+                to = to.replace(/\/*$/, "/");
+            } else if (!confirm("Delete " + paths.length + " item(s)?")) {
+                return;
+            }
+            fetch("/api/v1/batch", {method: "POST", headers: {"Content-Type": "application/json"},
+                body: JSON.stringify({atomic: true, operations: paths.map(function (p) { return {op: op, path: p, to: to}; })})
+            }).then(function (r) {
+                return r.json();
+            }).then(function (res) {
+                var bad = res.results.filter(function (x) { return x.error; });
+                if (bad.length) alert(bad.map(function (x) { return x.path + ": " + x.error; }).join("\n"));
+                location.reload();
+            }, function () {
+                alert("Request failed");
+            });
+        });
+    });
+    </script>
+`
@@ -0,0 +1,242 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// archiveRequest 是 POST /api/v1/archive 的请求体，路径均为虚拟文件树中的完整路径（与 /put/ 相同）
+type archiveRequest struct {
+	Paths       []string `json:"paths"`
+	Dest        string   `json:"dest"`
+	Compression string   `json:"compression,omitempty"`
+	Overwrite   bool     `json:"overwrite,omitempty"`
+	Password    string   `json:"password,omitempty"`
+}
+
+// archiveSource 是要打包的一个文件或目录，entry 为它在压缩包中的顶层名称
+type archiveSource struct {
+	vol   volume
+	name  string
+	entry string
+	info  os.FileInfo
+}
+
+// archivePlan 是已校验的打包请求，name 为压缩包在 vol 中的路径
+type archivePlan struct {
+	vol       volume
+	name      string
+	overwrite bool
+	created   bool
+	sources   []archiveSource
+	comp      zipCompression
+	password  string
+	user      string
+}
+
+// createArchive 将多个文件和目录打包成服务器上的一个新 ZIP 文件，供之后反复分享和下载，
+// 不必每次下载时重新打包。每个来源以其名称作为压缩包中的顶层条目
+func createArchive(r *http.Request, req archiveRequest) (fileEvent, int, error) {
+	plan, status, err := prepareArchive(r, req)
+	if err != nil {
+		return fileEvent{}, status, err
+	}
+	ev, err := plan.build(r.Context(), nil)
+	if err != nil {
+		return ev, http.StatusInternalServerError, err
+	}
+	return ev, http.StatusCreated, nil
+}
+
+// prepareArchive 校验打包请求并创建目标目录
+func prepareArchive(r *http.Request, req archiveRequest) (*archivePlan, int, error) {
+	comp := defaultZipCompression
+	if req.Compression != "" {
+		c, err := parseZipCompression(req.Compression)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		comp = c
+	}
+	dest := cleanName(req.Dest)
+	if dest == "" || reservedPath(dest) {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid destination")
+	}
+	if !strings.EqualFold(path.Ext(dest), ".zip") {
+		dest += ".zip"
+	}
+	if len(req.Paths) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("no paths to archive")
+	}
+
+	sources := make([]archiveSource, 0, len(req.Paths))
+	seen := make(map[string]bool)
+	for _, p := range req.Paths {
+		p = cleanName(p)
+		if p == "" || reservedPath(p) {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid path %q", p)
+		}
+		if dest == p || strings.HasPrefix(dest, p+"/") {
+			return nil, http.StatusBadRequest, fmt.Errorf("destination is inside %s", p)
+		}
+		vol, name, err := resolveVirtual(r.Context(), p)
+		if err != nil {
+			return nil, http.StatusNotFound, fmt.Errorf("path not found: %s", p)
+		}
+		info, err := vol.store.Stat(name)
+		if err != nil {
+			return nil, http.StatusNotFound, fmt.Errorf("path not found: %s", p)
+		}
+		entry := path.Base(p)
+		if seen[entry] {
+			return nil, http.StatusBadRequest, fmt.Errorf("duplicate name %s in selection", entry)
+		}
+		seen[entry] = true
+		sources = append(sources, archiveSource{vol: vol, name: name, entry: entry, info: info})
+	}
+
+	vol, name, err := resolveVirtual(r.Context(), dest)
+	if err != nil || name == "" {
+		return nil, http.StatusNotFound, fmt.Errorf("destination directory not found")
+	}
+	if vol.readOnly {
+		return nil, http.StatusForbidden, fmt.Errorf("directory is read-only")
+	}
+	if dir := path.Dir(name); dir != "." {
+		if info, err := vol.store.Stat(dir); err == nil && !info.IsDir() {
+			return nil, http.StatusConflict, fmt.Errorf("parent is not a directory")
+		}
+		if err := vol.store.Mkdir(dir); err != nil {
+			logger.Printf("Error creating directory %s: %v", dir, err)
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to create directory")
+		}
+	}
+	if req.Overwrite {
+		if info, err := vol.store.Stat(name); err == nil && info.IsDir() {
+			return nil, http.StatusConflict, fmt.Errorf("path is a directory")
+		}
+	}
+	created, err := checkNewEntry(vol, name, req.Overwrite)
+	if err != nil {
+		return nil, http.StatusInsufficientStorage, err
+	}
+	return &archivePlan{vol: vol, name: name, overwrite: req.Overwrite, created: created, sources: sources, comp: comp, password: req.Password, user: currentUser(r)}, 0, nil
+}
+
+// build 写出压缩包；job 不为 nil 时记录写入的字节数，ctx 取消时停止并删除未完成的压缩包
+func (p *archivePlan) build(ctx context.Context, job *job) (fileEvent, error) {
+	var ev fileEvent
+	vol := p.vol
+	safeName := p.name
+	if !p.overwrite {
+		safeName = generateUniqueName(vol.store, p.name, path.Ext(p.name))
+	}
+	if job != nil {
+		job.setResult(vol.virtual(safeName))
+		// 条目数不计，bytes 为写出的压缩包大小，TotalBytes 为未压缩的来源总大小
+		var bytes int64
+		for _, src := range p.sources {
+			_, b := treeSize(src.vol, src.name)
+			bytes += b
+		}
+		job.setTotals(0, bytes)
+	}
+
+	// 先写入同目录下的隐藏临时文件，完成后再改名，避免留下不完整的压缩包
+	logger.Printf("Creating archive %s from %d paths", vol.virtual(safeName), len(p.sources))
+	hasher := sha256.New()
+	err := stageAndCommit(vol.store, safeName, "archive", func(dst io.Writer) error {
+		w := io.MultiWriter(dst, hasher)
+		if job != nil {
+			w = io.MultiWriter(w, job.counter(ctx))
+		}
+		return writeArchive(w, p.sources, p.comp, p.password)
+	}, nil)
+	if err != nil {
+		logger.Printf("Error creating archive %s: %v", vol.virtual(safeName), err)
+		return ev, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	ev = fileEvent{Event: eventUpload, Path: vol.virtual(safeName), User: p.user, Checksum: hex.EncodeToString(hasher.Sum(nil))}
+	if info, err := vol.store.Stat(safeName); err == nil {
+		ev.Size = info.Size()
+	}
+	logger.Printf("Archive created: %s (%s)", ev.Path, formatSize(ev.Size))
+	completeUpload(vol, safeName, ev, p.created)
+	return ev, nil
+}
+
+// writeArchive 将来源依次写入 ZIP，目录递归打包；password 非空时加密文件内容
+func writeArchive(w io.Writer, sources []archiveSource, comp zipCompression, password string) error {
+	zw := comp.newZipWriter(w, password)
+	for _, src := range sources {
+		if src.info.IsDir() {
+			if err := zipDir(zw, src.vol.store, src.name, src.entry, comp); err != nil {
+				return err
+			}
+			continue
+		}
+		header, err := zip.FileInfoHeader(src.info)
+		if err != nil {
+			return err
+		}
+		header.Name = src.entry
+		header.Method = comp.method(src.name)
+		if err := writeZipEntry(zw, src.vol.store, &zipEntry{header: header, name: src.name}); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// apiArchiveHandler 处理 POST /api/v1/archive，在服务器上创建压缩包并返回其路径、大小和校验和
+func apiArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req archiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	ev, status, err := createArchive(r, req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"path": ev.Path, "size": ev.Size, "checksum": ev.Checksum})
+}
+
+// archiveFormHandler 处理列表页面的“打包所选”表单，在当前目录创建压缩包后返回列表
+func archiveFormHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	dir := strings.Trim(r.FormValue("dir"), "/")
+	name := path.Base(cleanName(r.FormValue("name")))
+	if name == "." || name == "/" {
+		http.Error(w, "Missing archive name", http.StatusBadRequest)
+		return
+	}
+	req := archiveRequest{Paths: r.Form["path"], Dest: mountPrefix(dir) + name, Password: r.FormValue("password")}
+	if _, status, err := createArchive(r, req); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Redirect(w, r, listURL(dir), http.StatusSeeOther)
+}
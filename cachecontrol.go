@@ -0,0 +1,100 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheRule 是一条 -cache-control 规则：match 为 listing（列表页和 JSON 列表）、type:<MIME 类型>（可以以 /* 结尾）
+// 或路径 glob（不含 "/" 时匹配文件名，否则匹配完整路径）
+type cacheRule struct {
+	match string
+	value string
+}
+
+// cacheRulesFlag 是可重复的 -cache-control MATCH=VALUE 参数，按顺序匹配，第一条匹配的规则生效
+type cacheRulesFlag []cacheRule
+
+func (c *cacheRulesFlag) String() string {
+	var parts []string
+	for _, rule := range *c {
+		parts = append(parts, rule.match+"="+rule.value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *cacheRulesFlag) Set(v string) error {
+	match, value, ok := strings.Cut(v, "=")
+	match, value = strings.TrimSpace(match), strings.TrimSpace(value)
+	if !ok || match == "" || value == "" {
+		return fmt.Errorf("invalid cache rule %q, expected pattern=value such as '*.js=public, max-age=31536000, immutable'", v)
+	}
+	pattern := strings.TrimPrefix(match, "type:")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", match)
+	}
+	*c = append(*c, cacheRule{match: match, value: value})
+	return nil
+}
+
+// cacheRules 由 -cache-control 设置；没有规则匹配时不发送 Cache-Control
+var cacheRules cacheRulesFlag
+
+// maxAgePattern 从 Cache-Control 中取出 max-age，用于生成 Expires
+var maxAgePattern = regexp.MustCompile(`(?:^|[,\s])max-age=(\d+)`)
+
+// matches 判断规则是否匹配虚拟路径 name 和类型 ctype；listing 规则只匹配列表
+func (rule cacheRule) matches(name, ctype string) bool {
+	if rule.match == "listing" {
+		return false
+	}
+	if pattern, ok := strings.CutPrefix(rule.match, "type:"); ok {
+		mediaType, _, _ := strings.Cut(ctype, ";")
+		ok, _ := path.Match(pattern, strings.TrimSpace(mediaType))
+		return ok
+	}
+	target := path.Base(name)
+	if strings.Contains(rule.match, "/") {
+		target = strings.TrimPrefix(name, "/")
+	}
+	ok, _ := path.Match(strings.TrimPrefix(rule.match, "/"), target)
+	return ok
+}
+
+// setCacheHeaders 发送 Cache-Control 和对应的 Expires：有 max-age 时为相应的过期时间，no-store 或 no-cache 时为已过期
+func setCacheHeaders(w http.ResponseWriter, value string) {
+	h := w.Header()
+	h.Set("Cache-Control", value)
+	if m := maxAgePattern.FindStringSubmatch(value); m != nil {
+		if secs, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			h.Set("Expires", time.Now().Add(time.Duration(secs)*time.Second).UTC().Format(http.TimeFormat))
+		}
+	} else if strings.Contains(value, "no-store") || strings.Contains(value, "no-cache") {
+		h.Set("Expires", "0")
+	}
+}
+
+// applyCacheControl 为文件响应应用第一条匹配的 -cache-control 规则
+func applyCacheControl(w http.ResponseWriter, name, ctype string) {
+	for _, rule := range cacheRules {
+		if rule.matches(name, ctype) {
+			setCacheHeaders(w, rule.value)
+			return
+		}
+	}
+}
+
+// applyListingCacheControl 为列表页和 JSON 列表应用 listing 规则
+func applyListingCacheControl(w http.ResponseWriter) {
+	for _, rule := range cacheRules {
+		if rule.match == "listing" {
+			setCacheHeaders(w, rule.value)
+			return
+		}
+	}
+}
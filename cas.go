@@ -0,0 +1,414 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// casEnabled 由 -cas 设置：服务目录中的文件内容按 SHA-256 存放在 .fileserver/blobs 中，目录树里只保存引用，
+// 相同内容只存一份，复制文件只需复制引用；不再被引用的内容由垃圾回收删除
+var casEnabled bool
+
+// 引用文件的内容为一行 "fileserver-blob sha256:<64 位十六进制> <大小>"；只有大小在 casRefMin 到 casRefMax 之间的文件才可能是引用
+const (
+	casRefMagic = "fileserver-blob sha256:"
+	casRefMin   = len(casRefMagic) + 64 + 3
+	casRefMax   = len(casRefMagic) + 64 + 21
+)
+
+// casBlobGrace 是新写入或刚被再次引用的内容在垃圾回收中受保护的时间，避免删除引用尚未写入的内容
+const casBlobGrace = time.Hour
+
+// casTreeChanges 在引用被移动或复制时递增；垃圾回收扫描期间有变化时本轮不删除，以免漏看正在移动的引用
+var casTreeChanges atomic.Int64
+
+// casGCMu 保证同一时间只有一次垃圾回收
+var casGCMu sync.Mutex
+
+// casStorage 在目录树中以引用文件代替文件内容，内容按哈希存放在 blobs 目录下的 <前两位>/<哈希>。
+// 不是引用的已有文件按原样读取。它不提供本地路径，需要直接读取磁盘文件的功能（视频封面、HLS、硬链接去重、监视等）不可用
+type casStorage struct {
+	storage
+	blobs string
+}
+
+// setupCAS 在启用 -cas 时将服务目录的存储包装为按内容寻址的存储；挂载点不受影响
+func setupCAS() error {
+	if !casEnabled {
+		return nil
+	}
+	if masterAEAD != nil {
+		return errors.New("-cas cannot be combined with encryption at rest")
+	}
+	blobs := filepath.Join(uploadDir, metaDirName, "blobs")
+	if err := os.MkdirAll(filepath.Join(blobs, "tmp"), 0700); err != nil {
+		return err
+	}
+	rootStorage = &casStorage{storage: rootStorage, blobs: blobs}
+	logger.Printf("Storing file contents by hash in %s", blobs)
+	return nil
+}
+
+// casStorageFor 在启用 -cas 时以服务目录的内容存储包装 s（如用户主目录），否则原样返回
+func casStorageFor(s storage) storage {
+	root, ok := rootStorage.(*casStorage)
+	if !ok {
+		return s
+	}
+	if _, ok := s.(*casStorage); ok {
+		return s
+	}
+	return &casStorage{storage: s, blobs: root.blobs}
+}
+
+// blobPath 返回内容的存放路径
+func (c *casStorage) blobPath(sum string) string {
+	return filepath.Join(c.blobs, sum[:2], sum)
+}
+
+// parseRef 解析引用文件的内容
+func parseRef(data []byte) (string, int64, bool) {
+	rest, ok := bytes.CutPrefix(bytes.TrimSpace(data), []byte(casRefMagic))
+	if !ok {
+		return "", 0, false
+	}
+	sum, size, ok := strings.Cut(string(rest), " ")
+	if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != 64 {
+		return "", 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	return sum, n, true
+}
+
+// readRef 读取目录树中的引用，不是引用时返回 false
+func (c *casStorage) readRef(name string, info fs.FileInfo) (string, int64, bool) {
+	if !info.Mode().IsRegular() || info.Size() < int64(casRefMin) || info.Size() > int64(casRefMax) {
+		return "", 0, false
+	}
+	f, err := c.storage.Open(name)
+	if err != nil {
+		return "", 0, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(casRefMax)))
+	if err != nil {
+		return "", 0, false
+	}
+	return parseRef(data)
+}
+
+// writeRef 在目录树中写入引用
+func (c *casStorage) writeRef(name, sum string, size int64) error {
+	w, err := c.storage.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s %d\n", casRefMagic, sum, size)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// contentInfo 对引用返回内容的大小
+func (c *casStorage) contentInfo(name string, info fs.FileInfo) fs.FileInfo {
+	if _, size, ok := c.readRef(name, info); ok {
+		return plainSizeInfo{info, size}
+	}
+	return info
+}
+
+func (c *casStorage) List(name string) ([]fs.FileInfo, error) {
+	infos, err := c.storage.List(name)
+	for i, info := range infos {
+		infos[i] = c.contentInfo(path.Join(name, info.Name()), info)
+	}
+	return infos, err
+}
+
+func (c *casStorage) Stat(name string) (fs.FileInfo, error) {
+	info, err := c.storage.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.contentInfo(name, info), nil
+}
+
+func (c *casStorage) Walk(name string, fn walkFunc) error {
+	return c.storage.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err == nil {
+			info = c.contentInfo(p, info)
+		}
+		return fn(p, info, err)
+	})
+}
+
+// Open 打开引用指向的内容，文件信息的名称和修改时间仍取自目录树中的条目
+func (c *casStorage) Open(name string) (storageFile, error) {
+	info, err := c.storage.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	sum, size, ok := c.readRef(name, info)
+	if !ok {
+		return c.storage.Open(name)
+	}
+	f, err := os.Open(c.blobPath(sum))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: content %s is missing", name, sum[:12])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &casFile{File: f, info: plainSizeInfo{info, size}}, nil
+}
+
+// casFile 是打开的内容文件，Stat 返回目录树中条目的信息
+type casFile struct {
+	*os.File
+	info fs.FileInfo
+}
+
+func (f *casFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// Create 返回的写入器先将内容写入 blobs/tmp 并计算哈希，关闭时将其移入 blobs（已有相同内容时丢弃），然后写入引用
+func (c *casStorage) Create(name string) (io.WriteCloser, error) {
+	tmp, err := os.CreateTemp(filepath.Join(c.blobs, "tmp"), "blob-")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	return &casWriter{c: c, name: name, tmp: tmp, h: h, w: io.MultiWriter(tmp, h)}, nil
+}
+
+type casWriter struct {
+	c    *casStorage
+	name string
+	tmp  *os.File
+	h    hash.Hash
+	w    io.Writer
+	size int64
+}
+
+func (w *casWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *casWriter) Close() error {
+	err := w.tmp.Close()
+	if err != nil {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+	sum := hex.EncodeToString(w.h.Sum(nil))
+	if err := w.c.storeBlob(w.tmp.Name(), sum); err != nil {
+		return err
+	}
+	return w.c.writeRef(w.name, sum, w.size)
+}
+
+// storeBlob 将临时文件移到内容的存放位置；内容已存在时删除临时文件，并更新已有内容的修改时间使其不被垃圾回收
+func (c *casStorage) storeBlob(tmp, sum string) error {
+	dst := c.blobPath(sum)
+	if _, err := os.Stat(dst); err == nil {
+		os.Remove(tmp)
+		now := time.Now()
+		return os.Chtimes(dst, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chmod(tmp, 0444)
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (c *casStorage) Rename(oldName, newName string) error {
+	casTreeChanges.Add(1)
+	return c.storage.Rename(oldName, newName)
+}
+
+// ContentHash 返回引用中记录的 SHA-256，不必读取内容
+func (c *casStorage) ContentHash(name string) (string, bool) {
+	info, err := c.storage.Stat(name)
+	if err != nil {
+		return "", false
+	}
+	sum, _, ok := c.readRef(name, info)
+	return sum, ok
+}
+
+// Clone 复制 src 中 name 处文件的引用到 target，不复制内容；src 不是同一内容存储或文件不是引用时返回 errors.ErrUnsupported
+func (c *casStorage) Clone(src storage, name, target string) error {
+	s, ok := src.(*casStorage)
+	if !ok || s.blobs != c.blobs {
+		return errors.ErrUnsupported
+	}
+	info, err := s.storage.Stat(name)
+	if err != nil {
+		return err
+	}
+	sum, size, ok := s.readRef(name, info)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	casTreeChanges.Add(1)
+	now := time.Now()
+	if err := os.Chtimes(c.blobPath(sum), now, now); err != nil {
+		return err
+	}
+	return c.writeRef(target, sum, size)
+}
+
+// casStats 是内容存储的统计和垃圾回收结果
+type casStats struct {
+	Files       int   `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	PlainFiles  int   `json:"plain_files"`
+	Blobs       int   `json:"blobs"`
+	StoredBytes int64 `json:"stored_bytes"`
+	Unused      int   `json:"unused"`
+	UnusedBytes int64 `json:"unused_bytes"`
+	Removed     int   `json:"removed"`
+	Freed       int64 `json:"freed"`
+	// Skipped 表示扫描期间有文件被移动或复制，本轮没有删除
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// casCollect 统计目录树中的引用和 blobs 中的内容；sweep 为 true 时删除不再被引用、且超过保护期的内容和遗留的临时文件
+func casCollect(sweep bool) (casStats, error) {
+	var st casStats
+	c, ok := rootStorage.(*casStorage)
+	if !ok {
+		return st, errors.New("content-addressable storage is not enabled (-cas)")
+	}
+	casGCMu.Lock()
+	defer casGCMu.Unlock()
+
+	before := casTreeChanges.Load()
+	used := map[string]bool{}
+	err := c.storage.Walk("", func(name string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if name == metaDirName {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if sum, size, ok := c.readRef(name, info); ok {
+			used[sum] = true
+			st.Files++
+			st.Bytes += size
+		} else if info.Mode().IsRegular() {
+			st.PlainFiles++
+		}
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+	if sweep && casTreeChanges.Load() != before {
+		sweep, st.Skipped = false, true
+	}
+
+	cutoff := time.Now().Add(-casBlobGrace)
+	dirs, err := os.ReadDir(c.blobs)
+	if err != nil {
+		return st, err
+	}
+	for _, d := range dirs {
+		dir := filepath.Join(c.blobs, d.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if d.Name() == "tmp" {
+				if sweep && info.ModTime().Before(cutoff) && os.Remove(filepath.Join(dir, e.Name())) == nil {
+					st.Removed++
+					st.Freed += info.Size()
+				}
+				continue
+			}
+			st.Blobs++
+			st.StoredBytes += info.Size()
+			if used[e.Name()] {
+				continue
+			}
+			st.Unused++
+			st.UnusedBytes += info.Size()
+			if sweep && info.ModTime().Before(cutoff) {
+				if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+					logger.Printf("Error removing unused content %s: %v", e.Name(), err)
+					continue
+				}
+				st.Removed++
+				st.Freed += info.Size()
+			}
+		}
+	}
+	if st.Removed > 0 {
+		logger.Printf("Content store: removed %d unused blobs (%s)", st.Removed, formatSize(st.Freed))
+	}
+	if st.Skipped {
+		logger.Printf("Content store: files were moved during the scan, collecting garbage next time")
+	}
+	return st, nil
+}
+
+// apiCASHandler 处理 /api/v1/cas（仅管理员）：GET 返回内容存储的统计，POST 立即进行垃圾回收
+func apiCASHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !casEnabled {
+		http.Error(w, "Content-addressable storage is not enabled (-cas)", http.StatusNotFound)
+		return
+	}
+	var sweep bool
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		sweep = true
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := casCollect(sweep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,202 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// checksumEntry 是缓存的文件 SHA-256，文件大小或修改时间变化后失效
+type checksumEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// checksumCache 是保存在元数据库 checksums bucket 中的校验和缓存，按虚拟路径索引，
+// 避免对未变化的大文件重复计算哈希；changed 记录尚未写入数据库的路径
+type checksumCache struct {
+	mu      sync.Mutex
+	items   map[string]checksumEntry
+	changed map[string]bool
+	dirty   bool
+}
+
+var checksums *checksumCache
+
+// checksumSaveDelay 是缓存变化后延迟写盘的时间，合并短时间内的多次更新
+const checksumSaveDelay = 5 * time.Second
+
+// loadChecksumCache 从元数据库加载校验和缓存
+func loadChecksumCache() *checksumCache {
+	c := &checksumCache{items: make(map[string]checksumEntry), changed: make(map[string]bool)}
+	dbEach(bucketChecksums, func(k, v []byte) error {
+		var e checksumEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			logger.Printf("Error parsing checksum of %s: %v", k, err)
+			return nil
+		}
+		c.items[string(k)] = e
+		return nil
+	})
+	return c
+}
+
+// lookup 返回仍然有效的缓存校验和
+func (c *checksumCache) lookup(virtual string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[virtual]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return e.SHA256, true
+}
+
+// entry 返回路径记录的校验和及记录时的文件大小和修改时间，不论文件此后是否变化
+func (c *checksumCache) entry(virtual string) (checksumEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[virtual]
+	return e, ok
+}
+
+// put 记录文件的校验和，并安排写盘
+func (c *checksumCache) put(virtual string, info os.FileInfo, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[virtual] = checksumEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	c.changed[virtual] = true
+	c.markDirtyLocked()
+}
+
+// markDirtyLocked 安排延迟写盘，调用时需持有 c.mu
+func (c *checksumCache) markDirtyLocked() {
+	if !c.dirty {
+		c.dirty = true
+		time.AfterFunc(checksumSaveDelay, c.save)
+	}
+}
+
+// forget 删除路径及其下所有条目的缓存
+func (c *checksumCache) forget(virtual string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := virtual + "/"
+	for p := range c.items {
+		if p == virtual || virtual == "" || strings.HasPrefix(p, prefix) {
+			delete(c.items, p)
+			c.changed[p] = true
+			c.markDirtyLocked()
+		}
+	}
+}
+
+// move 将路径及其下所有条目的缓存移到新路径，替换新路径下原有的条目
+func (c *checksumCache) move(from, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.items {
+		if p == to || strings.HasPrefix(p, to+"/") {
+			delete(c.items, p)
+			c.changed[p] = true
+		}
+	}
+	for p, e := range c.items {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(c.items, p)
+			c.changed[p] = true
+			c.items[to+strings.TrimPrefix(p, from)] = e
+			c.changed[to+strings.TrimPrefix(p, from)] = true
+		}
+	}
+	c.markDirtyLocked()
+}
+
+// save 在一个事务中写入变化的条目，删除已失效的条目
+func (c *checksumCache) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty = false
+	if len(c.changed) == 0 || metaDB == nil {
+		return
+	}
+	err := dbUpdate(bucketChecksums, func(b *bolt.Bucket) error {
+		for p := range c.changed {
+			e, ok := c.items[p]
+			if !ok {
+				if err := b.Delete([]byte(p)); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(p), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		c.changed = make(map[string]bool)
+	}
+}
+
+// fileChecksum 返回卷中文件内容的 SHA-256，文件未变化时使用缓存
+func fileChecksum(vol volume, name string) (string, error) {
+	info, err := vol.store.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if h, ok := vol.store.(contentHasher); ok {
+		if sum, ok := h.ContentHash(name); ok {
+			return sum, nil
+		}
+	}
+	virtual := vol.virtual(name)
+	if sum, ok := checksums.lookup(virtual, info); ok {
+		return sum, nil
+	}
+	f, err := vol.store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	// 计算期间文件被修改时不缓存
+	if after, err := vol.store.Stat(name); err == nil && after.Size() == info.Size() && after.ModTime().Equal(info.ModTime()) {
+		checksums.put(virtual, info, sum)
+	}
+	return sum, nil
+}
+
+// rememberChecksum 记录上传时已经算出的校验和
+func rememberChecksum(vol volume, name, sum string) {
+	if sum == "" {
+		return
+	}
+	if info, err := vol.store.Stat(name); err == nil && !info.IsDir() {
+		checksums.put(vol.virtual(name), info, sum)
+	}
+}
+
+// forgetChecksum 在服务器改写了文件但没有算出新校验和时删除旧记录，以免完整性检查把改写当作在服务器之外的修改
+func forgetChecksum(vol volume, name string) {
+	if checksums != nil && name != "" {
+		checksums.forget(vol.virtual(name))
+	}
+}
@@ -0,0 +1,267 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClamAV 扫描配置
+var (
+	clamdAddr     string
+	quarantineDir string
+)
+
+// clamdChunkSize 是 INSTREAM 每个数据块的大小
+const clamdChunkSize = 64 * 1024
+
+// infectedError 表示上传内容被 clamd 判定为感染
+type infectedError struct {
+	name      string
+	signature string
+}
+
+func (e *infectedError) Error() string {
+	return fmt.Sprintf("infected content rejected: %s (%s)", e.name, e.signature)
+}
+
+// errScannerUnavailable 表示无法完成病毒扫描，上传将被拒绝
+var errScannerUnavailable = errors.New("virus scanner unavailable")
+
+// dialClamd 连接 clamd，支持 tcp://host:port、unix:///path、host:port 以及套接字文件路径
+func dialClamd() (net.Conn, error) {
+	network, addr := "tcp", clamdAddr
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, addr = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		addr = strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "/"):
+		network = "unix"
+	}
+	return net.DialTimeout(network, addr, 10*time.Second)
+}
+
+// clamdScan 使用 INSTREAM 命令扫描数据流，返回命中的病毒签名（未感染时为空）
+func clamdScan(r io.Reader) (string, error) {
+	conn, err := dialClamd()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Minute))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	result := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	result = strings.TrimPrefix(result, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", result)
+	}
+}
+
+// scanFile 扫描磁盘上的文件，name 用于日志和错误信息
+func scanFile(path, name string) error {
+	if clamdAddr == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanReader(f, name)
+}
+
+// scanTempFile 扫描暂存在本地的上传文件，感染时隔离，扫描失败时删除
+func scanTempFile(path, name string) error {
+	err := scanFile(path, name)
+	if _, ok := err.(*infectedError); ok {
+		quarantineFile(path, name)
+	} else if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// scanUpload 扫描暂存在 tmp、将保存为 name 的上传文件，感染时以 name 的文件名隔离，扫描失败时删除暂存文件
+func scanUpload(store storage, tmp, name string) error {
+	if clamdAddr == "" {
+		return nil
+	}
+	f, err := store.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = scanReader(f, name)
+	f.Close()
+	if _, ok := err.(*infectedError); ok {
+		quarantineStored(store, tmp, name)
+	} else if err != nil {
+		store.Delete(tmp)
+	}
+	return err
+}
+
+// rejectScan 根据扫描错误返回相应的 HTTP 状态
+func rejectScan(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), scanErrorStatus(err))
+}
+
+// scanErrorStatus 返回扫描失败对应的状态码：发现病毒为 403，扫描器不可用为 503
+func scanErrorStatus(err error) int {
+	if _, ok := err.(*infectedError); ok {
+		return http.StatusForbidden
+	}
+	return http.StatusServiceUnavailable
+}
+
+// scanArchiveEntries 逐个扫描归档中的文件条目，在解压之前调用
+func scanArchiveEntries(path string, format archiveFormat) error {
+	if clamdAddr == "" {
+		return nil
+	}
+	return walkArchive(path, format, func(name string, isDir bool, r io.Reader) error {
+		if isDir {
+			return nil
+		}
+		return scanReader(r, name)
+	})
+}
+
+func scanReader(r io.Reader, name string) error {
+	signature, err := clamdScan(r)
+	if err != nil {
+		logger.Printf("Virus scan of %s failed: %v", name, err)
+		return errScannerUnavailable
+	}
+	if signature != "" {
+		logger.Printf("Virus detected in %s: %s", name, signature)
+		return &infectedError{name: name, signature: signature}
+	}
+	return nil
+}
+
+// quarantineFile 将被感染的文件移动到隔离目录；未配置隔离目录时直接删除
+func quarantineFile(path, name string) {
+	if quarantineDir == "" {
+		os.Remove(path)
+		return
+	}
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		logger.Printf("Error creating quarantine dir: %v", err)
+		os.Remove(path)
+		return
+	}
+	dst := filepath.Join(quarantineDir, time.Now().Format("20060102150405")+"_"+filepath.Base(name))
+	if err := moveFile(path, dst); err != nil {
+		logger.Printf("Error quarantining %s: %v", path, err)
+		os.Remove(path)
+		return
+	}
+	logger.Printf("Quarantined %s to %s", name, dst)
+}
+
+// quarantineStored 将存储中被感染的暂存文件 tmp 以 name 的文件名复制到隔离目录后删除
+func quarantineStored(store storage, tmp, name string) {
+	defer store.Delete(tmp)
+	if quarantineDir == "" {
+		return
+	}
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		logger.Printf("Error creating quarantine dir: %v", err)
+		return
+	}
+	in, err := store.Open(tmp)
+	if err != nil {
+		logger.Printf("Error quarantining %s: %v", name, err)
+		return
+	}
+	defer in.Close()
+	dst := filepath.Join(quarantineDir, time.Now().Format("20060102150405")+"_"+filepath.Base(name))
+	out, err := os.Create(dst)
+	if err != nil {
+		logger.Printf("Error quarantining %s: %v", name, err)
+		return
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logger.Printf("Error quarantining %s: %v", name, err)
+		os.Remove(dst)
+		return
+	}
+	logger.Printf("Quarantined %s to %s", name, dst)
+}
+
+// moveFile 移动文件，跨设备时退化为复制后删除
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,371 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"file-server/client"
+)
+
+// clientCommands 是除 serve 以外、通过 JSON API 访问运行中服务器的子命令
+var clientCommands = map[string]func(args []string) error{
+	"upload":   cmdUpload,
+	"download": cmdDownload,
+	"ls":       cmdList,
+	"sync":     cmdSync,
+	"export":   cmdExport,
+	"import":   cmdImport,
+}
+
+const usageText = `Usage:
+  fileserver [serve] [flags]                 start the server (default)
+  fileserver upload [flags] <file|dir>... <url>
+  fileserver download [flags] <url> <path>
+  fileserver ls [flags] <url> [dir]
+  fileserver sync [flags] <local-dir> <url>
+  fileserver sync [flags] -from <url> -to <url>
+  fileserver export [flags] <url> [dir]      save a folder with its metadata as a tar archive
+  fileserver import [flags] <archive> <url>  restore an exported archive
+  fileserver start [flags]                   start the server in the background
+  fileserver stop [flags]                    stop a server started in the background
+  fileserver install [flags]                 install the server as a Windows service
+  fileserver uninstall                       remove the Windows service
+
+Run "fileserver <command> -h" for the flags of a command.
+`
+
+// clientFlags 注册客户端子命令共用的参数
+func clientFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	auth := fs.String("user", os.Getenv("FILESERVER_USER"), "Credentials as name:password (default $FILESERVER_USER)")
+	return fs, auth
+}
+
+// newClient 创建访问服务器的客户端，-user 参数优先于 URL 中的凭据
+func newClient(rawURL, auth string) (*client.Client, error) {
+	c, err := client.New(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		c.Username, c.Password, _ = strings.Cut(auth, ":")
+	}
+	return c, nil
+}
+
+func cmdUpload(args []string) error {
+	fs, auth := clientFlags("upload")
+	dir := fs.String("dir", "", "Target mount alias (default: the served directory)")
+	extract := fs.Bool("extract", false, "Extract uploaded .zip, .tar, .tar.gz, .tar.bz2 and .7z archives into folders on the server")
+	keepArchive := fs.Bool("keep-archive", false, "Keep each extracted archive, including zipped folders, next to its folder on the server")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: fileserver upload [flags] <file|dir>... <url>")
+	}
+	c, err := newClient(fs.Arg(fs.NArg()-1), *auth)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for _, local := range fs.Args()[:fs.NArg()-1] {
+		if err := c.UploadFile(ctx, local, &client.UploadOptions{Dir: *dir, Extract: *extract, KeepArchive: *keepArchive}); err != nil {
+			return fmt.Errorf("upload %s: %w", local, err)
+		}
+		fmt.Printf("Uploaded %s\n", local)
+	}
+	return nil
+}
+
+func cmdDownload(args []string) error {
+	fs, auth := clientFlags("download")
+	output := fs.String("o", "", "Output file (default: name of the remote file; directories are saved as .zip)")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: fileserver download [flags] <url> <path>")
+	}
+	c, err := newClient(fs.Arg(0), *auth)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	src, err := c.Open(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out := *output
+	if out == "" {
+		out = src.Name
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s (%s)\n", out, formatSize(n))
+	return nil
+}
+
+func cmdList(args []string) error {
+	fs, auth := clientFlags("ls")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: fileserver ls [flags] <url> [dir]")
+	}
+	c, err := newClient(fs.Arg(0), *auth)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	entries, err := c.List(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		size := formatSize(e.Size)
+		name := e.Name
+		if e.IsDir {
+			size = "-"
+			name += "/"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.ModTime.Local().Format("2006-01-02 15:04"), size, name)
+	}
+	return tw.Flush()
+}
+
+// cmdExport 将服务器上的目录连同标签和分享链接保存为 tar 归档，用于备份或迁移到其他主机
+func cmdExport(args []string) error {
+	fs, auth := clientFlags("export")
+	output := fs.String("o", "", "Output file (default fileserver-export-<date>.tar, - for stdout)")
+	compress := fs.Bool("z", false, "Compress the archive with gzip")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: fileserver export [flags] <url> [dir]")
+	}
+	c, err := newClient(fs.Arg(0), *auth)
+	if err != nil {
+		return err
+	}
+	out := *output
+	if out == "" {
+		out = "fileserver-export-" + time.Now().Format("20060102") + ".tar"
+		if *compress {
+			out += ".gz"
+		}
+	}
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if out != "-" {
+		if f, err = os.Create(out); err != nil {
+			return err
+		}
+		w = f
+	}
+	n, err := c.Export(context.Background(), fs.Arg(1), *compress, w, nil)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		fmt.Printf("Saved %s (%s)\n", out, formatSize(n))
+	}
+	return nil
+}
+
+// cmdImport 将 export 保存的归档恢复到服务器
+func cmdImport(args []string) error {
+	fs, auth := clientFlags("import")
+	dir := fs.String("dir", "", "Folder to restore into (default: the served directory)")
+	overwrite := fs.Bool("overwrite", false, "Replace files that already exist on the server")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: fileserver import [flags] <archive> <url>")
+	}
+	c, err := newClient(fs.Arg(1), *auth)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size := int64(-1)
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	res, err := c.Import(context.Background(), f, size, &client.ImportOptions{Dir: *dir, Overwrite: *overwrite})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d files (%s) and %d folders, skipped %d; %d tags, %d share links\n",
+		res.Files, formatSize(res.Bytes), res.Dirs, res.Skipped, res.Tags, res.Shares)
+	return nil
+}
+
+// cmdSync 将本地目录中服务器上还没有的文件和文件夹上传；服务器上已存在的同名条目不会被覆盖
+func cmdSync(args []string) error {
+	fs, auth := clientFlags("sync")
+	dir := fs.String("dir", "", "Target mount alias (default: the served directory)")
+	dryRun := fs.Bool("n", false, "Only print what would be uploaded")
+	from := fs.String("from", "", "Source server URL for server-to-server sync")
+	to := fs.String("to", "", "Destination server URL for server-to-server sync")
+	subtree := fs.String("path", "", "Folder to sync with -from/-to (default: the whole tree)")
+	fs.Parse(args)
+	if *from != "" || *to != "" {
+		if *from == "" || *to == "" || fs.NArg() != 0 {
+			return fmt.Errorf("usage: fileserver sync [flags] -from <url> -to <url>")
+		}
+		return mirror(*from, *to, *auth, strings.Trim(*subtree, "/"), *dryRun)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: fileserver sync [flags] <local-dir> <url>")
+	}
+	c, err := newClient(fs.Arg(1), *auth)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	remoteEntries, err := c.List(ctx, *dir)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]client.Entry, len(remoteEntries))
+	for _, e := range remoteEntries {
+		existing[e.Name] = e
+	}
+
+	localEntries, err := os.ReadDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	uploaded, skipped := 0, 0
+	for _, de := range localEntries {
+		if de.Name() == metaDirName {
+			continue
+		}
+		if e, ok := existing[de.Name()]; ok {
+			if info, err := de.Info(); err == nil && !de.IsDir() && info.Size() != e.Size {
+				fmt.Printf("Skipping %s: differs from the server copy\n", de.Name())
+			}
+			skipped++
+			continue
+		}
+		local := filepath.Join(fs.Arg(0), de.Name())
+		if *dryRun {
+			fmt.Printf("Would upload %s\n", local)
+			continue
+		}
+		if err := c.UploadFile(ctx, local, &client.UploadOptions{Dir: *dir}); err != nil {
+			return fmt.Errorf("upload %s: %w", local, err)
+		}
+		fmt.Printf("Uploaded %s\n", local)
+		uploaded++
+	}
+	fmt.Printf("%d uploaded, %d already on the server\n", uploaded, skipped)
+	return nil
+}
+
+// mirror 让目标服务器上的文件与源服务器一致：按路径和大小比较，大小相同时再比较 SHA-256，
+// 只传输缺少或内容不同的文件；目标上多出的文件保持不变
+func mirror(fromURL, toURL, auth, root string, dryRun bool) error {
+	src, err := newClient(fromURL, auth)
+	if err != nil {
+		return err
+	}
+	dst, err := newClient(toURL, auth)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	srcFiles, err := src.Walk(ctx, root)
+	if err != nil {
+		return fmt.Errorf("list %s: %w", fromURL, err)
+	}
+	dstFiles, err := dst.Walk(ctx, root)
+	var notFound *client.Error
+	if errors.As(err, &notFound) && notFound.StatusCode == http.StatusNotFound {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("list %s: %w", toURL, err)
+	}
+	existing := make(map[string]client.FileInfo, len(dstFiles))
+	for _, f := range dstFiles {
+		existing[f.Path] = f
+	}
+
+	copied, unchanged := 0, 0
+	var transferred int64
+	for _, f := range srcFiles {
+		old, found := existing[f.Path]
+		if found && old.Size == f.Size {
+			same, err := sameChecksum(ctx, src, dst, f.Path)
+			if err != nil {
+				return err
+			}
+			if same {
+				unchanged++
+				continue
+			}
+		}
+		if dryRun {
+			fmt.Printf("Would copy %s (%s)\n", f.Path, formatSize(f.Size))
+			continue
+		}
+		if err := copyRemote(ctx, src, dst, f); err != nil {
+			return fmt.Errorf("copy %s: %w", f.Path, err)
+		}
+		fmt.Printf("Copied %s (%s)\n", f.Path, formatSize(f.Size))
+		copied++
+		transferred += f.Size
+	}
+	fmt.Printf("%d copied (%s), %d unchanged\n", copied, formatSize(transferred), unchanged)
+	return nil
+}
+
+func sameChecksum(ctx context.Context, src, dst *client.Client, p string) (bool, error) {
+	a, err := src.Checksum(ctx, p)
+	if err != nil {
+		return false, fmt.Errorf("checksum %s: %w", p, err)
+	}
+	b, err := dst.Checksum(ctx, p)
+	if err != nil {
+		return false, fmt.Errorf("checksum %s: %w", p, err)
+	}
+	return a == b, nil
+}
+
+// copyRemote 将源服务器上的文件流式写入目标服务器，不经过本地磁盘
+func copyRemote(ctx context.Context, src, dst *client.Client, f client.FileInfo) error {
+	r, err := src.Get(ctx, f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.Put(ctx, f.Path, r, f.Size, true)
+}
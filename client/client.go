@@ -0,0 +1,411 @@
+// Package client 提供访问运行中 fileserver 的类型化 Go API，基于服务器的 HTTP 和 JSON 接口。
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Client 是 fileserver 客户端，零值不可用，请使用 New 创建
+type Client struct {
+	base *url.URL
+
+	// Username 和 Password 在服务器启用认证时用于 HTTP Basic 认证
+	Username string
+	Password string
+
+	// HTTPClient 用于发送请求，默认为 http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Entry 是目录中的单个文件或目录
+type Entry struct {
+	Name     string    `json:"name"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	MimeType string    `json:"mime_type,omitempty"`
+	Mount    bool      `json:"mount,omitempty"`
+	ReadOnly bool      `json:"read_only,omitempty"`
+
+	DownloadCount int64      `json:"download_count"`
+	LastDownload  *time.Time `json:"last_download,omitempty"`
+}
+
+// ProgressFunc 报告已传输的字节数；总大小未知时 total 为 -1
+type ProgressFunc func(done, total int64)
+
+// UploadOptions 是上传的可选参数
+type UploadOptions struct {
+	// Dir 是目标挂载点别名，为空表示服务器的默认目录
+	Dir string
+	// Progress 在上传过程中被调用
+	Progress ProgressFunc
+	// ID 是上传 ID，设置后可以在上传过程中用 UploadProgress 查询服务器端的进度
+	ID string
+	// Extract 要求服务器将 .zip、.tar.gz 等归档解压为同名文件夹
+	Extract bool
+	// KeepArchive 要求服务器解压后把归档本身也保存在文件夹旁
+	KeepArchive bool
+}
+
+// Error 是服务器返回的非 2xx 响应
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fileserver: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// New 创建客户端；baseURL 可以省略协议（默认 http），也可以在 userinfo 中携带凭据
+func New(baseURL string) (*Client, error) {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", baseURL, err)
+	}
+	c := &Client{base: u}
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return c, nil
+}
+
+func (c *Client) url(p string, query url.Values) string {
+	u := *c.base
+	u.Path += p
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// do 发送请求，非 2xx 响应转换为 *Error
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// API 的错误响应为 {"error": ..., "status": ...}
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(msg, &apiErr) == nil && apiErr.Error != "" {
+			msg = []byte(apiErr.Error)
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// List 列出目录内容；dir 为空表示服务器的默认目录，否则为挂载点别名
+func (c *Client) List(ctx context.Context, dir string) ([]Entry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/api/v1/list", url.Values{"dir": {dir}}), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Upload 以 name 为文件名上传 r 的内容；名称以 .up 结尾时服务器会将内容作为 ZIP 解压为文件夹
+// size 用于进度报告，未知时传 -1
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, size int64, opts *UploadOptions) error {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	if opts.Progress != nil {
+		r = &progressReader{r: r, total: size, fn: opts.Progress}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := mw.WriteField("dir", opts.Dir)
+		if err == nil {
+			var part io.Writer
+			if part, err = mw.CreateFormFile("file", name); err == nil {
+				_, err = io.Copy(part, r)
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// 目录同时放在查询参数中，服务器可以在接收请求体前检查剩余空间
+	query := url.Values{"dir": {opts.Dir}}
+	if opts.Extract {
+		query.Set("extract", "1")
+	}
+	if opts.KeepArchive {
+		query.Set("keep_archive", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/upload", query), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if opts.ID != "" {
+		req.Header.Set("X-Upload-ID", opts.ID)
+	}
+	// 上传成功时服务器重定向到列表页，不需要跟随
+	base := c.HTTPClient
+	if base == nil {
+		base = http.DefaultClient
+	}
+	hc := *base
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther && resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// UploadFile 上传本地文件；目录会被打包为 .up 文件，由服务器解压为同名文件夹
+func (c *Client) UploadFile(ctx context.Context, local string, opts *UploadOptions) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(local)
+	if !info.IsDir() {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return c.Upload(ctx, name, f, info.Size(), opts)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(zipFolder(pw, local))
+	}()
+	defer pr.Close()
+	return c.Upload(ctx, name+".up", pr, -1, opts)
+}
+
+// zipFolder 将本地目录打包为 ZIP 写入 w
+func zipFolder(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			_, err = zw.Create(rel + "/")
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst, err := zw.Create(rel)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// File 是以流式读取方式打开的远程文件，使用后需要关闭
+type File struct {
+	io.ReadCloser
+	// Name 是服务器建议的文件名，目录为 <名称>.zip
+	Name string
+	// Size 是内容长度，未知时为 -1
+	Size        int64
+	ContentType string
+}
+
+// Download 将 path 指向的文件写入 w，目录以 ZIP 格式下载；返回写入的字节数
+func (c *Client) Download(ctx context.Context, path string, w io.Writer, progress ProgressFunc) (int64, error) {
+	f, err := c.Open(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if progress != nil {
+		r = &progressReader{r: f, total: f.Size, fn: progress}
+	}
+	return io.Copy(w, r)
+}
+
+// Open 打开 path 指向的文件以流式读取，目录以 ZIP 格式返回
+func (c *Client) Open(ctx context.Context, path string) (*File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/download", url.Values{"path": {path}, "disposition": {"attachment"}}), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{ReadCloser: resp.Body, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		f.Name = filepath.Base(params["filename"])
+	}
+	if f.Name == "" || f.Name == "." || f.Name == "/" {
+		f.Name = filepath.Base(strings.Trim(path, "/"))
+	}
+	return f, nil
+}
+
+// Delete 删除 path 指向的文件或目录
+func (c *Client) Delete(ctx context.Context, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.url("/api/v1/delete", url.Values{"path": {path}}), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ShareLink 是服务器创建的公开分享链接
+type ShareLink struct {
+	Token    string     `json:"token"`
+	Code     string     `json:"code"`
+	Path     string     `json:"path"`
+	URL      string     `json:"url"`
+	ShortURL string     `json:"short_url"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Share 为 path 创建无需登录即可访问的分享链接；expires 为 0 表示永不过期
+func (c *Client) Share(ctx context.Context, path string, expires time.Duration) (*ShareLink, error) {
+	body := map[string]string{"path": path}
+	if expires > 0 {
+		body["expires"] = expires.String()
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/api/v1/shares", nil), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var link ShareLink
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// UploadProgress 是服务器端的上传进度；Received 和 Total 为请求体的字节数，Rate 为接收速度（字节/秒）
+type UploadProgress struct {
+	ID       string     `json:"id"`
+	Path     string     `json:"path,omitempty"`
+	State    string     `json:"state"`
+	Received int64      `json:"received"`
+	Total    int64      `json:"total"`
+	Rate     float64    `json:"rate"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// UploadProgress 查询以 UploadOptions.ID 发起的上传的进度；State 为 receiving、processing、done 或 failed
+func (c *Client) UploadProgress(ctx context.Context, id string) (*UploadProgress, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/api/v1/upload/"+url.PathEscape(id)+"/progress", nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var p UploadProgress
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// progressReader 在读取时报告进度
+type progressReader struct {
+	r     io.Reader
+	done  int64
+	total int64
+	fn    ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.fn(p.done, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Event 是服务器文件树的一条变化，Op 为 create、modify、delete 或 rename（OldPath 为原路径）
+type Event struct {
+	ID      int64     `json:"id"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// EventBatch 是一次 Events 调用返回的变化；Cursor 用于下一次调用，
+// Truncated 表示游标之后的部分变化已被服务器丢弃，调用方需要重新同步
+type EventBatch struct {
+	Cursor    int64   `json:"cursor"`
+	Events    []Event `json:"events"`
+	Truncated bool    `json:"truncated"`
+}
+
+// Events 返回 cursor 之后的变化，没有变化时最多等待 timeout（服务器限制为 5 分钟）；cursor 为负数时从现在开始
+func (c *Client) Events(ctx context.Context, cursor int64, timeout time.Duration) (*EventBatch, error) {
+	query := url.Values{"timeout": {timeout.String()}}
+	if cursor >= 0 {
+		query.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/api/v1/events", query), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out EventBatch
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
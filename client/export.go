@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ImportOptions 是导入的可选参数
+type ImportOptions struct {
+	// Dir 是恢复到的目录，为空表示服务目录
+	Dir string
+	// Overwrite 为 true 时替换已存在的文件，否则跳过
+	Overwrite bool
+	// Progress 在上传归档的过程中被调用
+	Progress ProgressFunc
+}
+
+// ImportResult 是服务器恢复的文件、文件夹、标签和分享链接数量
+type ImportResult struct {
+	Files   int   `json:"files"`
+	Dirs    int   `json:"dirs"`
+	Skipped int   `json:"skipped"`
+	Bytes   int64 `json:"bytes"`
+	Tags    int   `json:"tags"`
+	Shares  int   `json:"shares"`
+}
+
+// Export 将 path 指向的目录（为空表示服务目录）连同标签和分享链接以 tar 格式写入 w，需要管理员权限；
+// gzip 为 true 时服务器压缩为 .tar.gz。返回写入的字节数
+func (c *Client) Export(ctx context.Context, path string, gzip bool, w io.Writer, progress ProgressFunc) (int64, error) {
+	query := url.Values{"path": {path}}
+	if gzip {
+		query.Set("gzip", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/export", query), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var r io.Reader = resp.Body
+	if progress != nil {
+		r = &progressReader{r: resp.Body, total: -1, fn: progress}
+	}
+	return io.Copy(w, r)
+}
+
+// Import 将 Export 生成的归档恢复到服务器，需要管理员权限；size 用于进度报告，未知时传 -1
+func (c *Client) Import(ctx context.Context, r io.Reader, size int64, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	query := url.Values{}
+	if opts.Dir != "" {
+		query.Set("dir", opts.Dir)
+	}
+	if opts.Overwrite {
+		query.Set("overwrite", "true")
+	}
+	if opts.Progress != nil {
+		r = &progressReader{r: r, total: size, fn: opts.Progress}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/import", query), r)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
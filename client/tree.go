@@ -0,0 +1,296 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"file-server/delta"
+)
+
+// 以下方法使用虚拟文件树中的完整路径：服务目录位于顶层，挂载点以文件夹形式出现（与 WebDAV 相同）
+
+// FileInfo 是文件树中的文件或目录
+type FileInfo struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type gqlFile struct {
+	FileInfo
+	Size     float64   `json:"size"`
+	Checksum *string   `json:"checksum"`
+	Children []gqlFile `json:"children"`
+}
+
+// query 执行 GraphQL 查询，返回 file 字段
+func (c *Client) query(ctx context.Context, q string, path string) (*gqlFile, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": q, "variables": map[string]string{"path": path}})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/api/v1/graphql", nil), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Data struct {
+			File *gqlFile `json:"file"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Errors) > 0 {
+		return nil, fmt.Errorf("fileserver: %s", out.Errors[0].Message)
+	}
+	if out.Data.File == nil {
+		return nil, &Error{StatusCode: http.StatusNotFound, Message: "Path not found: " + path}
+	}
+	return out.Data.File, nil
+}
+
+// Walk 递归列出 root 下的所有文件（不含目录），root 为空表示整棵树
+func (c *Client) Walk(ctx context.Context, root string) ([]FileInfo, error) {
+	const q = `query($path: String) { file(path: $path) { path isDir size modTime children { path isDir size modTime } } }`
+	var files []FileInfo
+	dirs := []string{root}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		f, err := c.query(ctx, q, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !f.IsDir {
+			f.FileInfo.Size = int64(f.Size)
+			files = append(files, f.FileInfo)
+			continue
+		}
+		for _, child := range f.Children {
+			if child.IsDir {
+				dirs = append(dirs, child.Path)
+				continue
+			}
+			child.FileInfo.Size = int64(child.Size)
+			files = append(files, child.FileInfo)
+		}
+	}
+	return files, nil
+}
+
+// Stat 返回文件或目录的信息
+func (c *Client) Stat(ctx context.Context, path string) (*FileInfo, error) {
+	f, err := c.query(ctx, `query($path: String) { file(path: $path) { path isDir size modTime } }`, path)
+	if err != nil {
+		return nil, err
+	}
+	f.FileInfo.Size = int64(f.Size)
+	return &f.FileInfo, nil
+}
+
+// ReadDir 列出目录的直接子条目（包括子目录）
+func (c *Client) ReadDir(ctx context.Context, dir string) ([]FileInfo, error) {
+	f, err := c.query(ctx, `query($path: String) { file(path: $path) { path isDir children { path isDir size modTime } } }`, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !f.IsDir {
+		return nil, fmt.Errorf("fileserver: %s is not a directory", dir)
+	}
+	entries := make([]FileInfo, 0, len(f.Children))
+	for _, child := range f.Children {
+		child.FileInfo.Size = int64(child.Size)
+		entries = append(entries, child.FileInfo)
+	}
+	return entries, nil
+}
+
+// BatchOp 是批量请求中的一个操作：Op 为 delete、move 或 copy，move 和 copy 将 Path 放到 To；
+// To 是已存在的目录或以 "/" 结尾时放入该目录
+type BatchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	To   string `json:"to,omitempty"`
+}
+
+// BatchResult 是一个操作的结果，Status 为 ok、failed、skipped 或 rolled back
+type BatchResult struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	To     string `json:"to,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Batch 在一个请求中依次执行最多 1000 个操作，返回每个操作的结果；部分失败不返回错误。
+// atomic 为 true 时任一操作失败会撤销所有操作并返回 *Error（409）；overwrite 允许替换已存在的目标
+func (c *Client) Batch(ctx context.Context, ops []BatchOp, atomic, overwrite bool) ([]BatchResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"operations": ops, "atomic": atomic, "overwrite": overwrite})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/api/v1/batch", nil), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Results, nil
+}
+
+// Checksum 返回文件内容的 SHA-256（十六进制）
+func (c *Client) Checksum(ctx context.Context, path string) (string, error) {
+	f, err := c.query(ctx, `query($path: String) { file(path: $path) { path isDir checksum } }`, path)
+	if err != nil {
+		return "", err
+	}
+	if f.Checksum == nil {
+		return "", fmt.Errorf("fileserver: %s is a directory", path)
+	}
+	return *f.Checksum, nil
+}
+
+// Get 按完整路径打开文件以流式读取
+func (c *Client) Get(ctx context.Context, path string) (*File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/raw/"+path, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &File{ReadCloser: resp.Body, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// Put 将 r 的内容写入完整路径，缺少的父目录会被创建；overwrite 为 false 时服务器会为同名文件生成新名称
+// size 未知时传 -1
+func (c *Client) Put(ctx context.Context, path string, r io.Reader, size int64, overwrite bool) error {
+	query := url.Values{}
+	if overwrite {
+		query.Set("overwrite", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url("/put/"+path, query), r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PutDelta 用本地文件 local 替换服务器上 path 处的文件，只传输与服务器版本不同的块；
+// 服务器上还没有该文件时退化为完整上传。blockSize 为 0 时使用默认块大小
+func (c *Client) PutDelta(ctx context.Context, path, local string, blockSize int) (delta.Stats, error) {
+	var st delta.Stats
+	if blockSize == 0 {
+		blockSize = delta.DefaultBlockSize
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return st, err
+	}
+
+	query := url.Values{"path": {path}, "block": {strconv.Itoa(blockSize)}}
+	sig, err := c.signature(ctx, query)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		st.Literal = info.Size()
+		return st, c.Put(ctx, path, f, info.Size(), true)
+	}
+	if err != nil {
+		return st, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return st, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return st, err
+	}
+	query.Set("checksum", hex.EncodeToString(h.Sum(nil)))
+
+	pr, pw := io.Pipe()
+	done := make(chan delta.Stats, 1)
+	go func() {
+		st, err := delta.Compute(sig, f, pw)
+		pw.CloseWithError(err)
+		done <- st
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/api/v1/delta", query), pr)
+	if err != nil {
+		pr.Close()
+		<-done
+		return st, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(req)
+	pr.Close()
+	st = <-done
+	if err != nil {
+		return st, err
+	}
+	resp.Body.Close()
+	return st, nil
+}
+
+func (c *Client) signature(ctx context.Context, query url.Values) (*delta.Signature, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/api/v1/delta", query), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var sig delta.Signature
+	if err := json.NewDecoder(resp.Body).Decode(&sig); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
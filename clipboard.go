@@ -0,0 +1,312 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clipboardHistory 由 -clipboard-history 设置，是共享剪贴板保留的最近条目数
+var clipboardHistory = 20
+
+// clipMaxSize 是一条剪贴板内容的最大字节数
+const clipMaxSize = 64 << 10
+
+// clipEntry 是共享剪贴板中的一条文本
+type clipEntry struct {
+	ID   int64     `json:"id"`
+	Text string    `json:"text"`
+	User string    `json:"user,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// clipboardStore 保存最近的剪贴板条目，每条是元数据库 clipboard bucket 中以 ID 为键的记录，新条目到来时唤醒所有 SSE 连接。
+// 启用认证时每个用户只能看到自己发送的条目，用于在自己的多台设备间传递文本
+type clipboardStore struct {
+	mu      sync.Mutex
+	entries []clipEntry
+	last    int64
+	wake    chan struct{}
+}
+
+var clipboard *clipboardStore
+
+// loadClipboard 从元数据库加载剪贴板
+func loadClipboard() *clipboardStore {
+	c := &clipboardStore{wake: make(chan struct{})}
+	dbEach(bucketClipboard, func(k, v []byte) error {
+		var e clipEntry
+		if err := json.Unmarshal(v, &e); err != nil {
+			logger.Printf("Error parsing clipboard entry: %v", err)
+			return nil
+		}
+		c.entries = append(c.entries, e)
+		c.last = max(c.last, e.ID)
+		return nil
+	})
+	return c
+}
+
+// add 追加一条文本，每个用户只保留最近 clipboardHistory 条
+func (c *clipboardStore) add(text, user string) clipEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last++
+	e := clipEntry{ID: c.last, Text: text, User: user, Time: time.Now().UTC()}
+	c.entries = append(c.entries, e)
+	count := 0
+	for _, x := range c.entries {
+		if x.User == user {
+			count++
+		}
+	}
+	var dropped [][]byte
+	if count > clipboardHistory {
+		kept := make([]clipEntry, 0, len(c.entries))
+		for _, x := range c.entries {
+			if x.User == user && count > clipboardHistory {
+				count--
+				dropped = append(dropped, seqKey(uint64(x.ID)))
+				continue
+			}
+			kept = append(kept, x)
+		}
+		c.entries = kept
+	}
+	dbPut(bucketClipboard, seqKey(uint64(e.ID)), e)
+	dbDelete(bucketClipboard, dropped...)
+	close(c.wake)
+	c.wake = make(chan struct{})
+	return e
+}
+
+// remove 删除用户的一条条目，id 为 0 时清空用户的剪贴板
+func (c *clipboardStore) remove(id int64, user string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.entries[:0]
+	var keys [][]byte
+	for _, e := range c.entries {
+		if e.User == user && (id == 0 || e.ID == id) {
+			keys = append(keys, seqKey(uint64(e.ID)))
+			continue
+		}
+		kept = append(kept, e)
+	}
+	c.entries = kept
+	dbDelete(bucketClipboard, keys...)
+	return len(keys) > 0
+}
+
+// since 返回用户在 cursor 之后的条目（从旧到新），以及在下一条条目到来时关闭的通道
+func (c *clipboardStore) since(user string, cursor int64) ([]clipEntry, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := []clipEntry{}
+	for _, e := range c.entries {
+		if e.User == user && e.ID > cursor {
+			out = append(out, e)
+		}
+	}
+	return out, c.wake
+}
+
+// apiClipboardHandler 处理 /api/v1/clipboard：GET 返回最近的条目（从新到旧），
+// 请求头 Accept: text/event-stream 时以 SSE 推送新条目；POST 添加一条（纯文本请求体或 JSON {"text": ...}）；
+// DELETE ?id= 删除一条，省略 id 时清空
+func apiClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			streamClipboard(w, r, user)
+			return
+		}
+		entries, _ := clipboard.since(user, 0)
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, clipMaxSize+1))
+		if err != nil {
+			http.Error(w, "Failed to read request", http.StatusBadRequest)
+			return
+		}
+		text := string(data)
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+			var req struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			text = req.Text
+		}
+		if len(data) > clipMaxSize {
+			http.Error(w, "Text too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if strings.TrimSpace(text) == "" {
+			http.Error(w, "Empty text", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, clipboard.add(text, user))
+	case http.MethodDelete:
+		var id int64
+		if v := r.URL.Query().Get("id"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid id", http.StatusBadRequest)
+				return
+			}
+			id = n
+		}
+		if !clipboard.remove(id, user) && id != 0 {
+			http.Error(w, "Entry not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// streamClipboard 以 Server-Sent Events 推送新的剪贴板条目，断线重连时使用 Last-Event-ID 续传
+func streamClipboard(w http.ResponseWriter, r *http.Request, user string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	cursor := clipboard.lastID()
+	if v, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		cursor = v
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		entries, wake := clipboard.since(user, cursor)
+		for _, e := range entries {
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: clip\ndata: %s\n\n", e.ID, data)
+			cursor = e.ID
+		}
+		flusher.Flush()
+		select {
+		case <-wake:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// lastID 返回最新条目的 ID
+func (c *clipboardStore) lastID() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// clipboardPageHandler 处理 /clipboard：发送文本，并实时显示其他设备发送的文本，点击即可复制
+func clipboardPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, clipboardPage)
+}
+
+// clipboardPage 是共享剪贴板页面。navigator.clipboard 只在 HTTPS 下可用，HTTP 下改用 execCommand 复制
+const clipboardPage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Clipboard</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: sans-serif; max-width: 800px; margin: 0 auto; padding: 8px; }
+        textarea { width: 100%; box-sizing: border-box; font-family: monospace; }
+        .clip { border: 1px solid #ddd; border-radius: 4px; margin: 8px 0; padding: 8px; }
+        .clip pre { white-space: pre-wrap; word-break: break-all; margin: 0 0 8px; max-height: 12em; overflow: auto; }
+        .clip button { padding: 8px 16px; margin-right: 8px; }
+        .clip small { color: #888; }
+    </style>
+</head>
+<body>
+    <h1>Clipboard</h1>
+    <p><a href="/">&larr; Back</a></p>
+    <form id="send">
+        <textarea id="text" rows="4" placeholder="Paste text here to send it to your other devices" required></textarea>
+        <p><button type="submit">Send</button> <button type="button" id="clear">Clear all</button></p>
+    </form>
+    <div id="clips"></div>
+    <script>
+    (function () {
+        var list = document.getElementById("clips");
+        function copy(text, button) {
+            function done() { button.textContent = "Copied"; setTimeout(function () { button.textContent = "Copy"; }, 1500); }
+            if (navigator.clipboard && window.isSecureContext) {
+                navigator.clipboard.writeText(text).then(done);
+                return;
+            }
+            var ta = document.createElement("textarea");
+            ta.value = text;
+            ta.style.position = "fixed";
+            ta.style.opacity = "0";
+            document.body.appendChild(ta);
+            ta.select();
+            document.execCommand("copy");
+            document.body.removeChild(ta);
+            done();
+        }
+        function render(e) {
+            if (document.getElementById("clip-" + e.id)) return;
+            var div = document.createElement("div");
+            div.className = "clip";
+            div.id = "clip-" + e.id;
+            var pre = document.createElement("pre");
+            pre.textContent = e.text;
+            var btn = document.createElement("button");
+            btn.textContent = "Copy";
+            btn.onclick = function () { copy(e.text, btn); };
+            var del = document.createElement("button");
+            del.textContent = "Delete";
+            del.onclick = function () {
+                fetch("/api/v1/clipboard?id=" + e.id, { method: "DELETE" }).then(function () { div.remove(); });
+            };
+            var when = document.createElement("small");
+            when.textContent = new Date(e.time).toLocaleString();
+            div.append(pre, btn, del, when);
+            list.insertBefore(div, list.firstChild);
+        }
+        fetch("/api/v1/clipboard").then(function (r) { return r.json(); }).then(function (entries) {
+            entries.reverse().forEach(render);
+            var es = new EventSource("/api/v1/clipboard");
+            es.addEventListener("clip", function (m) { render(JSON.parse(m.data)); });
+        });
+        document.getElementById("send").onsubmit = function (ev) {
+            ev.preventDefault();
+            var ta = document.getElementById("text");
+            fetch("/api/v1/clipboard", { method: "POST", headers: { "Content-Type": "text/plain" }, body: ta.value })
+                .then(function (r) { if (r.ok) ta.value = ""; });
+        };
+        document.getElementById("clear").onclick = function () {
+            fetch("/api/v1/clipboard", { method: "DELETE" }).then(function () { list.innerHTML = ""; });
+        };
+    })();
+    </script>
+</body>
+</html>`
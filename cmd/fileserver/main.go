@@ -0,0 +1,12 @@
+// fileserver 命令启动文件服务器，或作为客户端访问运行中的服务器
+package main
+
+import (
+	"os"
+
+	fileserver "file-server"
+)
+
+func main() {
+	fileserver.Main(os.Args[1:])
+}
@@ -0,0 +1,101 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errPreconditionFailed 表示文件在客户端读取之后已被修改
+var errPreconditionFailed = errors.New("precondition failed: the file has changed since it was read")
+
+// fileETag 根据修改时间和大小生成文件的 ETag；含 "-" 的形式让 S3 客户端不会将其当作 MD5 校验
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// hasPreconditions 判断写请求是否带有 If-Match、If-None-Match 或 If-Unmodified-Since
+func hasPreconditions(r *http.Request) bool {
+	h := r.Header
+	return h.Get("If-Match") != "" || h.Get("If-None-Match") != "" || h.Get("If-Unmodified-Since") != ""
+}
+
+// preconditionsMet 按 RFC 9110 判断写请求的前提条件，info 为 nil 表示目标不存在。
+// If-Match 使用强比较，If-None-Match: * 表示只在目标不存在时写入；有 If-Match 时忽略 If-Unmodified-Since
+func preconditionsMet(r *http.Request, info fs.FileInfo) bool {
+	etag := ""
+	if info != nil {
+		etag = fileETag(info)
+	}
+	if im := r.Header.Get("If-Match"); im != "" {
+		if info == nil || !etagListMatch(im, etag, false) {
+			return false
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && info != nil {
+		if t, err := http.ParseTime(ius); err == nil && info.ModTime().Truncate(time.Second).After(t) {
+			return false
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && info != nil && etagListMatch(inm, etag, true) {
+		return false
+	}
+	return true
+}
+
+// etagListMatch 判断逗号分隔的 ETag 列表中是否有与 etag 相同的一项，"*" 匹配任何存在的文件；
+// weak 为 false 时弱 ETag（W/ 开头）不匹配
+func etagListMatch(list, etag string, weak bool) bool {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return etag != ""
+		}
+		if strings.HasPrefix(v, "W/") {
+			if !weak {
+				continue
+			}
+			v = v[2:]
+		}
+		if v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions 在写入或删除前检查前提条件，不满足时返回 412 和文件当前的 ETag
+func checkPreconditions(w http.ResponseWriter, r *http.Request, vol volume, name string) bool {
+	if !hasPreconditions(r) {
+		return true
+	}
+	var info fs.FileInfo
+	if fi, err := vol.store.Stat(name); err == nil {
+		info = fi
+	}
+	if preconditionsMet(r, info) {
+		return true
+	}
+	if info != nil {
+		w.Header().Set("ETag", fileETag(info))
+	}
+	http.Error(w, "Precondition failed: the file has changed since it was read", http.StatusPreconditionFailed)
+	return false
+}
+
+// stillMet 在替换文件前再次检查前提条件，缩小检查与写入之间被他人修改的窗口
+func stillMet(r *http.Request, vol volume, name string) error {
+	if !hasPreconditions(r) {
+		return nil
+	}
+	var info fs.FileInfo
+	if fi, err := vol.store.Stat(name); err == nil {
+		info = fi
+	}
+	if !preconditionsMet(r, info) {
+		return errPreconditionFailed
+	}
+	return nil
+}
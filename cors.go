@@ -0,0 +1,127 @@
+package fileserver
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig 是跨域资源共享设置，允许其他来源的网页应用通过浏览器调用 JSON API 和上传接口
+type CORSConfig struct {
+	// AllowedOrigins 是允许的来源，如 https://app.example.com；"*" 允许所有来源，
+	// https://*.example.com 允许该域名的所有子域名。为空时不发送任何 CORS 头
+	AllowedOrigins []string
+	// AllowedMethods 是预检请求允许的方法，默认为 GET、HEAD、POST、PUT、DELETE
+	AllowedMethods []string
+	// AllowedHeaders 是预检请求允许的请求头，默认为服务器使用的请求头；"*" 允许浏览器请求的所有头
+	AllowedHeaders []string
+	// AllowCredentials 允许浏览器携带 Cookie 和 HTTP 认证信息，此时 "*" 会回显请求的来源
+	AllowCredentials bool
+	// MaxAge 是浏览器缓存预检结果的时间，默认 10 分钟
+	MaxAge time.Duration
+}
+
+// corsConfig 由 -cors-origin、-cors-method、-cors-header、-cors-credentials 和 -cors-max-age 设置
+var corsConfig = CORSConfig{MaxAge: 10 * time.Minute}
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Range", "Last-Event-ID", "X-Upload-ID", "X-Zip-Password"}
+)
+
+// corsExposedHeaders 是允许跨域脚本读取的响应头
+const corsExposedHeaders = "Content-Disposition, Content-Length, Content-Range, ETag, Location, Retry-After, X-Upload-ID"
+
+// CORS 允许其他来源的网页应用访问服务器
+func CORS(c CORSConfig) Option {
+	return func(*Server) error {
+		corsConfig = c
+		return nil
+	}
+}
+
+// corsMiddleware 为允许的来源添加 CORS 响应头并直接应答预检请求。放在认证之前，因为浏览器发送预检请求时不带认证信息
+func corsMiddleware(next http.Handler) http.Handler {
+	origins := splitCSV(corsConfig.AllowedOrigins)
+	if len(origins) == 0 {
+		return next
+	}
+	methods := strings.Join(orDefault(splitCSV(corsConfig.AllowedMethods), defaultCORSMethods), ", ")
+	headers := orDefault(splitCSV(corsConfig.AllowedHeaders), defaultCORSHeaders)
+	anyHeader := slices.Contains(headers, "*")
+	maxAge := strconv.Itoa(int(corsConfig.MaxAge.Seconds()))
+	anyOrigin := slices.Contains(origins, "*") && !corsConfig.AllowCredentials
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if corsConfig.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			if anyHeader {
+				if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+					h.Set("Access-Control-Allow-Headers", req)
+				}
+			} else {
+				h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsOriginAllowed 判断来源是否在允许列表中，支持 "*" 和 scheme://*.domain 形式的子域名通配
+func corsOriginAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(a, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitCSV 展开以逗号分隔的列表项并去掉空白
+func splitCSV(list []string) []string {
+	var out []string
+	for _, item := range list {
+		for _, v := range strings.Split(item, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// orDefault 在 list 为空时返回默认值
+func orDefault(list, def []string) []string {
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
@@ -0,0 +1,177 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"file-server/delta"
+)
+
+// apiDeltaHandler 实现块差异上传，路径为虚拟文件树中的完整路径（与 /put/ 相同）：
+// GET ?path=&block= 返回服务器上现有文件的块签名；
+// POST ?path=&block=&checksum= 的请求体为差异流，服务器用旧文件和差异重建新文件，
+// 校验 SHA-256 后原子地替换旧文件
+func apiDeltaHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p := cleanName(q.Get("path"))
+	if p == "" || reservedPath(p) {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	blockSize := delta.DefaultBlockSize
+	if v := q.Get("block"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > delta.MaxBlockSize {
+			http.Error(w, "Invalid block size", http.StatusBadRequest)
+			return
+		}
+		blockSize = n
+	}
+	vol, name, err := resolveVirtual(r.Context(), p)
+	if err != nil || name == "" {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil || info.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f, err := vol.store.Open(name)
+		if err != nil {
+			http.Error(w, "Failed to open file", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		sig, err := delta.NewSignature(f, blockSize)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sig)
+	case http.MethodPost:
+		if vol.readOnly {
+			http.Error(w, "Directory is read-only", http.StatusForbidden)
+			return
+		}
+		if !checkPreconditions(w, r, vol, name) {
+			return
+		}
+		body := watchAbort(r)
+		tracker, ok := trackUpload(w, r)
+		if !ok {
+			return
+		}
+		defer tracker.finish()
+		tracker.setPath(vol.virtual(name))
+		applyDelta(tracker, r, body, vol, name, info.Size(), blockSize, strings.ToLower(q.Get("checksum")))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// applyDelta 将重建的文件写入同目录下的临时文件，校验通过后再替换原文件
+func applyDelta(w http.ResponseWriter, r *http.Request, body *abortReader, vol volume, name string, baseSize int64, blockSize int, checksum string) {
+	base, err := vol.store.Open(name)
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer base.Close()
+
+	hasher := sha256.New()
+	var size int64
+	var ev fileEvent
+	var rejected error
+	err = stageAndCommit(vol.store, name, "delta", func(dst io.Writer) error {
+		out := io.MultiWriter(dst, hasher)
+		if limits.MaxUploadSize > 0 {
+			out = &limitedWriter{w: out, n: limits.MaxUploadSize}
+		}
+		var err error
+		size, err = delta.Apply(readerAt(base), baseSize, blockSize, r.Body, out)
+		return err
+	}, func(tmp string) error {
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		if checksum != "" && checksum != sum {
+			return errChecksumMismatch
+		}
+		if err := stillMet(r, vol, name); err != nil {
+			return err
+		}
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(name), Size: size, User: currentUser(r), Checksum: sum}
+		rejected = acceptUpload(vol, tmp, ev)
+		return rejected
+	})
+	if err != nil {
+		switch {
+		case err == rejected:
+			http.Error(w, err.Error(), uploadErrorStatus(err))
+		case body.aborted():
+			logger.Printf("Delta upload to %s aborted by client, partial file removed", vol.virtual(name))
+		case errors.Is(err, errTooLarge):
+			http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, delta.ErrBadDelta), errors.Is(err, errChecksumMismatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err == errPreconditionFailed:
+			http.Error(w, "Precondition failed: the file has changed since it was read", http.StatusPreconditionFailed)
+		default:
+			logger.Printf("Error applying delta to %s: %v", vol.virtual(name), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Printf("Delta upload rebuilt %s (%s)", ev.Path, formatSize(size))
+	completeUpload(vol, name, ev, false)
+	if info, err := vol.store.Stat(name); err == nil {
+		w.Header().Set("ETag", fileETag(info))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"path": ev.Path, "size": size, "checksum": ev.Checksum})
+}
+
+var (
+	errChecksumMismatch = errors.New("checksum mismatch")
+	errTooLarge         = errors.New("upload too large")
+)
+
+// limitedWriter 在写入超过 n 字节时返回 errTooLarge
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errTooLarge
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// readerAt 为存储文件提供 io.ReaderAt；不支持时用 Seek 和 Read 模拟（不可并发使用）
+func readerAt(f storageFile) io.ReaderAt {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra
+	}
+	return seekReaderAt{f}
+}
+
+type seekReaderAt struct {
+	f storageFile
+}
+
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.f, p)
+}
@@ -0,0 +1,299 @@
+// Package delta 实现 rsync 风格的块差异传输：接收方为旧文件生成块签名，
+// 发送方据此找出可以复用的块，只传输变化的数据，接收方再用旧文件和差异重建新文件。
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultBlockSize 是默认的块大小
+const DefaultBlockSize = 64 << 10
+
+// MaxBlockSize 是允许的最大块大小
+const MaxBlockSize = 16 << 20
+
+// maxLiteral 是单个数据指令的最大长度，超过时拆分为多条
+const maxLiteral = 1 << 20
+
+// 差异流中的指令：opCopy 后跟 4 字节块序号，opData 后跟 4 字节长度和数据，均为大端序
+const (
+	opCopy = 'C'
+	opData = 'D'
+)
+
+// Block 是旧文件中一个块的弱校验和（可滚动计算）与强校验和
+type Block struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// Signature 是旧文件的块签名，最后一个块可能短于 BlockSize
+type Signature struct {
+	Size      int64   `json:"size"`
+	BlockSize int     `json:"block_size"`
+	Blocks    []Block `json:"blocks"`
+}
+
+// ErrBadDelta 表示差异流格式错误或引用了不存在的块
+var ErrBadDelta = errors.New("delta: malformed delta stream")
+
+// NewSignature 读取 r 并为每个块生成签名
+func NewSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 || blockSize > MaxBlockSize {
+		return nil, fmt.Errorf("delta: invalid block size %d", blockSize)
+	}
+	sig := &Signature{BlockSize: blockSize, Blocks: []Block{}}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Size += int64(n)
+			sig.Blocks = append(sig.Blocks, Block{Weak: weakSum(buf[:n]), Strong: strongSum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stats 统计差异中复用和新传输的字节数
+type Stats struct {
+	Reused  int64 `json:"reused"`
+	Literal int64 `json:"literal"`
+}
+
+// Compute 将新内容 r 与签名比较，把差异指令写入 w
+func Compute(sig *Signature, r io.Reader, w io.Writer) (Stats, error) {
+	var st Stats
+	bs := sig.BlockSize
+	if bs <= 0 || bs > MaxBlockSize {
+		return st, fmt.Errorf("delta: invalid block size %d", bs)
+	}
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, b := range sig.Blocks {
+		if i == len(sig.Blocks)-1 && sig.Size%int64(bs) != 0 {
+			continue // 最后的短块只在文件末尾比较
+		}
+		index[b.Weak] = append(index[b.Weak], i)
+	}
+	lastLen := int(sig.Size % int64(bs))
+
+	bw := bufio.NewWriter(w)
+	emitData := func(p []byte) error {
+		for len(p) > 0 {
+			n := min(len(p), maxLiteral)
+			var hdr [5]byte
+			hdr[0] = opData
+			binary.BigEndian.PutUint32(hdr[1:], uint32(n))
+			if _, err := bw.Write(hdr[:]); err != nil {
+				return err
+			}
+			if _, err := bw.Write(p[:n]); err != nil {
+				return err
+			}
+			st.Literal += int64(n)
+			p = p[n:]
+		}
+		return nil
+	}
+	emitCopy := func(i int, n int) error {
+		var hdr [5]byte
+		hdr[0] = opCopy
+		binary.BigEndian.PutUint32(hdr[1:], uint32(i))
+		st.Reused += int64(n)
+		_, err := bw.Write(hdr[:])
+		return err
+	}
+
+	// buf[lit:lo] 是尚未发送的数据，buf[lo:lo+bs] 是当前窗口
+	buf := make([]byte, 0, 4*bs+maxLiteral)
+	lit, lo := 0, 0
+	eof := false
+	fill := func() error {
+		if eof || len(buf)-lo >= bs {
+			return nil
+		}
+		if lit > 0 && cap(buf)-len(buf) < bs {
+			n := copy(buf, buf[lit:])
+			buf = buf[:n]
+			lo -= lit
+			lit = 0
+		}
+		for len(buf)-lo < bs && !eof {
+			if cap(buf)-len(buf) < bs {
+				grown := make([]byte, len(buf), 2*cap(buf))
+				copy(grown, buf)
+				buf = grown
+			}
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var a, b uint32
+	rolling := false
+	for {
+		if err := fill(); err != nil {
+			return st, err
+		}
+		if len(buf)-lo < bs {
+			break
+		}
+		win := buf[lo : lo+bs]
+		if !rolling {
+			a, b = sums(win)
+			rolling = true
+		}
+		if cands, ok := index[a|b<<16]; ok {
+			strong := strongSum(win)
+			if i, found := findStrong(sig, cands, strong); found {
+				if err := emitData(buf[lit:lo]); err != nil {
+					return st, err
+				}
+				if err := emitCopy(i, bs); err != nil {
+					return st, err
+				}
+				lo += bs
+				lit = lo
+				rolling = false
+				continue
+			}
+		}
+		// 窗口后移一个字节
+		out := uint32(buf[lo])
+		lo++
+		if lo-lit >= maxLiteral {
+			if err := emitData(buf[lit:lo]); err != nil {
+				return st, err
+			}
+			lit = lo
+		}
+		if err := fill(); err != nil {
+			return st, err
+		}
+		if len(buf)-lo < bs {
+			rolling = false
+			break
+		}
+		in := uint32(buf[lo+bs-1])
+		a = (a - out + in) & 0xffff
+		b = (b - uint32(bs)*out + a) & 0xffff
+	}
+
+	// 文件末尾不足一个块：只可能与旧文件的最后一个短块相同
+	tail := buf[lo:]
+	if lastLen > 0 && len(tail) >= lastLen {
+		last := len(sig.Blocks) - 1
+		end := tail[len(tail)-lastLen:]
+		if weakSum(end) == sig.Blocks[last].Weak && strongSum(end) == sig.Blocks[last].Strong {
+			if err := emitData(buf[lit : len(buf)-lastLen]); err != nil {
+				return st, err
+			}
+			if err := emitCopy(last, lastLen); err != nil {
+				return st, err
+			}
+			return st, bw.Flush()
+		}
+	}
+	if err := emitData(buf[lit:]); err != nil {
+		return st, err
+	}
+	return st, bw.Flush()
+}
+
+func findStrong(sig *Signature, cands []int, strong string) (int, bool) {
+	for _, i := range cands {
+		if sig.Blocks[i].Strong == strong {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Apply 用旧文件 base（大小为 baseSize）和差异流 r 重建新文件并写入 w，返回写入的字节数
+func Apply(base io.ReaderAt, baseSize int64, blockSize int, r io.Reader, w io.Writer) (int64, error) {
+	if blockSize <= 0 || blockSize > MaxBlockSize {
+		return 0, fmt.Errorf("delta: invalid block size %d", blockSize)
+	}
+	br := bufio.NewReader(r)
+	block := make([]byte, blockSize)
+	var written int64
+	var hdr [5]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:1]); err == io.EOF {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		}
+		if _, err := io.ReadFull(br, hdr[1:]); err != nil {
+			return written, ErrBadDelta
+		}
+		arg := binary.BigEndian.Uint32(hdr[1:])
+		switch hdr[0] {
+		case opCopy:
+			off := int64(arg) * int64(blockSize)
+			if off >= baseSize {
+				return written, ErrBadDelta
+			}
+			n, err := base.ReadAt(block[:min(int64(blockSize), baseSize-off)], off)
+			if err != nil && err != io.EOF {
+				return written, err
+			}
+			m, err := w.Write(block[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		case opData:
+			if arg > maxLiteral {
+				return written, ErrBadDelta
+			}
+			n, err := io.CopyN(w, br, int64(arg))
+			written += n
+			if err == io.EOF {
+				return written, ErrBadDelta
+			}
+			if err != nil {
+				return written, err
+			}
+		default:
+			return written, ErrBadDelta
+		}
+	}
+}
+
+// sums 计算 rsync 弱校验和的两个 16 位分量
+func sums(p []byte) (a, b uint32) {
+	l := uint32(len(p))
+	for i, c := range p {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+func weakSum(p []byte) uint32 {
+	a, b := sums(p)
+	return a | b<<16
+}
+
+// strongSum 返回 SHA-256 的前 16 字节
+func strongSum(p []byte) string {
+	h := sha256.Sum256(p)
+	return hex.EncodeToString(h[:16])
+}
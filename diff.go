@@ -0,0 +1,505 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// diffMaxEdits 是逐行比较时最多计算的编辑数；差异更大时中间部分整体显示为删除后插入，避免占用过多内存
+const diffMaxEdits = 2000
+
+// diffOp 是编辑脚本中的一行：kind 为 ' '（相同）、'-'（只在旧文件中）或 '+'（只在新文件中），
+// a 和 b 为该行在旧文件和新文件中的下标，不存在时为 -1
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// splitLines 按行拆分文本，保留最后一行没有换行符的情况
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines 用 Myers 算法计算把 a 变为 b 的最短逐行编辑脚本
+func diffLines(a, b []string) []diffOp {
+	// 相同的开头和结尾不参与计算
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ops []diffOp
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i})
+	}
+	ops = append(ops, myersDiff(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+	for i := suf; i > 0; i-- {
+		ops = append(ops, diffOp{' ', len(a) - i, len(b) - i})
+	}
+	return ops
+}
+
+// myersDiff 计算 a 和 b 之间的编辑脚本，off 为两者在原文件中的起始行；编辑数超过 diffMaxEdits 时整体替换
+func myersDiff(a, b []string, off int) []diffOp {
+	n, m := len(a), len(b)
+	maxD := min(n+m, diffMaxEdits)
+	v := make([]int, 2*maxD+2)
+	var trace [][]int32
+	d := 0
+	for ; d <= maxD; d++ {
+		found := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[maxD+k-1] < v[maxD+k+1]) {
+				x = v[maxD+k+1]
+			} else {
+				x = v[maxD+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[maxD+k] = x
+			if x >= n && y >= m {
+				found = true
+			}
+		}
+		// 只保存本轮用到的对角线，回溯时找出每一步的来源
+		row := make([]int32, 2*d+1)
+		for i := range row {
+			row[i] = int32(v[maxD-d+i])
+		}
+		trace = append(trace, row)
+		if found {
+			break
+		}
+	}
+
+	var ops []diffOp
+	if d > maxD {
+		for i := range a {
+			ops = append(ops, diffOp{'-', off + i, -1})
+		}
+		for j := range b {
+			ops = append(ops, diffOp{'+', -1, off + j})
+		}
+		return ops
+	}
+
+	// 从终点沿记录的路径倒推，得到逆序的编辑脚本
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && prev[d-1+k-1] < prev[d-1+k+1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := int(prev[d-1+pk])
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', off + x, off + y})
+		}
+		if x == px {
+			y--
+			ops = append(ops, diffOp{'+', -1, off + y})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', off + x, -1})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{' ', off + x, off + y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// diffHunk 是编辑脚本中带上下文的一段连续修改
+type diffHunk struct {
+	ops          []diffOp
+	aStart, aLen int
+	bStart, bLen int
+}
+
+// diffHunks 把编辑脚本分成带 context 行上下文的片段；context 小于 0 时整个文件为一段
+func diffHunks(ops []diffOp, context int) []diffHunk {
+	if context < 0 {
+		context = len(ops)
+	}
+	var hunks []diffHunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-context, 0)
+		// 向后扩展，直到连续相同的行超过上下文的两倍，再去掉多余的相同行
+		end, same := i, 0
+		for end < len(ops) && same <= 2*context {
+			if ops[end].kind == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+			end++
+		}
+		end -= max(same-context, 0)
+		hunks = append(hunks, newDiffHunk(ops, start, end))
+		i = end
+	}
+	return hunks
+}
+
+// newDiffHunk 计算 ops[start:end] 在两个文件中的起始行号（从 1 开始）和行数；
+// 某一边没有行时按 diff 的约定给出它之前一行的行号
+func newDiffHunk(ops []diffOp, start, end int) diffHunk {
+	h := diffHunk{ops: ops[start:end]}
+	for i := start - 1; i >= 0 && (h.aStart == 0 || h.bStart == 0); i-- {
+		if h.aStart == 0 && ops[i].a >= 0 {
+			h.aStart = ops[i].a + 1
+		}
+		if h.bStart == 0 && ops[i].b >= 0 {
+			h.bStart = ops[i].b + 1
+		}
+	}
+	for _, op := range h.ops {
+		if op.a >= 0 {
+			h.aLen++
+		}
+		if op.b >= 0 {
+			h.bLen++
+		}
+	}
+	if h.aLen > 0 {
+		h.aStart++
+	}
+	if h.bLen > 0 {
+		h.bStart++
+	}
+	return h
+}
+
+func (h diffHunk) header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+}
+
+func hunkRange(start, n int) string {
+	if n == 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// unifiedDiff 返回 diff -u 格式的文本差异
+func unifiedDiff(nameA, nameB string, a, b []string, hunks []diffHunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	for _, h := range hunks {
+		sb.WriteString(h.header() + "\n")
+		for _, op := range h.ops {
+			line := ""
+			if op.a >= 0 {
+				line = a[op.a]
+			} else {
+				line = b[op.b]
+			}
+			sb.WriteByte(op.kind)
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// compareCSS 是比较页面的样式
+const compareCSS = `    <style>
+        .diff { border-collapse: collapse; font: 13px/1.5 monospace; width: 100%; table-layout: fixed; }
+        .diff td { padding: 0 6px; white-space: pre-wrap; word-break: break-all; vertical-align: top; }
+        .diff .ln { width: 4em; color: #999; text-align: right; user-select: none; background: #f6f6f6; }
+        .diff .del { background: #ffebe9; } .diff .ins { background: #e6ffec; }
+        .diff .hunk td { background: #ddf4ff; color: #57606a; }
+    </style>
+`
+
+// compareHandler 处理 /compare?a=<path>&b=<path>，在浏览器中显示两个文本文件的差异：mode=unified（默认）或 split（左右对照），
+// context 为每处修改前后显示的相同行数（默认 3，all 显示全部），format=patch 返回纯文本的统一格式 diff。
+// POST 带两个 path 时（列表中勾选两个文件后按“比较所选”）跳转到对应的 GET 地址
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		paths := r.PostForm["path"]
+		if len(paths) != 2 {
+			http.Error(w, "Select exactly two files to compare", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/compare?a="+url.QueryEscape(paths[0])+"&b="+url.QueryEscape(paths[1]), http.StatusSeeOther)
+		return
+	}
+	q := r.URL.Query()
+	pa, pb := q.Get("a"), q.Get("b")
+	if pa == "" {
+		http.Error(w, "Missing a parameter", http.StatusBadRequest)
+		return
+	}
+	volA, nameA, srcA, ok := readCompared(w, r, pa)
+	if !ok {
+		return
+	}
+	links := fmt.Sprintf(`<a href="/view?path=%s">View</a> | <a href="%s">&larr; Back</a>`,
+		url.QueryEscape(volA.virtual(nameA)), html.EscapeString(listURL(path.Dir("/" + volA.virtual(nameA))[1:])))
+	if pb == "" {
+		writeCompareForm(w, volA.virtual(nameA), links)
+		return
+	}
+	volB, nameB, srcB, ok := readCompared(w, r, pb)
+	if !ok {
+		return
+	}
+	virtualA, virtualB := volA.virtual(nameA), volB.virtual(nameB)
+
+	context := 3
+	if c := q.Get("context"); c == "all" {
+		context = -1
+	} else if n, err := strconv.Atoi(c); err == nil && n >= 0 {
+		context = n
+	}
+	a, b := splitLines(srcA), splitLines(srcB)
+	hunks := diffHunks(diffLines(a, b), context)
+
+	if q.Get("format") == "patch" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		fmt.Fprint(w, unifiedDiff("a/"+virtualA, "b/"+virtualB, a, b, hunks))
+		return
+	}
+
+	split := q.Get("mode") == "split"
+	self := func(mode, ctx string) string {
+		v := url.Values{"a": {virtualA}, "b": {virtualB}, "mode": {mode}, "context": {ctx}}
+		return html.EscapeString("/compare?" + v.Encode())
+	}
+	mode, ctxParam := "unified", strconv.Itoa(context)
+	if split {
+		mode = "split"
+	}
+	if context < 0 {
+		ctxParam = "all"
+	}
+	other, otherName := "split", "Side by side"
+	if split {
+		other, otherName = "unified", "Unified"
+	}
+	added, removed := 0, 0
+	for _, h := range hunks {
+		for _, op := range h.ops {
+			switch op.kind {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+	}
+
+	var sb strings.Builder
+	title := path.Base(virtualA) + " vs " + path.Base(virtualB)
+	fmt.Fprintf(&sb, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+%s</head>
+<body>
+    <h1>%s</h1>
+    <p><span class="del">&minus; %s</span> &nbsp; <span class="ins">+ %s</span></p>
+    <p>%d lines added, %d removed. <a href="%s">%s</a> | <a href="%s">%s</a> | <a href="%s">Patch</a> | <a href="/compare?a=%s&amp;b=%s">Swap</a> | %s</p>
+`, html.EscapeString(title), compareCSS, html.EscapeString(title), html.EscapeString(virtualA), html.EscapeString(virtualB),
+		added, removed, self(other, ctxParam), otherName, compareContextLink(self, mode, context), compareContextName(context),
+		html.EscapeString("/compare?"+url.Values{"a": {virtualA}, "b": {virtualB}, "context": {ctxParam}, "format": {"patch"}}.Encode()),
+		url.QueryEscape(virtualB), url.QueryEscape(virtualA), links)
+	if len(hunks) == 0 {
+		sb.WriteString("    <p>The files are identical.</p>\n")
+	} else if split {
+		writeSplitDiff(&sb, a, b, hunks)
+	} else {
+		writeUnifiedDiff(&sb, a, b, hunks)
+	}
+	sb.WriteString("</body>\n</html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// compareContextLink 返回在“只显示修改处”和“显示全部”之间切换的地址
+func compareContextLink(self func(mode, ctx string) string, mode string, context int) string {
+	if context < 0 {
+		return self(mode, "3")
+	}
+	return self(mode, "all")
+}
+
+func compareContextName(context int) string {
+	if context < 0 {
+		return "Changes only"
+	}
+	return "Whole file"
+}
+
+// readCompared 读取要比较的文本文件，出错时写出错误响应并返回 false
+func readCompared(w http.ResponseWriter, r *http.Request, p string) (volume, string, string, bool) {
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound || name == "" {
+		http.Error(w, "Path not found: "+p, http.StatusNotFound)
+		return vol, name, "", false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return vol, name, "", false
+	}
+	src, err := readTextFile(vol.store, name)
+	if err == errNotText {
+		http.Error(w, "Not a text file or larger than 1 MB: "+p, http.StatusUnsupportedMediaType)
+		return vol, name, "", false
+	}
+	if err != nil {
+		http.Error(w, "Path not found: "+p, http.StatusNotFound)
+		return vol, name, "", false
+	}
+	return vol, name, src, true
+}
+
+// writeCompareForm 显示选择第二个文件的表单
+func writeCompareForm(w http.ResponseWriter, virtual, links string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Compare %s</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Compare %s</h1>
+    <p>%s</p>
+    <form action="/compare" method="get">
+        <input type="hidden" name="a" value="%s">
+        <input type="text" name="b" value="%s" required size="60">
+        <select name="mode"><option value="unified">Unified</option><option value="split">Side by side</option></select>
+        <input type="submit" value="Compare">
+    </form>
+</body>
+</html>`, html.EscapeString(virtual), html.EscapeString(virtual), links, html.EscapeString(virtual), html.EscapeString(virtual))
+}
+
+// diffLineHTML 返回一行的 HTML，去掉行尾换行符
+func diffLineHTML(lines []string, i int) string {
+	if i < 0 {
+		return ""
+	}
+	return html.EscapeString(strings.TrimRight(lines[i], "\r\n"))
+}
+
+func diffLineNumber(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// writeUnifiedDiff 以一列显示差异，删除的行在前，插入的行在后
+func writeUnifiedDiff(sb *strings.Builder, a, b []string, hunks []diffHunk) {
+	sb.WriteString("    <table class=\"diff\">\n")
+	for _, h := range hunks {
+		fmt.Fprintf(sb, "        <tr class=\"hunk\"><td class=\"ln\"></td><td class=\"ln\"></td><td>%s</td></tr>\n", h.header())
+		for _, op := range h.ops {
+			class, text := "", ""
+			switch op.kind {
+			case '-':
+				class, text = "del", "-"+diffLineHTML(a, op.a)
+			case '+':
+				class, text = "ins", "+"+diffLineHTML(b, op.b)
+			default:
+				text = " " + diffLineHTML(a, op.a)
+			}
+			fmt.Fprintf(sb, "        <tr class=\"%s\"><td class=\"ln\">%s</td><td class=\"ln\">%s</td><td>%s</td></tr>\n",
+				class, diffLineNumber(op.a), diffLineNumber(op.b), text)
+		}
+	}
+	sb.WriteString("    </table>\n")
+}
+
+// writeSplitDiff 左右对照显示差异：一处修改中删除和插入的行逐行并排，多出的一边留空
+func writeSplitDiff(sb *strings.Builder, a, b []string, hunks []diffHunk) {
+	sb.WriteString("    <table class=\"diff\">\n")
+	row := func(ai, bi int) {
+		left, right := "", ""
+		if ai >= 0 && bi < 0 {
+			left = "del"
+		}
+		if bi >= 0 && ai < 0 {
+			right = "ins"
+		}
+		if ai >= 0 && bi >= 0 && a[ai] != b[bi] {
+			left, right = "del", "ins"
+		}
+		fmt.Fprintf(sb, "        <tr><td class=\"ln\">%s</td><td class=\"%s\">%s</td><td class=\"ln\">%s</td><td class=\"%s\">%s</td></tr>\n",
+			diffLineNumber(ai), left, diffLineHTML(a, ai), diffLineNumber(bi), right, diffLineHTML(b, bi))
+	}
+	for _, h := range hunks {
+		fmt.Fprintf(sb, "        <tr class=\"hunk\"><td class=\"ln\"></td><td colspan=\"3\">%s</td></tr>\n", h.header())
+		for i := 0; i < len(h.ops); {
+			if h.ops[i].kind == ' ' {
+				row(h.ops[i].a, h.ops[i].b)
+				i++
+				continue
+			}
+			var del, ins []int
+			for ; i < len(h.ops) && h.ops[i].kind != ' '; i++ {
+				if h.ops[i].kind == '-' {
+					del = append(del, h.ops[i].a)
+				} else {
+					ins = append(ins, h.ops[i].b)
+				}
+			}
+			for j := 0; j < max(len(del), len(ins)); j++ {
+				ai, bi := -1, -1
+				if j < len(del) {
+					ai = del[j]
+				}
+				if j < len(ins) {
+					bi = ins[j]
+				}
+				row(ai, bi)
+			}
+		}
+	}
+	sb.WriteString("    </table>\n")
+}
@@ -0,0 +1,144 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// errTooManyEntries 表示上传会使目录或整个服务目录的条目数超过 limits 中的上限
+var errTooManyEntries = errors.New("too many entries")
+
+// shardUploads 由 -shard-uploads 设置：表单上传的目标目录达到 -max-dir-entries 时，改为放入按日期分层的子目录（如 2024/06/12/）
+var shardUploads bool
+
+// checkEntryLimits 检查在卷内目录 dir 中新建条目是否超过单个目录或服务目录总计的条目数上限；
+// 总数来自最近一次存储统计扫描加上之后上传新建的条目，首次扫描完成前不检查总数
+func checkEntryLimits(vol volume, dir string) error {
+	if limits.MaxDirEntries > 0 {
+		if entries, err := vol.store.List(dir); err == nil {
+			n := 0
+			for _, info := range entries {
+				if info.Name() != metaDirName {
+					n++
+				}
+			}
+			if n >= limits.MaxDirEntries {
+				return fmt.Errorf("%w: folder /%s already has %d entries (limit %d)", errTooManyEntries, vol.virtual(dir), n, limits.MaxDirEntries)
+			}
+		}
+	}
+	if limits.MaxEntries > 0 && storageUsage != nil {
+		if n, ok := storageUsage.entryCount(); ok && n >= limits.MaxEntries {
+			return fmt.Errorf("%w: the server already holds %d files and folders (limit %d)", errTooManyEntries, n, limits.MaxEntries)
+		}
+	}
+	return nil
+}
+
+// checkNewEntry 是所有写入途径共用的写前检查：在写入卷内路径 name 之前检查条目数上限，并返回写入是否会新建条目。
+// replace 为 true 且 name 已存在时是覆盖，不受上限限制；其余情况（包括生成唯一名称的写入）按新条目检查
+func checkNewEntry(vol volume, name string, replace bool) (bool, error) {
+	if replace {
+		if _, err := vol.store.Stat(name); err == nil {
+			return false, nil
+		}
+	}
+	if err := checkEntryLimits(vol, cleanName(path.Dir(name))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// uploadDirFor 返回表单上传在卷内的目标目录并确保其存在：通常为卷的根目录，设置了 -organize-uploads 时为按模板生成的子目录；
+// 启用 -shard-uploads 且该目录已满时为其下当天的日期子目录
+func uploadDirFor(vol volume, now time.Time, user string) (string, error) {
+	dir := organizedDir(now, user)
+	err := checkEntryLimits(vol, dir)
+	if err != nil && shardUploads && limits.MaxDirEntries > 0 {
+		// 日期子目录的上级目录（年、月）每年、每月只新建一次，允许超出所在目录的上限
+		dir = shardDir(dir, now)
+		err = checkEntryLimits(vol, dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	if dir != "" {
+		if err := vol.store.Mkdir(dir); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// shardDir 返回 dir 下按日期 t 分层的子目录
+func shardDir(dir string, t time.Time) string {
+	return path.Join(dir, t.Format("2006/01/02"))
+}
+
+// treeBudget 在复制或解压整棵目录树时逐个检查将要新建的条目：root 是刚创建的空目录，
+// 其下各目录的条目数和新建的总数在内存中累计，不必每新建一个条目都列出一次目录
+type treeBudget struct {
+	vol     volume
+	root    string
+	seen    map[string]bool
+	dirs    map[string]int
+	created int64
+	// total 是开始时的条目总数，-1 表示不检查总数
+	total int64
+}
+
+func newTreeBudget(vol volume, root string) *treeBudget {
+	b := &treeBudget{vol: vol, root: cleanName(root), seen: map[string]bool{}, dirs: map[string]int{}, total: -1}
+	if limits.MaxEntries > 0 && storageUsage != nil {
+		if n, ok := storageUsage.entryCount(); ok {
+			b.total = n
+		}
+	}
+	return b
+}
+
+// add 在新建卷内路径 name 之前调用，连同尚未记录的上级目录一起计入；会超过上限时返回 errTooManyEntries
+func (b *treeBudget) add(name string) error {
+	name = cleanName(name)
+	if b.seen[name] || !strings.HasPrefix(name, b.root+"/") {
+		return nil
+	}
+	dir := path.Dir(name)
+	if err := b.add(dir); err != nil {
+		return err
+	}
+	b.seen[name] = true
+	b.dirs[dir]++
+	b.created++
+	if limits.MaxDirEntries > 0 && b.dirs[dir] > limits.MaxDirEntries {
+		return fmt.Errorf("%w: folder /%s would get more than %d entries", errTooManyEntries, b.vol.virtual(dir), limits.MaxDirEntries)
+	}
+	if b.total >= 0 && b.total+b.created > limits.MaxEntries {
+		return fmt.Errorf("%w: the server would hold more than %d files and folders", errTooManyEntries, limits.MaxEntries)
+	}
+	return nil
+}
+
+// countNewEntries 记录上传新建的条目数，在下次扫描前计入总数
+func countNewEntries(n int64) {
+	if storageUsage != nil && limits.MaxEntries > 0 {
+		storageUsage.addEntries(n)
+	}
+}
+
+// countNewTree 记录解压等操作新建的整个目录树的条目数
+func countNewTree(vol volume, name string) {
+	if storageUsage == nil || limits.MaxEntries <= 0 {
+		return
+	}
+	var n int64
+	vol.store.Walk(name, func(string, fs.FileInfo, error) error {
+		n++
+		return nil
+	})
+	countNewEntries(n)
+}
@@ -0,0 +1,49 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// errInsufficientStorage 表示上传后磁盘剩余空间将低于保留空间
+var errInsufficientStorage = errors.New("not enough free disk space")
+
+// errDiskFreeUnsupported 表示当前平台无法查询磁盘剩余空间
+var errDiskFreeUnsupported = errors.New("free disk space unavailable on this platform")
+
+// declaredSize 返回请求声明的请求体大小，未知时返回 -1；
+// S3 分块签名上传的 Content-Length 包含分块头，此时使用 X-Amz-Decoded-Content-Length
+func declaredSize(r *http.Request) int64 {
+	if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return r.ContentLength
+}
+
+// checkFreeSpace 在接收上传前检查卷所在磁盘的剩余空间能否容纳 size 字节并保留 limits.MinFreeSpace，
+// 空间不足时返回 errInsufficientStorage。大小未知、非本地存储或无法查询剩余空间时不做检查
+func checkFreeSpace(vol volume, size int64) error {
+	if size < 0 {
+		return nil
+	}
+	lp, ok := baseStorage(vol.store).(localPather)
+	if !ok {
+		return nil
+	}
+	free, err := diskFree(lp.LocalPath(""))
+	if err != nil {
+		if err != errDiskFreeUnsupported {
+			logger.Printf("Error checking free space of %s: %v", lp.LocalPath(""), err)
+		}
+		return nil
+	}
+	if uint64(size)+uint64(limits.MinFreeSpace) > free {
+		logger.Printf("Rejecting upload of %s to %s: only %s free", formatSize(size), lp.LocalPath(""), formatSize(int64(free)))
+		return fmt.Errorf("%w: upload needs %s, %s available", errInsufficientStorage, formatSize(size+limits.MinFreeSpace), formatSize(int64(free)))
+	}
+	return nil
+}
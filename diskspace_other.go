@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package fileserver
+
+// diskFree 在不支持的平台上总是返回 errDiskFreeUnsupported，上传前不检查剩余空间
+func diskFree(dir string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package fileserver
+
+import "golang.org/x/sys/unix"
+
+// diskFree 返回目录所在文件系统中非特权用户可用的字节数
+func diskFree(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
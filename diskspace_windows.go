@@ -0,0 +1,16 @@
+package fileserver
+
+import "golang.org/x/sys/windows"
+
+// diskFree 返回目录所在磁盘中当前用户可用的字节数
+func diskFree(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, nil, nil); err != nil {
+		return 0, err
+	}
+	return avail, nil
+}
@@ -0,0 +1,284 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadToken 是访客上传令牌：持有 /drop/<token> 链接的人无需账户即可向 Dir 上传新文件，
+// 但不能查看、下载或覆盖任何文件。ID 用于列出和撤销，不能用来上传
+type uploadToken struct {
+	ID      string    `json:"id"`
+	Token   string    `json:"token"`
+	Dir     string    `json:"dir"`
+	Note    string    `json:"note,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// uploadTokenStore 保存在元数据库的 upload-tokens bucket 中，以 ID 为键
+type uploadTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*uploadToken
+}
+
+var uploadTokens *uploadTokenStore
+
+// uploadTokenMaxTTL 是上传令牌的最长有效期
+const uploadTokenMaxTTL = 90 * 24 * time.Hour
+
+// loadUploadTokens 从元数据库加载上传令牌
+func loadUploadTokens() *uploadTokenStore {
+	s := &uploadTokenStore{tokens: map[string]*uploadToken{}}
+	dbEach(bucketUploadTokens, func(k, v []byte) error {
+		t := &uploadToken{}
+		if err := json.Unmarshal(v, t); err != nil {
+			logger.Printf("Error parsing upload token %s: %v", k, err)
+			return nil
+		}
+		s.tokens[t.Token] = t
+		return nil
+	})
+	return s
+}
+
+// create 为目录创建上传令牌
+func (s *uploadTokenStore) create(dir, note, user string, ttl time.Duration) (*uploadToken, error) {
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	t := &uploadToken{ID: id, Token: token, Dir: dir, Note: note, User: user, Created: now, Expires: now.Add(ttl)}
+	if err := dbPut(bucketUploadTokens, []byte(id), t); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.tokens[token] = t
+	s.mu.Unlock()
+	return t, nil
+}
+
+// lookup 查找未过期的令牌
+func (s *uploadTokenStore) lookup(token string) *uploadToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[token]
+	if t == nil || time.Now().After(t.Expires) {
+		return nil
+	}
+	return t
+}
+
+// list 返回所有令牌，按创建时间排列
+func (s *uploadTokenStore) list() []*uploadToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []*uploadToken{}
+	for _, t := range s.tokens {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// revoke 按 ID 删除令牌
+func (s *uploadTokenStore) revoke(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, t := range s.tokens {
+		if t.ID == id {
+			delete(s.tokens, token)
+			dbDelete(bucketUploadTokens, []byte(id))
+			return true
+		}
+	}
+	return false
+}
+
+// prune 删除过期的令牌，返回删除的数量
+func (s *uploadTokenStore) prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids [][]byte
+	for token, t := range s.tokens {
+		if time.Now().After(t.Expires) {
+			delete(s.tokens, token)
+			ids = append(ids, []byte(t.ID))
+		}
+	}
+	dbDelete(bucketUploadTokens, ids...)
+	return len(ids)
+}
+
+// uploadTokenLink 是返回给管理员的令牌信息
+type uploadTokenLink struct {
+	*uploadToken
+	URL string `json:"url"`
+}
+
+// apiUploadTokensHandler 管理上传令牌（仅管理员）：GET 列出，POST 创建（JSON：dir、expires，默认 7 天、note），
+// DELETE ?id= 撤销
+func apiUploadTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		links := []uploadTokenLink{}
+		for _, t := range uploadTokens.list() {
+			links = append(links, uploadTokenLink{t, baseURL(r) + "/drop/" + t.Token})
+		}
+		writeJSON(w, http.StatusOK, links)
+	case http.MethodPost:
+		var req struct {
+			Dir     string `json:"dir"`
+			Expires string `json:"expires"`
+			Note    string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ttl := 7 * 24 * time.Hour
+		if req.Expires != "" {
+			d, err := time.ParseDuration(req.Expires)
+			if err != nil || d <= 0 || d > uploadTokenMaxTTL {
+				http.Error(w, fmt.Sprintf("invalid expires duration %q (at most %v)", req.Expires, uploadTokenMaxTTL), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		vol, name, err := resolveVirtual(r.Context(), req.Dir)
+		var info os.FileInfo
+		if err == nil {
+			info, err = vol.store.Stat(name)
+		}
+		if err != nil || !info.IsDir() {
+			http.Error(w, "Directory not found", http.StatusNotFound)
+			return
+		}
+		if vol.readOnly {
+			http.Error(w, "Directory is read-only", http.StatusForbidden)
+			return
+		}
+		t, err := uploadTokens.create(vol.virtual(name), strings.TrimSpace(req.Note), currentUser(r), ttl)
+		if err != nil {
+			http.Error(w, "Failed to create upload token", http.StatusInternalServerError)
+			return
+		}
+		logger.Printf("Upload token %s created for /%s, valid until %s", t.ID, t.Dir, t.Expires.Format(time.RFC3339))
+		writeJSON(w, http.StatusCreated, uploadTokenLink{t, baseURL(r) + "/drop/" + t.Token})
+	case http.MethodDelete:
+		if !uploadTokens.revoke(r.URL.Query().Get("id")) {
+			http.Error(w, "Upload token not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// withGuest 标记通过访客上传链接进入的请求
+func withGuest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, guestContextKey, true)
+}
+
+// isGuest 判断请求是否来自访客上传链接；访客只能新建文件
+func isGuest(r *http.Request) bool {
+	guest, _ := r.Context().Value(guestContextKey).(bool)
+	return guest
+}
+
+// dropHandler 处理访客上传链接：GET /drop/<token> 显示上传页面，PUT /drop/<token>/<文件名> 上传一个文件。
+// 上传交给 putHandler 完成，文件名只取最后一段，目标已存在时拒绝；设置了 -organize-uploads 时放入按模板生成的子目录
+func dropHandler(w http.ResponseWriter, r *http.Request) {
+	token, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/drop/"), "/")
+	t := uploadTokens.lookup(token)
+	if t == nil {
+		http.Error(w, "Upload link not found or expired", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		dropPage(w, t)
+	case http.MethodPut:
+		name = path.Base(cleanName(name))
+		if name == "." || name == "/" {
+			http.Error(w, "Missing file name", http.StatusBadRequest)
+			return
+		}
+		logger.Printf("Guest upload of %s through upload token %s", name, t.ID)
+		r2 := r.Clone(withGuest(withUser(r.Context(), "guest:"+t.ID, true)))
+		// 前提条件头会让 putHandler 替换已有文件，访客请求一律去掉
+		for _, h := range []string{"If-Match", "If-None-Match", "If-Unmodified-Since"} {
+			r2.Header.Del(h)
+		}
+		r2.URL.Path = "/put/" + path.Join(t.Dir, organizedDir(time.Now(), "guest:"+t.ID), name)
+		r2.URL.RawQuery = ""
+		putHandler(w, r2)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// dropPage 显示访客上传页面：只能选择文件上传，不显示目录内容
+func dropPage(w http.ResponseWriter, t *uploadToken) {
+	note := ""
+	if t.Note != "" {
+		note = "<p>" + html.EscapeString(t.Note) + "</p>"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Upload files</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link rel="icon" href="/favicon.ico">
+</head>
+<body>
+    <h1>Upload files</h1>
+    %s
+    <p>Files you upload here can't be viewed or changed from this page. This link works until %s.</p>
+    <input type="file" id="files" multiple>
+    <button id="send">Upload</button>
+    <ul id="status"></ul>
+    <script>
+    document.getElementById("send").addEventListener("click", function () {
+        var files = Array.prototype.slice.call(document.getElementById("files").files);
+        var list = document.getElementById("status");
+        (function next() {
+            var f = files.shift();
+            if (!f) return;
+            var li = document.createElement("li");
+            li.textContent = f.name + ": uploading...";
+            list.appendChild(li);
+            fetch(location.pathname.replace(/\/$/, "") + "/" + encodeURIComponent(f.name), {method: "PUT", body: f}).then(function (r) {
+                li.textContent = f.name + (r.ok ? ": done" : ": failed (" + r.status + ")");
+            }, function () {
+                li.textContent = f.name + ": failed";
+            }).then(next);
+        })();
+    });
+    </script>
+</body>
+</html>`, note, t.Expires.Format("2006-01-02 15:04 MST"))
+}
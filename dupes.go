@@ -0,0 +1,223 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// dupeFile 是扫描时找到的一个文件
+type dupeFile struct {
+	vol  volume
+	name string
+	info fs.FileInfo
+}
+
+// dupeGroup 是内容相同的一组文件；Wasted 为删除或硬链接多余副本后可节省的空间，已互为硬链接的副本不计入
+type dupeGroup struct {
+	Checksum string   `json:"checksum"`
+	Size     int64    `json:"size"`
+	Paths    []string `json:"paths"`
+	Wasted   int64    `json:"wasted"`
+}
+
+// findDuplicates 扫描服务目录和各挂载点，先按大小分组，再对大小相同的文件计算 SHA-256（使用校验和缓存），
+// 返回按可节省空间从大到小排列的重复文件组。空文件和已全部互为硬链接的组不在结果中
+func findDuplicates() ([]dupeGroup, error) {
+	bySize := make(map[int64][]dupeFile)
+	err := walkVolumes(func(vol volume, name string, info fs.FileInfo) error {
+		if info.Mode().IsRegular() && info.Size() > 0 {
+			bySize[info.Size()] = append(bySize[info.Size()], dupeFile{vol: vol, name: name, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []dupeGroup
+	for size, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		byHash := make(map[string][]dupeFile)
+		for _, f := range files {
+			sum, err := fileChecksum(f.vol, f.name)
+			if err != nil {
+				logger.Printf("Error hashing %s: %v", f.vol.virtual(f.name), err)
+				continue
+			}
+			byHash[sum] = append(byHash[sum], f)
+		}
+		for sum, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			g := dupeGroup{Checksum: sum, Size: size}
+			var distinct []fs.FileInfo
+			for _, f := range same {
+				g.Paths = append(g.Paths, f.vol.virtual(f.name))
+				linked := false
+				for _, d := range distinct {
+					if os.SameFile(d, f.info) {
+						linked = true
+						break
+					}
+				}
+				if !linked {
+					distinct = append(distinct, f.info)
+				}
+			}
+			if len(distinct) < 2 {
+				continue // 所有副本已经互为硬链接
+			}
+			sort.Strings(g.Paths)
+			g.Wasted = size * int64(len(distinct)-1)
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Wasted != groups[j].Wasted {
+			return groups[i].Wasted > groups[j].Wasted
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups, nil
+}
+
+// dedupeRequest 是 POST /api/v1/duplicates 的请求体：保留 Keep，对 Paths 中的每个副本执行 Action（delete 或 hardlink）
+type dedupeRequest struct {
+	Keep   string   `json:"keep"`
+	Paths  []string `json:"paths"`
+	Action string   `json:"action"`
+}
+
+// dedupeResult 是对单个副本执行操作的结果
+type dedupeResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// dedupe 确认副本与保留的文件内容相同后删除副本，或将副本替换为指向保留文件的硬链接
+func dedupe(r *http.Request, req dedupeRequest) ([]dedupeResult, int, error) {
+	if req.Action != "delete" && req.Action != "hardlink" {
+		return nil, http.StatusBadRequest, fmt.Errorf("action must be delete or hardlink")
+	}
+	keepVol, keepName, err := resolveVirtual(r.Context(), req.Keep)
+	if err != nil || keepName == "" {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found: %s", req.Keep)
+	}
+	keepSum, err := fileChecksum(keepVol, keepName)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found: %s", req.Keep)
+	}
+	keepPath := keepVol.virtual(keepName)
+
+	results := make([]dedupeResult, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		res := dedupeResult{Path: cleanName(p)}
+		if err := dedupeOne(r, keepVol, keepName, keepSum, p, req.Action); err != nil {
+			res.Error = err.Error()
+		} else {
+			logger.Printf("Duplicate %s: %s (kept %s)", res.Path, req.Action, keepPath)
+		}
+		results = append(results, res)
+	}
+	return results, http.StatusOK, nil
+}
+
+func dedupeOne(r *http.Request, keepVol volume, keepName, keepSum, p, action string) error {
+	vol, name, err := resolveVirtual(r.Context(), p)
+	if err != nil || name == "" || reservedPath(name) {
+		return fmt.Errorf("path not found")
+	}
+	if vol.virtual(name) == keepVol.virtual(keepName) {
+		return fmt.Errorf("path is the kept file")
+	}
+	if vol.readOnly {
+		return fmt.Errorf("directory is read-only")
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("path not found")
+	}
+	sum, err := fileChecksum(vol, name)
+	if err != nil {
+		return err
+	}
+	if sum != keepSum {
+		return fmt.Errorf("content differs from the kept file")
+	}
+
+	if action == "delete" {
+		if err := vol.store.Delete(name); err != nil {
+			return err
+		}
+		emitEvent(fileEvent{Event: eventDelete, Path: vol.virtual(name), Size: info.Size(), User: currentUser(r)})
+		return nil
+	}
+
+	// 硬链接需要两个文件都在本地磁盘上；先在副本旁创建链接，再原子地替换副本
+	src, ok1 := keepVol.store.(localPather)
+	dst, ok2 := vol.store.(localPather)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("hardlinks need local storage")
+	}
+	staged, err := stagingName(name, "link")
+	if err != nil {
+		return err
+	}
+	target := dst.LocalPath(name)
+	tmp := dst.LocalPath(staged)
+	if err := os.Link(src.LocalPath(keepName), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// apiDuplicatesHandler 是管理员的重复文件工具：GET 扫描并返回重复文件组和可节省的总空间，
+// POST 删除副本或将副本替换为硬链接
+func apiDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		groups, err := findDuplicates()
+		if err != nil {
+			logger.Printf("Error scanning for duplicates: %v", err)
+			http.Error(w, "Failed to scan files", http.StatusInternalServerError)
+			return
+		}
+		var wasted int64
+		for _, g := range groups {
+			wasted += g.Wasted
+		}
+		if groups == nil {
+			groups = []dupeGroup{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"groups": groups, "wasted": wasted})
+	case http.MethodPost:
+		var req dedupeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		results, status, err := dedupe(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, status, results)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
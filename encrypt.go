@@ -0,0 +1,442 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// encryptKeyFile 和 encryptKeyCommand 由 -encrypt-key-file 和 -encrypt-key-command 设置，
+// 也可以通过环境变量 FILESERVER_ENCRYPT_KEY 提供主密钥。设置任一项即启用静态加密
+var (
+	encryptKeyFile    string
+	encryptKeyCommand string
+)
+
+const encryptKeyEnv = "FILESERVER_ENCRYPT_KEY"
+
+// masterAEAD 是用主密钥包装每个文件密钥的 AES-GCM，为 nil 表示未启用加密；masterKeyID 是主密钥的指纹
+var (
+	masterAEAD  cipher.AEAD
+	masterKeyID []byte
+)
+
+// 加密文件格式：文件头为 8 字节标识、8 字节主密钥指纹、12 字节 nonce 和用主密钥加密的 32 字节文件密钥（含 16 字节校验），
+// 之后是以文件密钥加密的数据块，每块 64 KiB 明文加 16 字节校验。块 nonce 由块序号和最后一块标记组成，截断或调换块都能被发现
+const (
+	encMagic      = "FSENC\x00\x01\x00"
+	encKeyIDSize  = 8
+	encHeaderSize = len(encMagic) + encKeyIDSize + 12 + 32 + 16
+	encChunkSize  = 64 << 10
+	encTagSize    = 16
+)
+
+// errEncryptionKey 表示文件由其他主密钥加密，无法解密
+var errEncryptionKey = errors.New("file is encrypted with a different key")
+
+// setupEncryption 读取主密钥，启用时将服务目录和挂载点的存储包装为加密存储
+func setupEncryption() error {
+	key, err := loadMasterKey()
+	if err != nil {
+		return fmt.Errorf("encryption key: %v", err)
+	}
+	if key == nil {
+		masterAEAD, masterKeyID = nil, nil
+		return nil
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(key)
+	masterAEAD, masterKeyID = aead, sum[:encKeyIDSize]
+	rootStorage = encryptStorage(rootStorage)
+	for i := range mounts {
+		mounts[i].store = encryptStorage(mounts[i].store)
+	}
+	logger.Printf("Encrypting stored files (key %x)", masterKeyID)
+	return nil
+}
+
+// loadMasterKey 依次从密钥文件、密钥命令（如调用 KMS 的脚本）和环境变量读取主密钥，都未设置时返回 nil
+func loadMasterKey() ([]byte, error) {
+	var raw []byte
+	switch {
+	case encryptKeyFile != "":
+		data, err := os.ReadFile(encryptKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	case encryptKeyCommand != "":
+		fields := strings.Fields(encryptKeyCommand)
+		out, err := exec.Command(fields[0], fields[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fields[0], err)
+		}
+		raw = out
+	case os.Getenv(encryptKeyEnv) != "":
+		raw = []byte(os.Getenv(encryptKeyEnv))
+	default:
+		return nil, nil
+	}
+	return parseMasterKey(raw)
+}
+
+// parseMasterKey 接受 32 字节的原始密钥，或其十六进制、base64 编码
+func parseMasterKey(raw []byte) ([]byte, error) {
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	s := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("key must be 32 bytes, given raw, as 64 hex digits or in base64")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedStorage 在写入时加密文件、读取时透明解密，没有加密文件头的已有文件按原样读取。
+// 它不提供本地路径，需要直接读取磁盘文件的功能（视频封面、HLS、硬链接去重等）在加密存储上不可用
+type encryptedStorage struct {
+	storage
+	cache *encCache
+}
+
+// localEncCache 由所有本地加密存储共用，以磁盘路径为键
+var localEncCache = &encCache{}
+
+// encryptStorage 在启用加密时包装存储，否则原样返回
+func encryptStorage(s storage) storage {
+	if _, ok := s.(*encryptedStorage); ok || masterAEAD == nil {
+		return s
+	}
+	if _, ok := s.(localPather); ok {
+		return &encryptedStorage{storage: s, cache: localEncCache}
+	}
+	return &encryptedStorage{storage: s, cache: &encCache{}}
+}
+
+// baseStorage 返回加密存储或内容存储之下的存储，供只处理文件位置、不读取内容的功能使用，如剩余空间检查和清理临时文件
+func baseStorage(s storage) storage {
+	switch b := s.(type) {
+	case *encryptedStorage:
+		return b.storage
+	case *casStorage:
+		return b.storage
+	}
+	return s
+}
+
+// encCache 记录文件是否已加密，以路径、大小和修改时间判断是否仍然有效
+type encCache struct {
+	mu      sync.Mutex
+	entries map[string]encCacheEntry
+}
+
+type encCacheEntry struct {
+	size      int64
+	mod       time.Time
+	encrypted bool
+}
+
+// encCacheMax 是缓存的最大条目数，超出时清空重建
+const encCacheMax = 100000
+
+func (e *encryptedStorage) List(name string) ([]fs.FileInfo, error) {
+	infos, err := e.storage.List(name)
+	for i, info := range infos {
+		infos[i] = e.plainInfo(path.Join(name, info.Name()), info)
+	}
+	return infos, err
+}
+
+func (e *encryptedStorage) Stat(name string) (fs.FileInfo, error) {
+	info, err := e.storage.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.plainInfo(name, info), nil
+}
+
+func (e *encryptedStorage) Walk(name string, fn walkFunc) error {
+	return e.storage.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err == nil {
+			info = e.plainInfo(p, info)
+		}
+		return fn(p, info, err)
+	})
+}
+
+// plainInfo 对加密文件返回明文大小
+func (e *encryptedStorage) plainInfo(name string, info fs.FileInfo) fs.FileInfo {
+	if !info.Mode().IsRegular() || info.Size() < int64(encHeaderSize+encTagSize) {
+		return info
+	}
+	key := localPath(e.storage, name)
+	e.cache.mu.Lock()
+	c, ok := e.cache.entries[key]
+	e.cache.mu.Unlock()
+	if !ok || c.size != info.Size() || !c.mod.Equal(info.ModTime()) {
+		f, err := e.storage.Open(name)
+		if err != nil {
+			return info
+		}
+		magic := make([]byte, len(encMagic))
+		_, err = io.ReadFull(f, magic)
+		f.Close()
+		c = encCacheEntry{size: info.Size(), mod: info.ModTime(), encrypted: err == nil && string(magic) == encMagic}
+		e.cache.mu.Lock()
+		if e.cache.entries == nil || len(e.cache.entries) >= encCacheMax {
+			e.cache.entries = make(map[string]encCacheEntry)
+		}
+		e.cache.entries[key] = c
+		e.cache.mu.Unlock()
+	}
+	if !c.encrypted {
+		return info
+	}
+	return plainSizeInfo{info, plainSize(info.Size())}
+}
+
+// plainSizeInfo 以明文大小代替磁盘上的大小
+type plainSizeInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (p plainSizeInfo) Size() int64 { return p.size }
+
+// plainSize 由加密文件的大小计算明文大小
+func plainSize(size int64) int64 {
+	body := size - int64(encHeaderSize)
+	full, rest := body/(encChunkSize+encTagSize), body%(encChunkSize+encTagSize)
+	if rest == 0 {
+		return full * encChunkSize
+	}
+	return full*encChunkSize + max(rest-encTagSize, 0)
+}
+
+// Open 打开文件；加密文件返回可定位的解密读取器，未加密的文件按原样返回
+func (e *encryptedStorage) Open(name string) (storageFile, error) {
+	f, err := e.storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return f, nil
+	}
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(encMagic)]) != encMagic {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	aead, err := unwrapFileKey(header)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &decryptFile{f: f, aead: aead, cipherSize: info.Size(), size: plainSize(info.Size()), chunk: -1}, nil
+}
+
+// unwrapFileKey 用主密钥解开文件头中的文件密钥
+func unwrapFileKey(header []byte) (cipher.AEAD, error) {
+	idEnd := len(encMagic) + encKeyIDSize
+	if !bytes.Equal(header[len(encMagic):idEnd], masterKeyID) {
+		return nil, errEncryptionKey
+	}
+	nonce := header[idEnd : idEnd+12]
+	key, err := masterAEAD.Open(nil, nonce, header[idEnd+12:], header[:idEnd])
+	if err != nil {
+		return nil, fmt.Errorf("corrupt encryption header: %v", err)
+	}
+	return newGCM(key)
+}
+
+// chunkNonce 返回数据块的 nonce：块序号和是否为最后一块
+func chunkNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(index))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// decryptFile 按块解密读取加密文件，缓存当前块以便顺序读取和 Range 请求
+type decryptFile struct {
+	f          storageFile
+	aead       cipher.AEAD
+	cipherSize int64
+	size       int64
+	pos        int64
+	chunk      int64
+	plain      []byte
+}
+
+func (d *decryptFile) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	index := d.pos / encChunkSize
+	if index != d.chunk {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.pos-index*encChunkSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+// load 读取并解密第 index 块
+func (d *decryptFile) load(index int64) error {
+	last := int64(0)
+	if d.size > 0 {
+		last = (d.size - 1) / encChunkSize
+	}
+	off := int64(encHeaderSize) + index*(encChunkSize+encTagSize)
+	n := int64(encChunkSize + encTagSize)
+	if index == last {
+		n = d.cipherSize - off
+	}
+	if _, err := d.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.f, buf); err != nil {
+		return err
+	}
+	plain, err := d.aead.Open(buf[:0], chunkNonce(index, index == last), buf, nil)
+	if err != nil {
+		d.chunk = -1
+		return fmt.Errorf("decrypt block %d: %v", index, err)
+	}
+	d.chunk, d.plain = index, plain
+	return nil
+}
+
+func (d *decryptFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decryptFile) Stat() (fs.FileInfo, error) {
+	info, err := d.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return plainSizeInfo{info, d.size}, nil
+}
+
+func (d *decryptFile) Close() error { return d.f.Close() }
+
+// Create 创建加密文件：生成随机文件密钥，用主密钥包装后写入文件头
+func (e *encryptedStorage) Create(name string) (io.WriteCloser, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	header = append(header, masterKeyID...)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	header = masterAEAD.Seal(header, nonce, key, header[:len(encMagic)+encKeyIDSize])
+
+	w, err := e.storage.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead}, nil
+}
+
+// encryptWriter 缓存不足一块的数据，写满一块且后面还有数据时才加密写出，最后一块在 Close 时写出
+type encryptWriter struct {
+	w     io.WriteCloser
+	aead  cipher.AEAD
+	buf   []byte
+	index int64
+	err   error
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > encChunkSize {
+		if e.err = e.flush(e.buf[:encChunkSize], false); e.err != nil {
+			return 0, e.err
+		}
+		e.buf = append(e.buf[:0], e.buf[encChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (e *encryptWriter) flush(plain []byte, final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.index, final), plain, nil)
+	e.index++
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	err := e.err
+	if err == nil {
+		err = e.flush(e.buf, true)
+	}
+	if cerr := e.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
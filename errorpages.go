@@ -0,0 +1,181 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errorPagesDir 由 -error-pages 设置，其中的 <状态码>.html（如 404.html）或 error.html 替换浏览器看到的默认错误页
+var errorPagesDir string
+
+// errorPageHTML 是默认的错误页模板
+const errorPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Status}} {{.Title}}</title>
+    <meta charset="UTF-8">
+    <link rel="icon" href="/favicon.ico">
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    <p>{{.Message}}</p>
+    <p><a href="/">&larr; Back to the file list</a></p>
+    {{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>`
+
+// errorPage 是错误页模板的数据
+type errorPage struct {
+	Status    int
+	Title     string
+	Message   string
+	Path      string
+	RequestID string
+}
+
+// apiError 是 API 请求的错误响应
+type apiError struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorTemplates 以状态码为键，0 为其他状态码使用的模板
+var errorTemplates = map[int]*template.Template{0: template.Must(template.New("error").Parse(errorPageHTML))}
+
+// loadErrorPages 解析 -error-pages 目录中的模板，模板有误时返回错误
+func loadErrorPages() error {
+	if errorPagesDir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(errorPagesDir, "*.html"))
+	if err != nil {
+		return err
+	}
+	loaded := 0
+	for _, f := range files {
+		base := strings.TrimSuffix(filepath.Base(f), ".html")
+		status, err := strconv.Atoi(base)
+		if base == "error" {
+			status, err = 0, nil
+		}
+		if err != nil || (status != 0 && (status < 400 || status > 599)) {
+			continue
+		}
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(filepath.Base(f)).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("error page %s: %v", f, err)
+		}
+		errorTemplates[status] = t
+		loaded++
+	}
+	logger.Printf("Loaded %d custom error pages from %s", loaded, errorPagesDir)
+	return nil
+}
+
+// errorFormat 决定错误响应的格式：/api/v1/ 下的请求和只接受 JSON 的客户端得到 JSON，浏览器得到 HTML 页面，其他客户端（如 curl）得到纯文本
+func errorFormat(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v1/"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	}
+	return "text"
+}
+
+// errorPageWriter 截留 http.Error 写出的纯文本错误，在处理函数返回后按请求方改写为 JSON 或 HTML
+type errorPageWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+// WriteHeader 识别 http.Error 的响应：4xx/5xx 状态码、纯文本类型和 nosniff
+func (w *errorPageWriter) WriteHeader(code int) {
+	h := w.Header()
+	if !w.wroteHeader && w.status == 0 && code >= 400 &&
+		h.Get("Content-Type") == "text/plain; charset=utf-8" && h.Get("X-Content-Type-Options") == "nosniff" {
+		w.status = code
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if w.status != 0 {
+		if w.buf.Len() < 64<<10 {
+			w.buf.Write(p)
+		}
+		return len(p), nil
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorPageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == 0 {
+		f.Flush()
+	}
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish 写出截留的错误
+func (w *errorPageWriter) finish(r *http.Request) {
+	if w.status == 0 {
+		return
+	}
+	msg := strings.TrimSpace(w.buf.String())
+	h := w.Header()
+	switch errorFormat(r) {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(w.status)
+		json.NewEncoder(w.ResponseWriter).Encode(apiError{Error: msg, Status: w.status, RequestID: requestID(r.Context())})
+		return
+	case "html":
+		t := errorTemplates[w.status]
+		if t == nil {
+			t = errorTemplates[0]
+		}
+		var page bytes.Buffer
+		err := t.Execute(&page, errorPage{Status: w.status, Title: http.StatusText(w.status), Message: msg, Path: r.URL.Path, RequestID: requestID(r.Context())})
+		if err == nil {
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			w.ResponseWriter.WriteHeader(w.status)
+			w.ResponseWriter.Write(page.Bytes())
+			return
+		}
+		logger.Printf("Error rendering error page: %v", err)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// errorPageMiddleware 将处理函数用 http.Error 返回的纯文本错误改写为 JSON 或 HTML 错误页
+func errorPageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
@@ -0,0 +1,343 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 错误上报配置：-sentry-dsn 为 Sentry 兼容服务的 DSN（默认取 SENTRY_DSN），-error-webhook 为接收 JSON 错误报告的 URL
+var (
+	sentryDSN      = os.Getenv("SENTRY_DSN")
+	errorWebhooks  stringList
+	errorReporters []errorReporter
+)
+
+// errorReportLimit 是每分钟最多发送的错误报告数，避免故障时大量请求压垮上报服务；errorReportTimeout 是每次发送的超时
+const (
+	errorReportLimit   = 30
+	errorReportTimeout = 10 * time.Second
+)
+
+// errorReporter 上报意外的服务器错误
+type errorReporter interface {
+	Name() string
+	Report(rep errorReport) error
+}
+
+// errorReport 是一次 5xx 响应或 panic 的报告，也是发送给 -error-webhook 的 JSON 负载
+type errorReport struct {
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+	Status     int       `json:"status"`
+	Panic      bool      `json:"panic,omitempty"`
+	Stack      string    `json:"stack,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Version    string    `json:"version"`
+
+	frames []runtime.Frame // panic 时的调用栈，从 panic 处开始
+}
+
+// setupErrorReporting 根据命令行参数初始化错误上报器
+func setupErrorReporting() error {
+	if sentryDSN != "" {
+		s, err := newSentryReporter(sentryDSN)
+		if err != nil {
+			return err
+		}
+		errorReporters = append(errorReporters, s)
+		logger.Printf("Reporting server errors to Sentry project %s at %s", s.project, s.endpoint)
+	}
+	for _, u := range errorWebhooks {
+		if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+			return fmt.Errorf("-error-webhook must be an http(s) URL: %q", u)
+		}
+		errorReporters = append(errorReporters, &webhookErrorReporter{url: u})
+		logger.Printf("Reporting server errors to %s", u)
+	}
+	return nil
+}
+
+// errorReportWindow 限制每分钟的报告数
+var errorReportWindow struct {
+	sync.Mutex
+	start   time.Time
+	sent    int
+	dropped int
+}
+
+// reportError 异步地将报告发送给所有上报器，超过每分钟上限的报告被丢弃
+func reportError(rep errorReport) {
+	if len(errorReporters) == 0 {
+		return
+	}
+	w := &errorReportWindow
+	w.Lock()
+	if time.Since(w.start) > time.Minute {
+		if w.dropped > 0 {
+			logger.Printf("Dropped %d error reports over the limit of %d per minute", w.dropped, errorReportLimit)
+		}
+		w.start, w.sent, w.dropped = time.Now(), 0, 0
+	}
+	if w.sent >= errorReportLimit {
+		w.dropped++
+		w.Unlock()
+		return
+	}
+	w.sent++
+	w.Unlock()
+
+	if rep.Time.IsZero() {
+		rep.Time = time.Now().UTC()
+	}
+	rep.Version = version
+	for _, er := range errorReporters {
+		go func(er errorReporter) {
+			if err := er.Report(rep); err != nil {
+				logger.Printf("Error reporter %s failed: %v", er.Name(), err)
+			}
+		}(er)
+	}
+}
+
+// newErrorReport 创建包含请求信息的报告
+func newErrorReport(r *http.Request, status int, msg string) errorReport {
+	u := *r.URL
+	u.Host = r.Host
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.User = nil
+	// 分享和签名链接的令牌在查询参数中，不随报告发出
+	if q := u.Query(); q.Has("token") || q.Has("sig") {
+		q.Del("token")
+		q.Del("sig")
+		u.RawQuery = q.Encode()
+	}
+	return errorReport{
+		Message:    msg,
+		Status:     status,
+		RequestID:  requestID(r.Context()),
+		Method:     r.Method,
+		URL:        u.String(),
+		User:       currentUser(r),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+// reportedStatus 判断状态码是否为意外错误：501、503 和 507 是预期的拒绝，不上报
+func reportedStatus(code int) bool {
+	return code >= 500 && code != http.StatusNotImplemented && code != http.StatusServiceUnavailable && code != http.StatusInsufficientStorage
+}
+
+// errorReportWriter 记录响应状态码，并保留 5xx 响应开头的错误信息
+type errorReportWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *errorReportWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorReportWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if reportedStatus(w.status) && w.body.Len() < 1024 {
+		w.body.Write(p[:min(len(p), 1024-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorReportWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *errorReportWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorReportMiddleware 上报处理函数返回的 5xx 响应和 panic；panic 上报后继续抛出，由 net/http 记录并断开连接
+func errorReportMiddleware(next http.Handler) http.Handler {
+	if len(errorReporters) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorReportWriter{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					rep := newErrorReport(r, http.StatusInternalServerError, fmt.Sprint(v))
+					rep.Panic = true
+					rep.Stack = string(debug.Stack())
+					rep.frames = panicFrames()
+					reportError(rep)
+				}
+				panic(v)
+			}
+			if reportedStatus(ew.status) {
+				msg := strings.TrimSpace(ew.body.String())
+				if msg == "" {
+					msg = http.StatusText(ew.status)
+				}
+				reportError(newErrorReport(r, ew.status, msg))
+			}
+		}()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// panicFrames 在 recover 所在的延迟函数中调用，返回从 panic 处开始的调用栈
+func panicFrames() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var out []runtime.Frame
+	for {
+		f, more := frames.Next()
+		// 跳过 runtime 中处理 panic 的帧
+		if !(len(out) == 0 && strings.HasPrefix(f.Function, "runtime.")) {
+			out = append(out, f)
+		}
+		if !more {
+			return out
+		}
+	}
+}
+
+// webhookErrorReporter 将报告以 JSON POST 到 URL，设置了 -webhook-secret 时与文件事件一样签名
+type webhookErrorReporter struct {
+	url string
+}
+
+func (h *webhookErrorReporter) Name() string { return h.url }
+
+func (h *webhookErrorReporter) Report(rep errorReport) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return postWebhook(&http.Client{Timeout: errorReportTimeout}, h.url, body)
+}
+
+// sentryReporter 将报告作为事件发送到 Sentry 或兼容的服务（如 GlitchTip）
+type sentryReporter struct {
+	endpoint string
+	key      string
+	project  string
+}
+
+// newSentryReporter 解析 https://<key>@<host>/<project> 形式的 DSN
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User == nil || u.Host == "" {
+		return nil, fmt.Errorf("-sentry-dsn must look like https://<key>@<host>/<project>")
+	}
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("-sentry-dsn has no project ID")
+	}
+	return &sentryReporter{
+		endpoint: u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/store/",
+		key:      u.User.Username(),
+		project:  project,
+	}, nil
+}
+
+func (s *sentryReporter) Name() string { return "sentry" }
+
+func (s *sentryReporter) Report(rep errorReport) error {
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	ev := map[string]any{
+		"event_id":    id,
+		"timestamp":   rep.Time.Format(time.RFC3339Nano),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "fileserver",
+		"server_name": host,
+		"release":     "fileserver@" + rep.Version,
+		"request": map[string]any{
+			"method":  rep.Method,
+			"url":     rep.URL,
+			"headers": map[string]string{"User-Agent": rep.UserAgent},
+			"env":     map[string]string{"REMOTE_ADDR": rep.RemoteAddr},
+		},
+		"tags": map[string]string{
+			"status":     fmt.Sprint(rep.Status),
+			"request_id": rep.RequestID,
+		},
+	}
+	if rep.User != "" {
+		ev["user"] = map[string]string{"username": rep.User}
+	}
+	if rep.Panic {
+		// Sentry 的调用栈从最外层开始
+		frames := make([]map[string]any, 0, len(rep.frames))
+		for i := len(rep.frames) - 1; i >= 0; i-- {
+			f := rep.frames[i]
+			frames = append(frames, map[string]any{
+				"function": f.Function,
+				"abs_path": f.File,
+				"filename": f.File,
+				"lineno":   f.Line,
+				"in_app":   strings.HasPrefix(f.Function, "file-server"),
+			})
+		}
+		ev["exception"] = map[string]any{"values": []any{map[string]any{
+			"type":       "panic",
+			"value":      rep.Message,
+			"stacktrace": map[string]any{"frames": frames},
+		}}}
+	} else {
+		ev["message"] = map[string]string{"formatted": fmt.Sprintf("%d %s: %s", rep.Status, rep.URL, rep.Message)}
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fileserver/"+version)
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=fileserver/"+version+", sentry_key="+s.key)
+	resp, err := (&http.Client{Timeout: errorReportTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
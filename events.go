@@ -0,0 +1,224 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文件系统变化事件类型
+const (
+	changeCreate = "create"
+	changeModify = "modify"
+	changeDelete = "delete"
+	changeRename = "rename"
+)
+
+// watchEnabled 由 -watch 设置，启用后监视服务目录和挂载目录中直接在磁盘上发生的变化
+var watchEnabled bool
+
+// watching 表示文件系统监视已启动；未启动时变化日志只记录经由服务器的上传和删除
+var watching bool
+
+// changeEvent 是 /api/v1/events 返回的一条变化，ID 单调递增，可作为游标
+type changeEvent struct {
+	ID      int64     `json:"id"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// changeLogSize 是内存中保留的最近变化数量
+const changeLogSize = 1000
+
+// changeLog 是最近变化的环形日志，新事件到来时唤醒所有等待者
+type changeLog struct {
+	mu     sync.Mutex
+	events []changeEvent
+	last   int64
+	wake   chan struct{}
+}
+
+var changes = &changeLog{wake: make(chan struct{})}
+
+// add 追加一条变化
+func (l *changeLog) add(op, path, oldPath string, isDir bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last++
+	l.events = append(l.events, changeEvent{ID: l.last, Op: op, Path: path, OldPath: oldPath, IsDir: isDir, Time: time.Now().UTC()})
+	if len(l.events) > changeLogSize {
+		l.events = append([]changeEvent(nil), l.events[len(l.events)-changeLogSize:]...)
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// since 返回游标之后的变化、新的游标，以及游标之后是否有变化已被丢弃；
+// 没有新变化时同时返回在下一条变化到来时关闭的通道
+func (l *changeLog) since(cursor int64) ([]changeEvent, int64, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cursor > l.last || cursor < 0 {
+		cursor = l.last
+	}
+	var out []changeEvent
+	truncated := false
+	if len(l.events) > 0 && cursor < l.events[0].ID-1 {
+		truncated = true
+	}
+	for _, ev := range l.events {
+		if ev.ID > cursor {
+			out = append(out, ev)
+		}
+	}
+	return out, l.last, truncated, l.wake
+}
+
+// head 返回最新变化的 ID
+func (l *changeLog) head() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// recordUploadEvent 在未监视文件系统时，将经由服务器的上传和删除记入变化日志
+func recordUploadEvent(ev fileEvent) {
+	if watching {
+		return // 监视器会从磁盘观察到同样的变化
+	}
+	switch ev.Event {
+	case eventUpload:
+		changes.add(changeCreate, ev.Path, "", false)
+	case eventDelete:
+		changes.add(changeDelete, ev.Path, "", false)
+	}
+}
+
+// canSeePath 判断用户能否看到路径：多用户模式下普通用户只能看到自己的主目录和挂载点
+func canSeePath(r *http.Request, p string) bool {
+	if !userHomes || isAdmin(r) {
+		return true
+	}
+	first, _, _ := strings.Cut(p, "/")
+	if _, ok := findMount(first); ok {
+		return true
+	}
+	return first == currentUser(r)
+}
+
+// visibleChanges 过滤掉用户无权看到的变化
+func visibleChanges(r *http.Request, events []changeEvent) []changeEvent {
+	out := make([]changeEvent, 0, len(events))
+	for _, ev := range events {
+		if canSeePath(r, ev.Path) || (ev.OldPath != "" && canSeePath(r, ev.OldPath)) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// maxEventWait 是长轮询的最长等待时间
+const maxEventWait = 5 * time.Minute
+
+// apiEventsHandler 处理 GET /api/v1/events，返回游标之后的文件变化（create、modify、delete、rename）：
+// 默认为长轮询，?cursor= 为上次返回的游标（省略时从现在开始），?timeout= 为没有变化时的最长等待时间；
+// 请求头 Accept: text/event-stream 时以 SSE 持续推送，断线重连时使用 Last-Event-ID 续传
+func apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	cursor := changes.head()
+	v := q.Get("cursor")
+	if v == "" {
+		v = r.Header.Get("Last-Event-ID")
+	}
+	if v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = n
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamEvents(w, r, cursor)
+		return
+	}
+
+	wait := 30 * time.Second
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid timeout", http.StatusBadRequest)
+			return
+		}
+		wait = min(d, maxEventWait)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	anyTruncated := false
+	for {
+		events, next, truncated, wake := changes.since(cursor)
+		anyTruncated = anyTruncated || truncated
+		events = visibleChanges(r, events)
+		cursor = next
+		if len(events) > 0 || anyTruncated {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"cursor": cursor, "events": events, "truncated": anyTruncated})
+			return
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"cursor": cursor, "events": events, "truncated": false})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamEvents 以 Server-Sent Events 推送变化，每 15 秒发送一次注释行保持连接
+func streamEvents(w http.ResponseWriter, r *http.Request, cursor int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		events, next, truncated, wake := changes.since(cursor)
+		if truncated {
+			fmt.Fprintf(w, "event: truncated\ndata: {}\n\n")
+		}
+		for _, ev := range visibleChanges(r, events) {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Op, data)
+		}
+		cursor = next
+		flusher.Flush()
+		select {
+		case <-wake:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
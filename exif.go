@@ -0,0 +1,184 @@
+package fileserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// stripEXIF 由 -strip-exif 设置：为 true 时去掉所有上传的 JPEG 中的 EXIF 元数据；
+// 否则只对带 strip_exif=1 参数的上传去掉
+var stripEXIF bool
+
+var errInvalidJPEG = errors.New("invalid JPEG: cannot remove metadata")
+
+// wantStripEXIF 判断上传的 JPEG 是否需要去掉元数据
+func wantStripEXIF(r *http.Request) bool {
+	if stripEXIF {
+		return true
+	}
+	// 不用 FormValue，以免读取 PUT 请求体
+	v := r.URL.Query().Get("strip_exif")
+	if v == "" && r.MultipartForm != nil {
+		if vs := r.MultipartForm.Value["strip_exif"]; len(vs) > 0 {
+			v = vs[0]
+		}
+	}
+	strip, _ := strconv.ParseBool(v)
+	return strip
+}
+
+// stripEXIFOption 返回上传表单中的“去掉照片元数据”复选框，服务器已统一去掉时不显示
+func stripEXIFOption() string {
+	if stripEXIF {
+		return ""
+	}
+	return `<label><input type="checkbox" name="strip_exif" value="1"> Remove photo metadata (EXIF, GPS)</label>
+        `
+}
+
+// copyUpload 将上传内容复制到 dst；需要去掉元数据时 JPEG 经 copyWithoutEXIF 复制，其他文件原样复制
+func copyUpload(r *http.Request, dst io.Writer, src io.Reader) (int64, error) {
+	if wantStripEXIF(r) {
+		return copyWithoutEXIF(dst, src)
+	}
+	return io.Copy(dst, src)
+}
+
+// JPEG 段标记
+const (
+	jpegSOI   = 0xD8
+	jpegEOI   = 0xD9
+	jpegSOS   = 0xDA
+	jpegAPP1  = 0xE1 // EXIF（含 GPS 位置、相机型号、拍摄时间）和 XMP
+	jpegAPP13 = 0xED // Photoshop IPTC
+	jpegCOM   = 0xFE
+)
+
+// copyWithoutEXIF 复制 JPEG 并去掉 APP1、APP13 和注释段，不重新编码图像。
+// EXIF 中的方向不为默认值时写入只含方向的最小 EXIF 段，保证图片显示方向不变。
+// src 不是 JPEG 时原样复制
+func copyWithoutEXIF(dst io.Writer, src io.Reader) (int64, error) {
+	br := bufio.NewReader(src)
+	if head, err := br.Peek(2); err != nil || head[0] != 0xFF || head[1] != jpegSOI {
+		return io.Copy(dst, br)
+	}
+	br.Discard(2)
+	var written int64
+	write := func(b ...[]byte) error {
+		for _, p := range b {
+			n, err := dst.Write(p)
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write([]byte{0xFF, jpegSOI}); err != nil {
+		return written, err
+	}
+	orientationWritten := false
+	for {
+		marker, err := readJPEGMarker(br)
+		if err != nil {
+			return written, err
+		}
+		if marker == jpegEOI || marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			if err := write([]byte{0xFF, marker}); err != nil {
+				return written, err
+			}
+			if marker == jpegEOI {
+				n, err := io.Copy(dst, br)
+				return written + n, err
+			}
+			continue
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return written, jpegError(err)
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return written, errInvalidJPEG
+		}
+		payload := make([]byte, n-2)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return written, jpegError(err)
+		}
+		switch marker {
+		case jpegAPP1, jpegAPP13, jpegCOM:
+			if o := exifOrientation(payload); o > 1 && !orientationWritten {
+				orientationWritten = true
+				if err := write(orientationSegment(o)); err != nil {
+					return written, err
+				}
+			}
+			continue
+		}
+		if err := write([]byte{0xFF, marker}, length[:], payload); err != nil {
+			return written, err
+		}
+		if marker == jpegSOS {
+			// 扫描数据开始，之后不再有元数据段
+			n, err := io.Copy(dst, br)
+			return written + n, err
+		}
+	}
+}
+
+// readJPEGMarker 读取下一个段标记，跳过填充的 0xFF
+func readJPEGMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, jpegError(err)
+	}
+	if b != 0xFF {
+		return 0, errInvalidJPEG
+	}
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, jpegError(err)
+		}
+	}
+	return b, nil
+}
+
+// jpegError 将截断的数据视为无效 JPEG，保留客户端中断等其他读取错误
+func jpegError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errInvalidJPEG
+	}
+	return err
+}
+
+// exifOrientation 返回 APP1 EXIF 段中 IFD0 的方向（1-8），不是 EXIF 或没有方向时返回 0
+func exifOrientation(payload []byte) int {
+	t := newTIFFReader(payload)
+	if t == nil {
+		return 0
+	}
+	for _, e := range t.entries(t.ifd0()) {
+		if e.tag == exifOrientationTag {
+			if o := t.uint(e); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 0
+}
+
+// orientationSegment 生成只含方向标签的 APP1 EXIF 段
+func orientationSegment(o int) []byte {
+	return []byte{
+		0xFF, jpegAPP1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // TIFF 头，IFD0 紧随其后
+		0x00, 0x01, // 1 个条目
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(o), 0x00, 0x00, // Orientation, SHORT, 1
+		0x00, 0x00, 0x00, 0x00, // 没有下一个 IFD
+	}
+}
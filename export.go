@@ -0,0 +1,323 @@
+package fileserver
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportManifestName 是导出归档中第一个条目，记录导出的根路径和元数据；文件和目录放在 files/ 下
+const exportManifestName = "fileserver-export.json"
+
+// exportManifest 是导出归档的清单，其中的路径都相对于导出的根目录，"" 表示根目录本身
+type exportManifest struct {
+	Version int                 `json:"version"`
+	Created time.Time           `json:"created"`
+	Root    string              `json:"root"`
+	Tags    map[string][]string `json:"tags,omitempty"`
+	Shares  []*share            `json:"shares,omitempty"`
+}
+
+// importResult 是导入的统计
+type importResult struct {
+	Files   int   `json:"files"`
+	Dirs    int   `json:"dirs"`
+	Skipped int   `json:"skipped"`
+	Bytes   int64 `json:"bytes"`
+	Tags    int   `json:"tags"`
+	Shares  int   `json:"shares"`
+}
+
+// relativeTo 返回虚拟路径 p 相对于 root 的路径，p 不在 root 下时返回 false
+func relativeTo(root, p string) (string, bool) {
+	switch {
+	case root == "":
+		return p, true
+	case p == root:
+		return "", true
+	case strings.HasPrefix(p, root+"/"):
+		return p[len(root)+1:], true
+	}
+	return "", false
+}
+
+// exportHandler 处理 GET /export?path=（仅管理员），将目录（默认为服务目录）连同标签和分享链接流式输出为 tar 归档，
+// gzip=true 时压缩为 .tar.gz。不进入挂载点，每个挂载点需单独导出；.fileserver 元数据目录和遗留的临时文件不包括在内
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	p := cleanName(r.URL.Query().Get("path"))
+	vol, name, err := resolveVirtual(r.Context(), p)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if info, err := vol.store.Stat(name); err != nil || !info.IsDir() {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	root := vol.virtual(name)
+
+	manifest := exportManifest{Version: 1, Created: time.Now().UTC(), Root: root, Tags: map[string][]string{}}
+	tags.mu.Lock()
+	for v, list := range tags.items {
+		if rel, ok := relativeTo(root, v); ok {
+			manifest.Tags[rel] = list
+		}
+	}
+	tags.mu.Unlock()
+	for _, sh := range shares.list("", true) {
+		if rel, ok := relativeTo(root, sh.Path); ok && !sh.expired() {
+			c := *sh
+			c.Path = rel
+			manifest.Shares = append(manifest.Shares, &c)
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := path.Base(root)
+	if root == "" {
+		base = "files"
+	}
+	filename := fmt.Sprintf("fileserver-export-%s-%s.tar", base, manifest.Created.Format("20060102"))
+	var out io.Writer = w
+	if r.URL.Query().Get("gzip") == "true" {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
+
+	tw := tar.NewWriter(out)
+	err = tw.WriteHeader(&tar.Header{Name: exportManifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.Created})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	files := 0
+	if err == nil {
+		err = vol.store.Walk(name, func(n string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := relativeTo(name, n)
+			if rel == "" {
+				return nil
+			}
+			if info.IsDir() {
+				if n == metaDirName {
+					return fs.SkipDir
+				}
+				return tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "files/" + rel + "/", Mode: int64(info.Mode().Perm()), ModTime: info.ModTime()})
+			}
+			if !info.Mode().IsRegular() || stagingFilePattern.MatchString(info.Name()) {
+				return nil
+			}
+			f, err := vol.store.Open(n)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := tw.WriteHeader(&tar.Header{Name: "files/" + rel, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+			files++
+			return nil
+		})
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		// 响应已经开始，只能中断归档
+		logger.Printf("Error exporting %q: %v", root, err)
+		return
+	}
+	logger.Printf("Exported %q (%d files, %d tags, %d share links)", root, files, len(manifest.Tags), len(manifest.Shares))
+}
+
+// importHandler 处理 POST /import?dir=（仅管理员），将 /export 生成的 tar 或 .tar.gz 归档恢复到目录（默认为服务目录），
+// 并恢复其中的标签和分享链接，分享链接保留原来的令牌和短代码。已存在的文件默认跳过，overwrite=true 时替换。
+// 导入的文件不经过病毒扫描和上传钩子，也不发送通知
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	vol, name, err := resolveVirtual(r.Context(), r.URL.Query().Get("dir"))
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if vol.readOnly {
+		http.Error(w, "Directory is read-only", http.StatusForbidden)
+		return
+	}
+	if err := checkFreeSpace(vol, declaredSize(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+
+	var body io.Reader = bufio.NewReader(r.Body)
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "Invalid archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var res importResult
+	var manifest *exportManifest
+	type dirTime struct {
+		name    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	tr := tar.NewReader(body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Printf("Error importing into %q after %d files: %v", vol.virtual(name), res.Files, err)
+			http.Error(w, fmt.Sprintf("Invalid archive after %d files: %v", res.Files, err), http.StatusBadRequest)
+			return
+		}
+		if hdr.Name == exportManifestName {
+			manifest = &exportManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				http.Error(w, "Invalid export manifest: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		rel, ok := strings.CutPrefix(hdr.Name, "files/")
+		rel = strings.TrimSuffix(rel, "/")
+		if !ok || rel == "" {
+			continue
+		}
+		if cleanName(rel) != rel || reservedPath(rel) {
+			res.Skipped++
+			continue
+		}
+		target := path.Join(name, rel)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := vol.store.Mkdir(target); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to create %s: %v", rel, err), http.StatusInternalServerError)
+				return
+			}
+			dirs = append(dirs, dirTime{target, hdr.ModTime})
+			res.Dirs++
+		case tar.TypeReg:
+			written, err := importFile(vol, target, tr, hdr, overwrite)
+			if err != nil {
+				logger.Printf("Error importing %s: %v", vol.virtual(target), err)
+				http.Error(w, fmt.Sprintf("Failed to write %s: %v", rel, err), http.StatusInternalServerError)
+				return
+			}
+			if !written {
+				res.Skipped++
+				continue
+			}
+			res.Files++
+			res.Bytes += hdr.Size
+			if !watching {
+				changes.add(changeCreate, vol.virtual(target), "", false)
+			}
+		default:
+			res.Skipped++
+		}
+	}
+	if manifest == nil && res.Files+res.Dirs+res.Skipped == 0 {
+		http.Error(w, "Not a fileserver export archive", http.StatusBadRequest)
+		return
+	}
+
+	// 目录的修改时间在其中的文件写入后才能恢复，由深到浅设置
+	if lp, ok := baseStorage(vol.store).(localPather); ok {
+		sort.Slice(dirs, func(i, j int) bool { return dirs[i].name > dirs[j].name })
+		for _, d := range dirs {
+			os.Chtimes(lp.LocalPath(d.name), d.modTime, d.modTime)
+		}
+	}
+	if manifest != nil {
+		for rel, list := range manifest.Tags {
+			if list, err := normalizeTags(list); err == nil && len(list) > 0 && cleanName(rel) == rel {
+				tags.set(vol.virtual(path.Join(name, rel)), list)
+				res.Tags++
+			}
+		}
+		for _, sh := range manifest.Shares {
+			if cleanName(sh.Path) != sh.Path || sh.Token == "" {
+				continue
+			}
+			sh.Path = vol.virtual(path.Join(name, sh.Path))
+			if shares.restore(sh) {
+				res.Shares++
+			}
+		}
+	}
+	logger.Printf("Imported into %q: %d files, %d folders, %d skipped, %d tags, %d share links",
+		vol.virtual(name), res.Files, res.Dirs, res.Skipped, res.Tags, res.Shares)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// importFile 将归档中的文件写入目标旁的临时文件后改名到位，并恢复修改时间。
+// 目标已存在且不覆盖（或是目录）时丢弃内容并返回 false
+func importFile(vol volume, name string, r io.Reader, hdr *tar.Header, overwrite bool) (bool, error) {
+	if info, err := vol.store.Stat(name); err == nil && (info.IsDir() || !overwrite) {
+		return false, nil
+	}
+	if err := vol.store.Mkdir(path.Dir(name)); err != nil {
+		return false, err
+	}
+	err := stageAndCommit(vol.store, name, "import", func(dst io.Writer) error {
+		_, err := io.Copy(dst, r)
+		return err
+	}, nil)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("archive is truncated")
+		}
+		return false, err
+	}
+	if lp, ok := baseStorage(vol.store).(localPather); ok {
+		os.Chtimes(lp.LocalPath(name), hdr.ModTime, hdr.ModTime)
+	}
+	forgetChecksum(vol, name)
+	return true, nil
+}
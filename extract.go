@@ -0,0 +1,277 @@
+package fileserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormat 是文件夹上传（.up）支持的归档格式
+type archiveFormat string
+
+const (
+	formatZip    archiveFormat = "zip"
+	formatTar    archiveFormat = "tar"
+	formatTarGz  archiveFormat = "tar.gz"
+	formatTarBz2 archiveFormat = "tar.bz2"
+	format7z     archiveFormat = "7z"
+)
+
+var errUnknownArchive = errors.New("unsupported archive format (use ZIP, tar, tar.gz, tar.bz2 or 7z)")
+
+// sevenZipCommands 是用于解压 7z 归档的外部命令，按顺序查找第一个可用的
+var sevenZipCommands = []string{"7zz", "7z", "7za"}
+
+// detectArchive 根据文件开头的魔数识别归档格式
+func detectArchive(path string) (archiveFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return formatZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return formatTarGz, nil
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return formatTarBz2, nil
+	case bytes.HasPrefix(head, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}):
+		return format7z, nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return formatTar, nil
+	}
+	return "", errUnknownArchive
+}
+
+// archiveEntryFunc 对归档中的每个条目调用一次，目录条目的 r 为 nil
+type archiveEntryFunc func(name string, isDir bool, r io.Reader) error
+
+// walkArchive 按顺序读取归档中的目录和普通文件；符号链接、设备等其他条目会被跳过
+func walkArchive(path string, format archiveFormat, fn archiveEntryFunc) error {
+	switch format {
+	case formatZip:
+		return walkZip(path, fn)
+	case formatTar, formatTarGz, formatTarBz2:
+		return walkTar(path, format, fn)
+	case format7z:
+		return walk7z(path, fn)
+	}
+	return errUnknownArchive
+}
+
+func walkZip(path string, fn archiveEntryFunc) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		mode := f.FileInfo().Mode()
+		if mode.IsDir() {
+			if err := fn(f.Name, true, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			logger.Printf("Skipping special archive entry: %s", f.Name)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			logger.Printf("Error opening ZIP entry %s: %v", f.Name, err)
+			return err
+		}
+		err = fn(f.Name, false, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(path string, format archiveFormat, fn archiveEntryFunc) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch format {
+	case formatTarGz:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case formatTarBz2:
+		r = bzip2.NewReader(f)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			err = fn(hdr.Name, true, nil)
+		case mode.IsRegular():
+			err = fn(hdr.Name, false, tr)
+		default:
+			logger.Printf("Skipping special archive entry: %s", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walk7z 使用外部 7z 命令将归档解压到私有临时目录，再遍历解压出的条目
+func walk7z(path string, fn archiveEntryFunc) error {
+	var bin string
+	for _, name := range sevenZipCommands {
+		if p, err := exec.LookPath(name); err == nil {
+			bin = p
+			break
+		}
+	}
+	if bin == "" {
+		return errors.New("7z archives need the 7z command (7zz, 7z or 7za) in PATH")
+	}
+	tmp, err := os.MkdirTemp("", "extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	out, err := exec.Command(bin, "x", "-y", "-bd", "-o"+tmp, "--", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("7z: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.IsDir():
+			return fn(rel, true, nil)
+		case d.Type().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return fn(rel, false, f)
+		}
+		logger.Printf("Skipping special archive entry: %s", rel)
+		return nil
+	})
+}
+
+// extractArchive 解压归档到存储中的指定目录，拒绝绝对路径和跳出目标目录的条目；job 不为 nil 时记录进度，ctx 取消时停止
+func extractArchive(ctx context.Context, path string, format archiveFormat, vol volume, destDir string, job *job) error {
+	store := vol.store
+	log := requestLogger(ctx)
+	_, sp := startSpan(ctx, "extract archive")
+	sp.setAttr("archive.format", string(format))
+	defer sp.finish()
+	if err := store.Mkdir(destDir); err != nil {
+		sp.setError(err)
+		return err
+	}
+	entries := 0
+	budget := newTreeBudget(vol, destDir)
+	job.setTotals(archiveTotals(path, format))
+
+	log.Printf("Starting %s extraction to %s", format, destDir)
+
+	err := walkArchive(path, format, func(name string, isDir bool, r io.Reader) error {
+		// 检查路径安全
+		entryName := strings.ReplaceAll(name, "\\", "/")
+		if pathpkg.IsAbs(entryName) || strings.HasPrefix(pathpkg.Clean(entryName), "../") || pathpkg.Clean(entryName) == ".." {
+			log.Printf("Illegal path detected: %s", name)
+			return fmt.Errorf("illegal file path")
+		}
+		fpath := pathpkg.Join(destDir, entryName)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := budget.add(fpath); err != nil {
+			return err
+		}
+		entries++
+		if isDir {
+			log.Printf("Creating directory: %s", fpath)
+			job.advance(1, 0)
+			return store.Mkdir(fpath)
+		}
+
+		if err := store.Mkdir(pathpkg.Dir(fpath)); err != nil {
+			log.Printf("Error creating parent dir for %s: %v", fpath, err)
+			return err
+		}
+
+		log.Printf("Extracting file: %s to %s", name, fpath)
+
+		outFile, err := store.Create(fpath)
+		if err != nil {
+			log.Printf("Error opening output file %s: %v", fpath, err)
+			return err
+		}
+		var dst io.Writer = outFile
+		if job != nil {
+			dst = io.MultiWriter(outFile, job.counter(ctx))
+		}
+		_, err = io.Copy(dst, r)
+		if cerr := outFile.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Printf("Error copying %s: %v", name, err)
+			return err
+		}
+		job.advance(1, 0)
+
+		log.Printf("Successfully extracted: %s", fpath)
+		return nil
+	})
+	sp.setAttr("archive.entries", entries)
+	if err != nil {
+		sp.setError(err)
+		return err
+	}
+
+	log.Printf("Extraction completed for %s", destDir)
+	return nil
+}
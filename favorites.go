@@ -0,0 +1,293 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// favorite 是用户收藏的一个文件或文件夹
+type favorite struct {
+	Path  string    `json:"path"`
+	Added time.Time `json:"added"`
+}
+
+// favoriteStore 按用户保存收藏；未启用认证时所有访问者共用一份收藏（用户名为空字符串）。
+// 每个收藏是元数据库 favorites bucket 中的一条记录，键为 favoriteKey
+type favoriteStore struct {
+	mu    sync.Mutex
+	items map[string][]favorite
+}
+
+var favorites *favoriteStore
+
+// loadFavorites 从元数据库加载收藏
+func loadFavorites() *favoriteStore {
+	f := &favoriteStore{items: make(map[string][]favorite)}
+	dbEach(bucketFavorites, func(k, v []byte) error {
+		var fav favorite
+		if err := json.Unmarshal(v, &fav); err != nil {
+			logger.Printf("Error parsing favorite %q: %v", k, err)
+			return nil
+		}
+		user, _, _ := strings.Cut(string(k), "\x00")
+		f.items[user] = append(f.items[user], fav)
+		return nil
+	})
+	for _, list := range f.items {
+		sort.Slice(list, func(i, j int) bool { return list[i].Added.Before(list[j].Added) })
+	}
+	return f
+}
+
+// favoriteKey 返回收藏在数据库中的键：用户名和路径以 NUL 分隔
+func favoriteKey(user, virtual string) []byte {
+	return []byte(user + "\x00" + virtual)
+}
+
+// list 返回用户的收藏，按收藏时间排列
+func (f *favoriteStore) list(user string) []favorite {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]favorite(nil), f.items[user]...)
+}
+
+// has 判断用户是否收藏了路径
+func (f *favoriteStore) has(user, virtual string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fav := range f.items[user] {
+		if fav.Path == virtual {
+			return true
+		}
+	}
+	return false
+}
+
+// add 收藏路径，已收藏时不做改变
+func (f *favoriteStore) add(user, virtual string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fav := range f.items[user] {
+		if fav.Path == virtual {
+			return
+		}
+	}
+	fav := favorite{Path: virtual, Added: time.Now().UTC()}
+	f.items[user] = append(f.items[user], fav)
+	dbPut(bucketFavorites, favoriteKey(user, virtual), fav)
+}
+
+// remove 取消收藏路径，返回路径是否曾被收藏
+func (f *favoriteStore) remove(user, virtual string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := f.items[user]
+	for i, fav := range list {
+		if fav.Path == virtual {
+			f.items[user] = append(list[:i:i], list[i+1:]...)
+			if len(f.items[user]) == 0 {
+				delete(f.items, user)
+			}
+			dbDelete(bucketFavorites, favoriteKey(user, virtual))
+			return true
+		}
+	}
+	return false
+}
+
+// forget 从所有用户的收藏中删除路径及其下的条目
+func (f *favoriteStore) forget(virtual string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := virtual + "/"
+	var keys [][]byte
+	for user, list := range f.items {
+		kept := list[:0]
+		for _, fav := range list {
+			if fav.Path == virtual || virtual == "" || strings.HasPrefix(fav.Path, prefix) {
+				keys = append(keys, favoriteKey(user, fav.Path))
+				continue
+			}
+			kept = append(kept, fav)
+		}
+		if len(kept) == 0 {
+			delete(f.items, user)
+		} else {
+			f.items[user] = kept
+		}
+	}
+	dbDelete(bucketFavorites, keys...)
+}
+
+// move 将所有用户收藏中的路径及其下的条目改为新路径，新路径下原有的收藏被删除
+func (f *favoriteStore) move(from, to string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys [][]byte
+	moved := map[string]interface{}{}
+	for user, list := range f.items {
+		kept := list[:0]
+		for _, fav := range list {
+			if underPath(fav.Path, to) {
+				keys = append(keys, favoriteKey(user, fav.Path))
+				continue
+			}
+			if np, ok := movedPath(fav.Path, from, to); ok {
+				keys = append(keys, favoriteKey(user, fav.Path))
+				fav.Path = np
+				moved[string(favoriteKey(user, np))] = fav
+			}
+			kept = append(kept, fav)
+		}
+		if len(kept) == 0 {
+			delete(f.items, user)
+		} else {
+			f.items[user] = kept
+		}
+	}
+	dbReplace(bucketFavorites, keys, moved)
+}
+
+// favoriteItem 是收藏列表中的一项；Missing 表示路径已不存在或当前无法访问
+type favoriteItem struct {
+	Path     string    `json:"path"`
+	Added    time.Time `json:"added"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	MimeType string    `json:"mime_type,omitempty"`
+	Missing  bool      `json:"missing,omitempty"`
+}
+
+// favoriteItems 返回请求者的收藏及各条目的当前状态
+func favoriteItems(r *http.Request) []favoriteItem {
+	items := []favoriteItem{}
+	for _, fav := range favorites.list(currentUser(r)) {
+		item := favoriteItem{Path: fav.Path, Added: fav.Added}
+		vol, name, err := resolveTarget(r, fav.Path)
+		var info os.FileInfo
+		if err == nil {
+			info, err = vol.store.Stat(name)
+		}
+		if err != nil {
+			item.Missing = true
+		} else if info.IsDir() {
+			item.IsDir = true
+		} else {
+			item.Size = info.Size()
+			item.MimeType = detectContentType(vol.store, name)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// apiFavoritesHandler 处理 /api/v1/favorites：GET 返回当前用户的收藏，
+// POST ?path= 收藏文件或文件夹，DELETE ?path= 取消收藏
+func apiFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, favoriteItems(r))
+	case http.MethodPost:
+		virtual, ok := tagTarget(w, r, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		favorites.add(user, virtual)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		p := cleanName(r.URL.Query().Get("path"))
+		if p == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		if !favorites.remove(user, p) {
+			http.Error(w, "Not a favorite", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// favoritesPageHandler 处理 /favorites：GET 显示当前用户的收藏；
+// POST 表单（path、action=add 或 remove、可选的 dir）收藏或取消收藏后返回来源页面
+func favoritesPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		p := cleanName(r.FormValue("path"))
+		if r.FormValue("action") == "remove" {
+			favorites.remove(currentUser(r), p)
+		} else {
+			virtual, ok := tagTarget(w, r, p)
+			if !ok {
+				return
+			}
+			favorites.add(currentUser(r), virtual)
+		}
+		back := "/favorites"
+		if _, ok := r.Form["dir"]; ok {
+			back = listURL(r.FormValue("dir"))
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Favorites</title>
+    <meta charset="UTF-8">
+    <link rel="icon" href="/favicon.ico">
+</head>
+<body>
+    <h1>Favorites</h1>
+    <p><a href="/">&larr; Back</a></p>
+`)
+	items := favoriteItems(r)
+	if len(items) == 0 {
+		sb.WriteString("    <p>No favorites yet. Use the &#9734; button next to a file or folder to add it here.</p>\n")
+	}
+	sb.WriteString("    <ul>\n")
+	for _, item := range items {
+		var entry string
+		switch {
+		case item.Missing:
+			entry = fmt.Sprintf("%s<s>%s</s> <small>(no longer available)</small>", iconHTML("file"), html.EscapeString(item.Path))
+		case item.IsDir:
+			entry = fmt.Sprintf(`%s<a href="/download?path=%s">%s/</a>`, iconHTML("folder"), url.QueryEscape(item.Path), html.EscapeString(item.Path))
+		default:
+			entry = fmt.Sprintf(`%s<a href="/download?path=%s">%s</a> <small>%s</small>`, iconHTML(fileIcon(item.Path, item.MimeType)), url.QueryEscape(item.Path), html.EscapeString(item.Path), formatSize(item.Size))
+		}
+		fmt.Fprintf(&sb, "        <li>%s %s</li>\n", entry, favoriteButton(item.Path, "", true, false))
+	}
+	sb.WriteString("    </ul>\n</body>\n</html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// favoriteButton 返回收藏或取消收藏的按钮；inList 为 true 时提交后返回 dir 的目录列表，否则返回收藏页面
+func favoriteButton(virtual, dir string, starred, inList bool) string {
+	action, label, title := "add", "&#9734;", "Add to favorites"
+	if starred {
+		action, label, title = "remove", "&#9733;", "Remove from favorites"
+	}
+	back := ""
+	if inList {
+		back = fmt.Sprintf(`<input type="hidden" name="dir" value="%s">`, html.EscapeString(dir))
+	}
+	return fmt.Sprintf(`<form method="post" action="/favorites" style="display:inline"><input type="hidden" name="path" value="%s"><input type="hidden" name="action" value="%s">%s<button type="submit" title="%s">%s</button></form>`,
+		html.EscapeString(virtual), action, back, title, label)
+}
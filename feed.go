@@ -0,0 +1,164 @@
+package fileserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedMaxEntries 是订阅源中条目数量的上限
+const feedMaxEntries = 500
+
+// atomFeed 和 atomEntry 是 Atom 订阅源（RFC 4287）的 XML 结构
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// feedItem 是订阅源中的一个文件
+type feedItem struct {
+	vol  volume
+	name string
+	info fs.FileInfo
+}
+
+// recentFiles 返回 dir 下最近修改的文件，按修改时间从新到旧排列；dir 为空时包括各挂载点
+func recentFiles(r *http.Request, dir string, limit int) ([]feedItem, error) {
+	vol, name, err := resolveVirtual(r.Context(), dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := vol.store.Stat(name); err != nil || !info.IsDir() {
+		return nil, errNotFound
+	}
+	var items []feedItem
+	collect := func(vol volume, name string, root bool) {
+		vol.store.Walk(name, func(p string, info fs.FileInfo, err error) error {
+			if err != nil || p == name {
+				return nil
+			}
+			first, _, _ := strings.Cut(p, "/")
+			_, shadowed := findMount(first)
+			base := info.Name()
+			// 跳过元数据目录、被挂载点遮盖的目录、上传过程中的隐藏临时文件，以及 -access 规则隐藏的条目
+			if first == metaDirName || (root && shadowed) || strings.HasPrefix(base, ".") || !listedFor(r, vol.virtual(p)) {
+				if info.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				items = append(items, feedItem{vol: vol, name: p, info: info})
+			}
+			return nil
+		})
+	}
+	collect(vol, name, cleanName(dir) == "")
+	if cleanName(dir) == "" {
+		for _, mt := range mounts {
+			collect(mt.volume(), "", false)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].info.ModTime().After(items[j].info.ModTime()) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// feedHandler 处理 GET /feed.xml，以 Atom 订阅源列出最近新增或修改的文件，
+// ?dir= 限定为某个文件夹（虚拟文件树中的完整路径），?limit= 为条目数量（默认 50）
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	dir := cleanName(q.Get("dir"))
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, feedMaxEntries)
+	}
+	if reservedPath(dir) {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	items, err := recentFiles(r, dir, limit)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	base := baseURL(r)
+	self := base + "/feed.xml"
+	title := "fileserver"
+	if dir != "" {
+		self += "?dir=" + url.QueryEscape(dir)
+		title += ": " + dir
+	}
+	feed := atomFeed{
+		Title:   title,
+		ID:      self,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  "fileserver",
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].info.ModTime().UTC().Format(time.RFC3339)
+	}
+	for _, it := range items {
+		virtual := it.vol.virtual(it.name)
+		link := base + "/download?path=" + url.QueryEscape(virtual)
+		modTime := it.info.ModTime().UTC()
+		ctype := detectContentType(it.vol.store, it.name)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title: virtual,
+			// 以修改时间区分同一路径的不同版本，文件被替换后订阅者会收到新条目
+			ID:      fmt.Sprintf("%s#%d", link, modTime.Unix()),
+			Updated: modTime.Format(time.RFC3339),
+			Link:    atomLink{Href: link, Type: ctype},
+			Summary: fmt.Sprintf("%s, %s", formatSize(it.info.Size()), ctype),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		logger.Printf("Error writing feed: %v", err)
+	}
+}
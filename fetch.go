@@ -0,0 +1,370 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fetchTimeout 是服务器端下载远程 URL 的最长时间
+var fetchTimeout = time.Hour
+
+// fetchPrivate 由 -fetch-private 设置，允许下载回环、内网和链路本地地址上的 URL
+var fetchPrivate bool
+
+// fetchMaxRunning 是每个用户同时进行的下载任务数上限
+const fetchMaxRunning = 3
+
+// errFetchForbidden 表示 URL 解析到了不允许访问的地址
+var errFetchForbidden = errors.New("URL points to a loopback, private or link-local address")
+
+// fetchClient 用于服务器端下载：连接建立前检查解析后的地址，重定向后的每次连接同样检查，
+// 防止借服务器访问内网服务或云主机的元数据接口
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			// 经过代理时只能检查代理的地址，因此只在允许内网地址时使用环境变量中的代理
+			if fetchPrivate {
+				return http.ProxyFromEnvironment(r)
+			}
+			return nil, nil
+		},
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: fetchDialControl}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	},
+}
+
+// fetchDialControl 拒绝连接非公网地址，除非设置了 -fetch-private
+func fetchDialControl(network, address string, _ syscall.RawConn) error {
+	if fetchPrivate {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ap.Addr()) {
+		return errFetchForbidden
+	}
+	return nil
+}
+
+// cgnatPrefix 是运营商级 NAT 使用的共享地址段
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr 判断地址是否可以从公网访问
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatPrefix.Contains(ip)
+}
+
+// fetchJob 是一次由服务器直接下载远程 URL 到目标目录的任务
+type fetchJob struct {
+	ID       string     `json:"id"`
+	URL      string     `json:"url"`
+	Path     string     `json:"path,omitempty"`
+	User     string     `json:"user,omitempty"`
+	Status   string     `json:"status"`
+	Done     int64      `json:"done"`
+	Total    int64      `json:"total"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+const (
+	fetchRunning = "running"
+	fetchDone    = "done"
+	fetchFailed  = "failed"
+)
+
+var (
+	fetchMu   sync.Mutex
+	fetchJobs = map[string]*fetchJob{}
+)
+
+// errFetchTooLarge 表示远程文件超过上传大小限制
+var errFetchTooLarge = errors.New("remote file exceeds the upload size limit")
+
+// startFetch 校验 URL 和目标目录，在后台开始下载并返回任务
+func startFetch(r *http.Request, rawURL, dir string) (*fetchJob, int, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid URL, only http and https are supported")
+	}
+	vol, err := resolveVolume(r, dir)
+	if err == errNotFound {
+		return nil, http.StatusNotFound, fmt.Errorf("directory not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to prepare directory")
+	}
+	if vol.readOnly {
+		return nil, http.StatusForbidden, fmt.Errorf("directory is read-only")
+	}
+	if err := checkEntryLimits(vol, ""); err != nil {
+		return nil, uploadErrorStatus(err), err
+	}
+	if !fetchPrivate {
+		// 先检查主机名，明显的内网地址直接拒绝；实际连接时还会再检查解析结果
+		if ip, err := netip.ParseAddr(strings.Trim(u.Hostname(), "[]")); (err == nil && !publicAddr(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+			return nil, http.StatusForbidden, errFetchForbidden
+		}
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	job := &fetchJob{ID: id, URL: u.String(), User: currentUser(r), Status: fetchRunning, Total: -1, Started: time.Now().UTC()}
+
+	fetchMu.Lock()
+	pruneFetchJobs()
+	running := 0
+	for _, j := range fetchJobs {
+		if j.User == job.User && j.Status == fetchRunning {
+			running++
+		}
+	}
+	if running >= fetchMaxRunning {
+		fetchMu.Unlock()
+		return nil, http.StatusTooManyRequests, fmt.Errorf("too many fetches in progress, at most %d at a time", fetchMaxRunning)
+	}
+	fetchJobs[id] = job
+	fetchMu.Unlock()
+
+	logger.Printf("Fetching %s into /%s", job.URL, vol.virtual(""))
+	go runFetch(job, vol)
+	return job, http.StatusAccepted, nil
+}
+
+// runFetch 下载远程文件并按普通上传处理（扫描、钩子、事件）
+func runFetch(job *fetchJob, vol volume) {
+	name, ev, err := fetchInto(job, vol)
+	if err == nil {
+		completeUpload(vol, name, ev, true)
+	}
+
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	now := time.Now().UTC()
+	job.Finished = &now
+	if err != nil {
+		job.Status = fetchFailed
+		job.Error = err.Error()
+		logger.Printf("Fetching %s failed: %v", job.URL, err)
+		return
+	}
+	job.Status = fetchDone
+	logger.Printf("Fetched %s to %s (%s)", job.URL, job.Path, formatSize(ev.Size))
+}
+
+// fetchInto 将远程内容写入卷中的唯一文件名，扫描和上传前钩子通过后改名到位，返回文件名和上传事件
+func fetchInto(job *fetchJob, vol volume) (string, fileEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return "", fileEvent{}, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", fileEvent{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fileEvent{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	limit := limits.MaxUploadSize
+	if limit > 0 && resp.ContentLength > limit {
+		return "", fileEvent{}, errFetchTooLarge
+	}
+
+	baseName := fetchFileName(resp)
+	ext := filepath.Ext(baseName)
+	safeName := generateUniqueName(vol.store, baseName, ext)
+	if _, err := checkNewEntry(vol, safeName, false); err != nil {
+		return "", fileEvent{}, err
+	}
+
+	fetchMu.Lock()
+	job.Path = vol.virtual(safeName)
+	job.Total = resp.ContentLength
+	fetchMu.Unlock()
+
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	hasher := sha256.New()
+	var ev fileEvent
+	err = stageAndCommit(vol.store, safeName, "put", func(dst io.Writer) error {
+		size, err := io.Copy(io.MultiWriter(dst, hasher, &fetchProgress{job: job}), body)
+		if err == nil && limit > 0 && size > limit {
+			err = errFetchTooLarge
+		}
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(safeName), Size: size, User: job.User, Checksum: hex.EncodeToString(hasher.Sum(nil))}
+		return err
+	}, func(tmp string) error {
+		return acceptUpload(vol, tmp, ev)
+	})
+	if err != nil {
+		return "", fileEvent{}, err
+	}
+	return safeName, ev, nil
+}
+
+// fetchFileName 从 Content-Disposition 或 URL 路径推断文件名
+func fetchFileName(resp *http.Response) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	ext := filepath.Ext(name)
+	if name == "." || name == "/" || name == ".." || name == metaDirName || strings.TrimSuffix(name, ext) == metaDirName {
+		name = "download"
+	}
+	return name
+}
+
+// fetchProgress 在写入时更新任务进度
+type fetchProgress struct {
+	job *fetchJob
+}
+
+func (p *fetchProgress) Write(b []byte) (int, error) {
+	fetchMu.Lock()
+	p.job.Done += int64(len(b))
+	fetchMu.Unlock()
+	return len(b), nil
+}
+
+// pruneFetchJobs 清理一小时前已结束的任务，调用时需持有 fetchMu
+func pruneFetchJobs() {
+	for id, job := range fetchJobs {
+		if job.Finished != nil && time.Since(*job.Finished) > time.Hour {
+			delete(fetchJobs, id)
+		}
+	}
+}
+
+// lookupFetchJob 返回当前用户可见的任务副本
+func lookupFetchJob(r *http.Request, id string) (fetchJob, bool) {
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	job, ok := fetchJobs[id]
+	if !ok || (!isAdmin(r) && job.User != currentUser(r)) {
+		return fetchJob{}, false
+	}
+	return *job, true
+}
+
+// apiFetchHandler 处理 URL 下载：POST 开始任务（JSON：url、dir），GET ?id= 查询进度，不带 id 时列出任务
+func apiFetchHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id"); id != "" {
+			job, ok := lookupFetchJob(r, id)
+			if !ok {
+				http.Error(w, "Job not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+			return
+		}
+		fetchMu.Lock()
+		jobs := []fetchJob{}
+		for _, job := range fetchJobs {
+			if isAdmin(r) || job.User == currentUser(r) {
+				jobs = append(jobs, *job)
+			}
+		}
+		fetchMu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var req struct {
+			URL string `json:"url"`
+			Dir string `json:"dir"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		job, status, err := startFetch(r, req.URL, req.Dir)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		fetchMu.Lock()
+		snapshot := *job
+		fetchMu.Unlock()
+		writeJSON(w, status, snapshot)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// fetchPageHandler 处理列表页中的 URL 下载表单：POST 开始任务，GET ?id= 显示自动刷新的进度页
+func fetchPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		job, status, err := startFetch(r, r.FormValue("url"), r.FormValue("dir"))
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		http.Redirect(w, r, "/fetch?id="+job.ID+"&dir="+url.QueryEscape(r.FormValue("dir")), http.StatusSeeOther)
+		return
+	}
+	job, ok := lookupFetchJob(r, r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	progress := formatSize(job.Done)
+	if job.Total > 0 {
+		progress = fmt.Sprintf("%s of %s (%d%%)", formatSize(job.Done), formatSize(job.Total), job.Done*100/job.Total)
+	}
+	refresh := ""
+	if job.Status == fetchRunning {
+		refresh = `<meta http-equiv="refresh" content="1">`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Fetch from URL</title>
+    <meta charset="UTF-8">
+    %s
+</head>
+<body>
+    <h1>Fetch from URL</h1>
+    <p>URL: %s</p>
+    <p>Saving to: %s</p>
+    <p>Status: <b>%s</b> %s</p>
+    <p>%s</p>
+    <p><a href="%s">&larr; Back</a></p>
+</body>
+</html>`, refresh, html.EscapeString(job.URL), html.EscapeString(job.Path), job.Status, html.EscapeString(job.Error),
+		progress, html.EscapeString(listURL(r.URL.Query().Get("dir"))))
+}
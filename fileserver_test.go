@@ -0,0 +1,69 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestServeTargetZip64 下载超过 65535 个条目的目录，确认流式写出的 ZIP 使用 ZIP64 且能被完整读回
+func TestServeTargetZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creates more than 65535 files")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "many")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const n = 65536 + 10
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%05d.txt", i)), []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldStats := stats
+	stats = &downloadStats{items: make(map[string]*downloadStat)}
+	defer func() { stats = oldStats }()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/download?path=many", nil)
+	serveTarget(w, r, volume{store: newLocalStorage(root)}, "many")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	body := w.Body.Bytes()
+	// ZIP64 中央目录结束记录的签名
+	if !bytes.Contains(body, []byte("PK\x06\x06")) {
+		t.Error("archive has no ZIP64 end of central directory record")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != n {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), n)
+	}
+	for _, i := range []int{0, 65535, n - 1} {
+		name := fmt.Sprintf("f%05d.txt", i)
+		f, err := zr.Open(name)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if got, want := buf.String(), fmt.Sprint(i); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package fileserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"strconv"
+	"strings"
+)
+
+// extractArchives 由 -extract-archives 设置：为 true 时上传的 .zip、.tar.gz 等归档都解压为文件夹；
+// 否则只解压带 extract=1 参数的上传
+var extractArchives bool
+
+// keepArchives 由 -keep-archives 设置：为 true 时解压后的归档本身也保存在文件夹旁；否则只对带 keep_archive=1 参数的上传保存
+var keepArchives bool
+
+// folderArchiveExts 是文件夹上传时从归档名中去掉的扩展名，较长的在前
+var folderArchiveExts = []string{".tar.gz", ".tar.bz2", ".tgz", ".tbz2", ".tar", ".zip", ".7z", ".up"}
+
+// isFolderUpload 判断上传是否为文件夹归档：扩展名为 .up，表单带有 folder=1（浏览器中打包的文件夹），
+// 或要求解压且文件名是归档
+func isFolderUpload(r *http.Request, name string) bool {
+	if strings.EqualFold(pathpkg.Ext(name), ".up") || r.FormValue("folder") == "1" {
+		return true
+	}
+	return wantExtract(r) && trimArchiveExt(name) != name
+}
+
+// wantExtract 判断上传的归档是否需要解压
+func wantExtract(r *http.Request) bool {
+	if extractArchives {
+		return true
+	}
+	extract, _ := strconv.ParseBool(r.FormValue("extract"))
+	return extract
+}
+
+// extractOption 返回上传表单中的“上传后解压”复选框，服务器已统一解压时不显示
+func extractOption() string {
+	if extractArchives {
+		return ""
+	}
+	return `<label><input type="checkbox" name="extract" value="1"> Extract archives (.zip, .tar.gz, ...) into folders</label>
+        `
+}
+
+// wantAsyncExtract 判断上传是否要求后台解压：带 async=1 时上传请求在归档接收并检查后立即返回任务 ID
+func wantAsyncExtract(r *http.Request) bool {
+	async, _ := strconv.ParseBool(r.FormValue("async"))
+	return async
+}
+
+// wantKeepArchive 判断解压后是否保留归档
+func wantKeepArchive(r *http.Request) bool {
+	if keepArchives {
+		return true
+	}
+	keep, _ := strconv.ParseBool(r.FormValue("keep_archive"))
+	return keep
+}
+
+// keepArchiveOption 返回上传表单中的“保留归档”复选框，服务器已统一保留时不显示
+func keepArchiveOption() string {
+	if keepArchives {
+		return ""
+	}
+	return `<label><input type="checkbox" name="keep_archive" value="1"> Keep the archive next to the extracted folder</label>
+        `
+}
+
+// keepArchive 将已解压的临时归档保存到文件夹旁：.up 上传以识别出的格式命名（如 photos.zip），其他归档保留原名，
+// 重名时与普通上传一样添加序号。解压已经成功，保存失败只记录日志
+func keepArchive(ctx context.Context, vol volume, tempArchive, name string, format archiveFormat, ev fileEvent) {
+	base := trimArchiveExt(name)
+	ext := name[len(base):]
+	if strings.EqualFold(ext, ".up") || ext == "" {
+		ext = "." + string(format)
+	}
+	log := requestLogger(ctx)
+	f, err := os.Open(tempArchive)
+	if err != nil {
+		log.Printf("Error keeping archive: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := checkFreeSpace(vol, ev.Size); err != nil {
+		log.Printf("Not keeping archive of %s: %v", ev.Path, err)
+		return
+	}
+	archiveName := generateUniqueName(vol.store, base+ext, ext)
+	if _, err := checkNewEntry(vol, archiveName, false); err != nil {
+		log.Printf("Not keeping archive of %s: %v", ev.Path, err)
+		return
+	}
+	dst, err := vol.store.Create(archiveName)
+	if err != nil {
+		log.Printf("Error keeping archive: %v", err)
+		return
+	}
+	_, err = io.Copy(dst, f)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		vol.store.Delete(archiveName)
+		log.Printf("Error keeping archive: %v", err)
+		return
+	}
+	log.Printf("Kept archive as %s", archiveName)
+	ev.Path = vol.virtual(archiveName)
+	completeUpload(vol, archiveName, ev, true)
+}
+
+// trimArchiveExt 返回归档解压后的目录名，即去掉归档扩展名的文件名；不是归档时原样返回
+func trimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range folderArchiveExts {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// folderUploadScript 让用户选择或拖入文件夹，在浏览器中打包为 ZIP（仅存储，不压缩）后以 folder=1 上传，由服务器照常解压。
+// 文件内容只在计算 CRC 时分块读取一次，ZIP 由头部和文件本身拼成 Blob，不会整体读入内存；超过 4 GB 时使用 ZIP64
+const folderUploadScript = `    <script>
+    (function () {
+        var form = document.getElementById("upload");
+        var out = document.getElementById("upload-progress");
+        var table = new Uint32Array(256);
+        for (var n = 0; n < 256; n++) {
+            var c = n;
+            for (var k = 0; k < 8; k++) c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+            table[n] = c >>> 0;
+        }
+        function crc32(file) {
+            var reader = file.stream().getReader(), crc = 0xffffffff;
+            function pump() {
+                return reader.read().then(function (r) {
+                    if (r.done) return (crc ^ 0xffffffff) >>> 0;
+                    for (var i = 0; i < r.value.length; i++) crc = table[(crc ^ r.value[i]) & 0xff] ^ (crc >>> 8);
+                    return pump();
+                });
+            }
+            return pump();
+        }
+        function bytes(size, fill) {
+            var buf = new ArrayBuffer(size), v = new DataView(buf);
+            fill(v, function (o, x) { v.setUint32(o, x % 4294967296, true); v.setUint32(o + 4, Math.floor(x / 4294967296), true); });
+            return new Uint8Array(buf);
+        }
+        function dosTime(ms) {
+            var d = new Date(ms);
+            if (d.getFullYear() < 1980) d = new Date(1980, 0, 1);
+            return {time: d.getHours() << 11 | d.getMinutes() << 5 | d.getSeconds() >> 1,
+                date: (d.getFullYear() - 1980) << 9 | (d.getMonth() + 1) << 5 | d.getDate()};
+        }
+        // zipEntries 将 [{path, file}] 打包为 ZIP Blob，目录条目的 file 为 null
+        function zipEntries(entries) {
+            var parts = [], central = [], offset = 0, enc = new TextEncoder(), done = 0;
+            return entries.reduce(function (p, e) {
+                return p.then(function () {
+                    out.textContent = "Zipping " + (++done) + "/" + entries.length + " files";
+                    return e.file ? crc32(e.file) : 0;
+                }).then(function (crc) {
+                    var name = enc.encode(e.path), size = e.file ? e.file.size : 0, t = dosTime(e.file ? e.file.lastModified : Date.now());
+                    var big = size >= 0xffffffff, far = offset >= 0xffffffff;
+                    var local = bytes(30 + name.length + (big ? 20 : 0), function (v, u64) {
+                        v.setUint32(0, 0x04034b50, true); v.setUint16(4, big ? 45 : 20, true); v.setUint16(6, 0x0800, true);
+                        v.setUint16(10, t.time, true); v.setUint16(12, t.date, true); v.setUint32(14, crc, true);
+                        v.setUint32(18, big ? 0xffffffff : size, true); v.setUint32(22, big ? 0xffffffff : size, true);
+                        v.setUint16(26, name.length, true); v.setUint16(28, big ? 20 : 0, true);
+                        if (big) { v.setUint16(30 + name.length, 1, true); v.setUint16(32 + name.length, 16, true); u64(34 + name.length, size); u64(42 + name.length, size); }
+                    });
+                    local.set(name, 30);
+                    var extra = (big ? 16 : 0) + (far ? 8 : 0);
+                    var cd = bytes(46 + name.length + (extra ? extra + 4 : 0), function (v, u64) {
+                        v.setUint32(0, 0x02014b50, true); v.setUint16(4, 0x0314, true); v.setUint16(6, extra ? 45 : 20, true); v.setUint16(8, 0x0800, true);
+                        v.setUint16(12, t.time, true); v.setUint16(14, t.date, true); v.setUint32(16, crc, true);
+                        v.setUint32(20, big ? 0xffffffff : size, true); v.setUint32(24, big ? 0xffffffff : size, true);
+                        v.setUint16(28, name.length, true); v.setUint16(30, extra ? extra + 4 : 0, true);
+                        v.setUint32(38, (e.file ? 0o100644 : 0o40755) * 65536, true); v.setUint32(42, far ? 0xffffffff : offset, true);
+                        if (extra) {
+                            var o = 46 + name.length;
+                            v.setUint16(o, 1, true); v.setUint16(o + 2, extra, true); o += 4;
+                            if (big) { u64(o, size); u64(o + 8, size); o += 16; }
+                            if (far) u64(o, offset);
+                        }
+                    });
+                    cd.set(name, 46);
+                    parts.push(local);
+                    if (e.file) parts.push(e.file);
+                    central.push(cd);
+                    offset += local.length + size;
+                });
+            }, Promise.resolve()).then(function () {
+                var cdSize = central.reduce(function (s, c) { return s + c.length; }, 0);
+                var z64 = entries.length >= 0xffff || offset >= 0xffffffff || cdSize >= 0xffffffff;
+                parts = parts.concat(central);
+                if (z64) {
+                    parts.push(bytes(76, function (v, u64) {
+                        v.setUint32(0, 0x06064b50, true); u64(4, 44); v.setUint16(12, 45, true); v.setUint16(14, 45, true);
+                        u64(24, entries.length); u64(32, entries.length); u64(40, cdSize); u64(48, offset);
+                        v.setUint32(56, 0x07064b50, true); u64(64, offset + cdSize); v.setUint32(72, 1, true);
+                    }));
+                }
+                parts.push(bytes(22, function (v) {
+                    v.setUint32(0, 0x06054b50, true);
+                    v.setUint16(8, z64 ? 0xffff : entries.length, true); v.setUint16(10, z64 ? 0xffff : entries.length, true);
+                    v.setUint32(12, z64 ? 0xffffffff : cdSize, true); v.setUint32(16, z64 ? 0xffffffff : offset, true);
+                }));
+                return new Blob(parts, {type: "application/zip"});
+            });
+        }
+        // waitExtract 轮询后台解压任务直到结束
+        function waitExtract(job) {
+            if (job.status === "done") return null;
+            if (job.status !== "queued" && job.status !== "running") return Promise.reject(new Error(job.error || "Extraction " + job.status));
+            var n = job.total ? job.done + "/" + job.total : job.done;
+            out.textContent = job.status === "queued" ? "Waiting to extract " + job.path : "Extracting " + job.path + ": " + n + " entries";
+            return new Promise(function (resolve) { setTimeout(resolve, 1000); }).then(function () {
+                return fetch("/api/v1/jobs?id=" + job.id);
+            }).then(function (r) { return r.json(); }).then(waitExtract);
+        }
+        function send(blob, name, folder) {
+            return new Promise(function (resolve, reject) {
+                var data = new FormData(form), xhr = new XMLHttpRequest();
+                data.set("file", blob, name);
+                if (folder) data.set("folder", "1");
+                xhr.open("POST", form.action);
+                xhr.setRequestHeader("Accept", "application/json");
+                xhr.upload.onprogress = function (ev) {
+                    if (ev.lengthComputable) out.textContent = "Uploading " + name + " " + Math.floor(ev.loaded * 100 / ev.total) + "%";
+                };
+                xhr.onload = function () {
+                    var res = null;
+                    try { res = JSON.parse(xhr.responseText); } catch (e) {}
+                    if (xhr.status >= 400) reject(new Error(res && res.error || xhr.responseText || xhr.statusText));
+                    else resolve(xhr.status === 202 && res ? waitExtract(res) : null);
+                };
+                xhr.onerror = function () { reject(new Error("Upload failed")); };
+                xhr.send(data);
+            });
+        }
+        // uploadFolders 依次打包并上传每个文件夹，folders 以顶层文件夹名为键
+        function uploadFolders(folders, files) {
+            var p = Promise.resolve();
+            files.forEach(function (f) { p = p.then(function () { return send(f, f.name, false); }); });
+            Object.keys(folders).forEach(function (top) {
+                p = p.then(function () { return zipEntries(folders[top]); }).then(function (blob) { return send(blob, top + ".zip", true); });
+            });
+            p.then(function () { location.reload(); }, function (err) { out.textContent = ""; alert(err.message); });
+        }
+        // readEntry 收集拖入的文件夹中的条目，路径相对于顶层文件夹，与服务器解压到的同名目录对应
+        function readEntry(entry, folders, top) {
+            var path = entry.fullPath.slice(top.length + 2);
+            if (entry.isFile) {
+                return new Promise(function (resolve, reject) { entry.file(resolve, reject); }).then(function (f) {
+                    folders[top].push({path: path, file: f});
+                });
+            }
+            if (path) folders[top].push({path: path + "/", file: null});
+            var reader = entry.createReader();
+            function more() {
+                return new Promise(function (resolve, reject) { reader.readEntries(resolve, reject); }).then(function (list) {
+                    if (!list.length) return;
+                    return Promise.all(list.map(function (e) { return readEntry(e, folders, top); })).then(more);
+                });
+            }
+            return more();
+        }
+        document.getElementById("folder-input").addEventListener("change", function () {
+            var folders = {};
+            Array.prototype.forEach.call(this.files, function (f) {
+                var top = f.webkitRelativePath.split("/")[0];
+                (folders[top] = folders[top] || []).push({path: f.webkitRelativePath.slice(top.length + 1), file: f});
+            });
+            this.value = "";
+            uploadFolders(folders, []);
+        });
+        document.addEventListener("dragover", function (ev) { ev.preventDefault(); });
+        document.addEventListener("drop", function (ev) {
+            var items = ev.dataTransfer.items;
+            if (!items || !items.length || !items[0].webkitGetAsEntry) return;
+            ev.preventDefault();
+            var folders = {}, files = [], pending = [];
+            Array.prototype.forEach.call(items, function (item) {
+                var entry = item.webkitGetAsEntry();
+                if (!entry) return;
+                if (entry.isFile) {
+                    files.push(item.getAsFile());
+                } else {
+                    folders[entry.name] = [];
+                    pending.push(readEntry(entry, folders, entry.name));
+                }
+            });
+            out.textContent = "Reading folders...";
+            Promise.all(pending).then(function () { uploadFolders(folders, files); }, function (err) { out.textContent = ""; alert(err.message); });
+        });
+    })();
+    </script>
+`
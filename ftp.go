@@ -0,0 +1,725 @@
+package fileserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errFTPDataConn 表示无法建立数据连接
+var errFTPDataConn = errors.New("cannot open data connection")
+
+// FTP/FTPS 服务配置
+var (
+	ftpAddr         string
+	ftpPassivePorts string
+	ftpPublicIP     string
+	ftpTLSCert      string
+	ftpTLSKey       string
+)
+
+// ftpIdleTimeout 是控制连接的空闲超时
+const ftpIdleTimeout = 15 * time.Minute
+
+// ftpServer 是内置的 FTP 服务器，与 HTTP 共享根目录、挂载点、用户和权限规则
+type ftpServer struct {
+	tlsConfig *tls.Config
+	portMin   int
+	portMax   int
+}
+
+// startFTPServer 在 -ftp-addr 上启动 FTP 服务（配置证书时支持显式 FTPS：AUTH TLS）
+func startFTPServer() error {
+	srv := &ftpServer{}
+	if ftpTLSCert != "" || ftpTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(ftpTLSCert, ftpTLSKey)
+		if err != nil {
+			return fmt.Errorf("loading FTP TLS certificate: %w", err)
+		}
+		srv.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if ftpPassivePorts != "" {
+		lo, hi, ok := strings.Cut(ftpPassivePorts, "-")
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if !ok || err1 != nil || err2 != nil || min <= 0 || max < min || max > 65535 {
+			return fmt.Errorf("invalid -ftp-passive-ports %q, expected e.g. 50000-50100", ftpPassivePorts)
+		}
+		srv.portMin, srv.portMax = min, max
+	}
+
+	ln, err := net.Listen("tcp", ftpAddr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("FTP server listening on %s (TLS: %v)", ftpAddr, srv.tlsConfig != nil)
+	go func() {
+		<-listenersReady
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				logger.Printf("FTP accept error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// ftpSession 是一个 FTP 控制连接的状态
+type ftpSession struct {
+	srv        *ftpServer
+	conn       net.Conn
+	r          *bufio.Reader
+	w          *bufio.Writer
+	ctx        context.Context
+	user       string
+	loggedIn   bool
+	cwd        string
+	pasv       net.Listener
+	activeAddr string
+	secureData bool
+	renameFrom string
+	restOffset int64
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	sess := &ftpSession{
+		srv:  s,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+		ctx:  context.Background(),
+		cwd:  "/",
+	}
+	defer func() {
+		sess.closeData()
+		sess.conn.Close()
+	}()
+	logger.Printf("FTP connection from %s", conn.RemoteAddr())
+
+	sess.reply(220, "fileserver "+version+" FTP ready")
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(ftpIdleTimeout))
+		line, err := sess.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg, _ := strings.Cut(line, " ")
+		cmd = strings.ToUpper(cmd)
+		if cmd == "QUIT" {
+			sess.reply(221, "Goodbye")
+			return
+		}
+		sess.handle(cmd, arg)
+	}
+}
+
+func (sess *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(sess.w, "%d %s\r\n", code, msg)
+	sess.w.Flush()
+}
+
+// ftpPublicCommands 是登录前允许的命令
+var ftpPublicCommands = map[string]bool{
+	"USER": true, "PASS": true, "AUTH": true, "PBSZ": true, "PROT": true,
+	"FEAT": true, "SYST": true, "NOOP": true, "OPTS": true,
+}
+
+func (sess *ftpSession) handle(cmd, arg string) {
+	if !sess.loggedIn && !ftpPublicCommands[cmd] {
+		sess.reply(530, "Please login with USER and PASS")
+		return
+	}
+	if cmd != "REST" && cmd != "RETR" {
+		defer func() { sess.restOffset = 0 }()
+	}
+
+	switch cmd {
+	case "USER":
+		sess.user = arg
+		sess.loggedIn = false
+		sess.reply(331, "Password required")
+	case "PASS":
+		sess.login(arg)
+	case "AUTH":
+		sess.authTLS(arg)
+	case "PBSZ":
+		sess.reply(200, "PBSZ=0")
+	case "PROT":
+		switch strings.ToUpper(arg) {
+		case "P":
+			if sess.srv.tlsConfig == nil {
+				sess.reply(536, "TLS not configured")
+				return
+			}
+			sess.secureData = true
+			sess.reply(200, "Protection level set to Private")
+		case "C":
+			sess.secureData = false
+			sess.reply(200, "Protection level set to Clear")
+		default:
+			sess.reply(504, "Unsupported protection level")
+		}
+	case "FEAT":
+		feats := []string{"UTF8", "PASV", "EPSV", "SIZE", "MDTM", "REST STREAM", "MLSD"}
+		if sess.srv.tlsConfig != nil {
+			feats = append(feats, "AUTH TLS", "PBSZ", "PROT")
+		}
+		fmt.Fprintf(sess.w, "211-Features:\r\n")
+		for _, f := range feats {
+			fmt.Fprintf(sess.w, " %s\r\n", f)
+		}
+		sess.reply(211, "End")
+	case "SYST":
+		sess.reply(215, "UNIX Type: L8")
+	case "NOOP":
+		sess.reply(200, "OK")
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			sess.reply(200, "UTF8 enabled")
+		} else {
+			sess.reply(501, "Option not understood")
+		}
+	case "TYPE":
+		sess.reply(200, "Type set to "+arg)
+	case "MODE", "STRU":
+		if strings.EqualFold(arg, "S") || strings.EqualFold(arg, "F") {
+			sess.reply(200, "OK")
+		} else {
+			sess.reply(504, "Unsupported")
+		}
+	case "PWD", "XPWD":
+		sess.reply(257, strconv.Quote(sess.cwd)+" is the current directory")
+	case "CWD", "XCWD":
+		sess.changeDir(arg)
+	case "CDUP", "XCUP":
+		sess.changeDir("..")
+	case "PASV":
+		sess.passive(false)
+	case "EPSV":
+		sess.passive(true)
+	case "PORT":
+		sess.port(arg)
+	case "LIST", "NLST", "MLSD":
+		sess.list(cmd, arg)
+	case "SIZE":
+		if _, info, err := sess.stat(arg); err != nil || info.IsDir() {
+			sess.reply(550, "Could not get file size")
+		} else {
+			sess.reply(213, strconv.FormatInt(info.Size(), 10))
+		}
+	case "MDTM":
+		if _, info, err := sess.stat(arg); err != nil {
+			sess.reply(550, "Could not get modification time")
+		} else {
+			sess.reply(213, info.ModTime().UTC().Format("20060102150405"))
+		}
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			sess.reply(501, "Invalid offset")
+			return
+		}
+		sess.restOffset = offset
+		sess.reply(350, "Restarting at "+arg)
+	case "RETR":
+		sess.retrieve(arg)
+	case "STOR":
+		sess.store(arg)
+	case "DELE":
+		sess.remove(arg, false)
+	case "RMD", "XRMD":
+		sess.remove(arg, true)
+	case "MKD", "XMKD":
+		sess.makeDir(arg)
+	case "RNFR":
+		if _, _, err := sess.stat(arg); err != nil {
+			sess.reply(550, "File not found")
+			return
+		}
+		sess.renameFrom = sess.abs(arg)
+		sess.reply(350, "Ready for RNTO")
+	case "RNTO":
+		sess.rename(arg)
+	default:
+		sess.reply(502, "Command not implemented")
+	}
+}
+
+func (sess *ftpSession) login(password string) {
+	if sess.user == "" {
+		sess.reply(503, "Login with USER first")
+		return
+	}
+	if authEnabled() {
+		admin, ok := checkLogin(sess.user, password)
+		if !ok {
+			logger.Printf("FTP login failed for %q from %s", sess.user, sess.conn.RemoteAddr())
+			sess.reply(530, "Login incorrect")
+			return
+		}
+		sess.ctx = withUser(context.Background(), sess.user, admin)
+	}
+	sess.loggedIn = true
+	logger.Printf("FTP user %q logged in from %s", sess.user, sess.conn.RemoteAddr())
+	sess.reply(230, "Login successful")
+}
+
+func (sess *ftpSession) authTLS(arg string) {
+	if sess.srv.tlsConfig == nil {
+		sess.reply(502, "TLS not configured")
+		return
+	}
+	if !strings.EqualFold(arg, "TLS") && !strings.EqualFold(arg, "SSL") {
+		sess.reply(504, "Unsupported security mechanism")
+		return
+	}
+	sess.reply(234, "AUTH TLS successful")
+	tlsConn := tls.Server(sess.conn, sess.srv.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Printf("FTP TLS handshake failed: %v", err)
+		sess.conn.Close()
+		return
+	}
+	sess.conn = tlsConn
+	sess.r = bufio.NewReader(tlsConn)
+	sess.w = bufio.NewWriter(tlsConn)
+}
+
+// abs 将 FTP 客户端给出的路径转换为虚拟树中的绝对路径
+func (sess *ftpSession) abs(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(sess.cwd, p)
+	}
+	return path.Clean("/" + p)
+}
+
+func (sess *ftpSession) resolve(p string) (volume, string, error) {
+	return resolveVirtual(sess.ctx, sess.abs(p))
+}
+
+func (sess *ftpSession) stat(p string) (volume, fs.FileInfo, error) {
+	vol, rel, err := sess.resolve(p)
+	if err != nil {
+		return vol, nil, err
+	}
+	info, err := vol.store.Stat(rel)
+	return vol, info, err
+}
+
+func (sess *ftpSession) changeDir(p string) {
+	_, info, err := sess.stat(p)
+	if err != nil || !info.IsDir() {
+		sess.reply(550, "No such directory")
+		return
+	}
+	sess.cwd = sess.abs(p)
+	sess.reply(250, "Directory changed to "+sess.cwd)
+}
+
+func (sess *ftpSession) closeData() {
+	if sess.pasv != nil {
+		sess.pasv.Close()
+		sess.pasv = nil
+	}
+	sess.activeAddr = ""
+}
+
+// passive 打开被动模式数据端口
+func (sess *ftpSession) passive(extended bool) {
+	sess.closeData()
+	host, _, _ := net.SplitHostPort(sess.conn.LocalAddr().String())
+	var ln net.Listener
+	var err error
+	if sess.srv.portMin > 0 {
+		for port := sess.srv.portMin; port <= sess.srv.portMax; port++ {
+			if ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+				break
+			}
+		}
+	} else {
+		ln, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+	}
+	if err != nil || ln == nil {
+		sess.reply(425, "Cannot open passive connection")
+		return
+	}
+	sess.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if extended {
+		sess.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+	ip := net.ParseIP(ftpPublicIP)
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		sess.reply(425, "PASV requires IPv4, use EPSV")
+		return
+	}
+	sess.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff))
+}
+
+// port 设置主动模式数据连接地址（部分老旧设备仅支持主动模式）
+func (sess *ftpSession) port(arg string) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		sess.reply(501, "Invalid PORT argument")
+		return
+	}
+	var nums [6]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 || n > 255 {
+			sess.reply(501, "Invalid PORT argument")
+			return
+		}
+		nums[i] = n
+	}
+	ip := fmt.Sprintf("%d.%d.%d.%d", nums[0], nums[1], nums[2], nums[3])
+	remote, _, _ := net.SplitHostPort(sess.conn.RemoteAddr().String())
+	if ip != remote {
+		// 防止 FTP bounce 攻击
+		sess.reply(504, "PORT address must match the client address")
+		return
+	}
+	sess.closeData()
+	sess.activeAddr = net.JoinHostPort(ip, strconv.Itoa(nums[4]<<8|nums[5]))
+	sess.reply(200, "PORT command successful")
+}
+
+// openData 建立数据连接
+func (sess *ftpSession) openData() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case sess.pasv != nil:
+		if tl, ok := sess.pasv.(*net.TCPListener); ok {
+			tl.SetDeadline(time.Now().Add(30 * time.Second))
+		}
+		conn, err = sess.acceptPassive()
+		sess.pasv.Close()
+		sess.pasv = nil
+	case sess.activeAddr != "":
+		conn, err = net.DialTimeout("tcp", sess.activeAddr, 30*time.Second)
+		sess.activeAddr = ""
+	default:
+		return nil, fmt.Errorf("use PASV or PORT first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sess.secureData {
+		tlsConn := tls.Server(conn, sess.srv.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return conn, nil
+}
+
+// acceptPassive 接受被动模式的数据连接。与 PORT 一样只接受来自控制连接客户端地址的连接，
+// 其他主机的连接直接关闭并继续等待，防止他人抢先连上端口窃取或篡改传输的数据
+func (sess *ftpSession) acceptPassive() (net.Conn, error) {
+	peer, _, _ := net.SplitHostPort(sess.conn.RemoteAddr().String())
+	for {
+		conn, err := sess.pasv.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if net.ParseIP(host).Equal(net.ParseIP(peer)) {
+			return conn, nil
+		}
+		logger.Printf("FTP data connection from %s rejected, expected %s", host, peer)
+		conn.Close()
+	}
+}
+
+func (sess *ftpSession) list(cmd, arg string) {
+	// 忽略 ls 风格的选项，例如 "LIST -la"
+	if strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+	}
+	target := sess.abs(arg)
+	vol, rel, err := resolveVirtual(sess.ctx, target)
+	if err != nil {
+		sess.reply(550, "No such directory")
+		return
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		sess.reply(550, "No such file or directory")
+		return
+	}
+	var entries []fs.FileInfo
+	if info.IsDir() {
+		entries, err = listVirtual(sess.ctx, vol, rel, target == "/")
+		if err != nil {
+			sess.reply(550, "Failed to read directory")
+			return
+		}
+	} else {
+		entries = []fs.FileInfo{info}
+	}
+
+	sess.reply(150, "Opening data connection for directory listing")
+	conn, err := sess.openData()
+	if err != nil {
+		sess.reply(425, "Cannot open data connection")
+		return
+	}
+	w := bufio.NewWriter(conn)
+	for _, e := range entries {
+		switch cmd {
+		case "NLST":
+			fmt.Fprintf(w, "%s\r\n", e.Name())
+		case "MLSD":
+			kind := "file"
+			if e.IsDir() {
+				kind = "dir"
+			}
+			fmt.Fprintf(w, "type=%s;size=%d;modify=%s; %s\r\n", kind, e.Size(), e.ModTime().UTC().Format("20060102150405"), e.Name())
+		default:
+			fmt.Fprintf(w, "%s\r\n", ftpListLine(e))
+		}
+	}
+	w.Flush()
+	conn.Close()
+	sess.reply(226, "Transfer complete")
+}
+
+// ftpListLine 生成 ls -l 风格的目录行
+func ftpListLine(info fs.FileInfo) string {
+	mode := "-rw-r--r--"
+	if info.IsDir() {
+		mode = "drwxr-xr-x"
+	}
+	mt := info.ModTime()
+	stamp := mt.Format("Jan _2 15:04")
+	if time.Since(mt) > 180*24*time.Hour || mt.After(time.Now()) {
+		stamp = mt.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, info.Size(), stamp, info.Name())
+}
+
+func (sess *ftpSession) retrieve(p string) {
+	offset := sess.restOffset
+	sess.restOffset = 0
+	vol, rel, err := sess.resolve(p)
+	if err != nil {
+		sess.reply(550, "File not found")
+		return
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil || info.IsDir() {
+		sess.reply(550, "File not found")
+		return
+	}
+	f, err := vol.store.Open(rel)
+	if err != nil {
+		sess.reply(550, "Cannot open file")
+		return
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			sess.reply(554, "Cannot restart at offset")
+			return
+		}
+	}
+
+	sess.reply(150, "Opening data connection for "+info.Name())
+	conn, err := sess.openData()
+	if err != nil {
+		sess.reply(425, "Cannot open data connection")
+		return
+	}
+	_, err = io.Copy(conn, f)
+	conn.Close()
+	if err != nil {
+		sess.reply(426, "Transfer aborted")
+		return
+	}
+	if offset == 0 {
+		stats.record(vol.virtual(rel))
+	}
+	sess.reply(226, "Transfer complete")
+}
+
+func (sess *ftpSession) store(p string) {
+	vol, rel, err := sess.resolve(p)
+	if err != nil || rel == "" {
+		sess.reply(553, "Invalid file name")
+		return
+	}
+	if vol.readOnly {
+		sess.reply(550, "Permission denied: read-only")
+		return
+	}
+	if info, err := vol.store.Stat(rel); err == nil && info.IsDir() {
+		sess.reply(553, "Is a directory")
+		return
+	}
+	created, err := checkNewEntry(vol, rel, true)
+	if err != nil {
+		sess.reply(552, err.Error())
+		return
+	}
+	// 先写入同目录下的隐藏临时文件，传输完成后再替换，连接失败或中断时原有文件保持不变
+	hasher := sha256.New()
+	var size int64
+	started := false
+	var ev fileEvent
+	var rejected error
+	err = stageAndCommit(vol.store, rel, "ftp", func(dst io.Writer) error {
+		started = true
+		sess.reply(150, "Ok to send data")
+		conn, err := sess.openData()
+		if err != nil {
+			return errFTPDataConn
+		}
+		size, err = io.Copy(io.MultiWriter(dst, hasher), conn)
+		conn.Close()
+		return err
+	}, func(tmp string) error {
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(rel), Size: size, User: contextUser(sess.ctx), Checksum: hex.EncodeToString(hasher.Sum(nil))}
+		rejected = acceptUpload(vol, tmp, ev)
+		return rejected
+	})
+	switch {
+	case err != nil && !started:
+		sess.reply(553, "Cannot create file")
+		return
+	case err != nil && err == rejected:
+		sess.reply(550, err.Error())
+		return
+	case err == errFTPDataConn:
+		sess.reply(425, "Cannot open data connection")
+		return
+	case err != nil:
+		logger.Printf("FTP upload of %s failed: %v", rel, err)
+		sess.reply(426, "Transfer aborted")
+		return
+	}
+
+	logger.Printf("FTP upload saved: %s", ev.Path)
+	completeUpload(vol, rel, ev, created)
+	sess.reply(226, "Transfer complete")
+}
+
+func (sess *ftpSession) remove(p string, dir bool) {
+	vol, rel, err := sess.resolve(p)
+	if err != nil || rel == "" {
+		sess.reply(550, "Permission denied")
+		return
+	}
+	if vol.readOnly {
+		sess.reply(550, "Permission denied: read-only")
+		return
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil || info.IsDir() != dir {
+		sess.reply(550, "No such file or directory")
+		return
+	}
+	if dir {
+		if entries, err := vol.store.List(rel); err != nil || len(entries) > 0 {
+			sess.reply(550, "Directory not empty")
+			return
+		}
+	}
+	if err := vol.store.Delete(rel); err != nil {
+		sess.reply(550, "Delete failed")
+		return
+	}
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(rel), User: contextUser(sess.ctx)}
+	if !dir {
+		ev.Size = info.Size()
+	}
+	logger.Printf("FTP deleted: %s", ev.Path)
+	emitEvent(ev)
+	sess.reply(250, "Deleted")
+}
+
+func (sess *ftpSession) makeDir(p string) {
+	vol, rel, err := sess.resolve(p)
+	if err != nil || rel == "" {
+		sess.reply(550, "Permission denied")
+		return
+	}
+	if vol.readOnly {
+		sess.reply(550, "Permission denied: read-only")
+		return
+	}
+	if _, err := vol.store.Stat(rel); err == nil {
+		sess.reply(550, "Already exists")
+		return
+	}
+	if _, err := checkNewEntry(vol, rel, false); err != nil {
+		sess.reply(552, err.Error())
+		return
+	}
+	if err := vol.store.Mkdir(rel); err != nil {
+		sess.reply(550, "Cannot create directory")
+		return
+	}
+	countNewEntries(1)
+	sess.reply(257, strconv.Quote(sess.abs(p))+" created")
+}
+
+func (sess *ftpSession) rename(p string) {
+	from := sess.renameFrom
+	sess.renameFrom = ""
+	if from == "" {
+		sess.reply(503, "RNFR required first")
+		return
+	}
+	oldVol, oldRel, err := resolveVirtual(sess.ctx, from)
+	if err != nil {
+		sess.reply(550, "File not found")
+		return
+	}
+	newVol, newRel, err := sess.resolve(p)
+	if err != nil || oldRel == "" || newRel == "" {
+		sess.reply(553, "Invalid file name")
+		return
+	}
+	if oldVol.readOnly || newVol.readOnly {
+		sess.reply(550, "Permission denied: read-only")
+		return
+	}
+	if oldVol.prefix != newVol.prefix {
+		sess.reply(553, errCrossVolume.Error())
+		return
+	}
+	if err := renameEntry(oldVol, oldRel, newRel); err != nil {
+		if os.IsExist(err) {
+			sess.reply(553, "Target exists")
+		} else {
+			sess.reply(550, "Rename failed")
+		}
+		return
+	}
+	sess.reply(250, "Rename successful")
+}
@@ -1,3 +1,23 @@
 module file-server
 
 go 1.24.5
+
+require (
+	github.com/graphql-go/graphql v0.8.1
+	github.com/huin/goupnp v1.3.0
+	github.com/jackpal/gateway v1.0.6
+	github.com/jackpal/go-nat-pmp v1.0.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.48.0
+	golang.org/x/net v0.50.0
+	golang.org/x/sys v0.41.0
+	google.golang.org/grpc v1.79.1
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
+)
@@ -0,0 +1,211 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// gqlFile 是 GraphQL 中 File 类型的数据来源，depth 为它在查询中嵌套的 children 层数
+type gqlFile struct {
+	vol   volume
+	rel   string
+	path  string
+	info  fs.FileInfo
+	depth int
+}
+
+// gqlMaxDepth 是一次查询中 children 最多嵌套的层数，避免一个查询遍历（并计算校验和）整棵树
+const gqlMaxDepth = 5
+
+// graphqlSchema 是只读的文件树元数据查询模式
+var graphqlSchema = mustGraphQLSchema()
+
+func mustGraphQLSchema() graphql.Schema {
+	fileType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "File",
+		Description: "A file or directory in the served tree",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*gqlFile).info.Name(), nil
+			}},
+			"path": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*gqlFile).path, nil
+			}},
+			"isDir": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*gqlFile).info.IsDir(), nil
+			}},
+			"size": &graphql.Field{Type: graphql.NewNonNull(graphql.Float), Description: "Size in bytes", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return float64(p.Source.(*gqlFile).info.Size()), nil
+			}},
+			"modTime": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*gqlFile).info.ModTime().UTC(), nil
+			}},
+			"readOnly": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*gqlFile).vol.readOnly, nil
+			}},
+			"mimeType": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := p.Source.(*gqlFile)
+				if f.info.IsDir() {
+					return nil, nil
+				}
+				return detectContentType(f.vol.store, f.rel), nil
+			}},
+			"checksum": &graphql.Field{Type: graphql.String, Description: "SHA-256 of the file contents, computed on request", Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := p.Source.(*gqlFile)
+				if f.info.IsDir() {
+					return nil, nil
+				}
+				if err := p.Context.Err(); err != nil {
+					return nil, err
+				}
+				return fileChecksum(f.vol, f.rel)
+			}},
+			"downloadCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := p.Source.(*gqlFile)
+				return int(stats.get(f.vol.virtual(f.rel)).Count), nil
+			}},
+			"lastDownload": &graphql.Field{Type: graphql.DateTime, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := p.Source.(*gqlFile)
+				dl := stats.get(f.vol.virtual(f.rel))
+				if dl.Count == 0 {
+					return nil, nil
+				}
+				return dl.LastDownload, nil
+			}},
+		},
+	})
+	fileType.AddFieldConfig("children", &graphql.Field{
+		Type:        graphql.NewList(graphql.NewNonNull(fileType)),
+		Description: "Directory entries; null for files. Nested at most 5 levels deep",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			f := p.Source.(*gqlFile)
+			if !f.info.IsDir() {
+				return nil, nil
+			}
+			if f.depth >= gqlMaxDepth {
+				return nil, fmt.Errorf("children cannot be nested more than %d levels deep", gqlMaxDepth)
+			}
+			return gqlChildren(p.Context, f)
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"file": &graphql.Field{
+				Type:        fileType,
+				Description: "Look up a file or directory by path; the empty path is the root",
+				Args: graphql.FieldConfigArgument{
+					"path": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					name, _ := p.Args["path"].(string)
+					f, err := gqlLookup(p.Context, cleanName(name))
+					if err != nil {
+						return nil, nil
+					}
+					return f, nil
+				},
+			},
+			"version": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return version, nil
+			}},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// gqlLookup 在虚拟文件树中查找路径
+func gqlLookup(ctx context.Context, name string) (*gqlFile, error) {
+	vol, rel, err := resolveVirtual(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		info = renamedInfo{FileInfo: info, name: ""}
+	}
+	return &gqlFile{vol: vol, rel: rel, path: name, info: info}, nil
+}
+
+func gqlChildren(ctx context.Context, dir *gqlFile) ([]*gqlFile, error) {
+	entries, err := listVirtual(ctx, dir.vol, dir.rel, dir.path == "")
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*gqlFile, 0, len(entries))
+	for _, info := range entries {
+		child := path.Join(dir.path, info.Name())
+		if dir.path == "" {
+			// 虚拟根目录中的条目可能是挂载点，需要重新解析所在卷
+			vol, rel, err := resolveVirtual(ctx, child)
+			if err != nil {
+				continue
+			}
+			children = append(children, &gqlFile{vol: vol, rel: rel, path: child, info: info, depth: dir.depth + 1})
+			continue
+		}
+		children = append(children, &gqlFile{vol: dir.vol, rel: path.Join(dir.rel, info.Name()), path: child, info: info, depth: dir.depth + 1})
+	}
+	return children, nil
+}
+
+// graphqlHandler 处理 GraphQL 查询，支持 GET ?query= 和 POST JSON
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Query == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        ctx,
+	})
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,332 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "file-server/fileserverpb"
+)
+
+// grpcAddr 是 gRPC 服务的监听地址，为空时不启动
+var grpcAddr string
+
+// grpcChunkSize 是下载时每条消息携带的数据量
+const grpcChunkSize = 64 * 1024
+
+// startGRPCServer 在 -grpc-addr 上启动 gRPC 服务
+func startGRPCServer() error {
+	ln, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryAuth),
+		grpc.StreamInterceptor(grpcStreamAuth),
+	)
+	pb.RegisterFileServiceServer(srv, &grpcServer{})
+	logger.Printf("gRPC API listening on %s", grpcAddr)
+	go func() {
+		<-listenersReady
+		log.Fatal(srv.Serve(ln))
+	}()
+	return nil
+}
+
+// grpcAuthenticate 在认证模式下校验 authorization 元数据中的 Basic 凭据，并将用户名放入上下文
+func grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	if !authEnabled() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+	name, password, ok := r.BasicAuth()
+	admin, valid := checkLogin(name, password)
+	if !ok || !valid {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	return withUser(ctx, name, admin), nil
+}
+
+func grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authedStream 替换流的上下文以携带用户名
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
+
+// grpcServer 实现 FileService，路径与 WebDAV、FTP 相同，均位于虚拟文件树中
+type grpcServer struct {
+	pb.UnimplementedFileServiceServer
+}
+
+// grpcError 将文件系统错误转换为 gRPC 状态
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, fs.ErrPermission):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.Is(err, fs.ErrExist):
+		return status.Error(codes.AlreadyExists, "already exists")
+	}
+	switch uploadErrorStatus(err) {
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case http.StatusInsufficientStorage:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fileInfo 构造返回给客户端的文件信息
+func (g *grpcServer) fileInfo(vol volume, rel, virtual string, info fs.FileInfo) *pb.FileInfo {
+	fi := &pb.FileInfo{
+		Name:     info.Name(),
+		Path:     virtual,
+		IsDir:    info.IsDir(),
+		Size:     info.Size(),
+		ModTime:  timestamppb.New(info.ModTime()),
+		ReadOnly: vol.readOnly,
+	}
+	if !info.IsDir() {
+		fi.MimeType = detectContentType(vol.store, rel)
+	}
+	return fi
+}
+
+func (g *grpcServer) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	dir := cleanName(req.Dir)
+	vol, rel, err := resolveVirtual(ctx, dir)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	entries, err := listVirtual(ctx, vol, rel, dir == "")
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ListResponse{}
+	for _, info := range entries {
+		child := path.Join(dir, info.Name())
+		childVol, childRel, err := resolveVirtual(ctx, child)
+		if err != nil {
+			continue
+		}
+		resp.Entries = append(resp.Entries, g.fileInfo(childVol, childRel, child, info))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Stat(ctx context.Context, req *pb.StatRequest) (*pb.FileInfo, error) {
+	name := cleanName(req.Path)
+	vol, rel, err := resolveVirtual(ctx, name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return g.fileInfo(vol, rel, name, info), nil
+}
+
+func (g *grpcServer) Upload(stream pb.FileService_UploadServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	name := cleanName(first.GetPath())
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "first message must carry the target path")
+	}
+	if path.Base(name) == metaDirName {
+		return status.Error(codes.InvalidArgument, "reserved name")
+	}
+	vol, rel, err := resolveVirtual(ctx, name)
+	if err != nil {
+		return grpcError(err)
+	}
+	if vol.readOnly || rel == "" {
+		return status.Error(codes.PermissionDenied, "read-only")
+	}
+	if info, err := vol.store.Stat(rel); err == nil && info.IsDir() {
+		return status.Error(codes.FailedPrecondition, "target is a directory")
+	}
+	created, err := checkNewEntry(vol, rel, true)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	// 先写入同目录下的隐藏临时文件，流结束后再替换，出错时原有文件保持不变
+	h := sha256.New()
+	var size int64
+	var recvErr error
+	var ev fileEvent
+	err = stageAndCommit(vol.store, rel, "grpc", func(w io.Writer) error {
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				recvErr = err
+				return err
+			}
+			if _, err := io.MultiWriter(w, h).Write(msg.GetChunk()); err != nil {
+				return err
+			}
+			size += int64(len(msg.GetChunk()))
+		}
+	}, func(tmp string) error {
+		ev = fileEvent{
+			Event:    eventUpload,
+			Path:     vol.virtual(rel),
+			Size:     size,
+			User:     contextUser(ctx),
+			Checksum: hex.EncodeToString(h.Sum(nil)),
+			Time:     time.Now().UTC(),
+		}
+		return acceptUpload(vol, tmp, ev)
+	})
+	if recvErr != nil {
+		return recvErr
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+
+	logger.Printf("gRPC upload saved: %s", ev.Path)
+	completeUpload(vol, rel, ev, created)
+
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return grpcError(err)
+	}
+	return stream.SendAndClose(&pb.UploadResponse{File: g.fileInfo(vol, rel, name, info), Checksum: ev.Checksum})
+}
+
+func (g *grpcServer) Download(req *pb.DownloadRequest, stream pb.FileService_DownloadServer) error {
+	vol, rel, err := resolveVirtual(stream.Context(), req.Path)
+	if err != nil {
+		return grpcError(err)
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return grpcError(err)
+	}
+	if info.IsDir() {
+		return status.Error(codes.FailedPrecondition, "cannot download a directory")
+	}
+	f, err := vol.store.Open(rel)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer f.Close()
+	if req.Offset > 0 {
+		if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+			return grpcError(err)
+		}
+	} else {
+		stats.record(vol.virtual(rel))
+	}
+
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.DownloadChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+func (g *grpcServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	vol, rel, err := resolveVirtual(ctx, req.Path)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if vol.readOnly || rel == "" {
+		return nil, status.Error(codes.PermissionDenied, "read-only")
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := vol.store.Delete(rel); err != nil {
+		return nil, grpcError(err)
+	}
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(rel), User: contextUser(ctx)}
+	if !info.IsDir() {
+		ev.Size = info.Size()
+	}
+	logger.Printf("gRPC deleted: %s", ev.Path)
+	emitEvent(ev)
+	return &pb.DeleteResponse{}, nil
+}
+
+func (g *grpcServer) Move(ctx context.Context, req *pb.MoveRequest) (*pb.MoveResponse, error) {
+	oldVol, oldRel, err := resolveVirtual(ctx, req.From)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	to := cleanName(req.To)
+	newVol, newRel, err := resolveVirtual(ctx, to)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if oldVol.readOnly || newVol.readOnly || oldRel == "" || newRel == "" {
+		return nil, status.Error(codes.PermissionDenied, "read-only")
+	}
+	if oldVol.prefix != newVol.prefix {
+		return nil, status.Error(codes.InvalidArgument, errCrossVolume.Error())
+	}
+	if err := renameEntry(oldVol, oldRel, newRel); err != nil {
+		return nil, grpcError(err)
+	}
+	info, err := newVol.store.Stat(newRel)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.MoveResponse{File: g.fileInfo(newVol, newRel, to, info)}, nil
+}
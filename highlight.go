@@ -0,0 +1,197 @@
+package fileserver
+
+import (
+	"html"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// highlightLang 描述一种语言的词法规则，用于在网页中高亮代码
+type highlightLang struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string // 字符串的引号；反引号字符串可以跨行
+}
+
+func keywordSet(words string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLike = &highlightLang{
+		keywords: keywordSet(`if else for while do switch case default break continue return goto struct union enum typedef
+			const static extern void int char long short float double unsigned signed sizeof class public private protected
+			new delete try catch throw this true false null nullptr namespace using template virtual import package
+			interface extends implements final abstract boolean byte fn let mut impl trait pub use mod match loop self Self`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	goLang = &highlightLang{
+		keywords: keywordSet(`break case chan const continue default defer else fallthrough for func go goto if import
+			interface map package range return select struct switch type var true false nil iota`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	jsLang = &highlightLang{
+		keywords: keywordSet(`async await break case catch class const continue debugger default delete do else export
+			extends finally for function if import in instanceof let new of return static super switch this throw try
+			typeof var void while yield true false null undefined interface type enum implements`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	pyLang = &highlightLang{
+		keywords: keywordSet(`and as assert async await break class continue def del elif else except finally for from
+			global if import in is lambda nonlocal not or pass raise return try while with yield True False None self`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	shLang = &highlightLang{
+		keywords: keywordSet(`if then else elif fi for while until do done case esac in function return local export
+			echo exit set unset shift source alias sudo cd`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	sqlLang = &highlightLang{
+		keywords: keywordSet(`select from where and or not insert into values update set delete create table drop alter
+			index join left right inner outer on group by order having limit offset as distinct null is primary key
+			SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT
+			RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT OFFSET AS DISTINCT NULL IS PRIMARY KEY`),
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	confLang = &highlightLang{
+		keywords:     keywordSet(`true false yes no on off null`),
+		lineComments: []string{"#", ";"},
+		quotes:       `"'`,
+	}
+)
+
+// highlightLangs 按扩展名选择高亮规则，其他扩展名按纯文本显示
+var highlightLangs = map[string]*highlightLang{
+	".go": goLang, ".c": cLike, ".h": cLike, ".cpp": cLike, ".cc": cLike, ".hpp": cLike, ".java": cLike,
+	".cs": cLike, ".rs": cLike, ".kt": cLike, ".swift": cLike, ".php": cLike,
+	".js": jsLang, ".mjs": jsLang, ".ts": jsLang, ".jsx": jsLang, ".tsx": jsLang, ".json": jsLang,
+	".py": pyLang, ".rb": pyLang,
+	".sh": shLang, ".bash": shLang, ".zsh": shLang, ".ps1": shLang,
+	".sql":  sqlLang,
+	".yaml": confLang, ".yml": confLang, ".toml": confLang, ".ini": confLang, ".conf": confLang, ".env": confLang,
+}
+
+// highlightCode 将源代码转换为 HTML，关键字、字符串、注释和数字以不同的 class 标记（k、s、c、n）
+func highlightCode(src, name string) string {
+	lang := highlightLangs[strings.ToLower(path.Ext(name))]
+	if lang == nil {
+		return html.EscapeString(src)
+	}
+	var sb strings.Builder
+	span := func(class, text string) {
+		sb.WriteString(`<span class="` + class + `">`)
+		sb.WriteString(html.EscapeString(text))
+		sb.WriteString("</span>")
+	}
+	isIdent := func(r byte) bool {
+		return r == '_' || r >= 0x80 || unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r))
+	}
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		if b := lang.blockComment; b[0] != "" && strings.HasPrefix(rest, b[0]) {
+			end := strings.Index(rest[len(b[0]):], b[1])
+			n := len(rest)
+			if end >= 0 {
+				n = len(b[0]) + end + len(b[1])
+			}
+			span("c", rest[:n])
+			i += n
+			continue
+		}
+		if lineComment(lang, rest) {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span("c", rest[:n])
+			i += n
+			continue
+		}
+		c := src[i]
+		switch {
+		case strings.IndexByte(lang.quotes, c) >= 0:
+			n := 1
+			for n < len(rest) && rest[n] != c && (c == '`' || rest[n] != '\n') {
+				if rest[n] == '\\' && c != '`' {
+					n++
+				}
+				n++
+			}
+			n = min(n+1, len(rest))
+			span("s", rest[:n])
+			i += n
+		case c >= '0' && c <= '9' && (i == 0 || !isIdent(src[i-1])):
+			n := 1
+			for n < len(rest) && (isIdent(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("n", rest[:n])
+			i += n
+		case isIdent(c):
+			n := 1
+			for n < len(rest) && isIdent(rest[n]) {
+				n++
+			}
+			if lang.keywords[rest[:n]] {
+				span("k", rest[:n])
+			} else {
+				sb.WriteString(html.EscapeString(rest[:n]))
+			}
+			i += n
+		default:
+			sb.WriteString(html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// lineComment 判断 rest 是否以行注释开头
+func lineComment(lang *highlightLang, rest string) bool {
+	for _, p := range lang.lineComments {
+		if strings.HasPrefix(rest, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// codeViewCSS 是代码查看页面的样式
+const codeViewCSS = `    <style>
+        .code { display: flex; font: 13px/1.5 monospace; border: 1px solid #ddd; overflow-x: auto; }
+        .code pre { margin: 0; padding: 8px; }
+        .code .ln { color: #999; text-align: right; user-select: none; background: #f6f6f6; border-right: 1px solid #ddd; }
+        .k { color: #a626a4; font-weight: bold; } .s { color: #50a14f; } .c { color: #a0a1a7; font-style: italic; } .n { color: #986801; }
+    </style>
+`
+
+// codeViewHTML 返回带行号的高亮代码
+func codeViewHTML(src, name string) string {
+	lines := strings.Count(src, "\n")
+	if !strings.HasSuffix(src, "\n") {
+		lines++
+	}
+	var ln strings.Builder
+	for i := 1; i <= lines; i++ {
+		ln.WriteString(strconv.Itoa(i))
+		ln.WriteByte('\n')
+	}
+	return `<div class="code"><pre class="ln">` + ln.String() + `</pre><pre>` + highlightCode(src, name) + "</pre></div>"
+}
@@ -0,0 +1,378 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsEnabled 由 -hls 设置：为 true 时浏览器无法直接播放的视频通过 ffmpeg 转为 HLS 流播放
+var hlsEnabled bool
+
+// HLS 转码的限制
+const (
+	hlsMaxSessions  = 2                // 同时运行的转码进程数
+	hlsIdleTimeout  = 2 * time.Minute  // 播放器停止请求后终止未完成的转码
+	hlsCacheMaxAge  = 24 * time.Hour   // 已完成的转码结果在最后一次访问后保留的时间
+	hlsSegmentTime  = 6                // 每个分段的秒数
+	hlsStartTimeout = 30 * time.Second // 等待 ffmpeg 写出第一个播放列表的时间
+)
+
+// webVideoCodecs 是浏览器普遍能直接播放的 MP4 视频编码
+var webVideoCodecs = map[string]bool{"avc1": true, "avc3": true}
+
+// webVideoExts 是浏览器可以直接播放的视频扩展名，其中 MP4 还要求编码在 webVideoCodecs 中
+var webVideoExts = map[string]bool{".mp4": true, ".m4v": true, ".webm": true, ".ogv": true}
+
+// hlsSession 是一个视频的转码进程，输出的播放列表和分段写入 dir
+type hlsSession struct {
+	dir    string
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	mu         sync.Mutex
+	lastAccess time.Time
+}
+
+func (s *hlsSession) touch() {
+	s.mu.Lock()
+	s.lastAccess = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *hlsSession) idle() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastAccess)
+}
+
+var (
+	hlsMu       sync.Mutex
+	hlsSessions = make(map[string]*hlsSession)
+)
+
+var errHLSBusy = errors.New("too many videos are being transcoded, try again later")
+
+// hlsCacheDir 返回转码结果的缓存目录，位于服务目录的元数据目录中
+func hlsCacheDir() string {
+	return filepath.Join(uploadDir, metaDirName, "hls")
+}
+
+// hlsKey 根据视频的虚拟路径、大小和修改时间生成缓存目录名，文件变化后重新转码
+func hlsKey(virtual string, info os.FileInfo) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", virtual, info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(h[:16])
+}
+
+// hlsComplete 判断缓存目录中的转码是否已完成
+func hlsComplete(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	return err == nil && strings.Contains(string(data), "#EXT-X-ENDLIST")
+}
+
+// needsTranscode 判断视频是否需要转为 HLS 才能在浏览器中播放
+func needsTranscode(f storageFile, name, ctype string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if !webVideoExts[ext] {
+		return true
+	}
+	if ext == ".webm" || ext == ".ogv" {
+		return false
+	}
+	m := &mediaInfo{}
+	readMP4Info(f, m)
+	for _, fl := range m.Fields {
+		if fl.Name == "Video codec" {
+			return !webVideoCodecs[fl.Value]
+		}
+	}
+	return false
+}
+
+// ffprobeBin 返回与 ffmpeg 同目录的 ffprobe，不可用时返回空
+func ffprobeBin(ffmpeg string) string {
+	dir, base := filepath.Split(ffmpeg)
+	p, err := exec.LookPath(filepath.Join(dir, strings.Replace(base, "ffmpeg", "ffprobe", 1)))
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// videoCodec 用 ffprobe 读取第一个视频流的编码名，无法读取时返回空
+func videoCodec(ffmpeg, src string) string {
+	probe := ffprobeBin(ffmpeg)
+	if probe == "" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, probe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name", "-of", "default=nw=1:nk=1", src).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// startHLS 返回视频的转码会话，没有时启动 ffmpeg：H.264 视频只重新封装，其他编码转为 H.264，音频转为 AAC
+func startHLS(key, src string) (*hlsSession, error) {
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+	if s := hlsSessions[key]; s != nil {
+		s.touch()
+		return s, nil
+	}
+	running := 0
+	for _, s := range hlsSessions {
+		select {
+		case <-s.done:
+		default:
+			running++
+		}
+	}
+	if running >= hlsMaxSessions {
+		return nil, errHLSBusy
+	}
+	bin := ffmpegBin()
+	if bin == "" {
+		return nil, errors.New("ffmpeg not found (set -ffmpeg)")
+	}
+	dir := filepath.Join(hlsCacheDir(), key)
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	video := []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p"}
+	if videoCodec(bin, src) == "h264" {
+		video = []string{"-c:v", "copy"}
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", src, "-map", "0:v:0", "-map", "0:a:0?"}
+	args = append(args, video...)
+	args = append(args, "-c:a", "aac", "-b:a", "160k", "-ac", "2",
+		"-f", "hls", "-hls_time", fmt.Sprint(hlsSegmentTime), "-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"), filepath.Join(dir, "index.m3u8"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &hlsSession{dir: dir, cancel: cancel, done: make(chan struct{}), lastAccess: time.Now()}
+	hlsSessions[key] = s
+	logger.Printf("Transcoding %s to HLS", src)
+	go func() {
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			s.err = fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+			logger.Printf("Error transcoding %s: %v", src, s.err)
+		}
+		cancel()
+		close(s.done)
+	}()
+	go s.reap(key)
+	return s, nil
+}
+
+// reap 在播放器停止请求一段时间后终止未完成的转码并删除其输出，转码结束后移出会话表
+func (s *hlsSession) reap(key string) {
+	t := time.NewTicker(hlsIdleTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			if s.err != nil {
+				os.RemoveAll(s.dir)
+			}
+			// 保留失败的会话一段时间，避免播放器重试时反复启动 ffmpeg
+			time.Sleep(hlsIdleTimeout)
+			hlsMu.Lock()
+			delete(hlsSessions, key)
+			hlsMu.Unlock()
+			return
+		case <-t.C:
+			if s.idle() > hlsIdleTimeout {
+				logger.Printf("Stopping idle HLS transcode in %s", s.dir)
+				s.cancel()
+				<-s.done
+				os.RemoveAll(s.dir)
+				hlsMu.Lock()
+				delete(hlsSessions, key)
+				hlsMu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// hlsHandler 处理 /hls/<路径>/index.m3u8 和 /hls/<路径>/segNNNNN.ts：
+// 首次请求播放列表时启动转码，播放器随后按播放列表获取已写出的分段
+func hlsHandler(w http.ResponseWriter, r *http.Request) {
+	if !hlsEnabled {
+		http.Error(w, "HLS streaming is disabled (start the server with -hls)", http.StatusNotFound)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/hls/")
+	p, file := path.Split(rest)
+	if file != "index.m3u8" && !(strings.HasPrefix(file, "seg") && strings.HasSuffix(file, ".ts")) || strings.ContainsAny(file, `/\`) {
+		http.NotFound(w, r)
+		return
+	}
+	vol, name, err := resolveTarget(r, strings.TrimSuffix(p, "/"))
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	info, err := vol.store.Stat(name)
+	lp, local := vol.store.(localPather)
+	if err != nil || info.IsDir() || !local {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	key := hlsKey(vol.virtual(name), info)
+	dir := filepath.Join(hlsCacheDir(), key)
+	if !hlsComplete(dir) {
+		s, err := startHLS(key, lp.LocalPath(name))
+		if err == errHLSBusy {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			logger.Printf("Error starting HLS transcode of %s: %v", vol.virtual(name), err)
+			http.Error(w, "Failed to start transcoding", http.StatusInternalServerError)
+			return
+		}
+		s.touch()
+		if file == "index.m3u8" {
+			// 等待 ffmpeg 写出第一个分段
+			deadline := time.Now().Add(hlsStartTimeout)
+			for {
+				if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+					break
+				}
+				select {
+				case <-s.done:
+				case <-r.Context().Done():
+					return
+				case <-time.After(200 * time.Millisecond):
+					if time.Now().Before(deadline) {
+						continue
+					}
+				}
+				if s.err != nil {
+					http.Error(w, "Transcoding failed", http.StatusInternalServerError)
+				} else {
+					http.Error(w, "Transcoding did not start in time", http.StatusGatewayTimeout)
+				}
+				return
+			}
+		}
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now) // 记录访问时间，供 janitor 判断转码结果是否仍在使用
+	if file == "index.m3u8" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	http.ServeFile(w, r, filepath.Join(dir, file))
+}
+
+// hlsURL 返回视频 HLS 播放列表的地址
+func hlsURL(virtual string) string {
+	segs := strings.Split(virtual, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return "/hls/" + strings.Join(segs, "/") + "/index.m3u8"
+}
+
+// playPageHandler 处理 /play?path=，用浏览器内置的播放器播放视频：
+// 浏览器能直接播放的文件直接加载，其他文件在启用 -hls 时以 HLS 流播放
+func playPageHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	f, err := vol.store.Open(name)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
+		return
+	}
+	ctype := detectContentType(vol.store, name)
+	if !strings.HasPrefix(ctype, "video/") {
+		http.Error(w, "Not a video", http.StatusBadRequest)
+		return
+	}
+	virtual := vol.virtual(name)
+	src := "/download?path=" + url.QueryEscape(virtual)
+	note := ""
+	_, local := vol.store.(localPather)
+	switch {
+	case !needsTranscode(f, name, ctype):
+	case hlsEnabled && local && ffmpegBin() != "":
+		src = hlsURL(virtual)
+		note = `Streaming a converted copy; seeking is possible up to the part converted so far. Browsers without built-in HLS support can open the <a href="` + html.EscapeString(src) + `">playlist</a> in a player such as VLC.`
+	default:
+		note = "This browser may not be able to play this video format. Download it to play it locally."
+	}
+	poster := ""
+	if ffmpegBin() != "" && local {
+		poster = ` poster="/thumb?path=` + html.EscapeString(url.QueryEscape(virtual)) + `"`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+    <h1>%s</h1>
+    <p><a href="/download?path=%s&amp;disposition=attachment">Download</a> | <a href="/info?path=%s">Details</a> | <a href="%s">&larr; Back</a></p>
+    <video src="%s"%s controls autoplay playsinline style="max-width:100%%">%s
+    </video>
+    <p><small>%s</small></p>
+</body>
+</html>`, html.EscapeString(path.Base(virtual)), html.EscapeString(path.Base(virtual)),
+		html.EscapeString(url.QueryEscape(virtual)), html.EscapeString(url.QueryEscape(virtual)),
+		html.EscapeString(listURL(strings.TrimSuffix(vol.prefix, "/"))), html.EscapeString(src), poster,
+		subtitleTracksHTML(vol, findSubtitles(vol.store, name)), note)
+}
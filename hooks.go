@@ -0,0 +1,81 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上传钩子命令配置，命令以空白分隔参数，文件路径作为最后一个参数追加
+var (
+	preUploadHook  string
+	postUploadHook string
+	hookTimeout    time.Duration
+)
+
+// hookRejectedError 表示上传前钩子以非零状态退出，拒绝了本次上传
+type hookRejectedError struct {
+	output string
+	err    error
+}
+
+func (e *hookRejectedError) Error() string {
+	if e.output != "" {
+		return fmt.Sprintf("upload rejected by pre-upload hook: %s", e.output)
+	}
+	return fmt.Sprintf("upload rejected by pre-upload hook: %v", e.err)
+}
+
+// runPreUploadHook 在接受上传前执行校验命令，非零退出码表示拒绝
+func runPreUploadHook(path string, ev fileEvent) error {
+	if preUploadHook == "" {
+		return nil
+	}
+	out, err := runHook(preUploadHook, path, ev)
+	if err != nil {
+		logger.Printf("Pre-upload hook rejected %s: %v", path, err)
+		return &hookRejectedError{output: strings.TrimSpace(string(out)), err: err}
+	}
+	return nil
+}
+
+// runPostUploadHook 在文件保存后异步执行处理命令
+func runPostUploadHook(path string, ev fileEvent) {
+	if postUploadHook == "" {
+		return
+	}
+	go func() {
+		out, err := runHook(postUploadHook, path, ev)
+		if err != nil {
+			logger.Printf("Post-upload hook failed for %s: %v: %s", path, err, strings.TrimSpace(string(out)))
+			return
+		}
+		logger.Printf("Post-upload hook completed for %s", path)
+	}()
+}
+
+// runHook 执行钩子命令，通过参数和环境变量传递文件路径与元数据
+func runHook(command, path string, ev fileEvent) ([]byte, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	args := append(fields[1:], path)
+	cmd := exec.CommandContext(ctx, fields[0], args...)
+	cmd.Env = append(os.Environ(),
+		"FILESERVER_EVENT="+ev.Event,
+		"FILESERVER_PATH="+path,
+		"FILESERVER_NAME="+ev.Path,
+		"FILESERVER_SIZE="+strconv.FormatInt(ev.Size, 10),
+		"FILESERVER_SHA256="+ev.Checksum,
+		"FILESERVER_USER="+ev.User,
+	)
+	return cmd.CombinedOutput()
+}
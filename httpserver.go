@@ -0,0 +1,56 @@
+package fileserver
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTP 服务器的超时设置，由 -read-header-timeout、-idle-timeout、-write-timeout 和 -max-header-size 设置
+var (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+	writeStallTimeout = 5 * time.Minute
+	maxHeaderBytes    = byteSize(http.DefaultMaxHeaderBytes)
+)
+
+// newHTTPServer 创建带超时设置的 HTTP 服务器。请求头必须在 readHeaderTimeout 内收完，防止慢速连接（slowloris）占满连接；
+// 不设置整个响应的写超时，以免中断大文件下载，改为由 stallWriter 限制每次写入的等待时间
+func newHTTPServer(h http.Handler) *http.Server {
+	if writeStallTimeout > 0 {
+		h = stallTimeoutMiddleware(h)
+	}
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    int(maxHeaderBytes),
+		ErrorLog:          logger,
+	}
+}
+
+// stallWriter 在每次写入前把连接的写截止时间推迟 writeStallTimeout：
+// 持续接收数据的慢速下载不受影响，客户端停止读取超过该时间时连接被断开
+type stallWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (w *stallWriter) Write(b []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(writeStallTimeout))
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *stallWriter) Flush() {
+	w.rc.SetWriteDeadline(time.Now().Add(writeStallTimeout))
+	w.rc.Flush()
+}
+
+func (w *stallWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func stallTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&stallWriter{ResponseWriter: w, rc: http.NewResponseController(w)}, r)
+	})
+}
@@ -0,0 +1,120 @@
+package fileserver
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// janitorInterval 和 janitorMaxAge 由 -janitor-interval 和 -janitor-max-age 设置：
+// 每隔 janitorInterval 清理一次修改时间早于 janitorMaxAge 的遗留临时文件
+var (
+	janitorInterval = time.Hour
+	janitorMaxAge   = 24 * time.Hour
+)
+
+// stagingFilePattern 匹配 stagingKinds 中各种写入在目标旁创建的隐藏临时文件，如 .report.pdf.put-1a2b3c4d；
+// 服务器在写入过程中崩溃时它们会被遗留
+var stagingFilePattern = regexp.MustCompile(`^\..+\.(` + strings.Join(stagingKinds, "|") + `)-[0-9a-f]{8}$`)
+
+// startJanitor 在后台定期清理遗留的临时文件，启动时先执行一次
+func startJanitor() {
+	if janitorInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			cleanupOrphans(time.Now().Add(-janitorMaxAge))
+			time.Sleep(janitorInterval)
+		}
+	}()
+}
+
+// cleanupOrphans 删除修改时间早于 cutoff 的遗留文件：服务目录和挂载点中的上传临时文件、
+// 元数据目录中写入一半的 .tmp 文件（包括 ZIP 缓存）、长期未访问的视频封面和 HLS 转码结果、过期的审计事件和上传令牌、未完成的 S3 分段上传、内容存储中不再被引用的内容，以及暂存目录中的表单文件、文件夹上传归档和解压目录
+func cleanupOrphans(cutoff time.Time) {
+	removed := 0
+	remove := func(p string, info fs.FileInfo) {
+		if info.ModTime().After(cutoff) {
+			return
+		}
+		if err := os.RemoveAll(p); err != nil {
+			logger.Printf("Janitor: error removing %s: %v", p, err)
+			return
+		}
+		logger.Printf("Janitor: removed stale %s", p)
+		removed++
+	}
+
+	walkVolumes(func(vol volume, name string, info fs.FileInfo) error {
+		if lp, ok := baseStorage(vol.store).(localPather); ok && (!vol.readOnly || replicaWritable(vol)) && info.Mode().IsRegular() && stagingFilePattern.MatchString(info.Name()) {
+			remove(lp.LocalPath(name), info)
+		}
+		return nil
+	})
+
+	if casEnabled {
+		if _, err := casCollect(true); err != nil {
+			logger.Printf("Janitor: error collecting unused content: %v", err)
+		}
+	}
+
+	meta := filepath.Join(uploadDir, metaDirName)
+	filepath.Walk(meta, func(p string, info fs.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".tmp") {
+			remove(p, info)
+		}
+		return nil
+	})
+
+	thumbs, _ := os.ReadDir(thumbCacheDir())
+	for _, e := range thumbs {
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() && time.Since(info.ModTime()) > posterMaxAge {
+			remove(filepath.Join(thumbCacheDir(), e.Name()), info)
+		}
+	}
+
+	hlsDirs, _ := os.ReadDir(hlsCacheDir())
+	for _, e := range hlsDirs {
+		hlsMu.Lock()
+		_, active := hlsSessions[e.Name()]
+		hlsMu.Unlock()
+		if info, err := e.Info(); err == nil && e.IsDir() && !active && time.Since(info.ModTime()) > hlsCacheMaxAge {
+			remove(filepath.Join(hlsCacheDir(), e.Name()), info)
+		}
+	}
+
+	if n := uploadTokens.prune(); n > 0 {
+		logger.Printf("Janitor: removed %d expired upload tokens", n)
+	}
+	if n := pruneS3Uploads(cutoff); n > 0 {
+		logger.Printf("Janitor: removed %d abandoned S3 multipart uploads", n)
+	}
+	if n := locks.prune(); n > 0 {
+		logger.Printf("Janitor: removed %d expired file locks", n)
+	}
+	if auditMaxAge > 0 {
+		if n := pruneAudit(time.Now().Add(-auditMaxAge)); n > 0 {
+			logger.Printf("Janitor: removed %d audit events older than %v", n, auditMaxAge)
+		}
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	for _, e := range entries {
+		name := e.Name()
+		isUpload := (strings.HasPrefix(name, "upload-") && strings.HasSuffix(name, ".up") || strings.HasPrefix(name, "multipart-") || strings.HasPrefix(name, "append-")) && e.Type().IsRegular()
+		isExtract := strings.HasPrefix(name, "extract-") && e.IsDir()
+		if !isUpload && !isExtract {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			remove(filepath.Join(tmpDir, name), info)
+		}
+	}
+	if removed > 0 {
+		logger.Printf("Janitor: removed %d stale temporary files", removed)
+	}
+}
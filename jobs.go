@@ -0,0 +1,627 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// jobWorkers 是同时运行的后台任务数，其余任务排队等待
+var jobWorkers = 2
+
+// 后台任务类型
+const (
+	jobExtract = "extract"
+	jobZip     = "zip"
+	jobDelete  = "delete"
+	jobHash    = "hash"
+	jobScrub   = "scrub"
+)
+
+// 后台任务状态
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// job 是一个在请求之外运行的耗时操作；Done 和 Bytes 为已处理的条目数和字节数，
+// Total 和 TotalBytes 在能预先知道时给出，否则为 0
+type job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Path       string     `json:"path"`
+	Result     string     `json:"result,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Status     string     `json:"status"`
+	Done       int64      `json:"done"`
+	Total      int64      `json:"total,omitempty"`
+	Bytes      int64      `json:"bytes"`
+	TotalBytes int64      `json:"total_bytes,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Created    time.Time  `json:"created"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+
+	system bool // 服务器自己启动的任务，只有管理员可见
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = map[string]*job{}
+	jobSlots chan struct{}
+)
+
+// setupJobs 根据 -job-workers 初始化任务队列，关闭服务时取消所有未完成的任务
+func setupJobs() error {
+	if jobWorkers < 1 {
+		return fmt.Errorf("-job-workers must be at least 1")
+	}
+	jobSlots = make(chan struct{}, jobWorkers)
+	onShutdown(cancelJobs)
+	return nil
+}
+
+// newJob 登记一个排队中的任务；任务的 context 不随请求结束而取消，但保留请求 ID 用于日志
+func newJob(r *http.Request, kind, virtual string) (*job, error) {
+	return registerJob(context.WithoutCancel(r.Context()), currentUser(r), false, kind, virtual)
+}
+
+// newSystemJob 登记服务器自己启动的任务，如定时的完整性检查；只有管理员能看到和取消
+func newSystemJob(kind, virtual string) (*job, error) {
+	return registerJob(context.Background(), "", true, kind, virtual)
+}
+
+func registerJob(parent context.Context, user string, system bool, kind, virtual string) (*job, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(parent)
+	j := &job{ID: id, Kind: kind, Path: virtual, User: user, Status: jobQueued, Created: time.Now().UTC(), system: system, ctx: ctx, cancel: cancel}
+	jobsMu.Lock()
+	pruneJobs()
+	jobs[id] = j
+	jobsMu.Unlock()
+	return j, nil
+}
+
+// start 在有空闲的工作槽时于后台运行 fn；任务排队时被取消也会调用 fn，
+// 以便它清理临时文件，此时 ctx 已取消
+func (j *job) start(fn func(ctx context.Context) error) {
+	go func() {
+		select {
+		case jobSlots <- struct{}{}:
+			defer func() { <-jobSlots }()
+			jobsMu.Lock()
+			now := time.Now().UTC()
+			j.Status, j.Started = jobRunning, &now
+			jobsMu.Unlock()
+			requestLogger(j.ctx).Printf("Job %s started: %s %s", j.ID, j.Kind, j.Path)
+		case <-j.ctx.Done():
+		}
+		j.finish(fn(j.ctx))
+	}()
+}
+
+// finish 结束任务，err 不为 nil 时为失败；任务被取消时为已取消
+func (j *job) finish(err error) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	now := time.Now().UTC()
+	j.Finished = &now
+	switch {
+	case err != nil && j.ctx.Err() != nil:
+		j.Status = jobCanceled
+	case err != nil:
+		j.Status, j.Error = jobFailed, err.Error()
+	default:
+		j.Status = jobDone
+	}
+	status := j.Status
+	jobsMu.Unlock()
+	j.cancel()
+	if err != nil && status == jobFailed {
+		requestLogger(j.ctx).Printf("Job %s failed: %v", j.ID, err)
+		return
+	}
+	requestLogger(j.ctx).Printf("Job %s %s", j.ID, status)
+}
+
+// setTotals 记录需要处理的条目总数和字节总数
+func (j *job) setTotals(entries, bytes int64) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	j.Total, j.TotalBytes = entries, bytes
+	jobsMu.Unlock()
+}
+
+// setResult 记录任务产生的路径，如解压的文件夹或创建的压缩包
+func (j *job) setResult(virtual string) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	j.Result = virtual
+	jobsMu.Unlock()
+}
+
+// advance 累加已处理的条目数和字节数
+func (j *job) advance(entries, bytes int64) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	j.Done += entries
+	j.Bytes += bytes
+	jobsMu.Unlock()
+}
+
+// snapshot 返回任务的副本
+func (j *job) snapshot() job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return *j
+}
+
+// counter 返回在写入时累加任务字节数的 io.Writer，ctx 取消后写入返回错误，使复制尽快停止
+func (j *job) counter(ctx context.Context) io.Writer {
+	return jobCounter{j, ctx}
+}
+
+type jobCounter struct {
+	job *job
+	ctx context.Context
+}
+
+func (c jobCounter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.job.advance(0, int64(len(b)))
+	return len(b), nil
+}
+
+// cancelJobs 取消所有未结束的任务
+func cancelJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		if j.Finished == nil {
+			j.cancel()
+		}
+	}
+}
+
+// pruneJobs 清理一小时前已结束的任务，调用时需持有 jobsMu
+func pruneJobs() {
+	for id, j := range jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > time.Hour {
+			delete(jobs, id)
+		}
+	}
+}
+
+// lookupJob 返回当前用户可见的任务，管理员可以看到所有任务
+func lookupJob(r *http.Request, id string) (*job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	if !ok || !j.visible(r) {
+		return nil, false
+	}
+	return j, true
+}
+
+// visible 判断当前用户能否看到任务，调用时需持有 jobsMu
+func (j *job) visible(r *http.Request) bool {
+	return isAdmin(r) || (!j.system && j.User == currentUser(r))
+}
+
+// listJobs 返回当前用户可见的任务副本，按创建时间从新到旧排列
+func listJobs(r *http.Request) []job {
+	jobsMu.Lock()
+	list := []job{}
+	for _, j := range jobs {
+		if j.visible(r) {
+			list = append(list, *j)
+		}
+	}
+	jobsMu.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+	return list
+}
+
+// treeSize 返回 name 下的文件数和总字节数，name 为文件时返回它本身
+func treeSize(vol volume, name string) (files, bytes int64) {
+	vol.store.Walk(name, func(_ string, info fs.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
+
+// jobRequest 是 POST /api/v1/jobs 的请求体：extract、delete 和 hash 任务使用 path，
+// zip 任务使用与 /api/v1/archive 相同的 paths、dest 等字段
+type jobRequest struct {
+	Kind        string   `json:"kind"`
+	Path        string   `json:"path,omitempty"`
+	Paths       []string `json:"paths,omitempty"`
+	Dest        string   `json:"dest,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Overwrite   bool     `json:"overwrite,omitempty"`
+	Password    string   `json:"password,omitempty"`
+}
+
+// submitJob 校验请求并启动对应的任务
+func submitJob(r *http.Request, req jobRequest) (*job, int, error) {
+	switch req.Kind {
+	case jobZip:
+		plan, status, err := prepareArchive(r, archiveRequest{Paths: req.Paths, Dest: req.Dest, Compression: req.Compression, Overwrite: req.Overwrite, Password: req.Password})
+		if err != nil {
+			return nil, status, err
+		}
+		j, err := newJob(r, jobZip, plan.vol.virtual(plan.name))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		j.start(func(ctx context.Context) error {
+			_, err := plan.build(ctx, j)
+			return err
+		})
+		return j, http.StatusAccepted, nil
+	case jobExtract, jobDelete, jobHash:
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown job kind %q", req.Kind)
+	}
+
+	if req.Path == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing path")
+	}
+	vol, name, err := resolveTarget(r, req.Path)
+	if err == errNotFound {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read directory")
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found")
+	}
+	if req.Kind != jobHash && (vol.readOnly || name == "") {
+		return nil, http.StatusForbidden, fmt.Errorf("directory is read-only")
+	}
+
+	switch req.Kind {
+	case jobExtract:
+		return submitExtractJob(r, vol, name, info)
+	case jobDelete:
+		// 与同步删除一样遵守 If-Match 等前提条件，任务开始时再检查一次
+		if hasPreconditions(r) && !preconditionsMet(r, info) {
+			return nil, http.StatusPreconditionFailed, errPreconditionFailed
+		}
+		j, err := newJob(r, jobDelete, vol.virtual(name))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		user := currentUser(r)
+		j.start(func(ctx context.Context) error {
+			if err := stillMet(r, vol, name); err != nil {
+				return err
+			}
+			return deleteTree(ctx, vol, name, info, user, j)
+		})
+		return j, http.StatusAccepted, nil
+	default:
+		j, err := newJob(r, jobHash, vol.virtual(name))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		j.start(func(ctx context.Context) error { return hashTree(ctx, vol, name, j) })
+		return j, http.StatusAccepted, nil
+	}
+}
+
+// submitExtractJob 将树中已有的归档复制到临时文件，与文件夹上传一样检查（空间、条目数、病毒扫描、上传前钩子）后，
+// 在后台解压到同目录下去掉扩展名的文件夹
+func submitExtractJob(r *http.Request, vol volume, name string, info fs.FileInfo) (*job, int, error) {
+	if info.IsDir() {
+		return nil, http.StatusBadRequest, fmt.Errorf("path is a directory")
+	}
+	if err := checkEntryLimits(vol, cleanName(path.Dir(name))); err != nil {
+		return nil, http.StatusInsufficientStorage, err
+	}
+	src, err := vol.store.Open(name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(tmpDir, "upload-*.up")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	tempArchive := tmp.Name()
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	removeTemp := true
+	defer func() {
+		if removeTemp {
+			os.Remove(tempArchive)
+		}
+	}()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	format, err := detectArchive(tempArchive)
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+	// 只有 ZIP 能预先知道解压后的大小，其他格式按归档大小估算
+	if _, unpacked := archiveTotals(tempArchive, format); unpacked > size {
+		size = unpacked
+	}
+	if err := checkFreeSpace(vol, size); err != nil {
+		return nil, http.StatusInsufficientStorage, err
+	}
+	if err := scanArchiveEntries(tempArchive, format); err != nil {
+		return nil, scanErrorStatus(err), err
+	}
+	ev := fileEvent{Event: eventUpload, Path: vol.virtual(trimArchiveExt(name)), Size: info.Size(), User: currentUser(r), Checksum: hex.EncodeToString(hasher.Sum(nil))}
+	if err := runPreUploadHook(tempArchive, ev); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	folderName, err := reserveFolder(vol.store, trimArchiveExt(name))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	ev.Path = vol.virtual(folderName)
+	j, err := newJob(r, jobExtract, vol.virtual(name))
+	if err != nil {
+		vol.store.Delete(folderName)
+		return nil, http.StatusInternalServerError, err
+	}
+	j.setResult(ev.Path)
+	removeTemp = false
+	j.start(func(ctx context.Context) error {
+		defer os.Remove(tempArchive)
+		return extractFolderUpload(ctx, vol, tempArchive, format, name, folderName, false, ev, j)
+	})
+	return j, http.StatusAccepted, nil
+}
+
+// deleteTree 逐个删除 name 下的文件，最后删除 name 本身；取消时已删除的文件不会恢复
+func deleteTree(ctx context.Context, vol volume, name string, info fs.FileInfo, user string, j *job) error {
+	var files []string
+	if info.IsDir() {
+		j.setTotals(treeSize(vol, name))
+		err := vol.store.Walk(name, func(p string, fi fs.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				files = append(files, p)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fi, err := vol.store.Stat(p)
+		if err != nil {
+			continue
+		}
+		if err := vol.store.Delete(p); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", vol.virtual(p), err)
+		}
+		j.advance(1, fi.Size())
+	}
+	if err := vol.store.Delete(name); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", vol.virtual(name), err)
+	}
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(name), User: user}
+	if !info.IsDir() {
+		ev.Size = info.Size()
+		j.advance(1, ev.Size)
+	}
+	logger.Printf("Deleted: %s", ev.Path)
+	emitEvent(ev)
+	return nil
+}
+
+// hashTree 计算 name 下每个文件的 SHA-256 并写入校验和缓存，之后的去重、校验和查询和完整性检查无需再读取文件
+func hashTree(ctx context.Context, vol volume, name string, j *job) error {
+	j.setTotals(treeSize(vol, name))
+	return vol.store.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil || info.IsDir() || reservedPath(p) {
+			return nil
+		}
+		if _, err := fileChecksum(vol, p); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("failed to hash %s: %w", vol.virtual(p), err)
+		}
+		j.advance(1, info.Size())
+		return nil
+	})
+}
+
+// archiveTotals 返回归档中的条目数和解压后的总字节数；只有 ZIP 的中央目录能不解压就读出，其他格式返回 0
+func archiveTotals(path string, format archiveFormat) (entries, bytes int64) {
+	if format != formatZip {
+		return 0, 0
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		entries++
+		bytes += int64(f.UncompressedSize64)
+	}
+	return entries, bytes
+}
+
+// apiJobsHandler 处理 /api/v1/jobs：GET 列出任务或按 id 返回进度，POST 提交任务，DELETE 按 id 取消任务
+func apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			writeJSON(w, http.StatusOK, listJobs(r))
+			return
+		}
+		j, ok := lookupJob(r, id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, j.snapshot())
+	case http.MethodPost:
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		j, status, err := submitJob(r, req)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, status, j.snapshot())
+	case http.MethodDelete:
+		j, ok := lookupJob(r, id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		j.cancel()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// jobsPageHandler 显示自动刷新的任务列表；带 id 和 dir 时只显示该任务，完成后回到 dir 的列表。
+// POST 带 cancel=<id> 时取消任务
+func jobsPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if j, ok := lookupJob(r, r.FormValue("cancel")); ok {
+			j.cancel()
+		}
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+		return
+	}
+	q := r.URL.Query()
+	var list []job
+	back := "/"
+	if id := q.Get("id"); id != "" {
+		j, ok := lookupJob(r, id)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		s := j.snapshot()
+		back = listURL(q.Get("dir"))
+		if s.Status == jobDone {
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		list = []job{s}
+	} else {
+		list = listJobs(r)
+	}
+
+	refresh := ""
+	rows := ""
+	for _, s := range list {
+		if s.Finished == nil {
+			refresh = `<meta http-equiv="refresh" content="1">`
+		}
+		cancel := ""
+		if s.Finished == nil {
+			cancel = fmt.Sprintf(`<form method="post"><input type="hidden" name="cancel" value="%s"><button type="submit">Cancel</button></form>`, s.ID)
+		}
+		target := html.EscapeString(s.Path)
+		if s.Result != "" && s.Result != s.Path {
+			target += " &rarr; " + html.EscapeString(s.Result)
+		}
+		rows += fmt.Sprintf("<tr><td>%s</td><td>%s</td><td><b>%s</b> %s</td><td>%s</td><td>%s</td></tr>\n",
+			s.Kind, target, s.Status, html.EscapeString(s.Error), jobProgress(s), cancel)
+	}
+	if rows == "" {
+		rows = `<tr><td colspan="5">No jobs</td></tr>`
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Jobs</title>
+    <meta charset="UTF-8">
+    %s
+</head>
+<body>
+    <h1>Jobs</h1>
+    <table>
+    <tr><th>Kind</th><th>Path</th><th>Status</th><th>Progress</th><th></th></tr>
+    %s
+    </table>
+    <p><a href="%s">&larr; Back</a></p>
+</body>
+</html>`, refresh, rows, html.EscapeString(back))
+}
+
+// jobProgress 格式化任务进度
+func jobProgress(s job) string {
+	progress := fmt.Sprintf("%d entries, %s", s.Done, formatSize(s.Bytes))
+	if s.Total > 0 {
+		progress = fmt.Sprintf("%d of %d entries, %s of %s", s.Done, s.Total, formatSize(s.Bytes), formatSize(s.TotalBytes))
+	}
+	if s.TotalBytes > 0 && s.Bytes <= s.TotalBytes {
+		progress += " (" + strconv.FormatInt(s.Bytes*100/s.TotalBytes, 10) + "%)"
+	}
+	return progress
+}
+
+// jobPageURL 返回任务进度页的地址
+func jobPageURL(j *job, dir string) string {
+	return "/jobs?id=" + j.ID + "&dir=" + url.QueryEscape(dir)
+}
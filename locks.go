@@ -0,0 +1,319 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileLock 是文件的协作锁：只是告诉其他人某人正在编辑，不阻止写入。过期后自动失效
+type fileLock struct {
+	Path    string    `json:"path"`
+	Owner   string    `json:"owner"`
+	Note    string    `json:"note,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// lockStore 保存在元数据库的 locks bucket 中，以虚拟路径为键
+type lockStore struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+var locks *lockStore
+
+const (
+	// lockDefaultTTL 是未指定时锁的有效期
+	lockDefaultTTL = time.Hour
+	// lockMaxTTL 是锁的最长有效期，需要更久时由持有者续期
+	lockMaxTTL = 7 * 24 * time.Hour
+)
+
+// loadLocks 从元数据库加载锁
+func loadLocks() *lockStore {
+	s := &lockStore{locks: map[string]*fileLock{}}
+	dbEach(bucketLocks, func(k, v []byte) error {
+		l := &fileLock{}
+		if err := json.Unmarshal(v, l); err != nil {
+			logger.Printf("Error parsing lock %s: %v", k, err)
+			return nil
+		}
+		s.locks[l.Path] = l
+		return nil
+	})
+	return s
+}
+
+// get 返回路径上未过期的锁
+func (s *lockStore) get(virtual string) *fileLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.locks[virtual]
+	if l == nil || time.Now().After(l.Expires) {
+		return nil
+	}
+	return l
+}
+
+// acquire 为 owner 锁定路径；owner 已持有锁时续期。被他人锁定时返回该锁和 false
+func (s *lockStore) acquire(virtual, owner, note string, ttl time.Duration) (*fileLock, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	l := s.locks[virtual]
+	if l != nil && now.Before(l.Expires) && l.Owner != owner {
+		return l, false
+	}
+	if l == nil || l.Owner != owner || !now.Before(l.Expires) {
+		l = &fileLock{Path: virtual, Owner: owner, Created: now}
+	}
+	if note != "" {
+		l.Note = note
+	}
+	l.Expires = now.Add(ttl)
+	s.locks[virtual] = l
+	dbPut(bucketLocks, []byte(virtual), l)
+	return l, true
+}
+
+// release 解除路径上的锁。只有持有者能解锁，force 为 true 时（管理员强制解锁）不检查持有者；
+// 返回解除前的锁，路径被他人锁定时返回该锁和 false
+func (s *lockStore) release(virtual, owner string, force bool) (*fileLock, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.locks[virtual]
+	if l == nil || time.Now().After(l.Expires) {
+		return nil, true
+	}
+	if l.Owner != owner && !force {
+		return l, false
+	}
+	delete(s.locks, virtual)
+	dbDelete(bucketLocks, []byte(virtual))
+	return l, true
+}
+
+// list 返回 visible 为 true 的未过期的锁，按路径排列
+func (s *lockStore) list(visible func(string) bool) []*fileLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []*fileLock{}
+	now := time.Now()
+	for _, l := range s.locks {
+		if now.Before(l.Expires) && visible(l.Path) {
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// forget 删除路径及其下条目的锁
+func (s *lockStore) forget(virtual string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys [][]byte
+	for p := range s.locks {
+		if p == virtual || virtual == "" || strings.HasPrefix(p, virtual+"/") {
+			delete(s.locks, p)
+			keys = append(keys, []byte(p))
+		}
+	}
+	dbDelete(bucketLocks, keys...)
+}
+
+// move 将路径及其下条目的锁移到新路径，替换新路径下原有的锁
+func (s *lockStore) move(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys [][]byte
+	moved := map[string]interface{}{}
+	for p, l := range s.locks {
+		if underPath(p, to) {
+			delete(s.locks, p)
+			keys = append(keys, []byte(p))
+		} else if np, ok := movedPath(p, from, to); ok {
+			delete(s.locks, p)
+			keys = append(keys, []byte(p))
+			c := *l
+			c.Path = np
+			moved[np] = &c
+		}
+	}
+	for p, l := range moved {
+		s.locks[p] = l.(*fileLock)
+	}
+	dbReplace(bucketLocks, keys, moved)
+}
+
+// prune 删除过期的锁，返回删除的数量
+func (s *lockStore) prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys [][]byte
+	for p, l := range s.locks {
+		if time.Now().After(l.Expires) {
+			delete(s.locks, p)
+			keys = append(keys, []byte(p))
+		}
+	}
+	dbDelete(bucketLocks, keys...)
+	return len(keys)
+}
+
+// apiLocksHandler 处理 /api/v1/locks：GET 列出用户能看到的锁，?path= 返回一个文件的锁；
+// POST（JSON：path、ttl，默认 1h、note）锁定或续期，被他人锁定时返回 409 和该锁；
+// DELETE ?path= 解锁，管理员加 ?force=true 可以解除他人的锁
+func apiLocksHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	switch r.Method {
+	case http.MethodGet:
+		if p := r.URL.Query().Get("path"); p != "" {
+			virtual, ok := tagTarget(w, r, p)
+			if !ok {
+				return
+			}
+			l := locks.get(virtual)
+			if l == nil {
+				http.Error(w, "Not locked", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, l)
+			return
+		}
+		writeJSON(w, http.StatusOK, locks.list(func(p string) bool { return canSeePath(r, p) }))
+	case http.MethodPost:
+		var req struct {
+			Path string `json:"path"`
+			TTL  string `json:"ttl"`
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ttl := lockDefaultTTL
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 || d > lockMaxTTL {
+				http.Error(w, fmt.Sprintf("invalid ttl %q (at most %v)", req.TTL, lockMaxTTL), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		virtual, ok := tagTarget(w, r, req.Path)
+		if !ok {
+			return
+		}
+		l, ok := locks.acquire(virtual, user, strings.TrimSpace(req.Note), ttl)
+		if !ok {
+			writeJSON(w, http.StatusConflict, l)
+			return
+		}
+		logger.Printf("Locked %s for %q until %s", virtual, user, l.Expires.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, l)
+	case http.MethodDelete:
+		force := r.URL.Query().Get("force") == "true"
+		if force && !isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		virtual, ok := tagTarget(w, r, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		if !unlockPath(w, virtual, user, force) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// unlockPath 解除锁并记录日志，路径被他人锁定时返回 409
+func unlockPath(w http.ResponseWriter, virtual, user string, force bool) bool {
+	l, ok := locks.release(virtual, user, force)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Locked by %s", lockOwnerName(l)), http.StatusConflict)
+		return false
+	}
+	if l != nil && l.Owner != user {
+		logger.Printf("Lock on %s held by %q broken by %q", virtual, l.Owner, user)
+	} else if l != nil {
+		logger.Printf("Unlocked %s", virtual)
+	}
+	return true
+}
+
+// lockFormHandler 处理列表页的锁定按钮：POST 表单（path、action=lock、unlock 或 break、dir）后返回目录列表
+func lockFormHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := currentUser(r)
+	switch r.FormValue("action") {
+	case "lock":
+		virtual, ok := tagTarget(w, r, cleanName(r.FormValue("path")))
+		if !ok {
+			return
+		}
+		if l, ok := locks.acquire(virtual, user, "", lockDefaultTTL); !ok {
+			http.Error(w, fmt.Sprintf("Already locked by %s", lockOwnerName(l)), http.StatusConflict)
+			return
+		}
+		logger.Printf("Locked %s for %q", virtual, user)
+	case "unlock", "break":
+		force := r.FormValue("action") == "break"
+		if force && !isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		virtual, ok := tagTarget(w, r, cleanName(r.FormValue("path")))
+		if !ok {
+			return
+		}
+		if !unlockPath(w, virtual, user, force) {
+			return
+		}
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, listURL(r.FormValue("dir")), http.StatusSeeOther)
+}
+
+// lockOwnerName 返回显示用的锁持有者名称
+func lockOwnerName(l *fileLock) string {
+	if l.Owner == "" {
+		return "someone"
+	}
+	return l.Owner
+}
+
+// lockButton 返回列表页中文件的锁状态和锁定、解锁或（管理员）强制解锁按钮；target 是表单中提交的、用户看到的路径
+func lockButton(r *http.Request, virtual, target, dir string) string {
+	l := locks.get(virtual)
+	action, label, status := "lock", "lock", ""
+	if l != nil {
+		status = fmt.Sprintf(` <small title="%s">&#128274; locked by %s until %s</small>`,
+			html.EscapeString(l.Note), html.EscapeString(lockOwnerName(l)), l.Expires.Local().Format("Jan 2 15:04"))
+		switch {
+		case l.Owner == currentUser(r):
+			action, label = "unlock", "unlock"
+		case isAdmin(r):
+			action, label = "break", "break lock"
+		default:
+			return status
+		}
+	}
+	return fmt.Sprintf(`%s <form method="post" action="/lock" style="display:inline"><input type="hidden" name="path" value="%s"><input type="hidden" name="dir" value="%s"><input type="hidden" name="action" value="%s"><button type="submit">%s</button></form>`,
+		status, html.EscapeString(target), html.EscapeString(dir), action, label)
+}
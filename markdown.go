@@ -0,0 +1,395 @@
+package fileserver
+
+import (
+	"html"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// 这里实现的是 Markdown 的常用子集，足够显示目录中的 README：标题、段落、列表、引用、代码块、表格、
+// 分隔线以及行内的代码、强调、链接和图片。原始 HTML 不会透传，一律转义
+
+var (
+	mdHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRule      = regexp.MustCompile(`^ {0,3}(?:(?:- *){3,}|(?:\* *){3,}|(?:_ *){3,})$`)
+	mdListItem  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	mdTableSep  = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	mdSetextH1  = regexp.MustCompile(`^ {0,3}=+\s*$`)
+	mdSetextH2  = regexp.MustCompile(`^ {0,3}-+\s*$`)
+	mdURLScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+)
+
+// mdLinker 将 Markdown 中的链接地址转换为页面中使用的地址，image 表示图片地址；返回空字符串时不生成链接
+type mdLinker func(href string, image bool) string
+
+// renderMarkdown 将 Markdown 文本转换为 HTML
+func renderMarkdown(src string, link mdLinker) string {
+	src = strings.ReplaceAll(strings.ReplaceAll(src, "\r\n", "\n"), "\t", "    ")
+	var sb strings.Builder
+	mdBlocks(&sb, strings.Split(src, "\n"), link)
+	return sb.String()
+}
+
+// mdBlocks 逐行解析块级元素
+func mdBlocks(sb *strings.Builder, lines []string, link mdLinker) {
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			sb.WriteString("<p>" + mdInline(strings.Join(para, "\n"), link) + "</p>\n")
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			lang := strings.TrimSpace(strings.Trim(trimmed, fence[:1]))
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			src := strings.Join(code, "\n")
+			if lang != "" {
+				src = highlightCode(src, "code."+strings.Fields(lang)[0])
+			} else {
+				src = html.EscapeString(src)
+			}
+			sb.WriteString(`<pre class="code-block">` + src + "</pre>\n")
+		case len(para) > 0 && mdSetextH1.MatchString(line):
+			sb.WriteString("<h1>" + mdInline(strings.Join(para, " "), link) + "</h1>\n")
+			para = nil
+		case len(para) > 0 && mdSetextH2.MatchString(line):
+			sb.WriteString("<h2>" + mdInline(strings.Join(para, " "), link) + "</h2>\n")
+			para = nil
+		case mdRule.MatchString(line):
+			flush()
+			sb.WriteString("<hr>\n")
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			sb.WriteString("<" + tag + ">" + mdInline(m[2], link) + "</" + tag + ">\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			i--
+			sb.WriteString("<blockquote>\n")
+			mdBlocks(sb, quote, link)
+			sb.WriteString("</blockquote>\n")
+		case mdListItem.MatchString(line):
+			flush()
+			i = mdList(sb, lines, i, link) - 1
+		case len(para) == 0 && strings.HasPrefix(line, "    "):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			i--
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			sb.WriteString(`<pre class="code-block">` + html.EscapeString(strings.Join(code, "\n")) + "</pre>\n")
+		case len(para) == 0 && strings.Contains(line, "|") && i+1 < len(lines) && mdTableSep.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			i = mdTable(sb, lines, i, link) - 1
+		default:
+			para = append(para, strings.TrimLeft(line, " "))
+		}
+	}
+	flush()
+}
+
+// mdList 解析从 lines[start] 开始的列表，返回列表之后的行号。缩进的行属于上一项，可以包含嵌套的列表和段落
+func mdList(sb *strings.Builder, lines []string, start int, link mdLinker) int {
+	first := mdListItem.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	sb.WriteString("<" + tag + ">\n")
+	i := start
+	for i < len(lines) {
+		m := mdListItem.FindStringSubmatch(lines[i])
+		if m == nil || (m[2][0] >= '0' && m[2][0] <= '9') != ordered {
+			break
+		}
+		indent := len(m[0])
+		item := []string{lines[i][indent:]}
+		loose := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// 空行之后只有缩进的行仍属于这一项
+				if i+1 < len(lines) && strings.HasPrefix(lines[i+1], strings.Repeat(" ", indent)) && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					loose = true
+					continue
+				}
+				break
+			}
+			if strings.HasPrefix(line, strings.Repeat(" ", indent)) {
+				item = append(item, line[indent:])
+				continue
+			}
+			if mdListItem.MatchString(line) || mdRule.MatchString(line) {
+				break
+			}
+			item = append(item, strings.TrimLeft(line, " "))
+		}
+		var inner strings.Builder
+		mdBlocks(&inner, item, link)
+		body := strings.TrimSuffix(inner.String(), "\n")
+		if !loose && strings.HasPrefix(body, "<p>") {
+			// 紧凑列表项不包裹段落
+			if end := strings.Index(body, "</p>"); end >= 0 {
+				body = body[3:end] + body[end+4:]
+			}
+		}
+		sb.WriteString("<li>" + body + "</li>\n")
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			if i+1 < len(lines) && mdListItem.MatchString(lines[i+1]) {
+				i++
+				continue
+			}
+			break
+		}
+	}
+	sb.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// mdTable 解析从 lines[start] 开始的表格（表头、分隔行和数据行），返回表格之后的行号
+func mdTable(sb *strings.Builder, lines []string, start int, link mdLinker) int {
+	cells := func(line string) []string {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(strings.TrimSuffix(line, "|"), "|")
+		parts := strings.Split(line, "|")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts
+	}
+	var aligns []string
+	for _, c := range cells(lines[start+1]) {
+		switch {
+		case strings.HasPrefix(c, ":") && strings.HasSuffix(c, ":"):
+			aligns = append(aligns, ` style="text-align:center"`)
+		case strings.HasSuffix(c, ":"):
+			aligns = append(aligns, ` style="text-align:right"`)
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	row := func(line, cell string) {
+		sb.WriteString("<tr>")
+		for j, c := range cells(line) {
+			align := ""
+			if j < len(aligns) {
+				align = aligns[j]
+			}
+			sb.WriteString("<" + cell + align + ">" + mdInline(c, link) + "</" + cell + ">")
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("<table>\n")
+	row(lines[start], "th")
+	i := start + 2
+	for ; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+		row(lines[i], "td")
+	}
+	sb.WriteString("</table>\n")
+	return i
+}
+
+// mdInline 转换行内元素：代码、粗体、斜体、删除线、链接、图片和 <URL> 形式的自动链接
+func mdInline(s string, link mdLinker) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		rest := s[i:]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|<>~", s[i+1]) >= 0:
+			sb.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[n:], rest[:n]); end >= 0 {
+				sb.WriteString("<code>" + html.EscapeString(strings.TrimSpace(rest[n:n+end])) + "</code>")
+				i += 2*n + end
+				continue
+			}
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if text, href, n, ok := mdLinkAt(rest[1:]); ok {
+				if src := link(href, true); src != "" {
+					sb.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(text) + `" style="max-width:100%">`)
+				} else {
+					sb.WriteString(html.EscapeString(text))
+				}
+				i += 1 + n
+				continue
+			}
+		case c == '[':
+			if text, href, n, ok := mdLinkAt(rest); ok {
+				if dst := link(href, false); dst != "" {
+					sb.WriteString(`<a href="` + html.EscapeString(dst) + `">` + mdInline(text, link) + "</a>")
+				} else {
+					sb.WriteString(mdInline(text, link))
+				}
+				i += n
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexByte(rest, '>'); end > 0 && mdURLScheme.MatchString(rest[1:end]) && !strings.ContainsAny(rest[1:end], " <") {
+				if dst := link(rest[1:end], false); dst != "" {
+					sb.WriteString(`<a href="` + html.EscapeString(dst) + `">` + html.EscapeString(rest[1:end]) + "</a>")
+					i += end + 1
+					continue
+				}
+			}
+		case c == '*' || c == '_' || c == '~':
+			n := len(rest) - len(strings.TrimLeft(rest, string(c)))
+			if n > 2 {
+				break
+			}
+			// 单词内部的下划线（如 snake_case）不表示强调
+			if c == '_' && i > 0 && mdWordByte(s[i-1]) {
+				break
+			}
+			if c == '~' && n != 2 {
+				break
+			}
+			delim := rest[:n]
+			inner := rest[n:]
+			end := strings.Index(inner, delim)
+			for end > 0 && n == 1 && end+1 < len(inner) && inner[end+1] == c {
+				// 单个分隔符不与双分隔符配对
+				next := strings.Index(inner[end+2:], delim)
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += 2 + next
+			}
+			if end <= 0 || inner[0] == ' ' || inner[end-1] == ' ' {
+				break
+			}
+			if c == '_' && n+end+n < len(rest) && mdWordByte(rest[n+end+n]) {
+				break
+			}
+			tag := "em"
+			switch {
+			case c == '~':
+				tag = "del"
+			case n == 2:
+				tag = "strong"
+			}
+			sb.WriteString("<" + tag + ">" + mdInline(inner[:end], link) + "</" + tag + ">")
+			i += n + end + n
+			continue
+		case c == '\n':
+			// 行尾两个空格表示换行
+			if strings.HasSuffix(sb.String(), "  ") {
+				sb.WriteString("<br>")
+			}
+		}
+		sb.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return sb.String()
+}
+
+// mdLinkAt 解析 s 开头的 [text](href "title")，返回文字、地址和消耗的字节数
+func mdLinkAt(s string) (text, href string, n int, ok bool) {
+	depth := 0
+	close := -1
+	for i := 0; i < len(s) && close < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				close = i
+			}
+		}
+	}
+	if close < 0 || close+1 >= len(s) || s[close+1] != '(' {
+		return "", "", 0, false
+	}
+	end := -1
+	depth = 0
+	for i := close + 2; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = i - close - 2
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return "", "", 0, false
+	}
+	dest := strings.TrimSpace(s[close+2 : close+2+end])
+	if sp := strings.IndexAny(dest, " \t"); sp >= 0 {
+		dest = dest[:sp]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return s[1:close], dest, close + 3 + end, true
+}
+
+func mdWordByte(b byte) bool {
+	return b == '_' || b >= 0x80 || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// mdSafeURL 只允许 http、https、mailto 和相对地址，阻止 javascript: 等协议
+func mdSafeURL(href string) bool {
+	if !mdURLScheme.MatchString(href) {
+		return true
+	}
+	scheme := strings.ToLower(href[:strings.IndexByte(href, ':')])
+	return scheme == "http" || scheme == "https" || scheme == "mailto"
+}
+
+// readmeLinker 返回目录 README 使用的链接转换：相对地址指向同一目录中的文件（图片直接显示，以 / 结尾的地址指向目录列表），
+// 绝对地址、页内锚点和外部链接保持不变
+func readmeLinker(dir string) mdLinker {
+	return func(href string, image bool) string {
+		if !mdSafeURL(href) {
+			return ""
+		}
+		if href == "" || mdURLScheme.MatchString(href) || strings.HasPrefix(href, "/") || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "?") {
+			return href
+		}
+		rel := href
+		if j := strings.IndexAny(rel, "?#"); j >= 0 {
+			rel = rel[:j]
+		}
+		p := path.Join(dir, rel)
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return ""
+		}
+		if strings.HasSuffix(rel, "/") && !image {
+			if p == "." {
+				p = ""
+			}
+			return listURL(p)
+		}
+		return "/download?path=" + url.QueryEscape(p)
+	}
+}
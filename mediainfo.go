@@ -0,0 +1,680 @@
+package fileserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// mediaField 是详情面板中的一项元数据，按提取顺序展示
+type mediaField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// mediaInfo 是媒体文件的元数据：图片的 EXIF、音频的 ID3 标签和 MP4/MOV 视频的容器信息
+type mediaInfo struct {
+	Path        string       `json:"path"`
+	ContentType string       `json:"content_type"`
+	Size        int64        `json:"size"`
+	Width       int          `json:"width,omitempty"`
+	Height      int          `json:"height,omitempty"`
+	Duration    float64      `json:"duration,omitempty"`
+	Latitude    *float64     `json:"latitude,omitempty"`
+	Longitude   *float64     `json:"longitude,omitempty"`
+	Fields      []mediaField `json:"fields"`
+
+	dir string // 文件所在卷的列表目录，用于返回链接
+}
+
+func (m *mediaInfo) add(name, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		m.Fields = append(m.Fields, mediaField{Name: name, Value: value})
+	}
+}
+
+// isMediaType 判断内容类型是否有可提取的元数据
+func isMediaType(ctype string) bool {
+	return strings.HasPrefix(ctype, "image/") || strings.HasPrefix(ctype, "audio/") || strings.HasPrefix(ctype, "video/")
+}
+
+// viewLinks 返回列表中文件的查看链接：文本文件高亮查看，媒体文件播放和查看详情；link 为已转义的路径
+func viewLinks(name, ctype, link string) string {
+	if strings.HasPrefix(ctype, "text/") || highlightLangs[strings.ToLower(path.Ext(name))] != nil {
+		return fmt.Sprintf(` <a href="/view?path=%s">(view)</a>`, link)
+	}
+	if !isMediaType(ctype) {
+		return ""
+	}
+	play := ""
+	if strings.HasPrefix(ctype, "video/") {
+		play = fmt.Sprintf(` <a href="/play?path=%s">(play)</a>`, link)
+	}
+	return fmt.Sprintf(`%s <a href="/info?path=%s">(info)</a>`, play, link)
+}
+
+// readMediaInfo 从文件中提取元数据；无法识别的格式只返回基本信息
+func readMediaInfo(f storageFile, ctype string) *mediaInfo {
+	m := &mediaInfo{ContentType: ctype, Fields: []mediaField{}}
+	if info, err := f.Stat(); err == nil {
+		m.Size = info.Size()
+	}
+	var head [8]byte
+	n, _ := io.ReadFull(f, head[:])
+	f.Seek(0, io.SeekStart)
+	switch {
+	case n == 8 && string(head[4:8]) == "ftyp":
+		readMP4Info(f, m)
+	case strings.HasPrefix(ctype, "image/"):
+		readImageInfo(f, m)
+	case strings.HasPrefix(ctype, "audio/"):
+		readID3Info(f, m)
+	}
+	return m
+}
+
+// readImageInfo 读取图片尺寸和 JPEG 中的 EXIF
+func readImageInfo(f storageFile, m *mediaInfo) {
+	if cfg, format, err := image.DecodeConfig(bufio.NewReader(f)); err == nil {
+		m.Width, m.Height = cfg.Width, cfg.Height
+		m.add("Format", strings.ToUpper(format))
+		m.add("Resolution", fmt.Sprintf("%d × %d", cfg.Width, cfg.Height))
+	}
+	f.Seek(0, io.SeekStart)
+	if payload := jpegEXIF(bufio.NewReader(f)); payload != nil {
+		readEXIFFields(payload, m)
+	}
+}
+
+// jpegEXIF 返回 JPEG 中 APP1 EXIF 段的内容，没有时返回 nil
+func jpegEXIF(br *bufio.Reader) []byte {
+	if head, err := br.Peek(2); err != nil || head[0] != 0xFF || head[1] != jpegSOI {
+		return nil
+	}
+	br.Discard(2)
+	for {
+		marker, err := readJPEGMarker(br)
+		if err != nil || marker == jpegSOS || marker == jpegEOI {
+			return nil
+		}
+		if marker >= 0xD0 && marker <= 0xD7 || marker == 0x01 {
+			continue
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return nil
+		}
+		payload := make([]byte, n-2)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return nil
+		}
+		if marker == jpegAPP1 && strings.HasPrefix(string(payload), "Exif\x00\x00") {
+			return payload
+		}
+	}
+}
+
+// tiffEntry 是 EXIF（TIFF 结构）IFD 中的一个条目，data 为值的原始字节
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count int
+	data  []byte
+}
+
+// tiffReader 读取 APP1 EXIF 段中的 TIFF 结构
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// tiffTypeSize 是 TIFF 各数据类型的字节数
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+func newTIFFReader(payload []byte) *tiffReader {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return nil
+	}
+	t := &tiffReader{b: payload[6:]}
+	switch string(t.b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil
+	}
+	return t
+}
+
+// ifd0 返回第一个 IFD 的偏移
+func (t *tiffReader) ifd0() int {
+	return int(t.order.Uint32(t.b[4:8]))
+}
+
+// entries 返回偏移 off 处 IFD 的条目，越界的条目被忽略
+func (t *tiffReader) entries(off int) []tiffEntry {
+	if off <= 0 || off+2 > len(t.b) {
+		return nil
+	}
+	var out []tiffEntry
+	count := int(t.order.Uint16(t.b[off:]))
+	for i := 0; i < count; i++ {
+		p := off + 2 + 12*i
+		if p+12 > len(t.b) {
+			break
+		}
+		e := tiffEntry{tag: t.order.Uint16(t.b[p:]), typ: t.order.Uint16(t.b[p+2:]), count: int(t.order.Uint32(t.b[p+4:]))}
+		size := tiffTypeSize[e.typ] * e.count
+		if size == 0 || e.count > len(t.b) {
+			continue
+		}
+		if size <= 4 {
+			e.data = t.b[p+8 : p+8+size]
+		} else if v := int(t.order.Uint32(t.b[p+8:])); v >= 0 && v+size <= len(t.b) {
+			e.data = t.b[v : v+size]
+		} else {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// uint 返回 SHORT 或 LONG 条目的第一个值
+func (t *tiffReader) uint(e tiffEntry) int {
+	switch e.typ {
+	case 3:
+		return int(t.order.Uint16(e.data))
+	case 4:
+		return int(t.order.Uint32(e.data))
+	}
+	return 0
+}
+
+// rationals 返回 RATIONAL 或 SRATIONAL 条目的值
+func (t *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+	out := make([]float64, e.count)
+	for i := range out {
+		num, den := t.order.Uint32(e.data[8*i:]), t.order.Uint32(e.data[8*i+4:])
+		if den == 0 {
+			continue
+		}
+		if e.typ == 10 {
+			out[i] = float64(int32(num)) / float64(int32(den))
+		} else {
+			out[i] = float64(num) / float64(den)
+		}
+	}
+	return out
+}
+
+// ascii 返回 ASCII 条目去掉结尾 NUL 的值
+func (e tiffEntry) ascii() string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(e.data), "\x00 ")
+}
+
+// EXIF 标签
+const (
+	exifMake             = 0x010F
+	exifModel            = 0x0110
+	exifOrientationTag   = 0x0112
+	exifSoftware         = 0x0131
+	exifDateTime         = 0x0132
+	exifIFDPointer       = 0x8769
+	exifGPSPointer       = 0x8825
+	exifExposureTime     = 0x829A
+	exifFNumber          = 0x829D
+	exifISO              = 0x8827
+	exifDateTimeOriginal = 0x9003
+	exifFocalLength      = 0x920A
+	exifLensModel        = 0xA434
+)
+
+// readEXIFFields 提取相机、拍摄参数、拍摄时间和 GPS 位置
+func readEXIFFields(payload []byte, m *mediaInfo) {
+	t := newTIFFReader(payload)
+	if t == nil {
+		return
+	}
+	tags := make(map[uint16]tiffEntry)
+	for _, e := range t.entries(t.ifd0()) {
+		tags[e.tag] = e
+	}
+	if e, ok := tags[exifIFDPointer]; ok {
+		for _, sub := range t.entries(t.uint(e)) {
+			tags[sub.tag] = sub
+		}
+	}
+	camera := tags[exifMake].ascii()
+	if model := tags[exifModel].ascii(); strings.HasPrefix(model, camera) {
+		camera = model
+	} else if model != "" {
+		camera += " " + model
+	}
+	m.add("Camera", camera)
+	m.add("Lens", tags[exifLensModel].ascii())
+	date := tags[exifDateTimeOriginal].ascii()
+	if date == "" {
+		date = tags[exifDateTime].ascii()
+	}
+	if len(date) >= 10 {
+		date = strings.ReplaceAll(date[:10], ":", "-") + date[10:]
+	}
+	m.add("Date taken", date)
+	if v := t.rationals(tags[exifExposureTime]); len(v) > 0 && v[0] > 0 {
+		if v[0] < 1 {
+			m.add("Exposure", fmt.Sprintf("1/%.0f s", 1/v[0]))
+		} else {
+			m.add("Exposure", fmt.Sprintf("%g s", v[0]))
+		}
+	}
+	if v := t.rationals(tags[exifFNumber]); len(v) > 0 && v[0] > 0 {
+		m.add("Aperture", fmt.Sprintf("f/%.1f", v[0]))
+	}
+	if iso := t.uint(tags[exifISO]); iso > 0 {
+		m.add("ISO", strconv.Itoa(iso))
+	}
+	if v := t.rationals(tags[exifFocalLength]); len(v) > 0 && v[0] > 0 {
+		m.add("Focal length", fmt.Sprintf("%g mm", v[0]))
+	}
+	if o := t.uint(tags[exifOrientationTag]); o > 1 {
+		m.add("Orientation", strconv.Itoa(o))
+	}
+	m.add("Software", tags[exifSoftware].ascii())
+	if e, ok := tags[exifGPSPointer]; ok {
+		readGPS(t, t.entries(t.uint(e)), m)
+	}
+}
+
+// readGPS 从 GPS IFD 中读取经纬度
+func readGPS(t *tiffReader, entries []tiffEntry, m *mediaInfo) {
+	gps := make(map[uint16]tiffEntry)
+	for _, e := range entries {
+		gps[e.tag] = e
+	}
+	coord := func(refTag, valueTag uint16, negative string) (float64, bool) {
+		v := t.rationals(gps[valueTag])
+		if len(v) != 3 {
+			return 0, false
+		}
+		deg := v[0] + v[1]/60 + v[2]/3600
+		if gps[refTag].ascii() == negative {
+			deg = -deg
+		}
+		return deg, !math.IsNaN(deg)
+	}
+	lat, ok1 := coord(1, 2, "S")
+	lon, ok2 := coord(3, 4, "W")
+	if !ok1 || !ok2 {
+		return
+	}
+	m.Latitude, m.Longitude = &lat, &lon
+	m.add("GPS", fmt.Sprintf("%.6f, %.6f", lat, lon))
+}
+
+// readID3Info 读取 MP3 等音频文件的 ID3v2 标签，没有时读取文件末尾的 ID3v1 标签
+func readID3Info(f storageFile, m *mediaInfo) {
+	if readID3v2(f, m) {
+		return
+	}
+	if m.Size < 128 {
+		return
+	}
+	var tag [128]byte
+	if _, err := f.Seek(-128, io.SeekEnd); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(f, tag[:]); err != nil || string(tag[:3]) != "TAG" {
+		return
+	}
+	field := func(b []byte) string { return latin1(b[:strings.IndexByte(string(b)+"\x00", 0)]) }
+	m.add("Title", field(tag[3:33]))
+	m.add("Artist", field(tag[33:63]))
+	m.add("Album", field(tag[63:93]))
+	m.add("Year", field(tag[93:97]))
+}
+
+// id3Frames 是展示的 ID3v2 文本帧，ID3v2.2 使用 3 字符的帧名
+var id3Frames = map[string]string{
+	"TIT2": "Title", "TT2": "Title",
+	"TPE1": "Artist", "TP1": "Artist",
+	"TALB": "Album", "TAL": "Album",
+	"TYER": "Year", "TYE": "Year", "TDRC": "Year",
+	"TRCK": "Track", "TRK": "Track",
+	"TCON": "Genre", "TCO": "Genre",
+}
+
+// id3MaxFrame 是读取的单个文本帧的最大大小，更大的帧（如封面图片）被跳过
+const id3MaxFrame = 64 << 10
+
+// readID3v2 读取文件开头的 ID3v2 标签中的文本帧，没有标签时返回 false
+func readID3v2(f storageFile, m *mediaInfo) bool {
+	var h [10]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil || string(h[:3]) != "ID3" {
+		return false
+	}
+	major := h[3]
+	end := int64(10 + syncsafe(h[6:10]))
+	m.add("Tag", fmt.Sprintf("ID3v2.%d", major))
+	seen := make(map[string]bool)
+	pos := int64(10)
+	for pos < end {
+		var id string
+		var size int64
+		if major == 2 {
+			var fh [6]byte
+			if _, err := io.ReadFull(f, fh[:]); err != nil {
+				break
+			}
+			id, size = string(fh[:3]), int64(fh[3])<<16|int64(fh[4])<<8|int64(fh[5])
+			pos += 6
+		} else {
+			var fh [10]byte
+			if _, err := io.ReadFull(f, fh[:]); err != nil {
+				break
+			}
+			id = string(fh[:4])
+			if major >= 4 {
+				size = int64(syncsafe(fh[4:8]))
+			} else {
+				size = int64(binary.BigEndian.Uint32(fh[4:8]))
+			}
+			pos += 10
+		}
+		if id[0] == 0 || size <= 0 || pos+size > end {
+			break
+		}
+		name, ok := id3Frames[id]
+		if ok && !seen[name] && size <= id3MaxFrame {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(f, data); err != nil {
+				break
+			}
+			seen[name] = true
+			m.add(name, id3Text(data))
+		} else if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			break
+		}
+		pos += size
+	}
+	return true
+}
+
+// syncsafe 解码 ID3v2 中每字节只用低 7 位的整数
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// id3Text 按文本帧第一个字节指定的编码解码，多个值以 NUL 分隔时只取第一个
+func id3Text(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	enc, b := data[0], data[1:]
+	switch enc {
+	case 1, 2:
+		bigEndian := enc == 2
+		if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+			bigEndian = b[0] == 0xFE
+			b = b[2:]
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			c := uint16(b[i])<<8 | uint16(b[i+1])
+			if !bigEndian {
+				c = uint16(b[i+1])<<8 | uint16(b[i])
+			}
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		return string(utf16.Decode(u))
+	case 3:
+		s, _, _ := strings.Cut(string(b), "\x00")
+		return s
+	}
+	s, _, _ := strings.Cut(latin1(b), "\x00")
+	return s
+}
+
+// latin1 将 ISO-8859-1 字节转换为字符串
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// mp4Track 是 MP4/MOV 中一条轨道的信息
+type mp4Track struct {
+	handler       string
+	codec         string
+	width, height int
+}
+
+// mp4Containers 是包含子 box、需要递归读取的 box
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// mp4Epoch 是 MP4 时间戳的起点
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// readMP4Info 读取 MP4/MOV/M4A 的品牌、时长、创建时间、分辨率和编码
+func readMP4Info(f storageFile, m *mediaInfo) {
+	var tracks []*mp4Track
+	var cur *mp4Track
+	var walk func(start, end int64, depth int) error
+	walk = func(start, end int64, depth int) error {
+		for pos := start; pos+8 <= end; {
+			var h [16]byte
+			if _, err := f.Seek(pos, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(f, h[:8]); err != nil {
+				return err
+			}
+			size, typ, hdr := int64(binary.BigEndian.Uint32(h[:4])), string(h[4:8]), int64(8)
+			switch size {
+			case 0:
+				size = end - pos
+			case 1:
+				if _, err := io.ReadFull(f, h[8:16]); err != nil {
+					return err
+				}
+				size, hdr = int64(binary.BigEndian.Uint64(h[8:16])), 16
+			}
+			if size < hdr || pos+size > end {
+				return errors.New("invalid box size")
+			}
+			body := size - hdr
+			switch {
+			case mp4Containers[typ] && depth < 8:
+				if typ == "trak" {
+					cur = &mp4Track{}
+					tracks = append(tracks, cur)
+				}
+				if err := walk(pos+hdr, pos+size, depth+1); err != nil {
+					return err
+				}
+			case typ == "ftyp" && body >= 4:
+				var brand [4]byte
+				io.ReadFull(f, brand[:])
+				m.add("Container", strings.TrimSpace(string(brand[:])))
+			case typ == "mvhd" && body >= 32:
+				b := make([]byte, min(body, 32))
+				io.ReadFull(f, b)
+				var created, scale, duration uint64
+				if b[0] == 1 {
+					created, scale, duration = binary.BigEndian.Uint64(b[4:]), uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:])
+				} else {
+					created, scale, duration = uint64(binary.BigEndian.Uint32(b[4:])), uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:]))
+				}
+				if scale > 0 {
+					m.Duration = float64(duration) / float64(scale)
+				}
+				if created > 0 {
+					m.add("Created", mp4Epoch.Add(time.Duration(created)*time.Second).Format("2006-01-02 15:04:05"))
+				}
+			case typ == "tkhd" && cur != nil && body >= 84:
+				b := make([]byte, body)
+				io.ReadFull(f, b)
+				cur.width, cur.height = int(binary.BigEndian.Uint32(b[body-8:])>>16), int(binary.BigEndian.Uint32(b[body-4:])>>16)
+			case typ == "hdlr" && cur != nil && body >= 12:
+				var b [12]byte
+				io.ReadFull(f, b[:])
+				cur.handler = string(b[8:12])
+			case typ == "stsd" && cur != nil && body >= 16:
+				var b [16]byte
+				io.ReadFull(f, b[:])
+				cur.codec = strings.TrimSpace(string(b[12:16]))
+			}
+			pos += size
+		}
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	walk(0, info.Size(), 0)
+	if m.Duration > 0 {
+		m.add("Duration", formatDuration(m.Duration))
+	}
+	for _, t := range tracks {
+		switch t.handler {
+		case "vide":
+			if m.Width == 0 && t.width > 0 {
+				m.Width, m.Height = t.width, t.height
+				m.add("Resolution", fmt.Sprintf("%d × %d", t.width, t.height))
+			}
+			m.add("Video codec", t.codec)
+		case "soun":
+			m.add("Audio codec", t.codec)
+		}
+	}
+}
+
+// formatDuration 将秒数格式化为 h:mm:ss 或 m:ss
+func formatDuration(sec float64) string {
+	s := int(sec + 0.5)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// openMediaInfo 解析 ?path= 并提取文件的元数据，出错时已写入响应
+func openMediaInfo(w http.ResponseWriter, r *http.Request) (*mediaInfo, bool) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return nil, false
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return nil, false
+	}
+	f, err := vol.store.Open(name)
+	if os.IsNotExist(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return nil, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
+		return nil, false
+	}
+	m := readMediaInfo(f, detectContentType(vol.store, name))
+	m.Path = vol.virtual(name)
+	m.dir = strings.TrimSuffix(vol.prefix, "/")
+	return m, true
+}
+
+// apiMetadataHandler 处理 GET /api/v1/metadata?path=，返回媒体文件的元数据
+func apiMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := openMediaInfo(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// posterHTML 返回视频封面图片，ffmpeg 不可用时为空
+func posterHTML(m *mediaInfo) string {
+	if !strings.HasPrefix(m.ContentType, "video/") || ffmpegBin() == "" {
+		return ""
+	}
+	return fmt.Sprintf("    <p><img src=\"/thumb?path=%s\" width=\"%d\" alt=\"\"></p>\n", html.EscapeString(url.QueryEscape(m.Path)), posterWidth)
+}
+
+// infoPageHandler 处理 /info?path=，以网页展示媒体文件的元数据，GPS 位置链接到 OpenStreetMap
+func infoPageHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := openMediaInfo(w, r)
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>%s</h1>
+    <p><a href="/download?path=%s">Open</a> | <a href="%s">&larr; Back</a></p>
+%s    <table>
+        <tr><th>Type</th><td>%s</td></tr>
+        <tr><th>Size</th><td>%s</td></tr>
+`, html.EscapeString(path.Base(m.Path)), html.EscapeString(path.Base(m.Path)), html.EscapeString(url.QueryEscape(m.Path)),
+		html.EscapeString(listURL(m.dir)), posterHTML(m), html.EscapeString(m.ContentType), formatSize(m.Size))
+	for _, f := range m.Fields {
+		value := html.EscapeString(f.Value)
+		if f.Name == "GPS" && m.Latitude != nil {
+			value = fmt.Sprintf(`<a href="https://www.openstreetmap.org/?mlat=%f&amp;mlon=%f&amp;zoom=15">%s</a>`, *m.Latitude, *m.Longitude, value)
+		}
+		fmt.Fprintf(&sb, "        <tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(f.Name), value)
+	}
+	sb.WriteString(`    </table>
+</body>
+</html>`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
@@ -0,0 +1,405 @@
+package fileserver
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// metaDB 是保存服务器元数据的嵌入式数据库 .fileserver/meta.db，每类数据一个 bucket。
+// 各功能在启动时从中加载数据到内存，修改时逐条写回
+var metaDB *bolt.DB
+
+const metaDBName = "meta.db"
+
+// 元数据库中的 bucket
+const (
+	bucketMeta           = "meta"
+	bucketDownloads      = "downloads"
+	bucketChecksums      = "checksums"
+	bucketShares         = "shares"
+	bucketTransfers      = "transfers"
+	bucketTags           = "tags"
+	bucketFavorites      = "favorites"
+	bucketClipboard      = "clipboard"
+	bucketStorageHistory = "storage-history"
+	bucketAudit          = "audit"
+	bucketUploadTokens   = "upload-tokens"
+	bucketLocks          = "locks"
+	bucketBackups        = "backups"
+	bucketScrub          = "scrub"
+)
+
+// metaMigration 是一步数据库结构升级；after 在升级提交后执行，用于清理数据库之外的文件
+type metaMigration struct {
+	name  string
+	apply func(tx *bolt.Tx) error
+	after func()
+}
+
+// metaMigrations 依次升级数据库，已执行的步数记录在 meta bucket 的 version 键中。只能在末尾追加
+var metaMigrations = []metaMigration{
+	{name: "create buckets", apply: createMetaBuckets},
+	{name: "import JSON files", apply: importLegacySidecars, after: retireLegacySidecars},
+	{name: "add upload tokens", apply: createBucket(bucketUploadTokens)},
+	{name: "add file locks", apply: createBucket(bucketLocks)},
+	{name: "add backup state", apply: createBucket(bucketBackups)},
+	{name: "add integrity scrub state", apply: createBucket(bucketScrub)},
+}
+
+// openMetaDB 打开（不存在时创建）元数据库并执行未完成的升级。数据库文件被锁定，同一服务目录只能由一个进程使用
+func openMetaDB() error {
+	closeMetaDB()
+	dir := filepath.Join(uploadDir, metaDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, metaDBName)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, berrors.ErrTimeout) {
+		return fmt.Errorf("metadata database %s is in use by another fileserver process", path)
+	}
+	if err != nil {
+		return fmt.Errorf("open metadata database: %v", err)
+	}
+	if err := migrateMetaDB(db); err != nil {
+		db.Close()
+		return fmt.Errorf("upgrade metadata database: %v", err)
+	}
+	metaDB = db
+	return nil
+}
+
+// errMetaDBClosed 表示服务器正在退出，元数据库已经关闭
+var errMetaDBClosed = errors.New("metadata database is closed")
+
+// closeMetaDB 关闭元数据库
+func closeMetaDB() {
+	if metaDB != nil {
+		metaDB.Close()
+		metaDB = nil
+	}
+}
+
+// flushMetaDB 写入延迟保存的校验和与流量统计，然后关闭元数据库；在服务器退出时调用
+func flushMetaDB() {
+	if checksums != nil {
+		checksums.save()
+	}
+	if transfers != nil {
+		transfers.save()
+	}
+	closeMetaDB()
+}
+
+// migrateMetaDB 执行尚未执行的升级，每一步在单独的事务中完成
+func migrateMetaDB(db *bolt.DB) error {
+	var version uint64
+	err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucketMeta)); b != nil {
+			if v := b.Get([]byte("version")); len(v) == 8 {
+				version = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version > uint64(len(metaMigrations)) {
+		return fmt.Errorf("database version %d is newer than this fileserver supports (%d)", version, len(metaMigrations))
+	}
+	for i := version; i < uint64(len(metaMigrations)); i++ {
+		m := metaMigrations[i]
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.apply(tx); err != nil {
+				return err
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("version"), seqKey(i+1))
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.name, err)
+		}
+		logger.Printf("Metadata database upgraded to version %d (%s)", i+1, m.name)
+		if m.after != nil {
+			m.after()
+		}
+	}
+	return nil
+}
+
+func createMetaBuckets(tx *bolt.Tx) error {
+	for _, name := range []string{bucketMeta, bucketDownloads, bucketChecksums, bucketShares, bucketTransfers,
+		bucketTags, bucketFavorites, bucketClipboard, bucketStorageHistory, bucketAudit} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createBucket 返回创建一个新 bucket 的升级步骤
+func createBucket(name string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	}
+}
+
+// legacySidecars 是引入元数据库之前各功能使用的 JSON 文件，split 将文件内容拆分为 bucket 中的记录
+var legacySidecars = []struct {
+	file   string
+	bucket string
+	split  func(data []byte) (map[string][]byte, error)
+}{
+	{"downloads.json", bucketDownloads, splitJSONObject},
+	{"checksums.json", bucketChecksums, splitJSONObject},
+	{"transfers.json", bucketTransfers, splitJSONObject},
+	{"tags.json", bucketTags, splitJSONObject},
+	{"shares.json", bucketShares, splitJSONList("token", stringKey)},
+	{"storage-history.json", bucketStorageHistory, splitJSONList("date", stringKey)},
+	{"clipboard.json", bucketClipboard, splitJSONList("id", func(v json.RawMessage) (string, error) {
+		var id uint64
+		err := json.Unmarshal(v, &id)
+		return string(seqKey(id)), err
+	})},
+	{"favorites.json", bucketFavorites, splitFavorites},
+}
+
+// importLegacySidecars 将已有的 JSON 文件导入对应的 bucket
+func importLegacySidecars(tx *bolt.Tx) error {
+	dir := filepath.Join(uploadDir, metaDirName)
+	for _, s := range legacySidecars {
+		data, err := os.ReadFile(filepath.Join(dir, s.file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		records, err := s.split(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.file, err)
+		}
+		b := tx.Bucket([]byte(s.bucket))
+		for k, v := range records {
+			if err := b.Put([]byte(k), v); err != nil {
+				return fmt.Errorf("%s: %v", s.file, err)
+			}
+		}
+		logger.Printf("Imported %d records from %s into the metadata database", len(records), s.file)
+	}
+	return nil
+}
+
+// retireLegacySidecars 将已导入的 JSON 文件改名为 .migrated，保留备份但不再使用
+func retireLegacySidecars() {
+	dir := filepath.Join(uploadDir, metaDirName)
+	for _, s := range legacySidecars {
+		p := filepath.Join(dir, s.file)
+		if err := os.Rename(p, p+".migrated"); err != nil && !os.IsNotExist(err) {
+			logger.Printf("Error renaming %s: %v", p, err)
+		}
+	}
+}
+
+// splitJSONObject 拆分以键为索引的 JSON 对象
+func splitJSONObject(data []byte) (map[string][]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// splitJSONList 拆分 JSON 数组，以每个元素的 field 字段作为键
+func splitJSONList(field string, key func(json.RawMessage) (string, error)) func([]byte) (map[string][]byte, error) {
+	return func(data []byte) (map[string][]byte, error) {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		out := make(map[string][]byte, len(list))
+		for _, item := range list {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(item, &fields); err != nil {
+				return nil, err
+			}
+			k, err := key(fields[field])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", field, err)
+			}
+			out[k] = item
+		}
+		return out, nil
+	}
+}
+
+func stringKey(v json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(v, &s)
+	return s, err
+}
+
+// splitFavorites 拆分按用户索引的收藏列表，每个收藏一条记录
+func splitFavorites(data []byte) (map[string][]byte, error) {
+	var m map[string][]favorite
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	for user, list := range m {
+		for _, fav := range list {
+			v, err := json.Marshal(fav)
+			if err != nil {
+				return nil, err
+			}
+			out[string(favoriteKey(user, fav.Path))] = v
+		}
+	}
+	return out, nil
+}
+
+// seqKey 将序号编码为按数值排序的 8 字节键
+func seqKey(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// dbEach 依次读取 bucket 中的记录，出错时只记录日志
+func dbEach(bucket string, fn func(key, value []byte) error) {
+	err := metaDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(fn)
+	})
+	if err != nil {
+		logger.Printf("Error reading %s from metadata database: %v", bucket, err)
+	}
+}
+
+// dbUpdate 在一个事务中修改 bucket，出错时只记录日志
+func dbUpdate(bucket string, fn func(b *bolt.Bucket) error) error {
+	if metaDB == nil {
+		return errMetaDBClosed
+	}
+	err := metaDB.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket([]byte(bucket)))
+	})
+	if err != nil {
+		logger.Printf("Error saving %s to metadata database: %v", bucket, err)
+	}
+	return err
+}
+
+// dbPut 以 JSON 保存一条记录
+func dbPut(bucket string, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return dbUpdate(bucket, func(b *bolt.Bucket) error {
+		return b.Put(key, data)
+	})
+}
+
+// dbDelete 删除记录
+func dbDelete(bucket string, keys ...[]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return dbUpdate(bucket, func(b *bolt.Bucket) error {
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dbReplace 在一个事务中先删除 keys 中的记录，再以 JSON 写入 values 中的记录，用于移动一批以路径为键的记录
+func dbReplace(bucket string, keys [][]byte, values map[string]interface{}) error {
+	if len(keys) == 0 && len(values) == 0 {
+		return nil
+	}
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data[k] = b
+	}
+	return dbUpdate(bucket, func(b *bolt.Bucket) error {
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, v := range data {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// underPath 判断虚拟路径 p 是否为 dir 或其下的条目
+func underPath(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// movedPath 返回 from 移动到 to 之后虚拟路径 p 的新路径；p 不在 from 之下时返回 false
+func movedPath(p, from, to string) (string, bool) {
+	if !underPath(p, from) {
+		return "", false
+	}
+	return to + strings.TrimPrefix(p, from), true
+}
+
+// moveMetadata 将虚拟路径 from 及其下所有条目的元数据（校验和、标签、收藏、锁和下载统计）移到 to，
+// 替换 to 下原有的记录。所有在服务器上移动或重命名条目的途径都在成功后调用它
+func moveMetadata(from, to string) {
+	if from == "" || to == "" || from == to {
+		return
+	}
+	if checksums != nil {
+		checksums.move(from, to)
+	}
+	if tags != nil {
+		tags.move(from, to)
+		favorites.move(from, to)
+		locks.move(from, to)
+	}
+	if stats != nil {
+		stats.move(from, to)
+	}
+}
+
+// renameEntry 在卷内移动文件或目录，条目的元数据随之移动，完整性检查继续校验移动后的文件
+func renameEntry(vol volume, from, to string) error {
+	if err := vol.store.Rename(from, to); err != nil {
+		return err
+	}
+	if from != "" && to != "" {
+		moveMetadata(vol.virtual(from), vol.virtual(to))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen 是内容嗅探读取的字节数，与 http.DetectContentType 一致
+const sniffLen = 512
+
+// detectContentType 根据扩展名判断文件 MIME 类型，无法判断时读取文件头进行嗅探
+func detectContentType(store storage, name string) string {
+	if ctype := mime.TypeByExtension(strings.ToLower(path.Ext(name))); ctype != "" {
+		return ctype
+	}
+	f, err := store.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	buf := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// contentDisposition 生成 Content-Disposition 头，非 ASCII 文件名按 RFC 2231 编码
+func contentDisposition(disposition, filename string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return disposition
+}
+
+// defaultDisposition 返回文件类型的默认处置方式：浏览器可直接展示的类型为 inline
+func defaultDisposition(ctype string) string {
+	mediaType, _, _ := mime.ParseMediaType(ctype)
+	switch {
+	case mediaType == "image/svg+xml":
+		return "attachment"
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		mediaType == "application/pdf",
+		mediaType == "text/plain":
+		return "inline"
+	}
+	return "attachment"
+}
+
+// isActiveContent 判断类型在浏览器中内联展示时是否可能执行脚本
+func isActiveContent(ctype string) bool {
+	mediaType, _, _ := mime.ParseMediaType(ctype)
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml":
+		return true
+	}
+	return false
+}
+
+// resolveDisposition 根据 ?disposition= 参数和文件类型决定处置方式
+func resolveDisposition(r *http.Request, ctype string) string {
+	switch strings.ToLower(r.URL.Query().Get("disposition")) {
+	case "inline":
+		return "inline"
+	case "attachment":
+		return "attachment"
+	}
+	return defaultDisposition(ctype)
+}
+
+// mimeTypesFlag 是可重复的 -mime-type .ext=type 参数，覆盖或补充按扩展名判断的类型
+type mimeTypesFlag map[string]string
+
+func (m mimeTypesFlag) String() string {
+	var parts []string
+	for ext, ctype := range m {
+		parts = append(parts, ext+"="+ctype)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m mimeTypesFlag) Set(v string) error {
+	ext, ctype, ok := strings.Cut(v, "=")
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	ctype = strings.TrimSpace(ctype)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if !ok || ext == "." || strings.ContainsAny(ext[1:], "./") {
+		return fmt.Errorf("invalid MIME type mapping %q, expected .ext=type such as .gcode=text/plain", v)
+	}
+	if _, _, err := mime.ParseMediaType(ctype); err != nil {
+		return fmt.Errorf("invalid MIME type %q: %v", ctype, err)
+	}
+	m[ext] = ctype
+	return nil
+}
+
+// mimeOverrides 由 -mime-type 设置，键为小写的扩展名
+var mimeOverrides = mimeTypesFlag{}
+
+// setupMIMETypes 注册 -mime-type 的类型，使下载的 Content-Type、预览方式和列表中的图标都按其判断
+func setupMIMETypes() error {
+	for ext, ctype := range mimeOverrides {
+		if err := mime.AddExtensionType(ext, ctype); err != nil {
+			return fmt.Errorf("-mime-type %s: %v", ext, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// mount 是以别名形式挂载为顶层虚拟文件夹的额外目录
+type mount struct {
+	alias    string
+	dir      string
+	readOnly bool
+	store    storage
+}
+
+// mountsFlag 解析 -mount alias=path[,ro] 参数
+type mountsFlag []mount
+
+var mounts mountsFlag
+
+func (m *mountsFlag) String() string {
+	var parts []string
+	for _, mt := range *m {
+		parts = append(parts, mt.alias+"="+mt.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *mountsFlag) Set(v string) error {
+	alias, dir, ok := strings.Cut(v, "=")
+	if !ok || dir == "" {
+		return fmt.Errorf("expected alias=path[,ro], got %q", v)
+	}
+	if !validUsername.MatchString(alias) {
+		return fmt.Errorf("invalid mount alias %q", alias)
+	}
+	mt := mount{alias: alias, dir: dir}
+	if d, opt, found := strings.Cut(dir, ","); found {
+		switch opt {
+		case "ro":
+			mt.readOnly = true
+		case "rw":
+		default:
+			return fmt.Errorf("unknown mount option %q", opt)
+		}
+		mt.dir = d
+	}
+	if _, exists := findMount(alias); exists {
+		return fmt.Errorf("duplicate mount alias %q", alias)
+	}
+	store, err := openStorage(mt.dir)
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", alias, err)
+	}
+	mt.store = store
+	*m = append(*m, mt)
+	return nil
+}
+
+// findMount 按别名查找挂载点
+func findMount(alias string) (mount, bool) {
+	for _, mt := range mounts {
+		if mt.alias == alias {
+			return mt, true
+		}
+	}
+	return mount{}, false
+}
+
+// checkMounts 在启动时确认所有挂载目录存在
+func checkMounts() error {
+	for _, mt := range mounts {
+		info, err := mt.store.Stat("")
+		if err != nil {
+			return fmt.Errorf("mount %s: %w", mt.alias, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("mount %s: %s is not a directory", mt.alias, mt.dir)
+		}
+	}
+	return nil
+}
+
+var errNotFound = errors.New("path not found")
+
+// resolveVolume 根据 dir 参数返回要操作的卷：空表示默认根目录，否则为挂载点别名
+func resolveVolume(r *http.Request, dir string) (volume, error) {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return rootVolume(r.Context())
+	}
+	mt, ok := findMount(dir)
+	if !ok {
+		return volume{}, errNotFound
+	}
+	return mt.volume(), nil
+}
+
+func (mt mount) volume() volume {
+	return volume{store: mt.store, prefix: mt.alias + "/", readOnly: mt.readOnly || replica != nil}
+}
+
+// resolveTarget 将下载路径解析为卷和卷内路径，卷内路径为空表示整个挂载点
+// 以挂载点别名开头的路径指向挂载目录，其余路径位于请求的默认卷中；路径可以包含子目录
+func resolveTarget(r *http.Request, p string) (volume, string, error) {
+	p = cleanName(p)
+	alias, rest, _ := strings.Cut(p, "/")
+	if mt, ok := findMount(alias); ok {
+		if rest == "" {
+			return mt.volume(), "", nil
+		}
+		if reservedPath(rest) {
+			return volume{}, "", errNotFound
+		}
+		return mt.volume(), rest, nil
+	}
+
+	if p == "" || reservedPath(p) {
+		return volume{}, "", errNotFound
+	}
+	vol, err := rootVolume(r.Context())
+	if err != nil {
+		return volume{}, "", err
+	}
+	return vol, p, nil
+}
+
+// resolveVirtual 将虚拟文件树中的路径（默认卷加上以别名出现的各挂载点）解析为卷和卷内路径，
+// 供 WebDAV、FTP 等以整棵树形式访问的协议使用
+func resolveVirtual(ctx context.Context, name string) (volume, string, error) {
+	name = cleanName(name)
+	first, rest, _ := strings.Cut(name, "/")
+	if first == metaDirName {
+		return volume{}, "", os.ErrNotExist
+	}
+	if mt, ok := findMount(first); ok {
+		return mt.volume(), rest, nil
+	}
+	vol, err := rootVolume(ctx)
+	return vol, name, err
+}
+
+// listVirtual 列出卷内目录；root 为 true 时表示虚拟根目录，会隐藏元数据目录并追加挂载点。
+// 按 -access 规则不应出现在 ctx 对应用户列表中的条目（hidden）会被略去
+func listVirtual(ctx context.Context, vol volume, name string, root bool) ([]fs.FileInfo, error) {
+	infos, err := vol.store.List(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.FileInfo, 0, len(infos)+len(mounts))
+	for _, info := range infos {
+		if root {
+			if _, shadowed := findMount(info.Name()); shadowed || info.Name() == metaDirName {
+				continue
+			}
+		}
+		if !contextListed(ctx, vol.virtual(path.Join(name, info.Name()))) {
+			continue
+		}
+		entries = append(entries, info)
+	}
+	if root {
+		for _, mt := range mounts {
+			if !contextListed(ctx, mt.alias) {
+				continue
+			}
+			if info, err := mt.store.Stat(""); err == nil {
+				entries = append(entries, renamedInfo{FileInfo: info, name: mt.alias})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// renamedInfo 以别名展示挂载点目录
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string { return i.name }
+
+// mountPrefix 返回目录在链接中使用的路径前缀
+func mountPrefix(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return dir + "/"
+}
+
+// allVolumes 返回服务目录和所有挂载点的卷
+func allVolumes() []volume {
+	vols := []volume{{store: rootStorage, readOnly: replica != nil}}
+	for _, mt := range mounts {
+		vols = append(vols, mt.volume())
+	}
+	return vols
+}
+
+// walkVolumes 遍历服务目录和所有挂载点中的条目，跳过元数据目录和被挂载点遮盖的目录；
+// fn 返回 fs.SkipDir 时跳过该目录，读取出错的条目会被忽略
+func walkVolumes(fn func(vol volume, name string, info fs.FileInfo) error) error {
+	for _, vol := range allVolumes() {
+		root := vol.prefix == ""
+		err := vol.store.Walk("", func(name string, info fs.FileInfo, err error) error {
+			if err != nil || name == "" {
+				return nil
+			}
+			first, _, _ := strings.Cut(name, "/")
+			if _, shadowed := findMount(first); first == metaDirName || (root && shadowed) {
+				if info.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			return fn(vol, name, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// notifier 在文件事件发生时发送通知
+type notifier interface {
+	Name() string
+	Notify(ev fileEvent) error
+}
+
+// notifiers 是启动时根据命令行参数构建的通知器列表
+var notifiers []notifier
+
+// SMTP 邮件通知配置
+var (
+	smtpAddr       string
+	smtpUser       string
+	smtpPass       string
+	smtpFrom       string
+	notifyEmailTo  stringList
+	notifyEmailDir stringList
+)
+
+// 聊天工具通知配置，取值格式为 [目录=]目标
+var (
+	publicURL      string
+	telegramToken  string
+	notifyTelegram stringList
+	notifySlack    stringList
+)
+
+// setupNotifiers 根据命令行参数初始化通知器
+func setupNotifiers() {
+	if smtpAddr != "" && len(notifyEmailTo) > 0 {
+		notifiers = append(notifiers, &emailNotifier{
+			addr: smtpAddr,
+			user: smtpUser,
+			pass: smtpPass,
+			from: smtpFrom,
+			to:   notifyEmailTo,
+			dirs: notifyEmailDir,
+		})
+		logger.Printf("Email notifications enabled for %s", strings.Join(notifyEmailTo, ", "))
+	}
+	for _, v := range notifySlack {
+		dir, target := splitNotifyTarget(v)
+		notifiers = append(notifiers, &slackNotifier{webhookURL: target, dir: dir})
+		logger.Printf("Slack notifications enabled for directory %q", dir)
+	}
+	if len(notifyTelegram) > 0 && telegramToken == "" {
+		logger.Printf("Warning: -notify-telegram given without -telegram-token, Telegram notifications disabled")
+		return
+	}
+	for _, v := range notifyTelegram {
+		dir, chatID := splitNotifyTarget(v)
+		notifiers = append(notifiers, &telegramNotifier{token: telegramToken, chatID: chatID, dir: dir})
+		logger.Printf("Telegram notifications enabled for directory %q", dir)
+	}
+}
+
+// splitNotifyTarget 解析 "[目录=]目标" 形式的参数，URL 本身不会被当作目录
+func splitNotifyTarget(v string) (dir, target string) {
+	if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+		return "", v
+	}
+	if i := strings.Index(v, "="); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return "", v
+}
+
+// notified 判断事件是否发送通知：新上传和完整性检查发现的问题
+func notified(ev fileEvent) bool {
+	return ev.Event == eventUpload || ev.Event == eventCorrupted || ev.Event == eventModified
+}
+
+// eventTitle 返回通知的标题，如 "New upload: report.pdf"
+func eventTitle(ev fileEvent) string {
+	switch ev.Event {
+	case eventCorrupted:
+		return "Corrupted file: " + ev.Path
+	case eventModified:
+		return "File modified outside the server: " + ev.Path
+	}
+	return "New upload: " + ev.Path
+}
+
+// uploadMessage 生成聊天通知的文本内容
+func uploadMessage(ev fileEvent) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%s)", eventTitle(ev), formatSize(ev.Size))
+	if ev.User != "" {
+		fmt.Fprintf(&sb, " by %s", ev.User)
+	}
+	if publicURL != "" {
+		fmt.Fprintf(&sb, "\n%s/download?path=%s", strings.TrimSuffix(publicURL, "/"), url.QueryEscape(ev.Path))
+	}
+	return sb.String()
+}
+
+// formatSize 将字节数格式化为易读的形式
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// postJSON 以 JSON 格式 POST 数据并检查返回状态
+func postJSON(target string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// dispatchNotifications 异步地将事件交给所有通知器
+func dispatchNotifications(ev fileEvent) {
+	for _, n := range notifiers {
+		go func(n notifier) {
+			if err := n.Notify(ev); err != nil {
+				logger.Printf("%s notification failed for %s: %v", n.Name(), ev.Path, err)
+			}
+		}(n)
+	}
+}
+
+// inWatchedDir 判断文件路径是否位于受监视的目录中，dirs 为空表示监视全部
+func inWatchedDir(dirs []string, p string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+	dir := path.Dir(path.Clean("/" + strings.ReplaceAll(p, "\\", "/")))
+	for _, d := range dirs {
+		d = path.Clean("/" + d)
+		if dir == d || strings.HasPrefix(dir, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// emailNotifier 通过 SMTP 发送新上传通知邮件
+type emailNotifier struct {
+	addr string
+	user string
+	pass string
+	from string
+	to   []string
+	dirs []string
+}
+
+func (e *emailNotifier) Name() string { return "Email" }
+
+func (e *emailNotifier) Notify(ev fileEvent) error {
+	if !notified(ev) || !inWatchedDir(e.dirs, ev.Path) {
+		return nil
+	}
+
+	from := e.from
+	if from == "" {
+		from = e.user
+	}
+	var auth smtp.Auth
+	if e.user != "" {
+		host, _, err := net.SplitHostPort(e.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.user, e.pass, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	// 标题含有文件名，去掉换行以免被插入额外的邮件头，非 ASCII 字符按 RFC 2047 编码
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(eventTitle(ev))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	switch ev.Event {
+	case eventCorrupted:
+		fmt.Fprintf(&msg, "The integrity check found a file whose content no longer matches its recorded checksum, although its size and modification time are unchanged.\r\n\r\n")
+	case eventModified:
+		fmt.Fprintf(&msg, "The integrity check found a file that was changed without going through the server.\r\n\r\n")
+	default:
+		fmt.Fprintf(&msg, "A new file was uploaded.\r\n\r\n")
+	}
+	fmt.Fprintf(&msg, "Path:     %s\r\n", ev.Path)
+	fmt.Fprintf(&msg, "Size:     %d bytes\r\n", ev.Size)
+	if ev.User != "" {
+		fmt.Fprintf(&msg, "User:     %s\r\n", ev.User)
+	}
+	if ev.Checksum != "" {
+		fmt.Fprintf(&msg, "SHA-256:  %s\r\n", ev.Checksum)
+	}
+	fmt.Fprintf(&msg, "Time:     %s\r\n", ev.Time.Format(time.RFC3339))
+
+	return smtp.SendMail(e.addr, auth, from, e.to, []byte(msg.String()))
+}
+
+// slackNotifier 通过 Slack incoming webhook 发送上传通知
+type slackNotifier struct {
+	webhookURL string
+	dir        string
+}
+
+func (n *slackNotifier) Name() string { return "Slack" }
+
+func (n *slackNotifier) Notify(ev fileEvent) error {
+	if !notified(ev) || !inWatchedDir(dirList(n.dir), ev.Path) {
+		return nil
+	}
+	return postJSON(n.webhookURL, map[string]string{"text": uploadMessage(ev)})
+}
+
+// telegramNotifier 通过 Telegram Bot API 向指定聊天发送上传通知
+type telegramNotifier struct {
+	token  string
+	chatID string
+	dir    string
+}
+
+func (n *telegramNotifier) Name() string { return "Telegram" }
+
+func (n *telegramNotifier) Notify(ev fileEvent) error {
+	if !notified(ev) || !inWatchedDir(dirList(n.dir), ev.Path) {
+		return nil
+	}
+	endpoint := "https://api.telegram.org/bot" + n.token + "/sendMessage"
+	return postJSON(endpoint, map[string]string{"chat_id": n.chatID, "text": uploadMessage(ev)})
+}
+
+func dirList(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	return []string{dir}
+}
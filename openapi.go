@@ -0,0 +1,492 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"file-server/delta"
+)
+
+// apiDocs 由 -api-docs 设置，在 /api/docs 提供浏览 OpenAPI 文档的 Swagger UI 页面
+var apiDocs bool
+
+// swaggerUIURL 由 -swagger-ui-url 设置，是 swagger-ui-dist 的地址；离线使用时可指向自己托管的副本
+var swaggerUIURL = "https://unpkg.com/swagger-ui-dist@5"
+
+// apiParam 是 API 操作的查询参数
+type apiParam struct {
+	name     string
+	typ      string
+	desc     string
+	required bool
+}
+
+// apiOp 是 OpenAPI 文档中的一个操作。body 和 resp 是请求体和响应体的 Go 类型的零值，schema 由反射生成，
+// 因此与处理器实际编码的 JSON 保持一致；bodyType 和 respType 为空时表示 JSON
+type apiOp struct {
+	method   string
+	path     string
+	tag      string
+	summary  string
+	admin    bool
+	params   []apiParam
+	body     interface{}
+	bodyType string
+	status   int
+	resp     interface{}
+	respType string
+}
+
+func query(name, typ, desc string) apiParam { return apiParam{name: name, typ: typ, desc: desc} }
+
+func required(p apiParam) apiParam {
+	p.required = true
+	return p
+}
+
+// 以下是处理器中内联构造的请求和响应结构，仅用于生成文档
+type (
+	pathTags struct {
+		Path string   `json:"path"`
+		Tags []string `json:"tags"`
+	}
+	eventsPage struct {
+		Cursor    int64         `json:"cursor"`
+		Events    []changeEvent `json:"events"`
+		Truncated bool          `json:"truncated"`
+	}
+	storedFile struct {
+		Path     string `json:"path"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"`
+	}
+	appendResult struct {
+		Path     string `json:"path"`
+		Appended int64  `json:"appended"`
+		Size     int64  `json:"size"`
+	}
+	linkRequest struct {
+		Path    string `json:"path"`
+		Expires string `json:"expires,omitempty"`
+	}
+	signedLink struct {
+		URL     string    `json:"url"`
+		Path    string    `json:"path"`
+		Expires time.Time `json:"expires"`
+	}
+	uploadTokenRequest struct {
+		Dir     string `json:"dir"`
+		Expires string `json:"expires,omitempty"`
+		Note    string `json:"note,omitempty"`
+	}
+	lockRequest struct {
+		Path string `json:"path"`
+		TTL  string `json:"ttl,omitempty"`
+		Note string `json:"note,omitempty"`
+	}
+	fetchRequest struct {
+		URL string `json:"url"`
+		Dir string `json:"dir"`
+	}
+	clipboardRequest struct {
+		Text string `json:"text"`
+	}
+	graphqlRequest struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName,omitempty"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+	}
+	graphqlResponse struct {
+		Data   map[string]interface{}   `json:"data,omitempty"`
+		Errors []map[string]interface{} `json:"errors,omitempty"`
+	}
+	batchResponse struct {
+		Results []batchResult `json:"results"`
+	}
+	duplicatesReport struct {
+		Groups []dupeGroup `json:"groups"`
+		Wasted int64       `json:"wasted"`
+	}
+	transfersReport struct {
+		Days    int           `json:"days"`
+		Clients []clientUsage `json:"clients"`
+		Daily   []transferDay `json:"daily"`
+	}
+	storageStatsReport struct {
+		*storageReport
+		Dir      dirUsage   `json:"dir"`
+		Children []dirUsage `json:"dirs"`
+	}
+)
+
+// apiOps 列出 JSON API 和主要的上传下载接口；新增 API 时在这里登记
+var apiOps = []apiOp{
+	{method: "get", path: "/api/v1/version", tag: "server", summary: "Build information", resp: buildInfo{}},
+	{method: "get", path: "/api/v1/list", tag: "files", summary: "List a directory, or all files below it with recursive=true",
+		params: []apiParam{
+			query("dir", "string", "Directory to list (default the root)"),
+			query("recursive", "boolean", "List all files below dir, with names relative to dir"),
+			query("glob", "string", "Recursive only: match file names, or relative paths if the pattern contains /"),
+			query("min-size", "string", "Recursive only: minimum size, e.g. 10M"),
+			query("max-size", "string", "Recursive only: maximum size"),
+			query("modified-after", "string", "Recursive only: RFC 3339 time, YYYY-MM-DD or a duration ago such as 168h"),
+			query("modified-before", "string", "Recursive only: RFC 3339 time, YYYY-MM-DD or a duration ago"),
+			query("tag", "string", "Recursive only: comma-separated tags that must all match"),
+		}, resp: []listEntry{}},
+	{method: "delete", path: "/api/v1/delete", tag: "files", summary: "Delete a file or folder",
+		params: []apiParam{required(query("path", "string", "Path as used by /download"))}, status: http.StatusNoContent},
+	{method: "post", path: "/api/v1/batch", tag: "files", summary: "Delete, move and copy several entries in one request",
+		body: batchRequest{}, resp: batchResponse{}},
+	{method: "get", path: "/download", tag: "files", summary: "Download a file, or a folder as ZIP",
+		params: []apiParam{
+			required(query("path", "string", "Path to download")),
+			query("disposition", "string", "inline or attachment"),
+			query("compression", "string", "Folder ZIPs: default, store, auto or a Deflate level 1-9"),
+			query("recipient", "string", "Encrypt the download to an age key or a configured recipient name"),
+		}, respType: "application/octet-stream"},
+	{method: "put", path: "/put/{path}", tag: "files", summary: "Upload a file with the raw request body",
+		params: []apiParam{query("overwrite", "boolean", "Replace an existing file")},
+		body:   []byte{}, bodyType: "application/octet-stream", status: http.StatusCreated, respType: "text/plain"},
+	{method: "post", path: "/api/v1/append", tag: "files", summary: "Append the request body to a file, creating it if needed",
+		params: []apiParam{required(query("path", "string", "File path"))},
+		body:   []byte{}, bodyType: "application/octet-stream", resp: appendResult{}},
+	{method: "post", path: "/upload", tag: "files", summary: "Upload files with a multipart form",
+		params: []apiParam{
+			query("dir", "string", "Target directory"),
+			query("extract", "boolean", "Extract .zip, .tar.gz and other archives into a folder"),
+			query("keep_archive", "boolean", "Also keep an extracted archive next to its folder"),
+			query("async", "boolean", "Extract folder archives in the background and answer 202 with the job from /api/v1/jobs"),
+		},
+		body: []byte{}, bodyType: "multipart/form-data", respType: "text/plain"},
+	{method: "get", path: "/api/v1/jobs", tag: "files", summary: "Progress of a background job, or all jobs without id",
+		params: []apiParam{query("id", "string", "Job ID")}, resp: job{}},
+	{method: "post", path: "/api/v1/jobs", tag: "files", summary: "Start a background job: extract an archive, build a ZIP, delete a folder recursively or hash a folder",
+		body: jobRequest{}, status: http.StatusAccepted, resp: job{}},
+	{method: "delete", path: "/api/v1/jobs", tag: "files", summary: "Cancel a queued or running background job",
+		params: []apiParam{required(query("id", "string", "Job ID"))}, status: http.StatusNoContent},
+	{method: "get", path: "/api/v1/upload/{id}/progress", tag: "files", summary: "Progress of an upload sent with X-Upload-ID", resp: uploadProgress{}},
+	{method: "get", path: "/api/v1/delta", tag: "files", summary: "Block signatures of a file for delta uploads",
+		params: []apiParam{required(query("path", "string", "File path")), query("block", "integer", "Block size in bytes")},
+		resp:   delta.Signature{}},
+	{method: "post", path: "/api/v1/delta", tag: "files", summary: "Rebuild a file from a delta stream",
+		params: []apiParam{
+			required(query("path", "string", "File path")),
+			query("block", "integer", "Block size the delta was computed with"),
+			required(query("checksum", "string", "SHA-256 of the new file")),
+		}, body: []byte{}, bodyType: "application/octet-stream", resp: storedFile{}},
+	{method: "post", path: "/api/v1/archive", tag: "files", summary: "Pack files and folders into a ZIP on the server",
+		body: archiveRequest{}, status: http.StatusCreated, resp: storedFile{}},
+	{method: "get", path: "/api/v1/fetch", tag: "files", summary: "Progress of a URL fetch, or all fetches without id",
+		params: []apiParam{query("id", "string", "Fetch job ID")}, resp: fetchJob{}},
+	{method: "post", path: "/api/v1/fetch", tag: "files", summary: "Fetch a URL into a directory on the server",
+		body: fetchRequest{}, status: http.StatusAccepted, resp: fetchJob{}},
+	{method: "get", path: "/api/v1/metadata", tag: "files", summary: "EXIF, ID3 and container details of a media file",
+		params: []apiParam{required(query("path", "string", "File path"))}, resp: mediaInfo{}},
+	{method: "get", path: "/api/v1/events", tag: "files", summary: "File changes by long-polling, or Server-Sent Events with Accept: text/event-stream",
+		params: []apiParam{
+			query("cursor", "integer", "Cursor returned by the previous call (default now)"),
+			query("timeout", "string", "How long to wait for a change, e.g. 30s"),
+		}, resp: eventsPage{}},
+	{method: "get", path: "/api/v1/graphql", tag: "files", summary: "Read-only GraphQL query",
+		params: []apiParam{required(query("query", "string", "GraphQL query")), query("operationName", "string", ""), query("variables", "string", "JSON object")},
+		resp:   graphqlResponse{}},
+	{method: "post", path: "/api/v1/graphql", tag: "files", summary: "Read-only GraphQL query", body: graphqlRequest{}, resp: graphqlResponse{}},
+
+	{method: "get", path: "/api/v1/tags", tag: "organize", summary: "All tags with counts, the tags of ?path=, or the entries with ?tag=",
+		params: []apiParam{query("path", "string", "Entry whose tags to return"), query("tag", "string", "Comma-separated tags that must all match")},
+		resp:   []tagCount{}},
+	{method: "put", path: "/api/v1/tags", tag: "organize", summary: "Replace the tags of an entry",
+		params: []apiParam{required(query("path", "string", "Entry path"))}, body: pathTags{}, resp: pathTags{}},
+	{method: "get", path: "/api/v1/favorites", tag: "organize", summary: "The current user's favorites", resp: []favoriteItem{}},
+	{method: "post", path: "/api/v1/favorites", tag: "organize", summary: "Star a file or folder",
+		params: []apiParam{required(query("path", "string", "Entry path"))}, status: http.StatusNoContent},
+	{method: "delete", path: "/api/v1/favorites", tag: "organize", summary: "Unstar a file or folder",
+		params: []apiParam{required(query("path", "string", "Entry path"))}, status: http.StatusNoContent},
+	{method: "get", path: "/api/v1/locks", tag: "organize", summary: "All file locks, or the lock on ?path=",
+		params: []apiParam{query("path", "string", "File path")}, resp: []fileLock{}},
+	{method: "post", path: "/api/v1/locks", tag: "organize", summary: "Lock a file, or extend your own lock (409 if someone else holds it)",
+		body: lockRequest{}, resp: fileLock{}},
+	{method: "delete", path: "/api/v1/locks", tag: "organize", summary: "Unlock a file; admins can break other people's locks with force=true",
+		params: []apiParam{required(query("path", "string", "File path")), query("force", "boolean", "Break someone else's lock (admins only)")},
+		status: http.StatusNoContent},
+	{method: "get", path: "/api/v1/clipboard", tag: "organize", summary: "Recent clipboard entries, newest first", resp: []clipEntry{}},
+	{method: "post", path: "/api/v1/clipboard", tag: "organize", summary: "Add a clipboard entry (plain text or JSON)",
+		body: clipboardRequest{}, status: http.StatusCreated, resp: clipEntry{}},
+	{method: "delete", path: "/api/v1/clipboard", tag: "organize", summary: "Delete an entry, or clear the clipboard without id",
+		params: []apiParam{query("id", "integer", "Entry ID")}, status: http.StatusNoContent},
+
+	{method: "get", path: "/api/v1/shares", tag: "sharing", summary: "List share links", resp: []shareLink{}},
+	{method: "post", path: "/api/v1/shares", tag: "sharing", summary: "Create a share link",
+		body: linkRequest{}, status: http.StatusCreated, resp: shareLink{}},
+	{method: "delete", path: "/api/v1/shares", tag: "sharing", summary: "Revoke a share link",
+		params: []apiParam{required(query("token", "string", "Share token"))}, status: http.StatusNoContent},
+	{method: "post", path: "/api/v1/sign", tag: "sharing", summary: "Create a signed download link (expires defaults to 24h)",
+		body: linkRequest{}, status: http.StatusCreated, resp: signedLink{}},
+	{method: "delete", path: "/api/v1/sign", tag: "sharing", summary: "Replace the signing key, invalidating all signed links",
+		admin: true, status: http.StatusNoContent},
+	{method: "get", path: "/api/v1/upload-tokens", tag: "sharing", summary: "List guest upload links", admin: true, resp: []uploadTokenLink{}},
+	{method: "post", path: "/api/v1/upload-tokens", tag: "sharing", summary: "Create a guest upload link (expires defaults to 7 days)",
+		admin: true, body: uploadTokenRequest{}, status: http.StatusCreated, resp: uploadTokenLink{}},
+	{method: "delete", path: "/api/v1/upload-tokens", tag: "sharing", summary: "Revoke a guest upload link",
+		admin: true, params: []apiParam{required(query("id", "string", "Upload token ID"))}, status: http.StatusNoContent},
+
+	{method: "get", path: "/api/v1/downloads/top", tag: "admin", summary: "The most downloaded files",
+		params: []apiParam{query("limit", "integer", "Number of files (default 10)")}, resp: []topDownload{}},
+	{method: "get", path: "/api/v1/duplicates", tag: "admin", summary: "Scan for duplicate files", admin: true, resp: duplicatesReport{}},
+	{method: "post", path: "/api/v1/duplicates", tag: "admin", summary: "Delete or hard-link duplicate copies", admin: true,
+		body: dedupeRequest{}, resp: []dedupeResult{}},
+	{method: "get", path: "/api/v1/stats", tag: "admin", summary: "Storage statistics", admin: true,
+		params: []apiParam{query("dir", "string", "Directory whose subfolders to report"), query("refresh", "boolean", "Rescan now")},
+		resp:   storageStatsReport{}},
+	{method: "get", path: "/api/v1/transfers", tag: "admin", summary: "Bytes uploaded and downloaded per client", admin: true,
+		params: []apiParam{query("days", "integer", "Number of days (default 30)")}, resp: transfersReport{}},
+	{method: "get", path: "/api/v1/audit", tag: "admin", summary: "Uploads and deletes, newest first", admin: true,
+		params: []apiParam{
+			query("limit", "integer", "Number of events (default 100, max 1000)"),
+			query("before", "integer", "Return events with smaller IDs, for paging"),
+			query("user", "string", "Only events by this user"),
+			query("path", "string", "Only events for this path or below it"),
+		}, resp: []auditEntry{}},
+	{method: "get", path: "/api/v1/backup", tag: "admin", summary: "Status of scheduled backups and the snapshots on the target", admin: true,
+		resp: backupStatus{}},
+	{method: "post", path: "/api/v1/backup", tag: "admin", summary: "Start a backup now (409 if one is running)", admin: true,
+		status: http.StatusAccepted},
+	{method: "get", path: "/api/v1/scrub", tag: "admin", summary: "Status of scheduled integrity checks and the corrupted or modified files they found", admin: true,
+		resp: scrubStatus{}},
+	{method: "post", path: "/api/v1/scrub", tag: "admin", summary: "Start an integrity check now (409 if one is running), or with accept record a file's current content as correct (204)", admin: true,
+		params: []apiParam{query("accept", "string", "Path of a reported file whose current content is intended")}, status: http.StatusAccepted},
+	{method: "get", path: "/api/v1/replication", tag: "admin", summary: "Synchronization status of a replica (404 on a primary)", admin: true,
+		resp: replicaStatus{}},
+	{method: "get", path: "/api/v1/cas", tag: "admin", summary: "Statistics of the content-addressable store (404 without -cas)", admin: true,
+		resp: casStats{}},
+	{method: "post", path: "/api/v1/cas", tag: "admin", summary: "Remove content no file refers to any more", admin: true,
+		resp: casStats{}},
+	{method: "get", path: "/export", tag: "admin", summary: "Export a folder with its tags and share links as a tar archive", admin: true,
+		params: []apiParam{
+			query("path", "string", "Folder to export (default the root)"),
+			query("gzip", "boolean", "Compress as .tar.gz"),
+		}, respType: "application/x-tar"},
+	{method: "post", path: "/import", tag: "admin", summary: "Restore an archive created by /export", admin: true,
+		params: []apiParam{
+			query("dir", "string", "Folder to restore into (default the root)"),
+			query("overwrite", "boolean", "Replace files that already exist"),
+		}, body: []byte{}, bodyType: "application/x-tar", resp: importResult{}},
+}
+
+// openAPIHandler 处理 GET /api/openapi.json，返回 OpenAPI 3 文档
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, openAPISpec(r))
+}
+
+// openAPISpec 由 apiOps 生成 OpenAPI 文档，服务器地址和认证方式取决于当前配置
+func openAPISpec(r *http.Request) map[string]interface{} {
+	g := &schemaGen{schemas: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOps {
+		o := map[string]interface{}{
+			"summary":     op.summary,
+			"tags":        []string{op.tag},
+			"operationId": operationID(op),
+		}
+		if op.admin {
+			o["description"] = "Admin only."
+		}
+		var params []interface{}
+		if strings.Contains(op.path, "{") {
+			name := op.path[strings.Index(op.path, "{")+1 : strings.Index(op.path, "}")]
+			params = append(params, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+		}
+		for _, p := range op.params {
+			param := map[string]interface{}{"name": p.name, "in": "query", "schema": map[string]string{"type": p.typ}}
+			if p.desc != "" {
+				param["description"] = p.desc
+			}
+			if p.required {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		if op.body != nil {
+			o["requestBody"] = map[string]interface{}{"required": true, "content": g.content(op.body, op.bodyType)}
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := map[string]interface{}{"description": http.StatusText(status)}
+		if op.resp != nil || op.respType != "" {
+			resp["content"] = g.content(op.resp, op.respType)
+		}
+		responses := map[string]interface{}{fmt.Sprint(status): resp}
+		if authEnabled() {
+			responses["401"] = map[string]string{"description": "Authentication required"}
+		}
+		if op.admin {
+			responses["403"] = map[string]string{"description": "Not an admin"}
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]interface{}{}
+		}
+		paths[op.path][op.method] = o
+	}
+	components := map[string]interface{}{"schemas": g.schemas}
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       "fileserver",
+			"version":     currentBuildInfo().Version,
+			"description": "JSON API of the file server. Paths are relative to the served directory; mounts appear as top-level folders.",
+		},
+		"servers":    []map[string]string{{"url": baseURL(r)}},
+		"paths":      paths,
+		"components": components,
+	}
+	if authEnabled() {
+		components["securitySchemes"] = map[string]interface{}{"basic": map[string]string{"type": "http", "scheme": "basic"}}
+		spec["security"] = []map[string][]string{{"basic": {}}}
+	}
+	return spec
+}
+
+// operationID 由方法和路径生成操作 ID，如 getApiV1List
+func operationID(op apiOp) string {
+	var b strings.Builder
+	b.WriteString(op.method)
+	for _, part := range strings.FieldsFunc(op.path, func(c rune) bool { return strings.ContainsRune("/-{}", c) }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// schemaGen 由 Go 类型生成 JSON Schema，具名结构体放入 components/schemas 并以 $ref 引用
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// content 返回请求体或响应体的 content 对象
+func (g *schemaGen) content(v interface{}, ctype string) map[string]interface{} {
+	if ctype != "" {
+		return map[string]interface{}{ctype: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(v))}}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s := map[string]interface{}{"type": "integer"}
+		if t.Size() == 8 {
+			s["format"] = "int64"
+		}
+		return s
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// object 按 encoding/json 的规则生成结构体的 schema：嵌入的结构体字段展开，omitempty 的字段不是必需的
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var req []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+				add(ft)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				req = append(req, name)
+			}
+		}
+	}
+	add(t)
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if req != nil {
+		s["required"] = req
+	}
+	return s
+}
+
+// apiDocsHandler 处理 /api/docs，显示加载 /api/openapi.json 的 Swagger UI 页面（需要 -api-docs）
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiDocs {
+		http.NotFound(w, r)
+		return
+	}
+	base := html.EscapeString(strings.TrimSuffix(swaggerUIURL, "/"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>fileserver API</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link rel="icon" href="/favicon.ico">
+    <link rel="stylesheet" href="%s/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="%s/swagger-ui-bundle.js"></script>
+    <script>
+    SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui", deepLinking: true});
+    </script>
+</body>
+</html>`, base, base)
+}
@@ -0,0 +1,54 @@
+package fileserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// organizeUploads 由 -organize-uploads 设置：表单上传和访客上传链接的文件放入按此模板生成的子目录，
+// 如 {YYYY}/{MM}/{DD}；为空时直接放入目标目录
+var organizeUploads string
+
+// organizeToken 匹配模板中的占位符
+var organizeToken = regexp.MustCompile(`\{[^{}]*\}`)
+
+// setupOrganizeUploads 检查 -organize-uploads 模板：只能使用已知的占位符，展开后须为目标目录下的相对路径
+func setupOrganizeUploads() error {
+	if organizeUploads == "" {
+		return nil
+	}
+	for _, tok := range organizeToken.FindAllString(organizeUploads, -1) {
+		switch tok {
+		case "{YYYY}", "{MM}", "{DD}", "{HH}", "{user}":
+		default:
+			return fmt.Errorf("-organize-uploads: unknown placeholder %s (expected {YYYY}, {MM}, {DD}, {HH} or {user})", tok)
+		}
+	}
+	for _, seg := range strings.Split(organizeUploads, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("-organize-uploads: %q must be a relative folder such as {YYYY}/{MM}/{DD}", organizeUploads)
+		}
+	}
+	return nil
+}
+
+// organizedDir 按 -organize-uploads 模板生成 t 时刻上传的用户 user 的子目录，未设置模板时返回空
+func organizedDir(t time.Time, user string) string {
+	if organizeUploads == "" {
+		return ""
+	}
+	if user == "" {
+		user = "anonymous"
+	}
+	r := strings.NewReplacer(
+		"{YYYY}", t.Format("2006"),
+		"{MM}", t.Format("01"),
+		"{DD}", t.Format("02"),
+		"{HH}", t.Format("15"),
+		// 访客上传链接的用户为 guest:<令牌 ID>
+		"{user}", strings.ReplaceAll(user, ":", "-"),
+	)
+	return cleanName(r.Replace(organizeUploads))
+}
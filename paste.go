@@ -0,0 +1,259 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// pasteDir 是保存文本片段的目录，位于目标卷的根目录下
+const pasteDir = "pastes"
+
+// pasteMaxSize 是文本片段的最大大小，也是在网页中高亮查看的文本文件的最大大小
+const pasteMaxSize = 1 << 20
+
+// pasteLanguages 是新建片段表单中可选的语言，值为保存时使用的扩展名
+var pasteLanguages = []struct{ ext, label string }{
+	{".txt", "Plain text"}, {".log", "Log"}, {".sh", "Shell"}, {".go", "Go"}, {".py", "Python"},
+	{".js", "JavaScript"}, {".json", "JSON"}, {".yaml", "YAML"}, {".sql", "SQL"}, {".c", "C / C++"},
+	{".java", "Java"}, {".rs", "Rust"},
+}
+
+// pasteHandler 处理 /paste：GET 显示新建片段的表单；POST 表单或 PUT 文本（如 curl -T - ）将文本保存到
+// pastes 目录并创建分享，表单提交后跳转到片段页面，PUT 和非表单的 POST 返回短链接
+func pasteHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		pasteFormPage(w, r)
+	case http.MethodPost, http.MethodPut:
+		createPaste(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func pasteFormPage(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("dir")
+	var opts strings.Builder
+	for _, l := range pasteLanguages {
+		fmt.Fprintf(&opts, `<option value="%s">%s</option>`, l.ext, l.label)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>New paste</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+    <h1>New paste</h1>
+    <form method="post" action="/paste">
+        <input type="hidden" name="dir" value="%s">
+        <p><textarea name="content" rows="20" cols="100" style="width:100%%;font-family:monospace" required autofocus></textarea></p>
+        <p>
+            <input type="text" name="name" placeholder="File name (optional)">
+            <select name="lang">%s</select>
+            <select name="expires"><option value="">Never expires</option><option value="1h">1 hour</option><option value="24h">1 day</option><option value="168h">1 week</option></select>
+            <input type="submit" value="Save and share">
+        </p>
+    </form>
+    <p><a href="%s">&larr; Back</a></p>
+</body>
+</html>`, html.EscapeString(dir), opts.String(), html.EscapeString(listURL(dir)))
+}
+
+// pasteName 返回片段的文件名：指定了名称时使用该名称，否则按时间生成，没有扩展名时加上所选语言的扩展名
+func pasteName(name, ext string) string {
+	name = path.Base(cleanName(name))
+	if name == "." || name == "/" || name == "" {
+		name = "paste-" + time.Now().Format("20060102-150405")
+	}
+	if path.Ext(name) == "" {
+		if ext == "" {
+			ext = ".txt"
+		}
+		name += ext
+	}
+	return name
+}
+
+func createPaste(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, pasteMaxSize+64<<10)
+	form := false
+	var content, name, ext, expires, dir string
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); r.Method == http.MethodPost && (ct == "application/x-www-form-urlencoded" || ct == "multipart/form-data") {
+		form = true
+		content, name, ext, expires, dir = r.FormValue("content"), r.FormValue("name"), r.FormValue("lang"), r.FormValue("expires"), r.FormValue("dir")
+	} else {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Paste too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		q := r.URL.Query()
+		content, name, ext, expires, dir = string(data), q.Get("name"), q.Get("lang"), q.Get("expires"), q.Get("dir")
+	}
+	if strings.TrimSpace(content) == "" {
+		http.Error(w, "Empty paste", http.StatusBadRequest)
+		return
+	}
+	if len(content) > pasteMaxSize {
+		http.Error(w, "Paste too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	vol, err := resolveVolume(r, dir)
+	if err == errNotFound {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to prepare directory", http.StatusInternalServerError)
+		return
+	}
+	if vol.readOnly {
+		http.Error(w, "Directory is read-only", http.StatusForbidden)
+		return
+	}
+	base := pasteName(name, ext)
+	if reservedPath(base) {
+		http.Error(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+	if err := vol.store.Mkdir(pasteDir); err != nil {
+		http.Error(w, "Failed to create directory", http.StatusInternalServerError)
+		return
+	}
+	target := generateUniqueName(vol.store, path.Join(pasteDir, base), path.Ext(base))
+	if _, err := checkNewEntry(vol, target, false); err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	sum := sha256.Sum256([]byte(content))
+	ev := fileEvent{Event: eventUpload, Path: vol.virtual(target), Size: int64(len(content)), User: currentUser(r), Checksum: hex.EncodeToString(sum[:])}
+	err = stageAndCommit(vol.store, target, "put", func(dst io.Writer) error {
+		_, err := io.WriteString(dst, content)
+		return err
+	}, func(tmp string) error {
+		return acceptUpload(vol, tmp, ev)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	completeUpload(vol, target, ev, true)
+	sh, status, err := createShareFromRequest(r, vol.virtual(target), expires)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.Printf("Paste saved: %s", ev.Path)
+	if form {
+		http.Redirect(w, r, "/p/"+sh.Code, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s/p/%s\n", baseURL(r), sh.Code)
+}
+
+var errNotText = errors.New("not a text file")
+
+// readTextFile 读取用于网页查看的文本文件，超过 pasteMaxSize 或不是 UTF-8 文本时返回错误
+func readTextFile(store storage, name string) (string, error) {
+	f, err := store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, pasteMaxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > pasteMaxSize || !utf8.Valid(data) || strings.IndexByte(string(data), 0) >= 0 {
+		return "", errNotText
+	}
+	return string(data), nil
+}
+
+// writeCodePage 输出高亮显示文本文件的页面，links 为页面顶部的链接
+func writeCodePage(w http.ResponseWriter, name, src, links string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+%s</head>
+<body>
+    <h1>%s</h1>
+    <p>%s</p>
+    %s
+</body>
+</html>`, html.EscapeString(name), codeViewCSS, html.EscapeString(name), links, codeViewHTML(src, name))
+}
+
+// pasteViewHandler 处理 /p/<code>，无需登录即可高亮查看分享的文本文件；不是文本时跳转到下载
+func pasteViewHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/p/")
+	sh := shares.lookup("", code)
+	if sh == nil {
+		http.Error(w, "Share not found or expired", http.StatusNotFound)
+		return
+	}
+	vol, name, err := resolveVirtual(withUser(r.Context(), sh.User, true), sh.Path)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	src, err := readTextFile(vol.store, name)
+	if err != nil {
+		http.Redirect(w, r, "/s/"+sh.Code, http.StatusFound)
+		return
+	}
+	raw := "/s/" + url.PathEscape(sh.Code)
+	links := fmt.Sprintf(`<a href="%s">Raw</a> | <a href="%s?disposition=attachment">Download</a> | Short link: <a href="%s/p/%s">%s/p/%s</a>`,
+		raw, raw, html.EscapeString(baseURL(r)), sh.Code, html.EscapeString(baseURL(r)), sh.Code)
+	writeCodePage(w, path.Base(sh.Path), src, links)
+}
+
+// viewHandler 处理 /view?path=，高亮查看服务器上的文本文件
+func viewHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound || p == "" {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	src, err := readTextFile(vol.store, name)
+	if err == errNotText {
+		http.Error(w, "Not a text file or larger than 1 MB", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	link := url.QueryEscape(vol.virtual(name))
+	links := fmt.Sprintf(`<a href="/download?path=%s">Raw</a> | <a href="/download?path=%s&amp;disposition=attachment">Download</a> | <a href="/compare?a=%s">Compare</a> | <a href="%s">&larr; Back</a>`,
+		link, link, link, html.EscapeString(listURL(strings.TrimSuffix(vol.prefix, "/"))))
+	writeCodePage(w, path.Base(name), src, links)
+}
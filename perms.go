@@ -0,0 +1,115 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// permFlag 是八进制权限位命令行参数，如 0640；未设置时保持系统默认（受 umask 影响）
+type permFlag struct {
+	mode os.FileMode
+	set  bool
+}
+
+func (p *permFlag) String() string {
+	if !p.set {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(p.mode))
+}
+
+func (p *permFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n > 0777 {
+		return fmt.Errorf("invalid mode %q, expected octal such as 0644", v)
+	}
+	p.mode, p.set = os.FileMode(n), true
+	return nil
+}
+
+// 上传的文件和创建的目录的权限与所有者，由 -file-mode、-dir-mode 和 -owner 设置
+var (
+	fileMode  permFlag
+	dirMode   permFlag
+	ownerSpec string
+	ownerUID  = -1
+	ownerGID  = -1
+)
+
+// setupOwnership 解析 -owner 的 user[:group]，需要以 root 运行
+func setupOwnership() error {
+	if ownerSpec == "" {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return errors.New("-owner is not supported on Windows")
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("-owner requires running as root")
+	}
+	name, group, hasGroup := strings.Cut(ownerSpec, ":")
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return fmt.Errorf("owner: %v", err)
+		}
+		ownerUID, _ = strconv.Atoi(u.Uid)
+		if !hasGroup {
+			ownerGID, _ = strconv.Atoi(u.Gid)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return fmt.Errorf("owner: %v", err)
+		}
+		ownerGID, _ = strconv.Atoi(g.Gid)
+	}
+	return nil
+}
+
+// applyPerms 为新建的文件或目录设置配置的权限和所有者，失败时只记录日志
+func applyPerms(path string, dir bool) {
+	mode := fileMode
+	if dir {
+		mode = dirMode
+	}
+	if mode.set {
+		if err := os.Chmod(path, mode.mode); err != nil {
+			logger.Printf("Error setting mode of %s: %v", path, err)
+		}
+	}
+	if ownerUID >= 0 || ownerGID >= 0 {
+		if err := os.Lchown(path, ownerUID, ownerGID); err != nil {
+			logger.Printf("Error setting owner of %s: %v", path, err)
+		}
+	}
+}
+
+// permsConfigured 判断是否需要对新建的文件和目录调用 applyPerms
+func permsConfigured() bool {
+	return fileMode.set || dirMode.set || ownerUID >= 0 || ownerGID >= 0
+}
+
+// missingDirs 返回创建 dir 时将新建的各级目录，从最上层开始
+func missingDirs(dir string) []string {
+	var out []string
+	for {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+			break
+		}
+		out = append([]string{dir}, out...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return out
+}
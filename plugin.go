@@ -0,0 +1,136 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 扩展点：其他 Go 包可以在 init 中注册认证器、存储后端、通知器、错误上报器和文件处理器，
+// 无需修改核心处理函数。注册需要在 New 之前完成。
+
+// Storage 是存储后端接口，路径均为以 "/" 分隔、相对于存储根的路径
+type Storage = storage
+
+// StorageFile 是从存储中打开的可读、可定位文件
+type StorageFile = storageFile
+
+// WalkFunc 是 Storage.Walk 的回调函数
+type WalkFunc = walkFunc
+
+// FileEvent 是上传、删除等文件事件
+type FileEvent = fileEvent
+
+// Notifier 在文件事件发生时发送通知
+type Notifier = notifier
+
+// ErrorReporter 上报意外的 5xx 响应和处理请求时的 panic
+type ErrorReporter = errorReporter
+
+// ErrorReport 是一次服务器错误的报告，包含请求信息和 panic 时的调用栈
+type ErrorReport = errorReport
+
+// Authenticator 校验 -user 账户以外的用户名和密码，适用于 HTTP、FTP、SFTP 和 gRPC 登录
+type Authenticator interface {
+	// Authenticate 在凭据有效时返回用户是否为管理员，无效时返回 ErrInvalidCredentials
+	Authenticate(name, password string) (admin bool, err error)
+}
+
+// AuthenticatorFunc 将函数适配为 Authenticator
+type AuthenticatorFunc func(name, password string) (bool, error)
+
+func (f AuthenticatorFunc) Authenticate(name, password string) (bool, error) {
+	return f(name, password)
+}
+
+// ErrInvalidCredentials 表示用户名或密码错误
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Processor 在上传被接受后在后台处理文件，例如生成缩略图或提取元数据
+type Processor interface {
+	Process(store Storage, name string, ev FileEvent) error
+}
+
+// ProcessorFunc 将函数适配为 Processor
+type ProcessorFunc func(store Storage, name string, ev FileEvent) error
+
+func (f ProcessorFunc) Process(store Storage, name string, ev FileEvent) error {
+	return f(store, name, ev)
+}
+
+// StorageFactory 根据位置字符串（scheme:// 之后的部分）创建存储后端
+type StorageFactory func(location string) (Storage, error)
+
+var (
+	pluginsMu       sync.Mutex
+	authenticators  []Authenticator
+	processors      []Processor
+	storageBackends = map[string]StorageFactory{}
+)
+
+// RegisterAuthenticator 注册认证器；注册后即启用认证，按注册顺序依次尝试
+func RegisterAuthenticator(a Authenticator) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	authenticators = append(authenticators, a)
+}
+
+// RegisterNotifier 注册通知器，与命令行配置的通知器一起接收所有文件事件
+func RegisterNotifier(n Notifier) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	notifiers = append(notifiers, n)
+}
+
+// RegisterErrorReporter 注册错误上报器，与 -sentry-dsn 和 -error-webhook 一起接收所有错误报告
+func RegisterErrorReporter(e ErrorReporter) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	errorReporters = append(errorReporters, e)
+}
+
+// RegisterProcessor 注册文件处理器
+func RegisterProcessor(p Processor) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	processors = append(processors, p)
+}
+
+// RegisterStorage 注册存储后端，之后可以用 -mount alias=scheme://location 挂载
+func RegisterStorage(scheme string, factory StorageFactory) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if _, dup := storageBackends[scheme]; dup {
+		panic("fileserver: storage backend registered twice: " + scheme)
+	}
+	storageBackends[scheme] = factory
+}
+
+// openStorage 按位置创建存储：带已注册 scheme 的使用对应后端，否则为本地目录
+func openStorage(location string) (storage, error) {
+	if scheme, rest, ok := strings.Cut(location, "://"); ok {
+		pluginsMu.Lock()
+		factory, found := storageBackends[scheme]
+		pluginsMu.Unlock()
+		if !found {
+			return nil, fmt.Errorf("unknown storage backend %q", scheme)
+		}
+		return factory(rest)
+	}
+	return newLocalStorage(location), nil
+}
+
+// runProcessors 在后台依次执行已注册的文件处理器
+func runProcessors(vol volume, name string, ev fileEvent) {
+	if len(processors) == 0 {
+		return
+	}
+	go func() {
+		for _, p := range processors {
+			if err := p.Process(vol.store, name, ev); err != nil {
+				logger.Printf("Processor %T failed for %s: %v", p, ev.Path, err)
+			}
+		}
+	}()
+}
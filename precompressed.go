@@ -0,0 +1,68 @@
+package fileserver
+
+import (
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// servePrecompressed 由 -precompressed 设置：文件旁有 .br 或 .gz 版本且客户端接受该编码时，直接发送压缩版本
+var servePrecompressed bool
+
+// precompressedVariants 是按优先顺序尝试的预压缩版本
+var precompressedVariants = []struct {
+	ext      string
+	encoding string
+}{
+	{".br", "br"},
+	{".gz", "gzip"},
+}
+
+// acceptsEncoding 判断 Accept-Encoding 是否接受 encoding（或 *），q=0 表示不接受
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// openPrecompressed 在启用 -precompressed 时打开 name 的预压缩版本：版本须为普通文件且不早于原文件修改，
+// 避免原文件更新后仍发送旧内容。找到时设置 Content-Encoding 并返回版本的文件和信息
+func openPrecompressed(w http.ResponseWriter, r *http.Request, store storage, name string, info fs.FileInfo) (storageFile, fs.FileInfo, bool) {
+	if !servePrecompressed {
+		return nil, nil, false
+	}
+	vary := false
+	for _, v := range precompressedVariants {
+		vi, err := store.Stat(name + v.ext)
+		if err != nil || !vi.Mode().IsRegular() || vi.ModTime().Before(info.ModTime()) {
+			continue
+		}
+		if !vary {
+			// 有预压缩版本时，无论是否发送，响应都随 Accept-Encoding 变化
+			w.Header().Add("Vary", "Accept-Encoding")
+			vary = true
+		}
+		if !acceptsEncoding(r, v.encoding) {
+			continue
+		}
+		f, err := store.Open(name + v.ext)
+		if err != nil {
+			continue
+		}
+		w.Header().Set("Content-Encoding", v.encoding)
+		return f, vi, true
+	}
+	return nil, nil, false
+}
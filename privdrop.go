@@ -0,0 +1,60 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runAs 由 -run-as 设置：以 root 启动并绑定所有端口后切换到该 user[:group] 运行
+var runAs string
+
+// listenersReady 在所有端口绑定完成并降低权限后关闭，FTP、SFTP、gRPC 和 S3 服务在此之前不接受连接
+var listenersReady = make(chan struct{})
+
+// lookupRunAs 解析 user[:group]，未指定组时使用用户的主组
+func lookupRunAs(spec string) (uid, gid int, err error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("run-as: %v", err)
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("run-as: %v", err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// dropPrivileges 切换到 -run-as 指定的账户。已存在的元数据目录（例如以 root 生成的 SFTP 主机密钥）
+// 会先交给该账户，之后服务器以普通用户身份创建和读写其中的文件
+func dropPrivileges() error {
+	if runAs == "" {
+		return nil
+	}
+	uid, gid, err := lookupRunAs(runAs)
+	if err != nil {
+		return err
+	}
+	meta := filepath.Join(uploadDir, metaDirName)
+	filepath.WalkDir(meta, func(p string, d fs.DirEntry, err error) error {
+		if err == nil {
+			os.Lchown(p, uid, gid)
+		}
+		return nil
+	})
+	if err := setIDs(uid, gid); err != nil {
+		return fmt.Errorf("run-as %s: %v", runAs, err)
+	}
+	logger.Printf("Running as %s (uid %d, gid %d)", runAs, uid, gid)
+	return nil
+}
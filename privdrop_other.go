@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package fileserver
+
+import "errors"
+
+// setIDs 在不支持切换用户的平台上总是返回错误
+func setIDs(uid, gid int) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package fileserver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setIDs 清除附加组并切换组和用户，Go 会将其应用到进程的所有线程
+func setIDs(uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return errors.New("must be started as root")
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
@@ -0,0 +1,176 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// putHandler 处理 PUT /put/<路径>，将请求体直接流式写入指定路径，方便 curl -T 上传
+// 路径规则与 WebDAV 相同：首段为挂载点别名时写入挂载目录，缺少的父目录会被创建；
+// 与表单上传一样，同名文件默认不会被覆盖，而是生成唯一名称；?overwrite=true 时替换已有文件
+func putHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := cleanName(strings.TrimPrefix(r.URL.Path, "/put/"))
+	if p == "" || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Missing file name", http.StatusBadRequest)
+		return
+	}
+	if reservedPath(p) {
+		http.Error(w, "Reserved filename", http.StatusBadRequest)
+		return
+	}
+
+	vol, name, err := resolveVirtual(r.Context(), p)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if name == "" {
+		http.Error(w, "Missing file name", http.StatusBadRequest)
+		return
+	}
+	if vol.readOnly {
+		http.Error(w, "Directory is read-only", http.StatusForbidden)
+		return
+	}
+	if _, err := vol.store.Stat(name); err == nil && (isAnonymous(r) || isGuest(r)) {
+		// 未登录的上传和访客上传只能新建文件
+		http.Error(w, "File exists", http.StatusForbidden)
+		return
+	}
+	if !checkPreconditions(w, r, vol, name) {
+		return
+	}
+	if limits.MaxUploadSize > 0 {
+		if r.ContentLength > limits.MaxUploadSize {
+			http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxUploadSize)
+	}
+	if err := checkFreeSpace(vol, declaredSize(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	created, err := checkNewEntry(vol, name, r.URL.Query().Get("overwrite") == "true" || hasPreconditions(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	body := watchAbort(r)
+	tracker, ok := trackUpload(w, r)
+	if !ok {
+		return
+	}
+	defer tracker.finish()
+	w = tracker
+
+	if dir := path.Dir(name); dir != "." {
+		if info, err := vol.store.Stat(dir); err == nil && !info.IsDir() {
+			http.Error(w, "Parent is not a directory", http.StatusConflict)
+			return
+		}
+		if err := vol.store.Mkdir(dir); err != nil {
+			reqLog(r).Printf("Error creating directory %s: %v", dir, err)
+			http.Error(w, "Failed to create directory", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 带 If-Match 等前提条件的请求是有条件的替换，不生成唯一名称
+	overwrite := r.URL.Query().Get("overwrite") == "true" || hasPreconditions(r)
+	safeName := name
+	if overwrite {
+		if info, err := vol.store.Stat(name); err == nil && info.IsDir() {
+			http.Error(w, "Path is a directory", http.StatusConflict)
+			return
+		}
+	} else {
+		safeName = generateUniqueName(vol.store, name, path.Ext(name))
+	}
+
+	// 先写入同目录下的临时文件，传输完成后再改名到位，避免中断时留下不完整的文件
+	tracker.setPath(vol.virtual(safeName))
+	reqLog(r).Printf("Saving PUT upload to: %s", vol.virtual(safeName))
+	_, wsp := startSpan(r.Context(), "write file")
+	wsp.setAttr("file.path", vol.virtual(safeName))
+	hasher := sha256.New()
+	var size int64
+	var ev fileEvent
+	err = stageAndCommit(vol.store, safeName, "put", func(dst io.Writer) error {
+		var err error
+		size, err = copyUpload(r, io.MultiWriter(dst, hasher), r.Body)
+		return err
+	}, func(tmp string) error {
+		if err := stillMet(r, vol, safeName); err != nil {
+			return err
+		}
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(safeName), Size: size, User: currentUser(r), Checksum: hex.EncodeToString(hasher.Sum(nil))}
+		return acceptUpload(vol, tmp, ev)
+	})
+	wsp.setAttr("file.size", size)
+	wsp.setError(err)
+	wsp.finish()
+	if err != nil {
+		if body.aborted() {
+			reqLog(r).Printf("PUT upload of %s aborted by client, partial file removed", vol.virtual(safeName))
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err == errPreconditionFailed {
+			reqLog(r).Printf("PUT upload of %s discarded: %v", vol.virtual(safeName), err)
+			http.Error(w, "Precondition failed: the file has changed since it was read", http.StatusPreconditionFailed)
+			return
+		}
+		reqLog(r).Printf("Error copying file: %v", err)
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+
+	reqLog(r).Printf("File saved successfully: %s", safeName)
+	completeUpload(vol, safeName, ev, created)
+	if info, err := vol.store.Stat(safeName); err == nil {
+		w.Header().Set("ETag", fileETag(info))
+	}
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "Saved %s (%s, sha256 %s)\n", ev.Path, formatSize(size), ev.Checksum)
+}
+
+// reservedPath 判断路径中是否有某一段使用了元数据目录的保留名称
+func reservedPath(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == metaDirName || strings.TrimSuffix(seg, path.Ext(seg)) == metaDirName {
+			return true
+		}
+	}
+	return false
+}
+
+// rawHandler 处理 GET /raw/<路径>，按虚拟文件树中的完整路径下载文件，目录打包为 ZIP
+func rawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	vol, name, err := resolveVirtual(r.Context(), strings.TrimPrefix(r.URL.Path, "/raw/"))
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	serveTarget(w, r, vol, name)
+}
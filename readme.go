@@ -0,0 +1,61 @@
+package fileserver
+
+import (
+	"html"
+	"io/fs"
+	"strings"
+)
+
+// readmeNames 是目录列表上方显示的说明文件名，按优先顺序排列，不区分大小写
+var readmeNames = []string{"readme.md", "readme.markdown", "readme.txt", "readme"}
+
+// findReadme 返回目录条目中的说明文件名，没有时返回空字符串
+func findReadme(entries []fs.FileInfo) string {
+	found := make(map[string]string)
+	for _, e := range entries {
+		if !e.IsDir() {
+			found[strings.ToLower(e.Name())] = e.Name()
+		}
+	}
+	for _, n := range readmeNames {
+		if name, ok := found[n]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// readmeHTML 渲染目录中的说明文件：Markdown 转换为 HTML，其他按预格式文本显示。
+// 文件过大或不是文本时返回空字符串
+func readmeHTML(vol volume, dir, name string) string {
+	src, err := readTextFile(vol.store, name)
+	if err != nil {
+		if err != errNotText {
+			logger.Printf("Error reading %s: %v", vol.virtual(name), err)
+		}
+		return ""
+	}
+	src = strings.TrimPrefix(src, "\uFEFF")
+	var body string
+	if lower := strings.ToLower(name); strings.HasSuffix(lower, ".md") || strings.HasSuffix(lower, ".markdown") {
+		body = renderMarkdown(src, readmeLinker(dir))
+	} else {
+		body = "<pre>" + html.EscapeString(src) + "</pre>\n"
+	}
+	return `    <div class="readme">
+        <div class="readme-name">` + html.EscapeString(name) + `</div>
+` + body + `    </div>
+`
+}
+
+// readmeCSS 是说明文件区域的样式，代码块沿用高亮显示的配色
+const readmeCSS = `    <style>
+        .readme { border: 1px solid #ddd; border-radius: 4px; padding: 0 16px 8px; margin: 16px 0; max-width: 900px; overflow-wrap: break-word; }
+        .readme-name { font-weight: bold; padding: 8px 0; border-bottom: 1px solid #ddd; }
+        .readme pre, .readme code { background: #f6f8fa; font-family: monospace; }
+        .readme pre { padding: 8px; overflow-x: auto; white-space: pre-wrap; }
+        .readme blockquote { color: #666; border-left: 4px solid #ddd; margin-left: 0; padding-left: 12px; }
+        .readme table { border-collapse: collapse; } .readme th, .readme td { border: 1px solid #ddd; padding: 4px 8px; }
+        .k { color: #a626a4; font-weight: bold; } .s { color: #50a14f; } .c { color: #a0a1a7; font-style: italic; } .n { color: #986801; }
+    </style>
+`
@@ -0,0 +1,163 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// recipient 是下载加密的接收者：下载内容在发送前加密给它的公钥，只有持有私钥的接收者能够解密
+type recipient interface {
+	// encrypt 返回加密写入 w 的写入器，Close 时写完剩余的密文
+	encrypt(w io.Writer) (io.WriteCloser, error)
+	// ext 是加密后文件名的扩展名
+	ext() string
+}
+
+// recipientsFlag 解析 -recipient name=key 参数：key 为 age 公钥或 OpenPGP 公钥文件的路径
+type recipientsFlag map[string]recipient
+
+// recipients 是预先配置的接收者，下载时以 ?recipient=name 引用
+var recipients = recipientsFlag{}
+
+// gpgPath 由 -gpg 设置，用于加密给 OpenPGP 公钥的下载
+var gpgPath = "gpg"
+
+func (f recipientsFlag) String() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f recipientsFlag) Set(v string) error {
+	name, key, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected name=age1... or name=/path/to/key.asc, got %q", v)
+	}
+	if !validUsername.MatchString(name) {
+		return fmt.Errorf("invalid recipient name %q", name)
+	}
+	if strings.HasPrefix(key, "age1") {
+		r, err := parseAgeRecipient(key)
+		if err != nil {
+			return err
+		}
+		f[name] = r
+		return nil
+	}
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return err
+	}
+	f[name] = &gpgRecipient{key: data}
+	return nil
+}
+
+// downloadRecipient 返回请求的参数 recipient 指定的接收者：预先配置的名称、age 公钥，
+// 或 POST 表单中 ASCII 格式的 OpenPGP 公钥。未指定时返回 nil
+func downloadRecipient(r *http.Request) (recipient, error) {
+	v := strings.TrimSpace(r.FormValue("recipient"))
+	switch {
+	case v == "":
+		return nil, nil
+	case strings.HasPrefix(v, "age1"):
+		return parseAgeRecipient(v)
+	case strings.HasPrefix(v, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+		return &gpgRecipient{key: []byte(v)}, nil
+	}
+	if rc, ok := recipients[v]; ok {
+		return rc, nil
+	}
+	return nil, fmt.Errorf("unknown recipient %q", v)
+}
+
+// gpgRecipient 是 OpenPGP 公钥，由外部 gpg 命令加密，支持 gpg 支持的所有密钥类型
+type gpgRecipient struct {
+	key []byte
+}
+
+func (g *gpgRecipient) ext() string { return ".gpg" }
+
+// encrypt 在私有的临时 GnuPG 目录中运行 gpg，不读取也不修改服务器用户的密钥环
+func (g *gpgRecipient) encrypt(w io.Writer) (io.WriteCloser, error) {
+	bin, err := exec.LookPath(gpgPath)
+	if gpgPath == "" || err != nil {
+		return nil, fmt.Errorf("OpenPGP encryption is not available (gpg not found)")
+	}
+	home, err := os.MkdirTemp(tmpDir, "gpg-")
+	if err != nil {
+		return nil, err
+	}
+	keyFile := filepath.Join(home, "recipient.asc")
+	if err := os.WriteFile(keyFile, g.key, 0600); err != nil {
+		os.RemoveAll(home)
+		return nil, err
+	}
+	cmd := exec.Command(bin, "--homedir", home, "--batch", "--quiet", "--no-tty", "--trust-model", "always",
+		"--recipient-file", keyFile, "--output", "-", "--encrypt")
+	gw := &gpgWriter{cmd: cmd, home: home}
+	cmd.Stdout = w
+	cmd.Stderr = &gw.stderr
+	if gw.in, err = cmd.StdinPipe(); err != nil {
+		os.RemoveAll(home)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(home)
+		return nil, err
+	}
+	return gw, nil
+}
+
+// gpgWriter 将明文写入 gpg 的标准输入，Close 时等待 gpg 写完密文
+type gpgWriter struct {
+	cmd    *exec.Cmd
+	home   string
+	in     io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func (g *gpgWriter) Write(p []byte) (int, error) { return g.in.Write(p) }
+
+func (g *gpgWriter) Close() error {
+	g.in.Close()
+	err := g.cmd.Wait()
+	os.RemoveAll(g.home)
+	if err != nil {
+		return fmt.Errorf("gpg: %v: %s", err, strings.TrimSpace(g.stderr.String()))
+	}
+	return nil
+}
+
+// serveEncrypted 将 write 生成的内容加密给接收者后作为附件 filename 发送。密文边生成边发送，不支持 Range；
+// 开始发送后出错时中断连接，避免客户端得到看似完整的文件
+func serveEncrypted(w http.ResponseWriter, rc recipient, filename string, write func(io.Writer) error) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", filename+rc.ext()))
+	cw := &countingWriter{ResponseWriter: w}
+	enc, err := rc.encrypt(cw)
+	if err == nil {
+		err = write(enc)
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logger.Printf("Error encrypting download of %s: %v", filename, err)
+		if cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Failed to encrypt download: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,384 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"file-server/client"
+)
+
+// 副本模式：-replicate-from 为主服务器地址（http(s)://user:pass@host:port，需要管理员账号）时，
+// 本实例跟随主服务器的变化事件镜像其文件树，所有卷对客户端只读；每隔 -replicate-resync 完整比对一次
+var (
+	replicateFrom   string
+	replicateResync = time.Hour
+)
+
+// replicaRetry 是连接主服务器失败后的重试间隔
+const replicaRetry = 10 * time.Second
+
+// replicaStatus 是 /api/v1/replication 返回的同步状态
+type replicaStatus struct {
+	Primary string `json:"primary"`
+	// State 为 syncing（完整比对中）、following（跟随变化事件）或 error（等待重试）
+	State        string     `json:"state"`
+	Cursor       int64      `json:"cursor"`
+	LastSync     *time.Time `json:"last_sync,omitempty"`
+	LastEvent    *time.Time `json:"last_event,omitempty"`
+	Lag          string     `json:"lag,omitempty"`
+	Events       int64      `json:"events"`
+	Downloaded   int64      `json:"downloaded"`
+	Bytes        int64      `json:"bytes"`
+	Deleted      int64      `json:"deleted"`
+	Error        string     `json:"error,omitempty"`
+	ErrorTime    *time.Time `json:"error_time,omitempty"`
+	lastEventLag time.Duration
+}
+
+// replicator 将主服务器的文件树镜像到本地
+type replicator struct {
+	c  *client.Client
+	mu sync.Mutex
+	st replicaStatus
+}
+
+// replica 在非副本模式下为 nil
+var replica *replicator
+
+// startReplica 在设置了 -replicate-from 时开始跟随主服务器
+func startReplica() error {
+	if replicateFrom == "" {
+		return nil
+	}
+	u, err := url.Parse(replicateFrom)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-replicate-from must be an http(s) URL of the primary server")
+	}
+	c, err := client.New(replicateFrom)
+	if err != nil {
+		return err
+	}
+	replica = &replicator{c: c, st: replicaStatus{Primary: u.Redacted(), State: "syncing", Cursor: -1}}
+	logger.Printf("Replicating %s; all directories are read-only", u.Redacted())
+	go replica.loop()
+	return nil
+}
+
+// replicaWritable 判断卷是否由副本同步写入：副本模式下服务目录和未设为只读的挂载点对客户端只读，但同步仍写入其中
+func replicaWritable(vol volume) bool {
+	if replica == nil {
+		return false
+	}
+	if vol.prefix == "" {
+		return true
+	}
+	mt, ok := findMount(strings.TrimSuffix(vol.prefix, "/"))
+	return ok && !mt.readOnly
+}
+
+// replicaTarget 将主服务器的虚拟路径解析为本地存储和其中的路径；写入只读挂载点或保留路径时返回 false
+func replicaTarget(p string) (storage, string, bool) {
+	if p != "" && (cleanName(p) != p || reservedPath(p)) {
+		return nil, "", false
+	}
+	first, rest, _ := strings.Cut(p, "/")
+	if first == metaDirName {
+		return nil, "", false
+	}
+	if mt, ok := findMount(first); ok {
+		return mt.store, rest, !mt.readOnly
+	}
+	return rootStorage, p, true
+}
+
+func (rp *replicator) update(fn func(st *replicaStatus)) {
+	rp.mu.Lock()
+	fn(&rp.st)
+	rp.mu.Unlock()
+}
+
+// loop 先记下主服务器当前的事件游标并完整比对，然后跟随游标之后的变化；
+// 出错、变化被丢弃或到了定期比对的时间时重新开始
+func (rp *replicator) loop() {
+	ctx := context.Background()
+	for {
+		err := rp.follow(ctx)
+		now := time.Now().UTC()
+		rp.update(func(st *replicaStatus) {
+			st.State, st.Error, st.ErrorTime = "error", err.Error(), &now
+		})
+		logger.Printf("Replication: %v; retrying in %v", err, replicaRetry)
+		time.Sleep(replicaRetry)
+	}
+}
+
+// follow 完整比对一次后跟随变化事件，直到出错
+func (rp *replicator) follow(ctx context.Context) error {
+	for {
+		rp.update(func(st *replicaStatus) { st.State = "syncing" })
+		batch, err := rp.c.Events(ctx, -1, 0)
+		if err != nil {
+			return err
+		}
+		cursor := batch.Cursor
+		start := time.Now()
+		if err := rp.syncDir(ctx, ""); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		rp.update(func(st *replicaStatus) { st.State, st.Cursor, st.LastSync, st.Error = "following", cursor, &now, "" })
+		logger.Printf("Replication: synchronized with %s in %v", rp.st.Primary, time.Since(start).Round(time.Millisecond))
+
+		resync := time.Now().Add(replicateResync)
+		for replicateResync <= 0 || time.Now().Before(resync) {
+			batch, err := rp.c.Events(ctx, cursor, time.Minute)
+			if err != nil {
+				return err
+			}
+			if batch.Truncated {
+				logger.Printf("Replication: missed changes on the primary, resynchronizing")
+				break
+			}
+			for _, ev := range batch.Events {
+				if err := rp.apply(ctx, ev); err != nil {
+					return fmt.Errorf("%s %s: %v", ev.Op, ev.Path, err)
+				}
+				applied := time.Now().UTC()
+				rp.update(func(st *replicaStatus) {
+					st.Events++
+					st.LastEvent = &applied
+					st.lastEventLag = applied.Sub(ev.Time)
+				})
+			}
+			cursor = batch.Cursor
+			rp.update(func(st *replicaStatus) { st.Cursor = cursor })
+		}
+	}
+}
+
+// apply 在本地重放一条变化；无法在本地重命名时（如原路径不在本地，或新路径已随所在目录同步）删除原路径并下载新路径
+func (rp *replicator) apply(ctx context.Context, ev client.Event) error {
+	if stagingFilePattern.MatchString(path.Base(ev.Path)) {
+		return nil
+	}
+	switch ev.Op {
+	case changeDelete:
+		return rp.remove(ev.Path)
+	case changeRename:
+		if err := rp.rename(ev.OldPath, ev.Path); err == nil {
+			return nil
+		}
+		if err := rp.remove(ev.OldPath); err != nil {
+			return err
+		}
+	}
+	info, err := rp.c.Stat(ctx, ev.Path)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		// 之后又被删除或移走，会有对应的事件
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return rp.syncDir(ctx, info.Path)
+	}
+	return rp.fetch(ctx, *info)
+}
+
+// syncDir 使本地目录与主服务器上的目录一致：下载大小或修改时间不同的文件，删除主服务器上没有的条目，并递归处理子目录
+func (rp *replicator) syncDir(ctx context.Context, dir string) error {
+	store, name, ok := replicaTarget(dir)
+	if !ok {
+		return nil
+	}
+	remote, err := rp.c.ReadDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if err := store.Mkdir(name); err != nil {
+		return err
+	}
+	entries, err := store.List(name)
+	if err != nil {
+		return err
+	}
+	local := make(map[string]os.FileInfo, len(entries))
+	for _, info := range entries {
+		local[info.Name()] = info
+	}
+	seen := make(map[string]bool, len(remote))
+	for _, e := range remote {
+		base := path.Base(e.Path)
+		if stagingFilePattern.MatchString(base) {
+			continue
+		}
+		seen[base] = true
+		li, exists := local[base]
+		if exists && li.IsDir() != e.IsDir {
+			if err := rp.remove(e.Path); err != nil {
+				return err
+			}
+			exists = false
+		}
+		if e.IsDir {
+			if err := rp.syncDir(ctx, e.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		if exists && li.Size() == e.Size && li.ModTime().Truncate(time.Second).Equal(e.ModTime.Truncate(time.Second)) {
+			continue
+		}
+		if err := rp.fetch(ctx, e); err != nil {
+			return err
+		}
+	}
+	for base := range local {
+		if seen[base] || stagingFilePattern.MatchString(base) {
+			continue
+		}
+		if dir == "" {
+			if _, shadowed := findMount(base); shadowed || base == metaDirName {
+				continue
+			}
+		}
+		if err := rp.remove(path.Join(dir, base)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch 将主服务器上的文件下载到目标旁的临时文件后改名到位，并设置与主服务器相同的修改时间
+func (rp *replicator) fetch(ctx context.Context, f client.FileInfo) error {
+	store, name, ok := replicaTarget(f.Path)
+	if !ok {
+		return nil
+	}
+	src, err := rp.c.Get(ctx, f.Path)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := store.Mkdir(path.Dir(name)); err != nil {
+		return err
+	}
+	var n int64
+	_, statErr := store.Stat(name)
+	err = stageAndCommit(store, name, "replica", func(dst io.Writer) error {
+		var err error
+		n, err = io.Copy(dst, src)
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if lp, ok := baseStorage(store).(localPather); ok {
+		os.Chtimes(lp.LocalPath(name), f.ModTime, f.ModTime)
+	}
+	if checksums != nil {
+		checksums.forget(f.Path)
+	}
+	rp.update(func(st *replicaStatus) {
+		st.Downloaded++
+		st.Bytes += n
+	})
+	if !watching {
+		op := changeModify
+		if statErr != nil {
+			op = changeCreate
+		}
+		changes.add(op, f.Path, "", false)
+	}
+	return nil
+}
+
+// remove 删除本地的文件或目录，不存在时忽略
+func (rp *replicator) remove(p string) error {
+	store, name, ok := replicaTarget(p)
+	if !ok || name == "" {
+		return nil
+	}
+	info, err := store.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rp.update(func(st *replicaStatus) { st.Deleted++ })
+	if !watching {
+		changes.add(changeDelete, p, "", info.IsDir())
+	}
+	return nil
+}
+
+// rename 在本地重放重命名；原路径不在本地或与新路径不在同一存储时返回错误
+func (rp *replicator) rename(oldPath, newPath string) error {
+	oldStore, oldName, ok1 := replicaTarget(oldPath)
+	newStore, newName, ok2 := replicaTarget(newPath)
+	if !ok1 || !ok2 || oldStore != newStore || oldName == "" || newName == "" {
+		return errors.ErrUnsupported
+	}
+	info, err := oldStore.Stat(oldName)
+	if err != nil {
+		return err
+	}
+	if err := oldStore.Mkdir(path.Dir(newName)); err != nil {
+		return err
+	}
+	if err := oldStore.Rename(oldName, newName); err != nil {
+		return err
+	}
+	moveMetadata(oldPath, newPath)
+	if !watching {
+		changes.add(changeRename, newPath, oldPath, info.IsDir())
+	}
+	return nil
+}
+
+// status 返回同步状态的副本
+func (rp *replicator) status() replicaStatus {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	st := rp.st
+	if st.LastEvent != nil {
+		st.Lag = st.lastEventLag.Round(time.Millisecond).String()
+	}
+	return st
+}
+
+// apiReplicationHandler 处理 GET /api/v1/replication（仅管理员），返回副本的同步状态；不是副本时返回 404
+func apiReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if replica == nil {
+		http.Error(w, "Not a replica (-replicate-from)", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, replica.status())
+}
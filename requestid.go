@@ -0,0 +1,51 @@
+package fileserver
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// requestIDHeader 是请求 ID 的请求头和响应头
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID 限制接受的上游请求 ID，避免把任意内容写入日志
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]{1,128}$`)
+
+// requestIDMiddleware 为每个请求分配 ID：沿用代理传入的 X-Request-ID，否则随机生成；ID 放入请求上下文并在响应头中返回
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			if id, err = randomHex(8); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+	})
+}
+
+// requestID 返回上下文中的请求 ID，不在请求中时为空
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// requestLogger 返回在每行消息前加上请求 ID 的日志记录器；上下文中没有请求 ID 时返回 logger
+func requestLogger(ctx context.Context) *log.Logger {
+	id := requestID(ctx)
+	if id == "" {
+		return logger
+	}
+	return log.New(logger.Writer(), logger.Prefix()+"["+id+"] ", logger.Flags()|log.Lmsgprefix)
+}
+
+// reqLog 返回请求的日志记录器
+func reqLog(r *http.Request) *log.Logger {
+	return requestLogger(r.Context())
+}
@@ -0,0 +1,480 @@
+package fileserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 兼容接口配置
+var (
+	s3Addr   string
+	s3Bucket string
+	s3Region string
+)
+
+const s3XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3Handler 在独立端口上提供最小的 S3 兼容接口（路径风格）：
+// ListBuckets、ListObjects(V1/V2)、HeadBucket、GetObject、HeadObject、PutObject、DeleteObject 和分段上传
+// 默认根目录对应 -s3-bucket 指定的桶，每个挂载点是一个同名的桶
+func s3Handler(w http.ResponseWriter, r *http.Request) {
+	user, err := s3Authenticate(r)
+	if err != nil {
+		logger.Printf("S3 authentication failed: %v", err)
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+		return
+	}
+	if user != "" {
+		r = r.WithContext(withUser(r.Context(), user, accounts[user].admin))
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+			return
+		}
+		s3ListBuckets(w, r)
+		return
+	}
+
+	vol, ok, err := s3Volume(r, bucket)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if !ok {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			if _, ok := r.URL.Query()["location"]; ok {
+				writeS3XML(w, http.StatusOK, struct {
+					XMLName xml.Name `xml:"LocationConstraint"`
+					XMLNS   string   `xml:"xmlns,attr"`
+					Region  string   `xml:",chardata"`
+				}{XMLNS: s3XMLNS, Region: s3Region})
+				return
+			}
+			if _, ok := r.URL.Query()["uploads"]; ok {
+				s3ListMultipartUploads(w, r, bucket)
+				return
+			}
+			s3ListObjects(w, r, bucket, vol)
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		}
+		return
+	}
+
+	if !validS3Key(key) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+
+	if q := r.URL.Query(); q.Get("uploadId") != "" {
+		s3Multipart(w, r, bucket, vol, key)
+		return
+	} else if _, ok := q["uploads"]; ok && r.Method == http.MethodPost {
+		s3CreateMultipartUpload(w, r, bucket, vol, key)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s3GetObject(w, r, vol, key)
+	case http.MethodPut:
+		s3PutObject(w, r, vol, key)
+	case http.MethodDelete:
+		s3DeleteObject(w, r, vol, key)
+	default:
+		writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// s3Volume 将桶名映射为卷
+func s3Volume(r *http.Request, bucket string) (volume, bool, error) {
+	if bucket == s3Bucket {
+		vol, err := rootVolume(r.Context())
+		return vol, err == nil, err
+	}
+	if mt, ok := findMount(bucket); ok {
+		return mt.volume(), true, nil
+	}
+	return volume{}, false, nil
+}
+
+// validS3Key 拒绝指向元数据目录或包含 ".." 段的对象键
+func validS3Key(key string) bool {
+	for _, seg := range strings.Split(key, "/") {
+		if seg == ".." || seg == "." {
+			return false
+		}
+	}
+	first, _, _ := strings.Cut(key, "/")
+	return first != metaDirName
+}
+
+type s3BucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func s3ListBuckets(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string `xml:"ID"`
+			DisplayName string `xml:"DisplayName"`
+		} `xml:"Owner"`
+		Buckets []s3BucketEntry `xml:"Buckets>Bucket"`
+	}
+	res := result{XMLNS: s3XMLNS}
+	res.Owner.ID = currentUser(r)
+	res.Owner.DisplayName = currentUser(r)
+
+	created := func(store storage) string {
+		if info, err := store.Stat(""); err == nil {
+			return info.ModTime().UTC().Format(time.RFC3339)
+		}
+		return time.Now().UTC().Format(time.RFC3339)
+	}
+	res.Buckets = append(res.Buckets, s3BucketEntry{Name: s3Bucket, CreationDate: created(rootStorage)})
+	for _, mt := range mounts {
+		res.Buckets = append(res.Buckets, s3BucketEntry{Name: mt.alias, CreationDate: created(mt.store)})
+	}
+	writeS3XML(w, http.StatusOK, res)
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// s3ListObjects 实现 ListObjects（V1，marker 分页）和 ListObjectsV2（list-type=2，continuation-token 分页）
+func s3ListObjects(w http.ResponseWriter, r *http.Request, bucket string, vol volume) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	// 起始位置：V2 使用不透明的 continuation-token 或 start-after，V1 使用 marker
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	var objects []s3Object
+	err := vol.store.Walk("", func(name string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == metaDirName && info.IsDir() {
+			return fs.SkipDir
+		}
+		if name != "" && !listedFor(r, vol.virtual(name)) {
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		objects = append(objects, s3Object{
+			Key:          name,
+			LastModified: info.ModTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         fileETag(info),
+			Size:         info.Size(),
+			StorageClass: "STANDARD",
+		})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	var contents []s3Object
+	var prefixes []s3Prefix
+	seenPrefix := map[string]bool{}
+	truncated := false
+	last := ""
+	for _, obj := range objects {
+		if obj.Key <= after {
+			continue
+		}
+		entryKey := obj.Key
+		isPrefix := false
+		if delimiter != "" {
+			if i := strings.Index(obj.Key[len(prefix):], delimiter); i >= 0 {
+				entryKey = obj.Key[:len(prefix)+i+len(delimiter)]
+				isPrefix = true
+			}
+		}
+		if isPrefix && seenPrefix[entryKey] {
+			continue
+		}
+		if len(contents)+len(prefixes) >= maxKeys {
+			truncated = true
+			break
+		}
+		if isPrefix {
+			seenPrefix[entryKey] = true
+			prefixes = append(prefixes, s3Prefix{Prefix: entryKey})
+			// 跳过该公共前缀下的所有键
+			last = entryKey + "\uffff"
+		} else {
+			contents = append(contents, obj)
+			last = obj.Key
+		}
+	}
+
+	if v2 {
+		type result struct {
+			XMLName               xml.Name   `xml:"ListBucketResult"`
+			XMLNS                 string     `xml:"xmlns,attr"`
+			Name                  string     `xml:"Name"`
+			Prefix                string     `xml:"Prefix"`
+			Delimiter             string     `xml:"Delimiter,omitempty"`
+			MaxKeys               int        `xml:"MaxKeys"`
+			KeyCount              int        `xml:"KeyCount"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
+			NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+			StartAfter            string     `xml:"StartAfter,omitempty"`
+			Contents              []s3Object `xml:"Contents"`
+			CommonPrefixes        []s3Prefix `xml:"CommonPrefixes"`
+		}
+		res := result{
+			XMLNS: s3XMLNS, Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys,
+			KeyCount: len(contents) + len(prefixes), IsTruncated: truncated,
+			ContinuationToken: q.Get("continuation-token"), StartAfter: q.Get("start-after"),
+			Contents: contents, CommonPrefixes: prefixes,
+		}
+		if truncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		writeS3XML(w, http.StatusOK, res)
+		return
+	}
+
+	type result struct {
+		XMLName        xml.Name   `xml:"ListBucketResult"`
+		XMLNS          string     `xml:"xmlns,attr"`
+		Name           string     `xml:"Name"`
+		Prefix         string     `xml:"Prefix"`
+		Marker         string     `xml:"Marker"`
+		NextMarker     string     `xml:"NextMarker,omitempty"`
+		Delimiter      string     `xml:"Delimiter,omitempty"`
+		MaxKeys        int        `xml:"MaxKeys"`
+		IsTruncated    bool       `xml:"IsTruncated"`
+		Contents       []s3Object `xml:"Contents"`
+		CommonPrefixes []s3Prefix `xml:"CommonPrefixes"`
+	}
+	res := result{
+		XMLNS: s3XMLNS, Name: bucket, Prefix: prefix, Marker: q.Get("marker"), Delimiter: delimiter,
+		MaxKeys: maxKeys, IsTruncated: truncated, Contents: contents, CommonPrefixes: prefixes,
+	}
+	if truncated {
+		res.NextMarker = strings.TrimSuffix(last, "\uffff")
+	}
+	writeS3XML(w, http.StatusOK, res)
+}
+
+func s3GetObject(w http.ResponseWriter, r *http.Request, vol volume, key string) {
+	info, err := vol.store.Stat(key)
+	if err != nil || info.IsDir() {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	f, err := vol.store.Open(key)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer f.Close()
+
+	if r.Method == http.MethodGet && isFullDownload(r) {
+		stats.record(vol.virtual(key))
+	}
+	w.Header().Set("Content-Type", detectContentType(vol.store, key))
+	w.Header().Set("ETag", fileETag(info))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// errS3SHAMismatch 和 errS3BadDigest 表示上传的内容与请求头中的校验和不符
+var (
+	errS3SHAMismatch = errors.New("x-amz-content-sha256 mismatch")
+	errS3BadDigest   = errors.New("content MD5 mismatch")
+)
+
+func s3PutObject(w http.ResponseWriter, r *http.Request, vol volume, key string) {
+	if vol.readOnly {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "CopyObject is not supported")
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		// 目录占位对象
+		if err := vol.store.Mkdir(key); err != nil {
+			writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := checkFreeSpace(vol, declaredSize(r)); err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+	created, err := checkNewEntry(vol, key, true)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+	if err := vol.store.Mkdir(path.Dir(key)); err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	// 先写入同目录下的隐藏临时文件，校验通过后再替换，失败或中断时原有对象保持不变
+	body := watchAbort(r)
+	var src io.Reader = body
+	contentHash := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(contentHash, "STREAMING-") {
+		src = newAWSChunkedReader(body)
+	}
+	md5sum := md5.New()
+	shasum := sha256.New()
+	var size int64
+	var ev fileEvent
+	var rejected error
+	err = stageAndCommit(vol.store, key, "put", func(dst io.Writer) error {
+		var err error
+		size, err = io.Copy(io.MultiWriter(dst, md5sum, shasum), src)
+		return err
+	}, func(tmp string) error {
+		sha := hex.EncodeToString(shasum.Sum(nil))
+		if len(contentHash) == 64 && !strings.EqualFold(contentHash, sha) {
+			return errS3SHAMismatch
+		}
+		if want := r.Header.Get("Content-Md5"); want != "" && want != base64.StdEncoding.EncodeToString(md5sum.Sum(nil)) {
+			return errS3BadDigest
+		}
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(key), Size: size, User: currentUser(r), Checksum: sha}
+		rejected = acceptUpload(vol, tmp, ev)
+		return rejected
+	})
+	switch {
+	case err == nil:
+	case body.aborted():
+		logger.Printf("S3 upload of %s aborted by client, partial object removed", key)
+		return
+	case err == errS3SHAMismatch:
+		writeS3Error(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+		return
+	case err == errS3BadDigest:
+		writeS3Error(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		return
+	case err == rejected:
+		writeS3Error(w, r, uploadErrorStatus(err), "AccessDenied", err.Error())
+		return
+	default:
+		logger.Printf("Error writing S3 object %s: %v", key, err)
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	logger.Printf("S3 object saved: %s", ev.Path)
+	completeUpload(vol, key, ev, created)
+
+	w.Header().Set("ETag", `"`+hex.EncodeToString(md5sum.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func s3DeleteObject(w http.ResponseWriter, r *http.Request, vol volume, key string) {
+	if vol.readOnly {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	info, err := vol.store.Stat(key)
+	if err == nil && !info.IsDir() {
+		if err := vol.store.Delete(key); err != nil {
+			writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		logger.Printf("S3 object deleted: %s", vol.virtual(key))
+	}
+	// 与 S3 一致：删除不存在的对象同样返回 204
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeS3XML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeS3XML(w, status, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string   `xml:"Code"`
+		Message  string   `xml:"Message"`
+		Resource string   `xml:"Resource"`
+	}{Code: code, Message: message, Resource: r.URL.Path})
+}
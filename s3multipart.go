@@ -0,0 +1,499 @@
+package fileserver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3MaxParts 是一次分段上传最多的分段数，与 S3 相同
+const s3MaxParts = 10000
+
+// s3Upload 是进行中的分段上传。分段和清单 upload.json 保存在暂存目录的 s3-uploads/<id>/ 中，
+// 服务器重启后可以继续上传；最后一个分段上传后超过 -janitor-max-age 未完成的上传由清理任务删除
+type s3Upload struct {
+	ID        string          `json:"id"`
+	Bucket    string          `json:"bucket"`
+	Key       string          `json:"key"`
+	User      string          `json:"user,omitempty"`
+	Initiated time.Time       `json:"initiated"`
+	Parts     map[int]*s3Part `json:"parts"`
+
+	mu   sync.Mutex
+	done bool // 已完成或已取消，之后到达的分段被拒绝
+}
+
+// s3Part 是已接收的分段，ETag 为分段内容的 MD5，与 S3 相同
+type s3Part struct {
+	Number   int       `json:"number"`
+	ETag     string    `json:"etag"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	File     string    `json:"file"`
+	Modified time.Time `json:"modified"`
+}
+
+var (
+	s3UploadsMu sync.Mutex
+	s3Uploads   = map[string]*s3Upload{}
+)
+
+// s3UploadsDir 返回分段上传的暂存目录
+func s3UploadsDir() string {
+	return filepath.Join(tmpDir, "s3-uploads")
+}
+
+func (u *s3Upload) dir() string {
+	return filepath.Join(s3UploadsDir(), u.ID)
+}
+
+// save 写入清单，调用方持有 u.mu
+func (u *s3Upload) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(u.dir(), "upload.json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(u.dir(), "upload.json"))
+}
+
+// getS3Upload 返回进行中的分段上传，不在内存中时从暂存目录加载清单
+func getS3Upload(id string) *s3Upload {
+	if !validUploadID(id) {
+		return nil
+	}
+	s3UploadsMu.Lock()
+	defer s3UploadsMu.Unlock()
+	if u, ok := s3Uploads[id]; ok {
+		return u
+	}
+	data, err := os.ReadFile(filepath.Join(s3UploadsDir(), id, "upload.json"))
+	if err != nil {
+		return nil
+	}
+	u := &s3Upload{}
+	if err := json.Unmarshal(data, u); err != nil || u.ID != id {
+		logger.Printf("Error loading S3 multipart upload %s: %v", id, err)
+		return nil
+	}
+	if u.Parts == nil {
+		u.Parts = map[int]*s3Part{}
+	}
+	s3Uploads[id] = u
+	return u
+}
+
+// forgetS3Upload 删除分段上传的暂存目录
+func forgetS3Upload(u *s3Upload) {
+	s3UploadsMu.Lock()
+	delete(s3Uploads, u.ID)
+	s3UploadsMu.Unlock()
+	os.RemoveAll(u.dir())
+}
+
+// pruneS3Uploads 删除清单修改时间早于 cutoff 的分段上传，返回删除的数量
+func pruneS3Uploads(cutoff time.Time) int {
+	entries, _ := os.ReadDir(s3UploadsDir())
+	n := 0
+	for _, e := range entries {
+		info, err := os.Stat(filepath.Join(s3UploadsDir(), e.Name(), "upload.json"))
+		if err == nil && info.ModTime().After(cutoff) {
+			continue
+		}
+		if err != nil {
+			// 没有清单的目录：创建时中断，按目录时间判断
+			if info, err = e.Info(); err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+		}
+		if u := getS3Upload(e.Name()); u != nil {
+			u.mu.Lock()
+			u.done = true
+			u.mu.Unlock()
+		}
+		s3UploadsMu.Lock()
+		delete(s3Uploads, e.Name())
+		s3UploadsMu.Unlock()
+		if err := os.RemoveAll(filepath.Join(s3UploadsDir(), e.Name())); err != nil {
+			logger.Printf("Janitor: error removing S3 multipart upload %s: %v", e.Name(), err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// s3Multipart 处理带 uploadId 参数的对象请求：PUT 上传分段（UploadPart）、POST 完成上传、
+// DELETE 取消上传、GET 列出已上传的分段（ListParts）
+func s3Multipart(w http.ResponseWriter, r *http.Request, bucket string, vol volume, key string) {
+	u := getS3Upload(r.URL.Query().Get("uploadId"))
+	if u == nil || u.Bucket != bucket || u.Key != key || u.User != currentUser(r) {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s3UploadPart(w, r, vol, u)
+	case http.MethodPost:
+		s3CompleteMultipartUpload(w, r, vol, u)
+	case http.MethodDelete:
+		u.mu.Lock()
+		u.done = true
+		u.mu.Unlock()
+		forgetS3Upload(u)
+		logger.Printf("S3 multipart upload of %s aborted", vol.virtual(key))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		s3ListParts(w, r, u)
+	default:
+		writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// s3CreateMultipartUpload 处理 POST <key>?uploads，返回新的 UploadId
+func s3CreateMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, vol volume, key string) {
+	if vol.readOnly {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+	// 开始时先检查一次，避免上传完所有分段后才被拒绝；完成时还会再检查
+	if _, err := checkNewEntry(vol, key, true); err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	u := &s3Upload{ID: id, Bucket: bucket, Key: key, User: currentUser(r), Initiated: time.Now().UTC(), Parts: map[int]*s3Part{}}
+	if err := os.MkdirAll(u.dir(), 0700); err == nil {
+		err = u.save()
+	}
+	if err != nil {
+		os.RemoveAll(u.dir())
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	s3UploadsMu.Lock()
+	s3Uploads[id] = u
+	s3UploadsMu.Unlock()
+
+	writeS3XML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{XMLNS: s3XMLNS, Bucket: bucket, Key: key, UploadID: id})
+}
+
+// s3UploadPart 处理 PUT <key>?partNumber=&uploadId=。分段写入各自的文件，可以并行上传；
+// 重复上传同一编号时替换之前的分段。Content-MD5 和 x-amz-content-sha256 不符时拒绝
+func s3UploadPart(w http.ResponseWriter, r *http.Request, vol volume, u *s3Upload) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "UploadPartCopy is not supported")
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > s3MaxParts {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Part number must be an integer between 1 and %d", s3MaxParts))
+		return
+	}
+	if err := checkFreeSpace(vol, declaredSize(r)); err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+	f, err := os.CreateTemp(u.dir(), fmt.Sprintf("part-%05d-*", n))
+	if err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	body := watchAbort(r)
+	var src io.Reader = body
+	contentHash := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(contentHash, "STREAMING-") {
+		src = newAWSChunkedReader(body)
+	}
+	md5sum := md5.New()
+	shasum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, md5sum, shasum), src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		if body.aborted() {
+			logger.Printf("S3 upload of part %d of %s aborted by client", n, u.Key)
+			return
+		}
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	sha := hex.EncodeToString(shasum.Sum(nil))
+	if len(contentHash) == 64 && !strings.EqualFold(contentHash, sha) {
+		os.Remove(f.Name())
+		writeS3Error(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+		return
+	}
+	if want := r.Header.Get("Content-Md5"); want != "" && want != base64.StdEncoding.EncodeToString(md5sum.Sum(nil)) {
+		os.Remove(f.Name())
+		writeS3Error(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		return
+	}
+
+	part := &s3Part{
+		Number: n, ETag: `"` + hex.EncodeToString(md5sum.Sum(nil)) + `"`, Size: size, SHA256: sha,
+		File: filepath.Base(f.Name()), Modified: time.Now().UTC(),
+	}
+	u.mu.Lock()
+	if u.done {
+		u.mu.Unlock()
+		os.Remove(f.Name())
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+	old := u.Parts[n]
+	u.Parts[n] = part
+	err = u.save()
+	u.mu.Unlock()
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if old != nil && old.File != part.File {
+		os.Remove(filepath.Join(u.dir(), old.File))
+	}
+	w.Header().Set("ETag", part.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3CompleteMultipartUpload 按请求中列出的分段顺序拼接出对象。每个分段的 ETag 必须与已上传的分段一致，
+// 未列出的分段被丢弃；对象先写入目标旁的临时文件，拼接完成后才替换目标，之后与普通上传一样扫描、执行钩子和通知
+func s3CompleteMultipartUpload(w http.ResponseWriter, r *http.Request, vol volume, u *s3Upload) {
+	if vol.readOnly {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+	parts := make([]*s3Part, 0, len(req.Parts))
+	var total int64
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+		part := u.Parts[p.PartNumber]
+		if part == nil || strings.Trim(p.ETag, `"`) != strings.Trim(part.ETag, `"`) {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d has not been uploaded or its ETag does not match.", p.PartNumber))
+			return
+		}
+		parts = append(parts, part)
+		total += part.Size
+	}
+	if err := checkFreeSpace(vol, total); err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+
+	key := u.Key
+	created, err := checkNewEntry(vol, key, true)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInsufficientStorage, "InsufficientStorage", err.Error())
+		return
+	}
+	if err := vol.store.Mkdir(path.Dir(key)); err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	shasum := sha256.New()
+	etags := md5.New()
+	var ev fileEvent
+	var rejected error
+	err = stageAndCommit(vol.store, key, "put", func(dst io.Writer) error {
+		for _, part := range parts {
+			f, err := os.Open(filepath.Join(u.dir(), part.File))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(io.MultiWriter(dst, shasum), f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			raw, _ := hex.DecodeString(strings.Trim(part.ETag, `"`))
+			etags.Write(raw)
+		}
+		return nil
+	}, func(tmp string) error {
+		ev = fileEvent{Event: eventUpload, Path: vol.virtual(key), Size: total, User: currentUser(r), Checksum: hex.EncodeToString(shasum.Sum(nil))}
+		rejected = acceptUpload(vol, tmp, ev)
+		return rejected
+	})
+	if err != nil && err == rejected {
+		writeS3Error(w, r, uploadErrorStatus(err), "AccessDenied", err.Error())
+		return
+	}
+	if err != nil {
+		logger.Printf("Error assembling S3 multipart upload of %s: %v", vol.virtual(key), err)
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	u.done = true
+	forgetS3Upload(u)
+
+	logger.Printf("S3 object saved: %s (%d parts)", ev.Path, len(parts))
+	completeUpload(vol, key, ev, created)
+
+	writeS3XML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}{
+		XMLNS: s3XMLNS, Location: "/" + u.Bucket + "/" + key, Bucket: u.Bucket, Key: key,
+		ETag: fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(etags.Sum(nil)), len(parts)),
+	})
+}
+
+// s3ListParts 处理 GET <key>?uploadId=，支持 part-number-marker 和 max-parts 分页
+func s3ListParts(w http.ResponseWriter, r *http.Request, u *s3Upload) {
+	q := r.URL.Query()
+	marker, _ := strconv.Atoi(q.Get("part-number-marker"))
+	maxParts := 1000
+	if v := q.Get("max-parts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-parts")
+			return
+		}
+		if n < maxParts {
+			maxParts = n
+		}
+	}
+
+	type partEntry struct {
+		PartNumber   int    `xml:"PartNumber"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+	}
+	u.mu.Lock()
+	numbers := make([]int, 0, len(u.Parts))
+	for n := range u.Parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	truncated := len(numbers) > maxParts
+	if truncated {
+		numbers = numbers[:maxParts]
+	}
+	var entries []partEntry
+	for _, n := range numbers {
+		p := u.Parts[n]
+		entries = append(entries, partEntry{
+			PartNumber: n, LastModified: p.Modified.Format("2006-01-02T15:04:05.000Z"), ETag: p.ETag, Size: p.Size,
+		})
+	}
+	u.mu.Unlock()
+
+	next := marker
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1]
+	}
+	writeS3XML(w, http.StatusOK, struct {
+		XMLName              xml.Name    `xml:"ListPartsResult"`
+		XMLNS                string      `xml:"xmlns,attr"`
+		Bucket               string      `xml:"Bucket"`
+		Key                  string      `xml:"Key"`
+		UploadID             string      `xml:"UploadId"`
+		PartNumberMarker     int         `xml:"PartNumberMarker"`
+		NextPartNumberMarker int         `xml:"NextPartNumberMarker"`
+		MaxParts             int         `xml:"MaxParts"`
+		IsTruncated          bool        `xml:"IsTruncated"`
+		StorageClass         string      `xml:"StorageClass"`
+		Parts                []partEntry `xml:"Part"`
+	}{
+		XMLNS: s3XMLNS, Bucket: u.Bucket, Key: u.Key, UploadID: u.ID, PartNumberMarker: marker,
+		NextPartNumberMarker: next, MaxParts: maxParts, IsTruncated: truncated, StorageClass: "STANDARD", Parts: entries,
+	})
+}
+
+// s3ListMultipartUploads 处理 GET <bucket>?uploads，列出当前用户在桶中进行中的分段上传，支持 prefix 过滤
+func s3ListMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	type uploadEntry struct {
+		Key          string `xml:"Key"`
+		UploadID     string `xml:"UploadId"`
+		Initiated    string `xml:"Initiated"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	prefix := r.URL.Query().Get("prefix")
+	var uploads []uploadEntry
+	entries, _ := os.ReadDir(s3UploadsDir())
+	for _, e := range entries {
+		u := getS3Upload(e.Name())
+		if u == nil || u.Bucket != bucket || u.User != currentUser(r) || !strings.HasPrefix(u.Key, prefix) {
+			continue
+		}
+		uploads = append(uploads, uploadEntry{
+			Key: u.Key, UploadID: u.ID, Initiated: u.Initiated.Format("2006-01-02T15:04:05.000Z"), StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated < uploads[j].Initiated
+	})
+	writeS3XML(w, http.StatusOK, struct {
+		XMLName     xml.Name      `xml:"ListMultipartUploadsResult"`
+		XMLNS       string        `xml:"xmlns,attr"`
+		Bucket      string        `xml:"Bucket"`
+		Prefix      string        `xml:"Prefix"`
+		MaxUploads  int           `xml:"MaxUploads"`
+		IsTruncated bool          `xml:"IsTruncated"`
+		Uploads     []uploadEntry `xml:"Upload"`
+	}{XMLNS: s3XMLNS, Bucket: bucket, Prefix: prefix, MaxUploads: 1000, Uploads: uploads})
+}
@@ -0,0 +1,474 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scrubInterval 由 -scrub-interval 设置：每隔这么久重新读取所有文件，与记录的校验和比较；0 表示不检查
+var scrubInterval time.Duration
+
+// scrubHistory 是保存的最近检查记录数
+const scrubHistory = 20
+
+// scrubRun 是一次完整性检查的进度和结果；Recorded 为首次记录校验和的文件数
+type scrubRun struct {
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Files    int        `json:"files"`
+	Verified int        `json:"verified"`
+	Recorded int        `json:"recorded"`
+	Bytes    int64      `json:"bytes"`
+	Problems int        `json:"problems"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// scrubProblem 是一个内容与记录不符的文件：Kind 为 corrupted（大小和修改时间未变，内容却变了）
+// 或 modified（在服务器之外被修改）；Expected 为记录的 SHA-256，Actual 为检查时读到的
+type scrubProblem struct {
+	Path     string    `json:"path"`
+	Kind     string    `json:"kind"`
+	Expected string    `json:"expected"`
+	Actual   string    `json:"actual"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Detected time.Time `json:"detected"`
+}
+
+// scrubState 保存在元数据库的 scrub bucket 中；Problems 为尚未解决的问题，Runs 为最近的检查，最新的在前
+type scrubState struct {
+	Problems []scrubProblem `json:"problems,omitempty"`
+	Runs     []scrubRun     `json:"runs,omitempty"`
+}
+
+// scrubScheduler 定期检查所有文件的完整性，检查作为后台任务运行，受 -job-workers 限制并可以取消
+type scrubScheduler struct {
+	mu      sync.Mutex
+	state   scrubState
+	current *scrubRun
+	job     *job
+	next    time.Time
+	trigger chan struct{}
+}
+
+// scrubber 在未设置 -scrub-interval 时为 nil
+var scrubber *scrubScheduler
+
+// scrubStateKey 是 scrub bucket 中保存状态的键
+var scrubStateKey = []byte("state")
+
+// startScrub 加载上次的状态并启动定时检查。上次检查已超过间隔时在启动十分钟后检查
+func startScrub() error {
+	if scrubInterval == 0 {
+		return nil
+	}
+	if scrubInterval < 0 {
+		return fmt.Errorf("-scrub-interval must be positive")
+	}
+	s := &scrubScheduler{trigger: make(chan struct{}, 1)}
+	dbEach(bucketScrub, func(k, v []byte) error {
+		if string(k) == string(scrubStateKey) {
+			if err := json.Unmarshal(v, &s.state); err != nil {
+				logger.Printf("Error parsing integrity scrub state: %v", err)
+			}
+		}
+		return nil
+	})
+	s.next = time.Now().Add(10 * time.Minute)
+	if len(s.state.Runs) > 0 {
+		if next := s.state.Runs[0].Started.Add(scrubInterval); next.After(s.next) {
+			s.next = next
+		}
+	}
+	scrubber = s
+	logger.Printf("Checking file integrity every %v; next check at %s", scrubInterval, s.next.Local().Format("2006-01-02 15:04"))
+	go s.loop()
+	return nil
+}
+
+func (s *scrubScheduler) loop() {
+	for {
+		s.mu.Lock()
+		wait := time.Until(s.next)
+		s.mu.Unlock()
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-timer.C:
+		case <-s.trigger:
+			timer.Stop()
+		}
+		s.start()
+	}
+}
+
+// runNow 请求立即检查，已有检查在排队或进行时返回 false
+func (s *scrubScheduler) runNow() bool {
+	s.mu.Lock()
+	running := s.job != nil
+	s.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// start 将一次检查提交为后台任务；下次定时检查从现在起算，检查结束后再从结束时间起算
+func (s *scrubScheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = time.Now().Add(scrubInterval)
+	if s.job != nil {
+		return
+	}
+	j, err := newSystemJob(jobScrub, "")
+	if err != nil {
+		logger.Printf("Error starting integrity scrub: %v", err)
+		return
+	}
+	s.job = j
+	j.start(s.run)
+}
+
+// run 执行一次检查。完整的检查替换问题列表，中断的检查只添加新发现的问题；新问题会发送事件和通知
+func (s *scrubScheduler) run(ctx context.Context) error {
+	run := &scrubRun{Started: time.Now().UTC()}
+	s.mu.Lock()
+	s.current = run
+	j := s.job
+	prev := s.state.Problems
+	s.mu.Unlock()
+
+	logger.Printf("Integrity scrub started")
+	problems, err := s.scrub(ctx, run, j)
+
+	known := map[string]scrubProblem{}
+	for _, p := range prev {
+		known[p.Path+" "+p.Actual] = p
+	}
+	for i, p := range problems {
+		if old, ok := known[p.Path+" "+p.Actual]; ok {
+			problems[i].Detected = old.Detected
+		} else {
+			logger.Printf("Integrity scrub: %s is %s (expected sha256 %s, got %s)", p.Path, p.Kind, p.Expected, p.Actual)
+			emitEvent(fileEvent{Event: p.Kind, Path: p.Path, Size: p.Size, Checksum: p.Actual})
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now().UTC()
+	run.Finished = &finished
+	if err != nil {
+		// 未检查到的文件保留上次发现的问题
+		found := map[string]bool{}
+		for _, p := range problems {
+			found[p.Path] = true
+		}
+		for _, p := range prev {
+			if !found[p.Path] {
+				problems = append(problems, p)
+			}
+		}
+		run.Error = err.Error()
+		if ctx.Err() != nil {
+			run.Error = "canceled"
+		}
+		logger.Printf("Integrity scrub stopped after %d files: %v", run.Files, err)
+	} else {
+		logger.Printf("Integrity scrub finished: %d files verified, %d newly recorded, %d problems", run.Verified, run.Recorded, len(problems))
+	}
+	sort.Slice(problems, func(a, b int) bool { return problems[a].Path < problems[b].Path })
+	s.state.Problems = problems
+	s.state.Runs = append([]scrubRun{*run}, s.state.Runs...)
+	if len(s.state.Runs) > scrubHistory {
+		s.state.Runs = s.state.Runs[:scrubHistory]
+	}
+	s.current, s.job = nil, nil
+	s.next = finished.Add(scrubInterval)
+	dbPut(bucketScrub, scrubStateKey, s.state)
+	return err
+}
+
+// scrub 读取服务目录和所有挂载点中的每个文件并计算 SHA-256：没有记录的文件记下校验和，作为以后检查的基准；
+// 与记录不符的文件作为问题返回，记录保持不变，直到文件通过服务器重新写入、被删除或管理员接受当前内容
+func (s *scrubScheduler) scrub(ctx context.Context, run *scrubRun, j *job) ([]scrubProblem, error) {
+	var files, bytes int64
+	walkVolumes(func(vol volume, name string, info fs.FileInfo) error {
+		if scrubbed(info) {
+			files++
+			bytes += info.Size()
+		}
+		return ctx.Err()
+	})
+	j.setTotals(files, bytes)
+
+	var problems []scrubProblem
+	err := walkVolumes(func(vol volume, name string, info fs.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !scrubbed(info) {
+			return nil
+		}
+		virtual := vol.virtual(name)
+		rec, recorded := checksums.entry(virtual)
+		sum, err := readChecksum(ctx, vol, name, j)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			if !os.IsNotExist(err) {
+				logger.Printf("Integrity scrub: error reading %s: %v", virtual, err)
+			}
+			return nil
+		}
+		j.advance(1, 0)
+		// 读取期间文件被改写或记录被更新（如正在上传）时跳过，下次再检查
+		after, err := vol.store.Stat(name)
+		if err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		if now, ok := checksums.entry(virtual); ok != recorded || now != rec {
+			return nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		run.Files++
+		run.Bytes += info.Size()
+		switch {
+		case !recorded:
+			checksums.put(virtual, info, sum)
+			run.Recorded++
+		case sum == rec.SHA256:
+			if rec.Size != info.Size() || !rec.ModTime.Equal(info.ModTime()) {
+				// 只是修改时间变了（如被 touch），内容未变
+				checksums.put(virtual, info, sum)
+			}
+			run.Verified++
+		default:
+			kind := eventModified
+			if rec.Size == info.Size() && rec.ModTime.Equal(info.ModTime()) {
+				kind = eventCorrupted
+			}
+			problems = append(problems, scrubProblem{Path: virtual, Kind: kind, Expected: rec.SHA256, Actual: sum,
+				Size: info.Size(), ModTime: info.ModTime().UTC(), Detected: time.Now().UTC()})
+			run.Problems++
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// scrubbed 判断是否检查该条目：只检查普通文件，跳过上传中的临时文件
+func scrubbed(info fs.FileInfo) bool {
+	return info.Mode().IsRegular() && !stagingFilePattern.MatchString(info.Name())
+}
+
+// readChecksum 读取文件全部内容并返回其 SHA-256，不使用校验和缓存；j 不为 nil 时记录读取的字节数
+func readChecksum(ctx context.Context, vol volume, name string, j *job) (string, error) {
+	f, err := vol.store.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	var w io.Writer = h
+	if j != nil {
+		w = io.MultiWriter(h, j.counter(ctx))
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// accept 将文件的当前内容记录为正确内容，并从问题列表中移除；用于确认在服务器之外的修改是有意的
+func (s *scrubScheduler) accept(ctx context.Context, virtual string) error {
+	vol, name, err := resolveVirtual(ctx, virtual)
+	if err != nil {
+		return errNotFound
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil || info.IsDir() {
+		return errNotFound
+	}
+	sum, err := readChecksum(ctx, vol, name, nil)
+	if err != nil {
+		return err
+	}
+	checksums.put(vol.virtual(name), info, sum)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []scrubProblem
+	for _, p := range s.state.Problems {
+		if p.Path != vol.virtual(name) {
+			kept = append(kept, p)
+		}
+	}
+	s.state.Problems = kept
+	dbPut(bucketScrub, scrubStateKey, s.state)
+	logger.Printf("Integrity scrub: accepted current content of %s (sha256 %s)", vol.virtual(name), sum)
+	return nil
+}
+
+// scrubStatus 是 /api/v1/scrub 返回的状态
+type scrubStatus struct {
+	Interval string         `json:"interval"`
+	Next     time.Time      `json:"next"`
+	Running  *scrubRun      `json:"running,omitempty"`
+	Job      string         `json:"job,omitempty"`
+	Problems []scrubProblem `json:"problems"`
+	Runs     []scrubRun     `json:"runs"`
+}
+
+func (s *scrubScheduler) status() scrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := scrubStatus{Interval: scrubInterval.String(), Next: s.next,
+		Problems: append([]scrubProblem{}, s.state.Problems...), Runs: append([]scrubRun{}, s.state.Runs...)}
+	if s.current != nil {
+		c := *s.current
+		st.Running = &c
+	}
+	if s.job != nil {
+		st.Job = s.job.ID
+	}
+	return st
+}
+
+// apiScrubHandler 处理 /api/v1/scrub（仅管理员）：GET 返回检查状态和发现的问题，POST 立即开始一次检查，
+// POST 带 accept=<path> 时接受该文件的当前内容
+func apiScrubHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if scrubber == nil {
+		http.Error(w, "Integrity checks are not configured (-scrub-interval)", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, scrubber.status())
+	case http.MethodPost:
+		if p := r.URL.Query().Get("accept"); p != "" {
+			if err := scrubber.accept(r.Context(), cleanName(p)); err == errNotFound {
+				http.Error(w, "File not found", http.StatusNotFound)
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+		if !scrubber.runNow() {
+			http.Error(w, "An integrity check is already running", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// scrubPageHandler 处理 /scrub，向管理员展示发现的问题和最近的检查；POST 立即检查，或带 accept 时接受文件的当前内容
+func scrubPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if scrubber == nil {
+		http.Error(w, "Integrity checks are not configured (-scrub-interval)", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if p := r.FormValue("accept"); p != "" {
+			if err := scrubber.accept(r.Context(), cleanName(p)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			scrubber.runNow()
+		}
+		http.Redirect(w, r, "/scrub", http.StatusSeeOther)
+		return
+	}
+	st := scrubber.status()
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Integrity</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Integrity</h1>
+`)
+	fmt.Fprintf(&sb, "    <p>All files are re-read and compared with their recorded SHA-256 every %s. <a href=\"/stats\">Storage</a> | <a href=\"/\">&larr; Back</a></p>\n", st.Interval)
+	switch {
+	case st.Running != nil:
+		fmt.Fprintf(&sb, "    <p>Check running since %s: %d files, %s read. <a href=\"/jobs?id=%s\">Progress</a> | <a href=\"/scrub\">Refresh</a></p>\n",
+			st.Running.Started.Local().Format("15:04"), st.Running.Files, formatSize(st.Running.Bytes), st.Job)
+	case st.Job != "":
+		fmt.Fprintf(&sb, "    <p>Check waiting for a free job slot. <a href=\"/jobs?id=%s\">Progress</a></p>\n", st.Job)
+	default:
+		fmt.Fprintf(&sb, "    <form method=\"post\" action=\"/scrub\">Next check at %s. <button type=\"submit\">Check now</button></form>\n",
+			st.Next.Local().Format("2006-01-02 15:04"))
+	}
+	sb.WriteString("    <h2>Problems</h2>\n")
+	writeScrubProblems(&sb, st.Problems, true)
+	sb.WriteString("    <h2>Recent checks</h2>\n    <table>\n        <tr><th>Started</th><th>Duration</th><th>Files</th><th>Verified</th><th>New</th><th>Read</th><th>Result</th></tr>\n")
+	for _, run := range st.Runs {
+		result := fmt.Sprintf("%d problems", run.Problems)
+		if run.Error != "" {
+			result += ", stopped: " + run.Error
+		}
+		duration := ""
+		if run.Finished != nil {
+			duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+		}
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td><td>%d</td><td>%s</td><td>%s</td></tr>\n",
+			run.Started.Local().Format("2006-01-02 15:04"), duration, run.Files, run.Verified, run.Recorded, formatSize(run.Bytes), html.EscapeString(result))
+	}
+	sb.WriteString(`    </table>
+</body>
+</html>`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// writeScrubProblems 写出问题表格；actions 为 true 时每行带“接受”按钮
+func writeScrubProblems(sb *strings.Builder, problems []scrubProblem, actions bool) {
+	if len(problems) == 0 {
+		sb.WriteString("    <p>No problems found.</p>\n")
+		return
+	}
+	sb.WriteString("    <table>\n        <tr><th>File</th><th>Problem</th><th>Size</th><th>Modified</th><th>Detected</th><th></th></tr>\n")
+	for _, p := range problems {
+		accept := ""
+		if actions {
+			accept = fmt.Sprintf(`<form method="post" action="/scrub"><input type="hidden" name="accept" value="%s"><button type="submit">Accept current content</button></form>`, html.EscapeString(p.Path))
+		}
+		fmt.Fprintf(sb, "        <tr><td><a href=\"/download?path=%s\">%s</a></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			url.QueryEscape(p.Path), html.EscapeString(p.Path), p.Kind, formatSize(p.Size), p.ModTime.Local().Format("2006-01-02 15:04"),
+			p.Detected.Local().Format("2006-01-02 15:04"), accept)
+	}
+	sb.WriteString("    </table>\n")
+}
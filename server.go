@@ -0,0 +1,339 @@
+package fileserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// logger 是服务器使用的日志记录器，可通过 Logger 选项替换
+var logger = log.Default()
+
+// Server 是可嵌入其他 Go 程序的文件服务器，实现 http.Handler
+// 配置目前保存在包级变量中，同一进程中只应创建一个 Server
+type Server struct {
+	handler http.Handler
+}
+
+// Option 是 New 的配置选项
+type Option func(*Server) error
+
+// LimitConfig 是请求大小和存储增长的限制
+type LimitConfig struct {
+	// MaxUploadSize 是单个上传请求的最大字节数，0 表示不限制
+	MaxUploadSize int64
+	// MinFreeSpace 是接受上传后磁盘上至少要保留的字节数
+	MinFreeSpace int64
+	// MaxDirEntries 是单个目录中的最大条目数，0 表示不限制
+	MaxDirEntries int
+	// MaxEntries 是服务目录和挂载点中文件与目录的最大总数，0 表示不限制
+	MaxEntries int64
+}
+
+// limits 是当前生效的限制
+var limits LimitConfig
+
+// Dir 设置提供服务的目录，默认为当前目录
+func Dir(path string) Option {
+	return func(*Server) error {
+		uploadDir = path
+		return nil
+	}
+}
+
+// Auth 添加一个账户并启用 HTTP Basic 认证，可以多次使用
+func Auth(name, password string, admin bool) Option {
+	return func(*Server) error {
+		v := name + ":" + password
+		if admin {
+			v += ":admin"
+		}
+		return accounts.Set(v)
+	}
+}
+
+// UserHomes 将普通用户限制在各自的 <dir>/<user>/ 目录中，需要至少一个账户
+func UserHomes() Option {
+	return func(*Server) error {
+		userHomes = true
+		return nil
+	}
+}
+
+// Mount 将额外目录以 alias 为名挂载为顶层文件夹
+func Mount(alias, dir string, readOnly bool) Option {
+	return func(*Server) error {
+		v := alias + "=" + dir
+		if readOnly {
+			v += ",ro"
+		}
+		return mounts.Set(v)
+	}
+}
+
+// TempDir 设置上传和解压的暂存目录，默认为 <dir>/.fileserver/tmp；服务器会将其设为进程的临时目录
+func TempDir(path string) Option {
+	return func(*Server) error {
+		tmpDir = path
+		return nil
+	}
+}
+
+// Permissions 设置上传的文件和新建目录的权限位，为 0 时保持系统默认
+func Permissions(file, dir os.FileMode) Option {
+	return func(*Server) error {
+		fileMode = permFlag{mode: file.Perm(), set: file != 0}
+		dirMode = permFlag{mode: dir.Perm(), set: dir != 0}
+		return nil
+	}
+}
+
+// WebDAV 在 /dav/ 提供 WebDAV 服务
+func WebDAV() Option {
+	return func(*Server) error {
+		enableWebDAV = true
+		return nil
+	}
+}
+
+// Watch 监视服务目录和挂载目录中直接在磁盘上发生的变化，通过 /api/v1/events 报告
+func Watch() Option {
+	return func(*Server) error {
+		watchEnabled = true
+		return nil
+	}
+}
+
+// Limits 设置请求大小限制
+func Limits(l LimitConfig) Option {
+	return func(*Server) error {
+		limits = l
+		return nil
+	}
+}
+
+// Logger 设置日志记录器，默认为 log.Default()
+func Logger(l *log.Logger) Option {
+	return func(*Server) error {
+		logger = l
+		return nil
+	}
+}
+
+// New 根据选项创建服务器，准备存储目录、挂载点、通知和下载统计
+func New(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if uploadDir == "" {
+		uploadDir = "."
+	}
+	if userHomes && !authEnabled() {
+		return nil, fmt.Errorf("user homes require at least one account")
+	}
+
+	// 创建上传目录，如果不存在
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := setupOwnership(); err != nil {
+		return nil, err
+	}
+	if err := setupTempDir(); err != nil {
+		return nil, err
+	}
+	if err := setupAccessRules(); err != nil {
+		return nil, err
+	}
+	if err := setupOrganizeUploads(); err != nil {
+		return nil, err
+	}
+	if err := setupMIMETypes(); err != nil {
+		return nil, err
+	}
+	if err := loadErrorPages(); err != nil {
+		return nil, err
+	}
+	if err := setupTracing(); err != nil {
+		return nil, err
+	}
+	if err := setupErrorReporting(); err != nil {
+		return nil, err
+	}
+	if err := setupJobs(); err != nil {
+		return nil, err
+	}
+	rootStorage = newLocalStorage(uploadDir)
+	if err := checkMounts(); err != nil {
+		return nil, err
+	}
+	if err := setupEncryption(); err != nil {
+		return nil, err
+	}
+	if err := setupCAS(); err != nil {
+		return nil, err
+	}
+	for _, mt := range mounts {
+		logger.Printf("Mounted %s at /%s (read-only: %v)", mt.dir, mt.alias, mt.readOnly)
+	}
+
+	setupNotifiers()
+	if err := openMetaDB(); err != nil {
+		return nil, err
+	}
+	onShutdown(flushMetaDB)
+	if err := setupURLSigning(); err != nil {
+		return nil, err
+	}
+	stats = loadDownloadStats()
+	checksums = loadChecksumCache()
+	shares = loadShares()
+	transfers = loadTransferStats()
+	tags = loadTags()
+	favorites = loadFavorites()
+	clipboard = loadClipboard()
+	storageUsage = loadStorageStats()
+	uploadTokens = loadUploadTokens()
+	locks = loadLocks()
+	startJanitor()
+	if err := startBackups(); err != nil {
+		return nil, err
+	}
+	if err := startScrub(); err != nil {
+		return nil, err
+	}
+	if watchEnabled && !watching {
+		if err := startWatcher(allVolumes()); err != nil {
+			logger.Printf("Error starting file watcher: %v", err)
+		} else {
+			watching = true
+		}
+	}
+	if err := startReplica(); err != nil {
+		return nil, err
+	}
+
+	// 注册处理函数
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", listHandler)
+	mux.HandleFunc("/upload", uploadHandler)
+	mux.HandleFunc("/download", downloadHandler)
+	mux.HandleFunc("/put/", putHandler)
+	mux.HandleFunc("/raw/", rawHandler)
+	mux.HandleFunc("/fetch", fetchPageHandler)
+	mux.HandleFunc("/jobs", jobsPageHandler)
+	mux.HandleFunc("/archive", archiveFormHandler)
+	mux.HandleFunc("/feed.xml", feedHandler)
+	mux.HandleFunc("/info", infoPageHandler)
+	mux.HandleFunc("/thumb", thumbHandler)
+	mux.HandleFunc("/play", playPageHandler)
+	mux.HandleFunc("/hls/", hlsHandler)
+	mux.HandleFunc("/subtitle", subtitleHandler)
+	mux.HandleFunc("/slideshow", slideshowHandler)
+	mux.HandleFunc("/paste", pasteHandler)
+	mux.HandleFunc("/p/", pasteViewHandler)
+	mux.HandleFunc("/view", viewHandler)
+	mux.HandleFunc("/compare", compareHandler)
+	mux.HandleFunc("/clipboard", clipboardPageHandler)
+	mux.HandleFunc("/tags", tagsPageHandler)
+	mux.HandleFunc("/favorites", favoritesPageHandler)
+	mux.HandleFunc("/lock", lockFormHandler)
+	mux.HandleFunc("/favicon.ico", assetHandler)
+	mux.HandleFunc("/assets/", assetHandler)
+	mux.HandleFunc("/stats", storageStatsPageHandler)
+	mux.HandleFunc("/transfers", transfersPageHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/export", exportHandler)
+	mux.HandleFunc("/import", importHandler)
+	mux.HandleFunc("/backup", backupPageHandler)
+	mux.HandleFunc("/scrub", scrubPageHandler)
+	mux.HandleFunc("/share", shareFormHandler)
+	mux.HandleFunc("/share/", sharedDownloadHandler)
+	mux.HandleFunc("/s/", sharedDownloadHandler)
+	mux.HandleFunc("/drop/", dropHandler)
+	if enableWebDAV {
+		mux.Handle(davPrefix+"/", newDAVHandler())
+		logger.Printf("WebDAV enabled at %s/", davPrefix)
+	}
+	mux.HandleFunc("/api/openapi.json", openAPIHandler)
+	mux.HandleFunc("/api/docs", apiDocsHandler)
+	mux.HandleFunc("/api/v1/version", versionHandler)
+	mux.HandleFunc("/api/v1/list", apiListHandler)
+	mux.HandleFunc("/api/v1/delete", apiDeleteHandler)
+	mux.HandleFunc("/api/v1/batch", apiBatchHandler)
+	mux.HandleFunc("/api/v1/append", apiAppendHandler)
+	mux.HandleFunc("/api/v1/downloads/top", topDownloadsHandler)
+	mux.HandleFunc("/api/v1/graphql", graphqlHandler)
+	mux.HandleFunc("/api/v1/shares", apiSharesHandler)
+	mux.HandleFunc("/api/v1/sign", apiSignHandler)
+	mux.HandleFunc("/api/v1/upload-tokens", apiUploadTokensHandler)
+	mux.HandleFunc("/api/v1/fetch", apiFetchHandler)
+	mux.HandleFunc("/api/v1/jobs", apiJobsHandler)
+	mux.HandleFunc("/api/v1/delta", apiDeltaHandler)
+	mux.HandleFunc("/api/v1/archive", apiArchiveHandler)
+	mux.HandleFunc("/api/v1/events", apiEventsHandler)
+	mux.HandleFunc("/api/v1/upload/", apiUploadProgressHandler)
+	mux.HandleFunc("/api/v1/duplicates", apiDuplicatesHandler)
+	mux.HandleFunc("/api/v1/stats", apiStorageStatsHandler)
+	mux.HandleFunc("/api/v1/transfers", apiTransfersHandler)
+	mux.HandleFunc("/api/v1/backup", apiBackupHandler)
+	mux.HandleFunc("/api/v1/scrub", apiScrubHandler)
+	mux.HandleFunc("/api/v1/replication", apiReplicationHandler)
+	mux.HandleFunc("/api/v1/cas", apiCASHandler)
+	mux.HandleFunc("/api/v1/metadata", apiMetadataHandler)
+	mux.HandleFunc("/api/v1/clipboard", apiClipboardHandler)
+	mux.HandleFunc("/api/v1/tags", apiTagsHandler)
+	mux.HandleFunc("/api/v1/favorites", apiFavoritesHandler)
+	mux.HandleFunc("/api/v1/locks", apiLocksHandler)
+	mux.HandleFunc("/api/v1/audit", apiAuditHandler)
+	routes = mux
+	s.handler = requestIDMiddleware(tracingMiddleware(corsMiddleware(errorPageMiddleware(authMiddleware(errorReportMiddleware(transferMiddleware(mux)))))))
+	return s, nil
+}
+
+// ServeHTTP 实现 http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// byteSize 是可带 K/M/G/T 后缀的字节数命令行参数
+type byteSize int64
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return "0"
+	}
+	return formatSize(int64(*b))
+}
+
+func (b *byteSize) Set(v string) error {
+	v = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(v), "B"))
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	*b = byteSize(n * float64(mult))
+	return nil
+}
@@ -0,0 +1,109 @@
+package fileserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// serviceName 是安装的 Windows 服务名
+const serviceName = "fileserver"
+
+// daemonMode、pidFile 和 logFile 由 -daemon、-pid-file 和 -log-file 设置
+var (
+	daemonMode bool
+	pidFile    string
+	logFile    string
+)
+
+// serviceCommands 是安装和控制在后台运行的服务器的子命令，实现见各平台的 service_*.go
+var serviceCommands = map[string]func(args []string) error{
+	"install":   cmdInstall,
+	"uninstall": cmdUninstall,
+	"start":     cmdStart,
+	"stop":      cmdStop,
+}
+
+// defaultPIDFile 和 defaultLogFile 是后台运行时未指定 -pid-file 和 -log-file 的默认位置
+func defaultPIDFile(dir string) string {
+	return filepath.Join(dir, metaDirName, "fileserver.pid")
+}
+
+func defaultLogFile(dir string) string {
+	return filepath.Join(dir, metaDirName, "fileserver.log")
+}
+
+// setupBackground 处理 -daemon、-log-file 和 -pid-file，并在收到退出信号或服务停止请求时运行清理函数。
+// 以 -daemon 启动时当前进程在后台启动副本后退出
+func setupBackground() error {
+	background := daemonMode || runningAsService()
+	if daemonMode {
+		if err := daemonize(); err != nil {
+			return err
+		}
+	}
+	if logFile == "" && background {
+		logFile = defaultLogFile(uploadDir)
+	}
+	if logFile != "" {
+		f, err := openLogFile(logFile)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	}
+	if pidFile == "" && background {
+		pidFile = defaultPIDFile(uploadDir)
+	}
+	if pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			return err
+		}
+		onShutdown(func() { os.Remove(pidFile) })
+	}
+	handleSignals()
+	startService()
+	return nil
+}
+
+// openLogFile 以追加方式打开日志文件，必要时创建所在目录
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("log file: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("log file: %v", err)
+	}
+	return f, nil
+}
+
+// writePIDFile 写入当前进程号；文件中的进程仍在运行时返回错误，防止重复启动
+func writePIDFile(path string) error {
+	if pid, err := readPIDFile(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("fileserver is already running (pid %d, %s)", pid, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("pid file: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("pid file: %v", err)
+	}
+	return nil
+}
+
+// readPIDFile 读取 PID 文件中的进程号
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package fileserver
+
+import "errors"
+
+var errNoBackground = errors.New("running in the background is not supported on this platform")
+
+func daemonize() error                 { return errNoBackground }
+func runningAsService() bool           { return false }
+func startService()                    {}
+func processAlive(pid int) bool        { return false }
+func cmdInstall(args []string) error   { return errNoBackground }
+func cmdUninstall(args []string) error { return errNoBackground }
+func cmdStart(args []string) error     { return errNoBackground }
+func cmdStop(args []string) error      { return errNoBackground }
@@ -0,0 +1,117 @@
+//go:build unix
+
+package fileserver
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// daemonEnv 标记由 -daemon 在后台启动的副本，避免它再次转入后台
+const daemonEnv = "FILESERVER_DAEMON"
+
+// daemonize 以相同参数在新会话中启动当前程序的副本，输出写入日志文件，然后退出当前进程；
+// 在副本中直接返回
+func daemonize() error {
+	if os.Getenv(daemonEnv) == "1" {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	path := logFile
+	if path == "" {
+		path = defaultLogFile(uploadDir)
+	}
+	out, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// 副本启动失败（如端口或 PID 文件冲突）时通常很快退出，稍等片刻以便报告
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("background server exited (%v), see %s", err, path)
+	case <-time.After(time.Second):
+	}
+	fmt.Printf("fileserver started in the background (pid %d), logging to %s\n", cmd.Process.Pid, path)
+	os.Exit(0)
+	return nil
+}
+
+// runningAsService 在 Unix 上总是返回 false，后台运行由 -daemon 或 systemd 等服务管理器负责
+func runningAsService() bool {
+	return false
+}
+
+func startService() {}
+
+// processAlive 判断进程是否存在
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func cmdInstall(args []string) error {
+	return errors.New("Windows services are not available on this platform; use \"fileserver start\" or a systemd unit (see README)")
+}
+
+func cmdUninstall(args []string) error {
+	return cmdInstall(args)
+}
+
+// cmdStart 在后台启动服务器，等同于 fileserver serve -daemon
+func cmdStart(args []string) error {
+	serve(append([]string{"-daemon"}, args...))
+	return nil
+}
+
+// cmdStop 向 PID 文件中的进程发送 SIGTERM 并等待它退出
+func cmdStop(args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory served by the server, used to find the default pid file")
+	path := fs.String("pid-file", "", "PID file of the server (default <dir>/.fileserver/fileserver.pid)")
+	fs.Parse(args)
+	if *path == "" {
+		*path = defaultPIDFile(*dir)
+	}
+	pid, err := readPIDFile(*path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("fileserver is not running (no pid file %s)", *path)
+	}
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		os.Remove(*path)
+		return fmt.Errorf("fileserver is not running (stale pid %d in %s)", pid, *path)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !processAlive(pid) {
+			fmt.Printf("fileserver (pid %d) stopped\n", pid)
+			return nil
+		}
+	}
+	return fmt.Errorf("fileserver (pid %d) did not exit within 10s", pid)
+}
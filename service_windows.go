@@ -0,0 +1,198 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func daemonize() error {
+	return errors.New("-daemon is not supported on Windows; use \"fileserver install\" to run as a service")
+}
+
+// runningAsService 判断进程是否由 Windows 服务管理器启动
+func runningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// startService 作为 Windows 服务运行时向服务管理器报告状态，收到停止请求时运行清理函数并退出
+func startService() {
+	if !runningAsService() {
+		return
+	}
+	go func() {
+		if err := svc.Run(serviceName, serviceHandler{}); err != nil {
+			logger.Printf("Windows service error: %v", err)
+		}
+		os.Exit(0)
+	}()
+}
+
+type serviceHandler struct{}
+
+func (serviceHandler) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for c := range req {
+		switch c.Cmd {
+		case svc.Interrogate:
+			status <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			logger.Printf("Stopping service")
+			runShutdownHooks()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// processAlive 判断进程是否存在
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == 259 // STILL_ACTIVE
+}
+
+// absDirArgs 将参数中的 -dir 转换为绝对路径：服务的工作目录是系统目录，未指定时使用当前目录
+func absDirArgs(args []string) []string {
+	out := make([]string, 0, len(args)+2)
+	found := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "dir" {
+			out = append(out, a)
+			continue
+		}
+		found = true
+		if !hasValue {
+			if i+1 >= len(args) {
+				out = append(out, a)
+				continue
+			}
+			i++
+			value = args[i]
+		}
+		abs, err := filepath.Abs(value)
+		if err != nil {
+			abs = value
+		}
+		out = append(out, "-dir", abs)
+	}
+	if !found {
+		wd, _ := os.Getwd()
+		out = append(out, "-dir", wd)
+	}
+	return out
+}
+
+// cmdInstall 将当前程序安装为开机自动启动的 Windows 服务，args 为服务器参数
+func cmdInstall(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	args = append([]string{"serve"}, absDirArgs(args)...)
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "File Server",
+		Description: "Serves files over HTTP for upload and download",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Printf("Installed service %s: %s %s\n", serviceName, exe, strings.Join(args, " "))
+	fmt.Println(`Run "fileserver start" to start it now.`)
+	return nil
+}
+
+// openService 连接服务管理器并打开已安装的服务
+func openService() (*mgr.Mgr, *mgr.Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		m.Disconnect()
+		return nil, nil, fmt.Errorf("service %s is not installed: %v", serviceName, err)
+	}
+	return m, s, nil
+}
+
+func cmdUninstall(args []string) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	if st, err := s.Query(); err == nil && st.State != svc.Stopped {
+		s.Control(svc.Stop)
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed service %s\n", serviceName)
+	return nil
+}
+
+func cmdStart(args []string) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Started service %s\n", serviceName)
+	return nil
+}
+
+// cmdStop 停止服务并等待它退出
+func cmdStop(args []string) error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	st, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(10 * time.Second); st.State != svc.Stopped; time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within 10s", serviceName)
+		}
+		if st, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Stopped service %s\n", serviceName)
+	return nil
+}
@@ -0,0 +1,799 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP 服务配置
+var (
+	sftpAddr    string
+	sftpHostKey string
+)
+
+// startSFTPServer 在 -sftp-addr 上启动 SSH 服务，仅提供 sftp 子系统
+func startSFTPServer() error {
+	signer, err := loadOrCreateHostKey(sftpHostKey)
+	if err != nil {
+		return err
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if !authEnabled() {
+				return nil, nil
+			}
+			admin, ok := checkLogin(meta.User(), string(password))
+			if !ok {
+				logger.Printf("SFTP login failed for %q from %s", meta.User(), meta.RemoteAddr())
+				return nil, ErrInvalidCredentials
+			}
+			perms := &ssh.Permissions{Extensions: map[string]string{}}
+			if admin {
+				perms.Extensions["admin"] = "yes"
+			}
+			return perms, nil
+		},
+		NoClientAuth:  !authEnabled(),
+		ServerVersion: "SSH-2.0-fileserver_" + version,
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", sftpAddr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("SFTP server listening on %s (host key %s)", sftpAddr, ssh.FingerprintSHA256(signer.PublicKey()))
+	go func() {
+		<-listenersReady
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				logger.Printf("SFTP accept error: %v", err)
+				continue
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return nil
+}
+
+// loadOrCreateHostKey 读取主机密钥，不存在时生成新的 Ed25519 密钥并保存
+func loadOrCreateHostKey(keyPath string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	logger.Printf("Generating SFTP host key %s", keyPath)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "fileserver host key")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		logger.Printf("SSH handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+	logger.Printf("SFTP user %q connected from %s", sconn.User(), sconn.RemoteAddr())
+	go ssh.DiscardRequests(reqs)
+
+	ctx := context.Background()
+	if authEnabled() {
+		ctx = withUser(ctx, sconn.User(), sconn.Permissions != nil && sconn.Permissions.Extensions["admin"] == "yes")
+	}
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range requests {
+				if req.Type == "subsystem" && len(req.Payload) >= 4 && string(req.Payload[4:]) == "sftp" {
+					req.Reply(true, nil)
+					srv := &sftpSession{ctx: ctx, rw: ch, handles: map[string]*sftpHandle{}}
+					if err := srv.serve(); err != nil && err != io.EOF {
+						logger.Printf("SFTP session error: %v", err)
+					}
+					srv.closeAll()
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					return
+				}
+				// 不提供 shell 和命令执行
+				req.Reply(false, nil)
+			}
+		}()
+	}
+}
+
+// SFTP 协议（版本 3）常量
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpExtended = 200
+
+	sftpStatusMsg = 101
+	sftpHandleMsg = 102
+	sftpDataMsg   = 103
+	sftpNameMsg   = 104
+	sftpAttrsMsg  = 105
+
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+	sftpFailure          = 4
+	sftpBadMessage       = 5
+	sftpOpUnsupported    = 8
+
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagAppend = 0x04
+	sftpFlagCreat  = 0x08
+	sftpFlagTrunc  = 0x10
+	sftpFlagExcl   = 0x20
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+
+	sftpMaxPacket = 256 * 1024
+)
+
+// sftpHandle 是打开的文件或目录；写入的内容先保存到 name 旁的暂存文件 tmp，关闭时再替换 name
+type sftpHandle struct {
+	vol     volume
+	name    string
+	tmp     string
+	reader  storageFile
+	writer  io.WriteCloser
+	offset  int64
+	hash    hash.Hash
+	ordered bool
+	entries []fs.FileInfo
+	listed  bool
+	created bool
+}
+
+// sftpSession 处理一个 sftp 子系统通道上的请求
+type sftpSession struct {
+	ctx     context.Context
+	rw      io.ReadWriter
+	mu      sync.Mutex
+	handles map[string]*sftpHandle
+	nextID  int
+}
+
+// sftpBuf 是解析请求负载的辅助类型
+type sftpBuf struct {
+	b   []byte
+	err error
+}
+
+func (b *sftpBuf) uint32() uint32 {
+	if len(b.b) < 4 {
+		b.err = errors.New("short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.b)
+	b.b = b.b[4:]
+	return v
+}
+
+func (b *sftpBuf) uint64() uint64 {
+	if len(b.b) < 8 {
+		b.err = errors.New("short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(b.b)
+	b.b = b.b[8:]
+	return v
+}
+
+func (b *sftpBuf) string() string {
+	n := b.uint32()
+	if b.err != nil || uint32(len(b.b)) < n {
+		b.err = errors.New("short packet")
+		return ""
+	}
+	s := string(b.b[:n])
+	b.b = b.b[n:]
+	return s
+}
+
+// skipAttrs 跳过请求中的文件属性
+func (b *sftpBuf) skipAttrs() {
+	flags := b.uint32()
+	if flags&sftpAttrSize != 0 {
+		b.uint64()
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		b.uint32()
+	}
+	if flags&sftpAttrACModTime != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	if flags&sftpAttrExtended != 0 {
+		n := b.uint32()
+		for i := uint32(0); i < n && b.err == nil; i++ {
+			b.string()
+			b.string()
+		}
+	}
+}
+
+// sftpOut 是构造响应的辅助类型
+type sftpOut []byte
+
+func (o *sftpOut) byte(v byte) { *o = append(*o, v) }
+
+func (o *sftpOut) uint32(v uint32) { *o = binary.BigEndian.AppendUint32(*o, v) }
+
+func (o *sftpOut) uint64(v uint64) { *o = binary.BigEndian.AppendUint64(*o, v) }
+
+func (o *sftpOut) string(s string) {
+	o.uint32(uint32(len(s)))
+	*o = append(*o, s...)
+}
+
+func (o *sftpOut) attrs(info fs.FileInfo) {
+	mode := uint32(info.Mode().Perm())
+	if info.IsDir() {
+		mode |= 0040000
+	} else {
+		mode |= 0100000
+	}
+	o.uint32(sftpAttrSize | sftpAttrPermissions | sftpAttrACModTime)
+	o.uint64(uint64(info.Size()))
+	o.uint32(mode)
+	mtime := uint32(info.ModTime().Unix())
+	o.uint32(mtime)
+	o.uint32(mtime)
+}
+
+func (s *sftpSession) send(out sftpOut) error {
+	pkt := make([]byte, 4, 4+len(out))
+	binary.BigEndian.PutUint32(pkt, uint32(len(out)))
+	_, err := s.rw.Write(append(pkt, out...))
+	return err
+}
+
+func (s *sftpSession) status(id uint32, code uint32, msg string) error {
+	var out sftpOut
+	out.byte(sftpStatusMsg)
+	out.uint32(id)
+	out.uint32(code)
+	out.string(msg)
+	out.string("")
+	return s.send(out)
+}
+
+// statusFor 将错误转换为 SFTP 状态码
+func (s *sftpSession) statusFor(id uint32, err error) error {
+	switch {
+	case err == nil:
+		return s.status(id, sftpOK, "OK")
+	case errors.Is(err, fs.ErrNotExist):
+		return s.status(id, sftpNoSuchFile, "No such file")
+	case errors.Is(err, fs.ErrPermission):
+		return s.status(id, sftpPermissionDenied, "Permission denied")
+	default:
+		return s.status(id, sftpFailure, err.Error())
+	}
+}
+
+func (s *sftpSession) serve() error {
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(s.rw, lenBuf[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		if n == 0 || n > sftpMaxPacket {
+			return fmt.Errorf("invalid packet length %d", n)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(s.rw, pkt); err != nil {
+			return err
+		}
+		if err := s.dispatch(pkt[0], &sftpBuf{b: pkt[1:]}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *sftpSession) dispatch(typ byte, b *sftpBuf) error {
+	if typ == sftpInit {
+		var out sftpOut
+		out.byte(sftpVersion)
+		out.uint32(3)
+		out.string("posix-rename@openssh.com")
+		out.string("1")
+		return s.send(out)
+	}
+
+	id := b.uint32()
+	if b.err != nil {
+		return b.err
+	}
+	switch typ {
+	case sftpRealpath:
+		p := path.Clean("/" + b.string())
+		var out sftpOut
+		out.byte(sftpNameMsg)
+		out.uint32(id)
+		out.uint32(1)
+		out.string(p)
+		out.string(p)
+		out.uint32(0)
+		return s.send(out)
+	case sftpStat, sftpLstat:
+		vol, rel, err := resolveVirtual(s.ctx, b.string())
+		if err != nil {
+			return s.statusFor(id, err)
+		}
+		info, err := vol.store.Stat(rel)
+		if err != nil {
+			return s.statusFor(id, err)
+		}
+		return s.sendAttrs(id, info)
+	case sftpFstat:
+		h := s.handle(b.string())
+		if h == nil {
+			return s.status(id, sftpFailure, "Invalid handle")
+		}
+		name := h.name
+		if h.writer != nil {
+			name = h.tmp
+		}
+		info, err := h.vol.store.Stat(name)
+		if err != nil {
+			return s.statusFor(id, err)
+		}
+		return s.sendAttrs(id, info)
+	case sftpSetstat, sftpFsetstat:
+		// 不支持修改权限和时间，静默接受以兼容 sftp -p 等客户端
+		return s.status(id, sftpOK, "OK")
+	case sftpOpendir:
+		return s.openDir(id, b.string())
+	case sftpReaddir:
+		return s.readDir(id, b.string())
+	case sftpOpen:
+		name := b.string()
+		flags := b.uint32()
+		b.skipAttrs()
+		if b.err != nil {
+			return s.status(id, sftpBadMessage, "Bad message")
+		}
+		return s.open(id, name, flags)
+	case sftpRead:
+		handle := b.string()
+		offset := b.uint64()
+		length := b.uint32()
+		return s.read(id, handle, int64(offset), length)
+	case sftpWrite:
+		handle := b.string()
+		offset := b.uint64()
+		data := b.string()
+		if b.err != nil {
+			return s.status(id, sftpBadMessage, "Bad message")
+		}
+		return s.write(id, handle, int64(offset), []byte(data))
+	case sftpClose:
+		return s.closeHandle(id, b.string())
+	case sftpRemove:
+		return s.remove(id, b.string(), false)
+	case sftpRmdir:
+		return s.remove(id, b.string(), true)
+	case sftpMkdir:
+		return s.mkdir(id, b.string())
+	case sftpRename:
+		oldName := b.string()
+		return s.rename(id, oldName, b.string())
+	case sftpExtended:
+		if b.string() == "posix-rename@openssh.com" {
+			oldName := b.string()
+			return s.rename(id, oldName, b.string())
+		}
+		return s.status(id, sftpOpUnsupported, "Unsupported extension")
+	default:
+		return s.status(id, sftpOpUnsupported, "Unsupported operation")
+	}
+}
+
+func (s *sftpSession) sendAttrs(id uint32, info fs.FileInfo) error {
+	var out sftpOut
+	out.byte(sftpAttrsMsg)
+	out.uint32(id)
+	out.attrs(info)
+	return s.send(out)
+}
+
+func (s *sftpSession) addHandle(h *sftpHandle) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	key := strconv.Itoa(s.nextID)
+	s.handles[key] = h
+	return key
+}
+
+func (s *sftpSession) handle(key string) *sftpHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handles[key]
+}
+
+func (s *sftpSession) sendHandle(id uint32, key string) error {
+	var out sftpOut
+	out.byte(sftpHandleMsg)
+	out.uint32(id)
+	out.string(key)
+	return s.send(out)
+}
+
+func (s *sftpSession) openDir(id uint32, name string) error {
+	vol, rel, err := resolveVirtual(s.ctx, name)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if !info.IsDir() {
+		return s.status(id, sftpFailure, "Not a directory")
+	}
+	entries, err := listVirtual(s.ctx, vol, rel, cleanName(name) == "")
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	return s.sendHandle(id, s.addHandle(&sftpHandle{vol: vol, name: rel, entries: entries}))
+}
+
+func (s *sftpSession) readDir(id uint32, key string) error {
+	h := s.handle(key)
+	if h == nil {
+		return s.status(id, sftpFailure, "Invalid handle")
+	}
+	if len(h.entries) == 0 {
+		return s.status(id, sftpEOF, "End of directory")
+	}
+	batch := h.entries
+	if len(batch) > 100 {
+		batch = batch[:100]
+	}
+	h.entries = h.entries[len(batch):]
+
+	var out sftpOut
+	out.byte(sftpNameMsg)
+	out.uint32(id)
+	out.uint32(uint32(len(batch)))
+	for _, info := range batch {
+		out.string(info.Name())
+		out.string(ftpListLine(info))
+		out.attrs(info)
+	}
+	return s.send(out)
+}
+
+func (s *sftpSession) open(id uint32, name string, flags uint32) error {
+	vol, rel, err := resolveVirtual(s.ctx, name)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if flags&(sftpFlagWrite|sftpFlagAppend|sftpFlagCreat|sftpFlagTrunc) == 0 {
+		info, err := vol.store.Stat(rel)
+		if err != nil {
+			return s.statusFor(id, err)
+		}
+		if info.IsDir() {
+			return s.status(id, sftpFailure, "Is a directory")
+		}
+		f, err := vol.store.Open(rel)
+		if err != nil {
+			return s.statusFor(id, err)
+		}
+		return s.sendHandle(id, s.addHandle(&sftpHandle{vol: vol, name: rel, reader: f}))
+	}
+
+	if vol.readOnly || rel == "" {
+		return s.status(id, sftpPermissionDenied, "Permission denied: read-only")
+	}
+	info, statErr := vol.store.Stat(rel)
+	switch {
+	case statErr == nil && info.IsDir():
+		return s.status(id, sftpFailure, "Is a directory")
+	case statErr == nil && flags&sftpFlagExcl != 0:
+		return s.status(id, sftpFailure, "File exists")
+	case statErr != nil && flags&sftpFlagCreat == 0:
+		return s.statusFor(id, statErr)
+	case statErr == nil && (flags&sftpFlagAppend != 0 || flags&sftpFlagTrunc == 0) && info.Size() > 0:
+		// 存储接口只支持整体写入，不能原地修改已有文件
+		return s.status(id, sftpOpUnsupported, "Modifying existing files in place is not supported")
+	}
+	created, err := checkNewEntry(vol, rel, true)
+	if err != nil {
+		return s.status(id, sftpFailure, err.Error())
+	}
+	// 先写入同目录下的隐藏临时文件，关闭时再替换目标，上传中断或被拒绝时原有文件保持不变
+	tmp, err := stagingName(rel, "sftp")
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	w, err := vol.store.Create(tmp)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	return s.sendHandle(id, s.addHandle(&sftpHandle{vol: vol, name: rel, tmp: tmp, writer: w, hash: sha256.New(), ordered: true, created: created}))
+}
+
+func (s *sftpSession) read(id uint32, key string, offset int64, length uint32) error {
+	h := s.handle(key)
+	if h == nil || h.reader == nil {
+		return s.status(id, sftpFailure, "Invalid handle")
+	}
+	if length > 64*1024 {
+		length = 64 * 1024
+	}
+	buf := make([]byte, length)
+	var n int
+	var err error
+	if ra, ok := h.reader.(io.ReaderAt); ok {
+		n, err = ra.ReadAt(buf, offset)
+	} else {
+		if _, err = h.reader.Seek(offset, io.SeekStart); err == nil {
+			n, err = io.ReadFull(h.reader, buf)
+		}
+	}
+	if n == 0 {
+		if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+			return s.status(id, sftpEOF, "EOF")
+		}
+		return s.statusFor(id, err)
+	}
+	if offset == 0 {
+		stats.record(h.vol.virtual(h.name))
+	}
+	var out sftpOut
+	out.byte(sftpDataMsg)
+	out.uint32(id)
+	out.string(string(buf[:n]))
+	return s.send(out)
+}
+
+func (s *sftpSession) write(id uint32, key string, offset int64, data []byte) error {
+	h := s.handle(key)
+	if h == nil || h.writer == nil {
+		return s.status(id, sftpFailure, "Invalid handle")
+	}
+	var err error
+	if offset == h.offset {
+		_, err = h.writer.Write(data)
+		if h.ordered {
+			h.hash.Write(data)
+		}
+	} else if wa, ok := h.writer.(io.WriterAt); ok {
+		_, err = wa.WriteAt(data, offset)
+		h.ordered = false
+	} else {
+		return s.status(id, sftpOpUnsupported, "Non-sequential writes are not supported")
+	}
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if end := offset + int64(len(data)); end > h.offset {
+		if offset == h.offset {
+			h.offset = end
+		}
+	}
+	return s.status(id, sftpOK, "OK")
+}
+
+func (s *sftpSession) closeHandle(id uint32, key string) error {
+	s.mu.Lock()
+	h := s.handles[key]
+	delete(s.handles, key)
+	s.mu.Unlock()
+	if h == nil {
+		return s.status(id, sftpFailure, "Invalid handle")
+	}
+	if h.reader != nil {
+		h.reader.Close()
+	}
+	if h.writer == nil {
+		return s.status(id, sftpOK, "OK")
+	}
+	if err := h.writer.Close(); err != nil {
+		h.vol.store.Delete(h.tmp)
+		return s.statusFor(id, err)
+	}
+	if err := s.finishUpload(h); err != nil {
+		return s.status(id, sftpPermissionDenied, err.Error())
+	}
+	return s.status(id, sftpOK, "OK")
+}
+
+// finishUpload 对写入完成的暂存文件执行与表单上传相同的扫描和钩子，通过后替换目标并发送通知
+func (s *sftpSession) finishUpload(h *sftpHandle) error {
+	var ev fileEvent
+	err := commitStaged(h.vol.store, h.tmp, h.name, func(tmp string) error {
+		info, err := h.vol.store.Stat(tmp)
+		if err != nil {
+			return err
+		}
+		sum := h.hash
+		if !h.ordered {
+			// 存在乱序写入时重新计算校验和
+			sum = sha256.New()
+			f, err := h.vol.store.Open(tmp)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(sum, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		ev = fileEvent{Event: eventUpload, Path: h.vol.virtual(h.name), Size: info.Size(), User: contextUser(s.ctx), Checksum: hex.EncodeToString(sum.Sum(nil)), Time: time.Now().UTC()}
+		return acceptUpload(h.vol, tmp, ev)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("SFTP upload saved: %s", ev.Path)
+	completeUpload(h.vol, h.name, ev, h.created)
+	return nil
+}
+
+// closeAll 在会话结束时关闭未关闭的句柄，删除未完成上传的暂存文件
+func (s *sftpSession) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, h := range s.handles {
+		if h.reader != nil {
+			h.reader.Close()
+		}
+		if h.writer != nil {
+			h.writer.Close()
+			h.vol.store.Delete(h.tmp)
+		}
+		delete(s.handles, key)
+	}
+}
+
+func (s *sftpSession) remove(id uint32, name string, dir bool) error {
+	vol, rel, err := resolveVirtual(s.ctx, name)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if vol.readOnly || rel == "" {
+		return s.status(id, sftpPermissionDenied, "Permission denied")
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if info.IsDir() != dir {
+		return s.status(id, sftpFailure, "Wrong file type")
+	}
+	if dir {
+		if entries, err := vol.store.List(rel); err != nil || len(entries) > 0 {
+			return s.status(id, sftpFailure, "Directory not empty")
+		}
+	}
+	if err := vol.store.Delete(rel); err != nil {
+		return s.statusFor(id, err)
+	}
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(rel), User: contextUser(s.ctx)}
+	if !dir {
+		ev.Size = info.Size()
+	}
+	logger.Printf("SFTP deleted: %s", ev.Path)
+	emitEvent(ev)
+	return s.status(id, sftpOK, "OK")
+}
+
+func (s *sftpSession) mkdir(id uint32, name string) error {
+	vol, rel, err := resolveVirtual(s.ctx, name)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if vol.readOnly || rel == "" {
+		return s.status(id, sftpPermissionDenied, "Permission denied")
+	}
+	if _, err := vol.store.Stat(rel); err == nil {
+		return s.status(id, sftpFailure, "Already exists")
+	}
+	if _, err := checkNewEntry(vol, rel, false); err != nil {
+		return s.status(id, sftpFailure, err.Error())
+	}
+	if err := vol.store.Mkdir(rel); err != nil {
+		return s.statusFor(id, err)
+	}
+	countNewEntries(1)
+	return s.status(id, sftpOK, "OK")
+}
+
+func (s *sftpSession) rename(id uint32, oldName, newName string) error {
+	oldVol, oldRel, err := resolveVirtual(s.ctx, oldName)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	newVol, newRel, err := resolveVirtual(s.ctx, newName)
+	if err != nil {
+		return s.statusFor(id, err)
+	}
+	if oldVol.readOnly || newVol.readOnly || oldRel == "" || newRel == "" {
+		return s.status(id, sftpPermissionDenied, "Permission denied")
+	}
+	if oldVol.prefix != newVol.prefix {
+		return s.status(id, sftpFailure, errCrossVolume.Error())
+	}
+	return s.statusFor(id, renameEntry(oldVol, oldRel, newRel))
+}
@@ -0,0 +1,343 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// share 是公开分享链接，持有链接的人无需登录即可下载对应文件或目录
+// 每个分享同时有长令牌（/share/<token>）和便于口述、输入的短代码（/s/<code>）
+type share struct {
+	Token   string     `json:"token"`
+	Code    string     `json:"code"`
+	Path    string     `json:"path"`
+	User    string     `json:"user,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (sh *share) expired() bool {
+	return sh.Expires != nil && time.Now().After(*sh.Expires)
+}
+
+// shareStore 是分享令牌存储，保存在元数据库的 shares bucket 中，以令牌为键
+type shareStore struct {
+	mu     sync.Mutex
+	tokens map[string]*share
+	codes  map[string]*share
+}
+
+var shares *shareStore
+
+// shareCodeAlphabet 去掉了容易混淆的 0/o、1/l/i
+const shareCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+const shareCodeLength = 6
+
+// loadShares 从元数据库加载分享
+func loadShares() *shareStore {
+	s := &shareStore{tokens: map[string]*share{}, codes: map[string]*share{}}
+	dbEach(bucketShares, func(k, v []byte) error {
+		sh := &share{}
+		if err := json.Unmarshal(v, sh); err != nil {
+			logger.Printf("Error parsing share %s: %v", k, err)
+			return nil
+		}
+		s.tokens[sh.Token] = sh
+		s.codes[sh.Code] = sh
+		return nil
+	})
+	return s
+}
+
+// create 为虚拟路径创建分享，ttl 为 0 表示永不过期
+func (s *shareStore) create(virtual, user string, ttl time.Duration) (*share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	var code string
+	for {
+		if code, err = randomCode(shareCodeLength); err != nil {
+			return nil, err
+		}
+		if _, taken := s.codes[code]; !taken {
+			break
+		}
+	}
+	sh := &share{Token: token, Code: code, Path: virtual, User: user, Created: time.Now().UTC()}
+	if ttl > 0 {
+		exp := sh.Created.Add(ttl)
+		sh.Expires = &exp
+	}
+	if err := dbPut(bucketShares, []byte(token), sh); err != nil {
+		return nil, err
+	}
+	s.tokens[token] = sh
+	s.codes[code] = sh
+	return sh, nil
+}
+
+// restore 恢复导入的分享，保留原来的令牌；短代码已被占用时生成新的代码。令牌已存在时返回 false
+func (s *shareStore) restore(sh *share) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[sh.Token]; ok {
+		return false
+	}
+	for sh.Code == "" || s.codes[sh.Code] != nil {
+		code, err := randomCode(shareCodeLength)
+		if err != nil {
+			return false
+		}
+		sh.Code = code
+	}
+	if err := dbPut(bucketShares, []byte(sh.Token), sh); err != nil {
+		return false
+	}
+	s.tokens[sh.Token] = sh
+	s.codes[sh.Code] = sh
+	return true
+}
+
+// lookup 按令牌或短代码查找未过期的分享
+func (s *shareStore) lookup(token, code string) *share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.tokens[token]
+	if code != "" {
+		sh = s.codes[strings.ToLower(code)]
+	}
+	if sh == nil || sh.expired() {
+		return nil
+	}
+	return sh
+}
+
+// list 返回 user 创建的分享，user 为空时返回全部
+func (s *shareStore) list(user string, all bool) []*share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []*share{}
+	for _, sh := range s.tokens {
+		if all || sh.User == user {
+			out = append(out, sh)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// revoke 删除分享并返回被删除的分享，只有创建者和管理员可以删除
+func (s *shareStore) revoke(token, user string, admin bool) (*share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh := s.tokens[token]
+	if sh == nil {
+		return nil, errNotFound
+	}
+	if !admin && sh.User != user {
+		return nil, os.ErrPermission
+	}
+	if err := dbDelete(bucketShares, []byte(token)); err != nil {
+		return nil, err
+	}
+	delete(s.tokens, token)
+	delete(s.codes, sh.Code)
+	return sh, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func randomCode(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(shareCodeAlphabet)))
+	for i := range b {
+		v, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = shareCodeAlphabet[v.Int64()]
+	}
+	return string(b), nil
+}
+
+// shareLink 是返回给客户端的分享信息
+type shareLink struct {
+	*share
+	URL      string `json:"url"`
+	ShortURL string `json:"short_url"`
+}
+
+// baseURL 返回对外的服务器地址，优先使用 -public-url
+func baseURL(r *http.Request) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func newShareLink(r *http.Request, sh *share) shareLink {
+	base := baseURL(r)
+	return shareLink{share: sh, URL: base + "/share/" + sh.Token, ShortURL: base + "/s/" + sh.Code}
+}
+
+// createShareFromRequest 解析 path 和 expires 参数并创建分享，路径规则与下载相同
+func createShareFromRequest(r *http.Request, p, expires string) (*share, int, error) {
+	if p == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing path")
+	}
+	var ttl time.Duration
+	if expires != "" {
+		d, err := time.ParseDuration(expires)
+		if err != nil || d < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid expires duration %q", expires)
+		}
+		ttl = d
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("path not found")
+	}
+	sh, err := shares.create(vol.virtual(name), currentUser(r), ttl)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	logger.Printf("Share %s created for %s", sh.Code, sh.Path)
+	ev := fileEvent{Event: eventShare, Path: sh.Path, User: sh.User, Share: sh.Code}
+	if !info.IsDir() {
+		ev.Size = info.Size()
+	}
+	emitEvent(ev)
+	return sh, http.StatusCreated, nil
+}
+
+// apiSharesHandler 管理分享：GET 列出，POST 创建（JSON：path、expires），DELETE ?token= 撤销
+func apiSharesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		links := []shareLink{}
+		for _, sh := range shares.list(currentUser(r), isAdmin(r)) {
+			links = append(links, newShareLink(r, sh))
+		}
+		writeJSON(w, http.StatusOK, links)
+	case http.MethodPost:
+		var req struct {
+			Path    string `json:"path"`
+			Expires string `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		sh, status, err := createShareFromRequest(r, req.Path, req.Expires)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, status, newShareLink(r, sh))
+	case http.MethodDelete:
+		sh, err := shares.revoke(r.URL.Query().Get("token"), currentUser(r), isAdmin(r))
+		switch {
+		case err == errNotFound:
+			http.Error(w, "Share not found", http.StatusNotFound)
+		case os.IsPermission(err):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case err != nil:
+			http.Error(w, "Failed to revoke share", http.StatusInternalServerError)
+		default:
+			logger.Printf("Share %s revoked for %s", sh.Code, sh.Path)
+			emitEvent(fileEvent{Event: eventUnshare, Path: sh.Path, User: currentUser(r), Share: sh.Code})
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// shareFormHandler 处理列表页中的分享按钮，显示生成的链接和短代码
+func shareFormHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sh, status, err := createShareFromRequest(r, r.FormValue("path"), r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	link := newShareLink(r, sh)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+    <title>Share</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Shared %s</h1>
+    <p>Code: <b style="font-size: 2em; letter-spacing: 0.2em">%s</b></p>
+    <p>Short link: <a href="%s">%s</a></p>
+    <p>Full link: <a href="%s">%s</a></p>
+    <p><a href="/">&larr; Back</a></p>
+</body>
+</html>`, html.EscapeString(sh.Path), strings.ToUpper(sh.Code),
+		html.EscapeString(link.ShortURL), html.EscapeString(link.ShortURL),
+		html.EscapeString(link.URL), html.EscapeString(link.URL))
+}
+
+// sharedDownloadHandler 无需登录即可通过 /share/<token> 或 /s/<code> 下载分享的内容
+func sharedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	var sh *share
+	if code, ok := strings.CutPrefix(r.URL.Path, "/s/"); ok {
+		sh = shares.lookup("", code)
+	} else {
+		sh = shares.lookup(strings.TrimPrefix(r.URL.Path, "/share/"), "")
+	}
+	if sh == nil {
+		http.Error(w, "Share not found or expired", http.StatusNotFound)
+		return
+	}
+	// 分享路径是整棵虚拟树中的路径，以管理员视角解析
+	vol, name, err := resolveVirtual(withUser(r.Context(), sh.User, true), sh.Path)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	serveTarget(w, r, vol, name)
+}
+
+// isPublicPath 判断请求路径是否无需认证（分享链接、访客上传链接、分享的文本片段和内置的图标资源）
+func isPublicPath(p string) bool {
+	return strings.HasPrefix(p, "/s/") || strings.HasPrefix(p, "/share/") || strings.HasPrefix(p, "/drop/") || strings.HasPrefix(p, "/p/") || p == "/favicon.ico" || strings.HasPrefix(p, "/assets/")
+}
@@ -0,0 +1,48 @@
+package fileserver
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks []func()
+)
+
+// onShutdown 注册在收到 Ctrl+C、SIGTERM 或服务停止请求时运行的清理函数
+func onShutdown(fn func()) {
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, fn)
+	shutdownMu.Unlock()
+}
+
+// runShutdownHooks 按注册的相反顺序运行清理函数，每个函数只运行一次
+func runShutdownHooks() {
+	shutdownMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// shutdown 运行清理函数后退出进程
+func shutdown() {
+	runShutdownHooks()
+	os.Exit(0)
+}
+
+// handleSignals 在收到 Ctrl+C 或 SIGTERM 时调用 shutdown
+func handleSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logger.Printf("Shutting down")
+		shutdown()
+	}()
+}
@@ -0,0 +1,175 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// urlSigningKeyFile 由 -url-signing-key-file 设置；为空时使用保存在元数据库中的随机密钥
+var urlSigningKeyFile string
+
+// urlSigningKey 是签名下载链接的 HMAC 密钥
+var (
+	urlSigningMu  sync.Mutex
+	urlSigningKey []byte
+)
+
+// urlSigningDBKey 是随机密钥在元数据库 meta bucket 中的键
+const urlSigningDBKey = "url-signing-key"
+
+// setupURLSigning 读取签名密钥：优先使用密钥文件，否则从元数据库读取，不存在时生成
+func setupURLSigning() error {
+	if urlSigningKeyFile != "" {
+		data, err := os.ReadFile(urlSigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("URL signing key: %v", err)
+		}
+		key := bytes.TrimSpace(data)
+		if len(key) < 16 {
+			return fmt.Errorf("URL signing key in %s is shorter than 16 bytes", urlSigningKeyFile)
+		}
+		setURLSigningKey(key)
+		return nil
+	}
+	return dbUpdate(bucketMeta, func(b *bolt.Bucket) error {
+		if v := b.Get([]byte(urlSigningDBKey)); v != nil {
+			setURLSigningKey(append([]byte(nil), v...))
+			return nil
+		}
+		return putNewURLSigningKey(b)
+	})
+}
+
+func setURLSigningKey(key []byte) {
+	urlSigningMu.Lock()
+	urlSigningKey = key
+	urlSigningMu.Unlock()
+}
+
+// putNewURLSigningKey 生成新的随机签名密钥并保存到元数据库
+func putNewURLSigningKey(b *bolt.Bucket) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := b.Put([]byte(urlSigningDBKey), key); err != nil {
+		return err
+	}
+	setURLSigningKey(key)
+	return nil
+}
+
+// urlSignature 返回路径和过期时间（Unix 秒）的签名
+func urlSignature(virtual string, expires int64) string {
+	urlSigningMu.Lock()
+	mac := hmac.New(sha256.New, urlSigningKey)
+	urlSigningMu.Unlock()
+	fmt.Fprintf(mac, "%s\n%d", virtual, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURL 返回下载 virtual 的签名链接
+func signedURL(r *http.Request, virtual string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("path", virtual)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", urlSignature(virtual, expires.Unix()))
+	return baseURL(r) + "/download?" + q.Encode()
+}
+
+// isSignedRequest 判断请求是否为签名下载链接，这类请求无需认证，由 signedDownloadHandler 校验签名
+func isSignedRequest(r *http.Request) bool {
+	return r.URL.Path == "/download" && r.URL.Query().Has("sig")
+}
+
+// signedDownloadHandler 校验签名和过期时间后下载签名中的路径。路径是整棵虚拟树中的路径，以管理员视角解析
+func signedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	virtual := q.Get("path")
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(q.Get("sig")), []byte(urlSignature(virtual, expires))) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "Link expired", http.StatusForbidden)
+		return
+	}
+	vol, name, err := resolveVirtual(withUser(r.Context(), "", true), virtual)
+	if err != nil || name == "" && vol.prefix == "" {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	serveTarget(w, r, vol, name)
+}
+
+// apiSignHandler 处理 /api/v1/sign：POST（JSON：path、expires，默认 24h）返回签名下载链接；
+// DELETE 由管理员更换签名密钥，使所有已发出的链接失效（使用密钥文件时不可用）
+func apiSignHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Path    string `json:"path"`
+			Expires string `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ttl := 24 * time.Hour
+		if req.Expires != "" {
+			d, err := time.ParseDuration(req.Expires)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("invalid expires duration %q", req.Expires), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		vol, name, err := resolveTarget(r, req.Path)
+		if err == nil {
+			_, err = vol.store.Stat(name)
+		}
+		if err != nil {
+			http.Error(w, "Path not found", http.StatusNotFound)
+			return
+		}
+		virtual := vol.virtual(name)
+		expires := time.Now().Add(ttl).Truncate(time.Second)
+		logger.Printf("Signed download link for %s issued by %q, valid until %s", virtual, currentUser(r), expires.Format(time.RFC3339))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"url": signedURL(r, virtual, expires), "path": virtual, "expires": expires.UTC()})
+	case http.MethodDelete:
+		if !isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if urlSigningKeyFile != "" {
+			http.Error(w, "The signing key is read from -url-signing-key-file; replace the file and restart instead", http.StatusConflict)
+			return
+		}
+		if err := dbUpdate(bucketMeta, putNewURLSigningKey); err != nil {
+			http.Error(w, "Failed to replace signing key", http.StatusInternalServerError)
+			return
+		}
+		logger.Printf("URL signing key replaced by %q; all signed links are now invalid", currentUser(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,153 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slideshowInterval 是幻灯片默认的切换间隔
+const slideshowInterval = 5 * time.Second
+
+// isImageName 根据扩展名判断文件是否为浏览器可显示的图片
+func isImageName(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path.Ext(name))), "image/")
+}
+
+// slideshowHandler 处理 /slideshow?path=<目录>&interval=<秒>，全屏依次展示目录中的所有图片。
+// 左右方向键或滑动切换，空格暂停或继续，F 切换全屏，Esc 返回
+func slideshowHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dir := strings.Trim(q.Get("path"), "/")
+	vol, name, err := resolveVirtual(r.Context(), dir)
+	if err == os.ErrNotExist || err == errNotFound || reservedPath(name) {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	entries, err := vol.store.List(name)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+	interval := slideshowInterval
+	if v := q.Get("interval"); v != "" {
+		sec, err := strconv.ParseFloat(v, 64)
+		if err != nil || sec < 1 || sec > 3600 {
+			http.Error(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+		interval = time.Duration(sec * float64(time.Second))
+	}
+	images := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && isImageName(e.Name()) {
+			images = append(images, "/download?path="+url.QueryEscape(vol.virtual(path.Join(name, e.Name()))))
+		}
+	}
+	if len(images) == 0 {
+		http.Error(w, "No images in this folder", http.StatusNotFound)
+		return
+	}
+	list, _ := json.Marshal(images)
+	title := vol.virtual(name)
+	if title == "" {
+		title = "Slideshow"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, slideshowPage, html.EscapeString(title), html.EscapeString(listURL(strings.TrimSuffix(vol.prefix, "/"))), list, interval.Milliseconds())
+}
+
+// slideshowPage 是幻灯片页面，参数依次为标题、返回地址、图片地址列表（JSON）和切换间隔（毫秒）
+const slideshowPage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        html, body { margin: 0; height: 100%%; background: #000; color: #ccc; font-family: sans-serif; overflow: hidden; }
+        #slide { width: 100%%; height: 100%%; object-fit: contain; display: block; }
+        #bar { position: fixed; bottom: 0; left: 0; right: 0; padding: 8px; background: rgba(0,0,0,.6); text-align: center; transition: opacity .5s; }
+        #bar a, #bar button { color: #ccc; background: none; border: 1px solid #666; padding: 4px 10px; margin: 0 4px; cursor: pointer; text-decoration: none; font: inherit; }
+        body.idle #bar { opacity: 0; }
+    </style>
+</head>
+<body>
+    <img id="slide" alt="">
+    <div id="bar">
+        <a id="back" href="%s">&larr; Back</a>
+        <button id="prev">&lsaquo;</button>
+        <button id="play">Pause</button>
+        <button id="next">&rsaquo;</button>
+        <button id="full">Full screen</button>
+        <span id="pos"></span>
+    </div>
+    <script>
+    (function () {
+        var images = %s, interval = %d, i = 0, timer = null, idle = null;
+        var img = document.getElementById("slide"), pos = document.getElementById("pos"), play = document.getElementById("play");
+        function show(n) {
+            i = (n + images.length) %% images.length;
+            img.src = images[i];
+            pos.textContent = (i + 1) + " / " + images.length;
+            new Image().src = images[(i + 1) %% images.length];
+            if (timer) { clearInterval(timer); timer = setInterval(function () { show(i + 1); }, interval); }
+        }
+        function toggle() {
+            if (timer) { clearInterval(timer); timer = null; play.textContent = "Play"; }
+            else { timer = setInterval(function () { show(i + 1); }, interval); play.textContent = "Pause"; }
+        }
+        function fullscreen() {
+            if (document.fullscreenElement) document.exitFullscreen();
+            else if (document.documentElement.requestFullscreen) document.documentElement.requestFullscreen();
+        }
+        function wake() {
+            document.body.classList.remove("idle");
+            clearTimeout(idle);
+            idle = setTimeout(function () { document.body.classList.add("idle"); }, 3000);
+        }
+        document.getElementById("prev").onclick = function () { show(i - 1); };
+        document.getElementById("next").onclick = function () { show(i + 1); };
+        document.getElementById("full").onclick = fullscreen;
+        play.onclick = toggle;
+        document.addEventListener("keydown", function (e) {
+            wake();
+            switch (e.key) {
+            case "ArrowLeft": case "PageUp": show(i - 1); break;
+            case "ArrowRight": case "PageDown": show(i + 1); break;
+            case " ": toggle(); e.preventDefault(); break;
+            case "f": case "F": fullscreen(); break;
+            case "Home": show(0); break;
+            case "End": show(images.length - 1); break;
+            case "Escape": if (!document.fullscreenElement) location.href = document.getElementById("back").href; break;
+            }
+        });
+        var startX = null;
+        img.addEventListener("touchstart", function (e) { startX = e.touches[0].clientX; wake(); }, { passive: true });
+        img.addEventListener("touchend", function (e) {
+            if (startX === null) return;
+            var dx = e.changedTouches[0].clientX - startX;
+            startX = null;
+            if (Math.abs(dx) > 40) show(dx < 0 ? i + 1 : i - 1);
+        });
+        img.addEventListener("click", function (e) { show(e.clientX < window.innerWidth / 3 ? i - 1 : i + 1); });
+        document.addEventListener("mousemove", wake);
+        timer = setInterval(function () { show(i + 1); }, interval);
+        show(0);
+        wake();
+    })();
+    </script>
+</body>
+</html>`
@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+)
+
+// tmpDir 由 -tmp-dir 设置，是上传和解压的暂存目录；默认为 <dir>/.fileserver/tmp，
+// 与服务目录在同一文件系统上，暂存的文件可以直接改名到目标位置，而不必从 /tmp（可能是很小的 tmpfs）跨设备复制
+var tmpDir string
+
+// setupTempDir 创建暂存目录并将其设为进程的临时目录，表单解析时写入磁盘的文件部分、
+// 文件夹上传的归档和 7z 解压目录都会放在这里
+func setupTempDir() error {
+	if tmpDir == "" {
+		tmpDir = filepath.Join(uploadDir, metaDirName, "tmp")
+	}
+	abs, err := filepath.Abs(tmpDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(abs, 0700); err != nil {
+		return err
+	}
+	tmpDir = abs
+	// os.TempDir 在 Unix 上读取 TMPDIR，在 Windows 上读取 TMP 和 TEMP
+	for _, key := range []string{"TMPDIR", "TMP", "TEMP"} {
+		os.Setenv(key, abs)
+	}
+	return nil
+}
+
+// moveStagedUpload 将表单解析时已写入暂存目录的文件直接改名为卷中的 name，并返回大小和 SHA-256。
+// 文件在内存中、存储不是本地磁盘或不在同一文件系统时返回 false，file 被重置到开头，由调用方复制
+func moveStagedUpload(file multipart.File, vol volume, name string) (int64, string, bool) {
+	f, ok := file.(*os.File)
+	lp, ok2 := vol.store.(localPather)
+	if !ok || !ok2 {
+		return 0, "", false
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	if err == nil {
+		// 暂存文件以 0600 创建，改为与直接写入的文件相同的权限
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), lp.LocalPath(name))
+	}
+	if err != nil {
+		f.Seek(0, io.SeekStart)
+		return 0, "", false
+	}
+	if permsConfigured() {
+		applyPerms(lp.LocalPath(name), false)
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), true
+}
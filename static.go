@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// staticMode 由 -static-mode 设置：包含 index.html 的目录按网站提供，/<目录>/ 返回其 index.html，其中的文件以正确的类型内联返回；
+// staticSPA 由 -static-spa 设置，网站中不存在且没有扩展名的路径返回网站的 index.html，供使用前端路由的单页应用使用
+var (
+	staticMode bool
+	staticSPA  bool
+)
+
+// staticIndex 是网站目录的首页文件名
+const staticIndex = "index.html"
+
+// siteRoot 返回卷内路径 name 所在的网站目录，即最近的包含 index.html 的目录（可以是 name 本身）
+func siteRoot(vol volume, name string) (string, bool) {
+	for dir := name; ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		if info, err := vol.store.Stat(path.Join(dir, staticIndex)); err == nil && info.Mode().IsRegular() {
+			return dir, true
+		}
+		if dir == "" {
+			return "", false
+		}
+	}
+}
+
+// serveStatic 在静态网站模式下处理列表页之外的 GET 请求：路径位于网站目录中时返回网站的文件并返回 true；
+// 不在任何网站中时返回 false，由列表页照常处理。根目录包含 index.html 时 / 也是网站，文件管理界面可通过 /?dir= 访问
+func serveStatic(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.URL.Path == "/" && r.URL.RawQuery != "" {
+		return false
+	}
+	vol, name, err := resolveVirtual(r.Context(), r.URL.Path)
+	if err != nil || reservedPath(name) {
+		return false
+	}
+	info, err := vol.store.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		index := path.Join(name, staticIndex)
+		if info, err := vol.store.Stat(index); err != nil || !info.Mode().IsRegular() {
+			// 目录本身不是网站，但可能位于网站中
+			return serveSiteFallback(w, r, vol, name)
+		}
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// 让页面中的相对链接指向目录内
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return true
+		}
+		serveSiteFile(w, r, vol, index)
+		return true
+	case err == nil:
+		if _, ok := siteRoot(vol, path.Dir(name)); !ok {
+			return false
+		}
+		serveSiteFile(w, r, vol, name)
+		return true
+	}
+	return serveSiteFallback(w, r, vol, name)
+}
+
+// serveSiteFallback 处理网站中不存在的路径：启用 -static-spa 且路径没有扩展名时返回网站的 index.html，否则返回 404；
+// 路径不在任何网站中时返回 false
+func serveSiteFallback(w http.ResponseWriter, r *http.Request, vol volume, name string) bool {
+	root, ok := siteRoot(vol, path.Dir(name))
+	if !ok {
+		return false
+	}
+	if staticSPA && path.Ext(name) == "" {
+		index := path.Join(root, staticIndex)
+		serveSiteFile(w, r, vol, index)
+		return true
+	}
+	http.Error(w, "Page not found", http.StatusNotFound)
+	return true
+}
+
+// serveSiteFile 以内联方式返回网站中的文件。与下载不同，HTML 不放入沙箱，页面中的脚本可以运行，也不计入下载次数
+func serveSiteFile(w http.ResponseWriter, r *http.Request, vol volume, name string) {
+	f, err := vol.store.Open(name)
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	ctype := detectContentType(vol.store, name)
+	w.Header().Set("Content-Type", ctype)
+	applyCacheControl(w, vol.virtual(name), ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if pf, pst, ok := openPrecompressed(w, r, vol.store, name, st); ok {
+		defer pf.Close()
+		w.Header().Set("ETag", fileETag(pst))
+		http.ServeContent(w, r, path.Base(name), pst.ModTime(), pf)
+		return
+	}
+	w.Header().Set("ETag", fileETag(st))
+	http.ServeContent(w, r, path.Base(name), st.ModTime(), f)
+}
+
+// siteLink 在静态网站模式下为包含 index.html 的目录返回列表页中打开网站的链接
+func siteLink(vol volume, name string) string {
+	if !staticMode {
+		return ""
+	}
+	if info, err := vol.store.Stat(path.Join(name, staticIndex)); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	u := url.URL{Path: "/" + vol.virtual(name) + "/"}
+	return fmt.Sprintf(` <a href="%s">(open site)</a>`, html.EscapeString(u.String()))
+}
@@ -0,0 +1,181 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaDirName 是服务目录下保存服务器元数据的隐藏目录，不会出现在列表中，也不能被下载
+const metaDirName = ".fileserver"
+
+// downloadStat 记录单个文件的下载统计
+type downloadStat struct {
+	Count        int64     `json:"count"`
+	LastDownload time.Time `json:"last_download"`
+}
+
+// downloadStats 是下载统计，保存在元数据库的 downloads bucket 中
+type downloadStats struct {
+	mu    sync.Mutex
+	items map[string]*downloadStat
+}
+
+var stats *downloadStats
+
+// loadDownloadStats 从元数据库加载下载统计
+func loadDownloadStats() *downloadStats {
+	s := &downloadStats{items: make(map[string]*downloadStat)}
+	dbEach(bucketDownloads, func(k, v []byte) error {
+		var st downloadStat
+		if err := json.Unmarshal(v, &st); err != nil {
+			logger.Printf("Error parsing download stats of %s: %v", k, err)
+			return nil
+		}
+		s.items[string(k)] = &st
+		return nil
+	})
+	return s
+}
+
+// record 记录一次下载并写入元数据库
+func (s *downloadStats) record(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.items[name]
+	if st == nil {
+		st = &downloadStat{}
+		s.items[name] = st
+	}
+	st.Count++
+	st.LastDownload = time.Now().UTC()
+	dbPut(bucketDownloads, []byte(name), st)
+}
+
+// move 将路径及其下所有文件的下载统计移到新路径，替换新路径下原有的统计
+func (s *downloadStats) move(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys [][]byte
+	moved := map[string]interface{}{}
+	for p, st := range s.items {
+		if underPath(p, to) {
+			delete(s.items, p)
+			keys = append(keys, []byte(p))
+		} else if np, ok := movedPath(p, from, to); ok {
+			delete(s.items, p)
+			keys = append(keys, []byte(p))
+			moved[np] = st
+		}
+	}
+	for p, st := range moved {
+		s.items[p] = st.(*downloadStat)
+	}
+	dbReplace(bucketDownloads, keys, moved)
+}
+
+// get 返回文件的下载统计副本
+func (s *downloadStats) get(name string) downloadStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.items[name]; st != nil {
+		return *st
+	}
+	return downloadStat{}
+}
+
+// topDownload 是下载排行中的一项
+type topDownload struct {
+	Path string `json:"path"`
+	downloadStat
+}
+
+// top 返回下载次数最多的 n 个文件；visible 决定哪些路径可以返回，并给出调用者看到的路径
+func (s *downloadStats) top(n int, visible func(string) (string, bool)) []topDownload {
+	s.mu.Lock()
+	list := make([]topDownload, 0, len(s.items))
+	for p, st := range s.items {
+		if p, ok := visible(p); ok {
+			list = append(list, topDownload{Path: p, downloadStat: *st})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// isFullDownload 判断请求是否为完整下载，避免视频拖动等分段请求重复计数
+func isFullDownload(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// topDownloadsHandler 返回下载最多的文件，?limit= 指定数量（默认 10）；
+// 多用户模式下普通用户只能看到自己目录和挂载点中的文件，路径相对于自己的目录
+func topDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	vol, err := rootVolume(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats.top(limit, func(p string) (string, bool) {
+		if vol.prefix == "" {
+			return p, true
+		}
+		if rest, ok := strings.CutPrefix(p, vol.prefix); ok {
+			return rest, true
+		}
+		alias, _, _ := strings.Cut(p, "/")
+		_, ok := findMount(alias)
+		return p, ok
+	}))
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写入中断留下损坏的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,298 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// storage 抽象了被服务文件的存取，所有路径均为以 "/" 分隔、相对于存储根的路径（"" 表示根目录）
+// 默认实现为本地磁盘 localStorage，其他后端实现该接口即可接入
+type storage interface {
+	// List 返回目录下的条目，按名称排序
+	List(name string) ([]fs.FileInfo, error)
+	// Open 打开文件用于读取
+	Open(name string) (storageFile, error)
+	// Create 创建或截断文件用于写入
+	Create(name string) (io.WriteCloser, error)
+	// Mkdir 创建目录及其所有父目录
+	Mkdir(name string) error
+	// Delete 删除文件或目录（包括目录内容）
+	Delete(name string) error
+	// Rename 在同一存储内移动或重命名文件或目录
+	Rename(oldName, newName string) error
+	// Stat 返回文件信息，不存在时返回满足 os.IsNotExist 的错误
+	Stat(name string) (fs.FileInfo, error)
+	// Walk 递归遍历 name 下的所有条目，回调中的路径相对于存储根
+	Walk(name string, fn walkFunc) error
+}
+
+// storageFile 是从存储中打开的可读、可定位文件
+type storageFile interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// walkFunc 是 storage.Walk 的回调函数
+type walkFunc func(name string, info fs.FileInfo, err error) error
+
+// localPather 由能够提供本地磁盘路径的后端实现，供外部命令等需要真实路径的功能使用
+type localPather interface {
+	LocalPath(name string) string
+}
+
+// appender 由能够原地追加写入的后端实现；不支持时 Append 返回 errors.ErrUnsupported
+type appender interface {
+	// Append 打开文件用于在末尾追加，不存在时创建
+	Append(name string) (io.WriteCloser, error)
+}
+
+// contentHasher 由记录了文件内容哈希的后端实现；文件没有记录的哈希时返回 false
+type contentHasher interface {
+	ContentHash(name string) (string, bool)
+}
+
+// cloner 由能够不复制内容而复制文件的后端实现；无法这样复制时 Clone 返回 errors.ErrUnsupported
+type cloner interface {
+	Clone(src storage, name, target string) error
+}
+
+// localPath 返回存储中文件的本地路径，后端不支持时返回存储内路径
+func localPath(store storage, name string) string {
+	if lp, ok := store.(localPather); ok {
+		return lp.LocalPath(name)
+	}
+	return name
+}
+
+// cleanName 规范化存储路径，去掉开头的 "/" 并阻止跳出根目录
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// stagingKinds 是写入时在目标旁创建的隐藏暂存文件的种类，用在文件名 .<名称>.<种类>-<8 位十六进制> 中；
+// 清理、备份、导出、复制和校验都按这个列表（见 stagingFilePattern）识别暂存文件
+var stagingKinds = []string{"put", "dav", "delta", "archive", "link", "append", "import", "replica", "ftp", "grpc", "batch", "sftp"}
+
+// stagingName 返回 name 旁 kind 种类的隐藏暂存文件名，如 .report.pdf.put-1a2b3c4d
+func stagingName(name, kind string) (string, error) {
+	suffix, err := randomHex(4)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(name), "."+path.Base(name)+"."+kind+"-"+suffix), nil
+}
+
+// stageAndCommit 将 write 写出的内容先保存到 name 旁的暂存文件，check 通过后再改名替换 name；
+// 任何一步失败都删除暂存文件，name 保持不变。check 收到暂存文件名，为 nil 时不检查
+func stageAndCommit(store storage, name, kind string, write func(io.Writer) error, check func(tmp string) error) error {
+	tmp, err := stagingName(name, kind)
+	if err != nil {
+		return err
+	}
+	dst, err := store.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(dst)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		store.Delete(tmp)
+		return err
+	}
+	return commitStaged(store, tmp, name, check)
+}
+
+// commitStaged 对已写好的暂存文件 tmp 执行 check，通过后改名为 name；失败时删除暂存文件
+func commitStaged(store storage, tmp, name string, check func(tmp string) error) error {
+	var err error
+	if check != nil {
+		err = check(tmp)
+	}
+	if err == nil {
+		err = store.Rename(tmp, name)
+	}
+	if err != nil {
+		store.Delete(tmp)
+	}
+	return err
+}
+
+// localStorage 是基于本地目录的存储实现
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) *localStorage {
+	return &localStorage{root: root}
+}
+
+func (l *localStorage) LocalPath(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(cleanName(name)))
+}
+
+func (l *localStorage) List(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(l.LocalPath(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (l *localStorage) Open(name string) (storageFile, error) {
+	return os.Open(l.LocalPath(name))
+}
+
+func (l *localStorage) Create(name string) (io.WriteCloser, error) {
+	p := l.LocalPath(name)
+	f, err := os.Create(p)
+	if err == nil && permsConfigured() {
+		applyPerms(p, false)
+	}
+	return f, err
+}
+
+func (l *localStorage) Append(name string) (io.WriteCloser, error) {
+	p := l.LocalPath(name)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err == nil && permsConfigured() {
+		applyPerms(p, false)
+	}
+	return f, err
+}
+
+func (l *localStorage) Mkdir(name string) error {
+	p := l.LocalPath(name)
+	if !permsConfigured() {
+		return os.MkdirAll(p, 0755)
+	}
+	created := missingDirs(p)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return err
+	}
+	for _, d := range created {
+		applyPerms(d, true)
+	}
+	return nil
+}
+
+func (l *localStorage) Delete(name string) error {
+	if cleanName(name) == "" {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrPermission}
+	}
+	return os.RemoveAll(l.LocalPath(name))
+}
+
+func (l *localStorage) Rename(oldName, newName string) error {
+	if cleanName(oldName) == "" || cleanName(newName) == "" {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	return os.Rename(l.LocalPath(oldName), l.LocalPath(newName))
+}
+
+func (l *localStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(l.LocalPath(name))
+}
+
+func (l *localStorage) Walk(name string, fn walkFunc) error {
+	base := cleanName(name)
+	return filepath.Walk(l.LocalPath(base), func(p string, info os.FileInfo, err error) error {
+		rel, rerr := filepath.Rel(l.root, p)
+		if rerr != nil {
+			return rerr
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		return fn(rel, info, err)
+	})
+}
+
+// subStorage 将另一个存储的子目录作为独立的存储根，例如用户主目录
+type subStorage struct {
+	parent storage
+	dir    string
+}
+
+func newSubStorage(parent storage, dir string) *subStorage {
+	return &subStorage{parent: parent, dir: cleanName(dir)}
+}
+
+func (s *subStorage) full(name string) string {
+	return path.Join(s.dir, cleanName(name))
+}
+
+func (s *subStorage) LocalPath(name string) string {
+	return localPath(s.parent, s.full(name))
+}
+
+func (s *subStorage) List(name string) ([]fs.FileInfo, error) { return s.parent.List(s.full(name)) }
+
+func (s *subStorage) Open(name string) (storageFile, error) { return s.parent.Open(s.full(name)) }
+
+func (s *subStorage) Create(name string) (io.WriteCloser, error) {
+	return s.parent.Create(s.full(name))
+}
+
+func (s *subStorage) Append(name string) (io.WriteCloser, error) {
+	a, ok := s.parent.(appender)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return a.Append(s.full(name))
+}
+
+func (s *subStorage) Mkdir(name string) error { return s.parent.Mkdir(s.full(name)) }
+
+func (s *subStorage) Delete(name string) error {
+	if cleanName(name) == "" {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrPermission}
+	}
+	return s.parent.Delete(s.full(name))
+}
+
+func (s *subStorage) Rename(oldName, newName string) error {
+	if cleanName(oldName) == "" || cleanName(newName) == "" {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	return s.parent.Rename(s.full(oldName), s.full(newName))
+}
+
+func (s *subStorage) Stat(name string) (fs.FileInfo, error) { return s.parent.Stat(s.full(name)) }
+
+func (s *subStorage) Walk(name string, fn walkFunc) error {
+	return s.parent.Walk(s.full(name), func(p string, info fs.FileInfo, err error) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, s.dir), "/")
+		return fn(rel, info, err)
+	})
+}
+
+// rootStorage 是 -dir 指定目录的存储
+var rootStorage storage
+
+// volume 是请求可操作的一个存储根，prefix 为其在服务器虚拟路径中的前缀（用于统计和事件）
+type volume struct {
+	store    storage
+	prefix   string
+	readOnly bool
+}
+
+// virtual 返回卷内路径对应的服务器虚拟路径
+func (v volume) virtual(name string) string {
+	return strings.TrimSuffix(v.prefix+cleanName(name), "/")
+}
@@ -0,0 +1,409 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storageScanInterval 是后台扫描存储统计的间隔，由 -stats-interval 设置
+var storageScanInterval = time.Hour
+
+// storageTopN 是统计中列出的最大文件和文件类型数量
+const storageTopN = 20
+
+// storageHistoryDays 是保留的每日容量记录天数
+const storageHistoryDays = 365
+
+// dirUsage 是目录（含所有子目录）的总大小和文件数
+type dirUsage struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+// typeUsage 是某个扩展名的文件总大小和数量
+type typeUsage struct {
+	Ext   string `json:"ext"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+// fileUsage 是一个大文件
+type fileUsage struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// storagePoint 是某一天扫描时的总容量，用于展示增长趋势
+type storagePoint struct {
+	Date  string `json:"date"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+// storageReport 是一次扫描的结果
+type storageReport struct {
+	Scanned  time.Time      `json:"scanned"`
+	Duration float64        `json:"duration_seconds"`
+	Size     int64          `json:"size"`
+	Files    int64          `json:"files"`
+	Dirs     int64          `json:"folders"`
+	Types    []typeUsage    `json:"types"`
+	Biggest  []fileUsage    `json:"biggest"`
+	History  []storagePoint `json:"history"`
+
+	dirs map[string]*dirUsage
+}
+
+// storageStats 保存最近一次扫描结果和历史记录，历史记录按日期保存在元数据库的 storage-history bucket 中
+type storageStats struct {
+	mu       sync.Mutex
+	report   *storageReport
+	history  []storagePoint
+	scanning bool
+	ready    chan struct{}
+	// added 是最近一次扫描后上传新建的条目数，用于 -max-entries
+	added int64
+}
+
+var storageUsage *storageStats
+
+// loadStorageStats 加载容量历史记录，并在后台开始定期扫描
+func loadStorageStats() *storageStats {
+	s := &storageStats{ready: make(chan struct{})}
+	dbEach(bucketStorageHistory, func(k, v []byte) error {
+		var p storagePoint
+		if err := json.Unmarshal(v, &p); err != nil {
+			logger.Printf("Error parsing storage history of %s: %v", k, err)
+			return nil
+		}
+		s.history = append(s.history, p)
+		return nil
+	})
+	go func() {
+		s.scan()
+		if storageScanInterval <= 0 {
+			return
+		}
+		for range time.Tick(storageScanInterval) {
+			s.scan()
+		}
+	}()
+	return s
+}
+
+// rescan 在后台开始一次扫描，已有扫描进行中时不做任何事
+func (s *storageStats) rescan() {
+	go s.scan()
+}
+
+// scan 遍历服务目录和所有挂载点，统计目录大小、文件类型和最大的文件
+func (s *storageStats) scan() {
+	s.mu.Lock()
+	if s.scanning {
+		s.mu.Unlock()
+		return
+	}
+	s.scanning = true
+	s.mu.Unlock()
+
+	start := time.Now()
+	rep := &storageReport{dirs: map[string]*dirUsage{"": {Path: ""}}}
+	types := make(map[string]*typeUsage)
+	err := walkVolumes(func(vol volume, name string, info fs.FileInfo) error {
+		virtual := vol.virtual(name)
+		if info.IsDir() {
+			rep.Dirs++
+			if rep.dirs[virtual] == nil {
+				rep.dirs[virtual] = &dirUsage{Path: virtual}
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		size := info.Size()
+		rep.Files++
+		rep.Size += size
+		// 文件大小计入所有上级目录
+		for dir := path.Dir(virtual); ; dir = path.Dir(dir) {
+			if dir == "." {
+				dir = ""
+			}
+			d := rep.dirs[dir]
+			if d == nil {
+				d = &dirUsage{Path: dir}
+				rep.dirs[dir] = d
+			}
+			d.Size += size
+			d.Files++
+			if dir == "" {
+				break
+			}
+		}
+		ext := strings.ToLower(path.Ext(name))
+		if ext == "" {
+			ext = "(none)"
+		}
+		t := types[ext]
+		if t == nil {
+			t = &typeUsage{Ext: ext}
+			types[ext] = t
+		}
+		t.Size += size
+		t.Files++
+		if len(rep.Biggest) < storageTopN || size > rep.Biggest[len(rep.Biggest)-1].Size {
+			rep.Biggest = append(rep.Biggest, fileUsage{Path: virtual, Size: size, ModTime: info.ModTime().UTC()})
+			sort.Slice(rep.Biggest, func(i, j int) bool { return rep.Biggest[i].Size > rep.Biggest[j].Size })
+			if len(rep.Biggest) > storageTopN {
+				rep.Biggest = rep.Biggest[:storageTopN]
+			}
+		}
+		return nil
+	})
+	for _, t := range types {
+		rep.Types = append(rep.Types, *t)
+	}
+	sort.Slice(rep.Types, func(i, j int) bool { return rep.Types[i].Size > rep.Types[j].Size })
+	if len(rep.Types) > storageTopN {
+		rep.Types = rep.Types[:storageTopN]
+	}
+	rep.Scanned = time.Now().UTC()
+	rep.Duration = time.Since(start).Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanning = false
+	if err != nil {
+		logger.Printf("Error scanning storage: %v", err)
+		return
+	}
+	point := storagePoint{Date: rep.Scanned.Format("2006-01-02"), Size: rep.Size, Files: rep.Files}
+	if n := len(s.history); n > 0 && s.history[n-1].Date == point.Date {
+		s.history[n-1] = point
+	} else {
+		s.history = append(s.history, point)
+	}
+	if len(s.history) > storageHistoryDays {
+		s.history = s.history[len(s.history)-storageHistoryDays:]
+	}
+	rep.History = append([]storagePoint(nil), s.history...)
+	if s.report == nil {
+		close(s.ready)
+	}
+	// 扫描期间新建的条目可能已计入扫描结果，也可能没有；上限只需近似，以新结果为准
+	s.added = 0
+	s.report = rep
+	s.saveLocked()
+}
+
+// entryCount 返回服务目录和挂载点中文件与目录数的估计，首次扫描完成前返回 false
+func (s *storageStats) entryCount() (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.report == nil {
+		return 0, false
+	}
+	return s.report.Files + s.report.Dirs + s.added, true
+}
+
+// addEntries 记录扫描后新建的条目
+func (s *storageStats) addEntries(n int64) {
+	s.mu.Lock()
+	s.added += n
+	s.mu.Unlock()
+}
+
+// saveLocked 写入历史记录，并删除超出保留天数的旧记录
+func (s *storageStats) saveLocked() {
+	if metaDB == nil {
+		return
+	}
+	dbUpdate(bucketStorageHistory, func(b *bolt.Bucket) error {
+		keep := make(map[string]bool, len(s.history))
+		for _, p := range s.history {
+			keep[p.Date] = true
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(p.Date), data); err != nil {
+				return err
+			}
+		}
+		var stale [][]byte
+		b.ForEach(func(k, _ []byte) error {
+			if !keep[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// latest 等待首次扫描完成后返回最近的扫描结果
+func (s *storageStats) latest(r *http.Request) (*storageReport, bool) {
+	select {
+	case <-s.ready:
+	case <-r.Context().Done():
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report, true
+}
+
+// children 返回目录的直接子目录用量，按大小从大到小排列
+func (rep *storageReport) children(dir string) []dirUsage {
+	out := []dirUsage{}
+	for p, d := range rep.dirs {
+		if p == "" || p == dir {
+			continue
+		}
+		parent := path.Dir(p)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	return out
+}
+
+// storageStatsRequest 解析统计请求中的目录，并在 ?refresh=true 时开始重新扫描
+func storageStatsRequest(w http.ResponseWriter, r *http.Request) (*storageReport, string, bool) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, "", false
+	}
+	if r.URL.Query().Get("refresh") == "true" {
+		storageUsage.rescan()
+	}
+	rep, ok := storageUsage.latest(r)
+	if !ok {
+		return nil, "", false
+	}
+	dir := cleanName(r.URL.Query().Get("dir"))
+	if _, ok := rep.dirs[dir]; !ok {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	return rep, dir, true
+}
+
+// apiStorageStatsHandler 处理 GET /api/v1/stats，返回存储统计；dirs 为 ?dir= 指定目录（默认根目录）的子目录用量
+func apiStorageStatsHandler(w http.ResponseWriter, r *http.Request) {
+	rep, dir, ok := storageStatsRequest(w, r)
+	if !ok {
+		return
+	}
+	out := struct {
+		*storageReport
+		Dir      dirUsage   `json:"dir"`
+		Children []dirUsage `json:"dirs"`
+	}{rep, *rep.dirs[dir], rep.children(dir)}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// storageStatsPageHandler 处理 /stats，以网页展示目录大小、文件类型、最大的文件和容量增长
+func storageStatsPageHandler(w http.ResponseWriter, r *http.Request) {
+	rep, dir, ok := storageStatsRequest(w, r)
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	total := rep.dirs[dir]
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Storage Statistics</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Storage Statistics</h1>
+`)
+	fmt.Fprintf(&sb, "    <p>%s in %d files and %d folders, scanned %s in %.1fs. <a href=\"/stats?refresh=true\">Rescan</a> | <a href=\"/transfers\">Transfers</a> | <a href=\"/\">&larr; Back</a></p>\n",
+		formatSize(rep.Size), rep.Files, rep.Dirs, rep.Scanned.Local().Format("2006-01-02 15:04"), rep.Duration)
+
+	sb.WriteString("    <h2>Folders")
+	if dir != "" {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		fmt.Fprintf(&sb, ": %s (<a href=\"/stats?dir=%s\">up</a>)", html.EscapeString(dir), url.QueryEscape(parent))
+	}
+	fmt.Fprintf(&sb, "</h2>\n    <p>%s in %d files</p>\n    <table>\n", formatSize(total.Size), total.Files)
+	for _, d := range rep.children(dir) {
+		fmt.Fprintf(&sb, "        <tr><td><a href=\"/stats?dir=%s\">%s/</a></td><td>%s</td><td>%d files</td><td>%s</td></tr>\n",
+			url.QueryEscape(d.Path), html.EscapeString(path.Base(d.Path)), formatSize(d.Size), d.Files, percentOf(d.Size, total.Size))
+	}
+	sb.WriteString("    </table>\n    <h2>File Types</h2>\n    <table>\n")
+	for _, t := range rep.Types {
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%d files</td><td>%s</td></tr>\n",
+			html.EscapeString(t.Ext), formatSize(t.Size), t.Files, percentOf(t.Size, rep.Size))
+	}
+	sb.WriteString("    </table>\n    <h2>Biggest Files</h2>\n    <table>\n")
+	for _, f := range rep.Biggest {
+		fmt.Fprintf(&sb, "        <tr><td><a href=\"/download?path=%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			url.QueryEscape(f.Path), html.EscapeString(f.Path), formatSize(f.Size), f.ModTime.Local().Format("2006-01-02"))
+	}
+	sb.WriteString("    </table>\n    <h2>Growth</h2>\n    <table>\n")
+	for i := len(rep.History) - 1; i >= 0; i-- {
+		p := rep.History[i]
+		change := ""
+		if i > 0 {
+			diff := p.Size - rep.History[i-1].Size
+			sign := "+"
+			if diff < 0 {
+				sign, diff = "-", -diff
+			}
+			change = sign + formatSize(diff)
+		}
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%d files</td><td>%s</td></tr>\n", p.Date, formatSize(p.Size), p.Files, change)
+	}
+	sb.WriteString("    </table>\n")
+	if scrubber != nil {
+		st := scrubber.status()
+		sb.WriteString("    <h2>Integrity</h2>\n")
+		if len(st.Runs) > 0 {
+			fmt.Fprintf(&sb, "    <p>Last check %s: %d files, %d problems. <a href=\"/scrub\">Details</a></p>\n",
+				st.Runs[0].Started.Local().Format("2006-01-02 15:04"), st.Runs[0].Files, len(st.Problems))
+		} else {
+			fmt.Fprintf(&sb, "    <p>First check at %s. <a href=\"/scrub\">Details</a></p>\n", st.Next.Local().Format("2006-01-02 15:04"))
+		}
+		writeScrubProblems(&sb, st.Problems, false)
+	}
+	sb.WriteString(`</body>
+</html>`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// percentOf 返回 part 占 total 的百分比文本
+func percentOf(part, total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
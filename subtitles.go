@@ -0,0 +1,131 @@
+package fileserver
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// subtitleExts 是作为字幕提供给播放器的扩展名
+var subtitleExts = map[string]bool{".srt": true, ".vtt": true}
+
+// subtitleTrack 是与视频同目录、同名的字幕文件，如 movie.srt 或 movie.en.vtt
+type subtitleTrack struct {
+	name  string // 卷内路径
+	lang  string // 文件名中视频名与扩展名之间的部分，如 en；没有时为空
+	label string
+}
+
+// findSubtitles 返回与视频同目录的字幕文件：文件名（忽略大小写）为视频名去掉扩展名，
+// 后面可以带语言代码或说明
+func findSubtitles(store storage, name string) []subtitleTrack {
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := store.List(dir)
+	if err != nil {
+		return nil
+	}
+	stem := strings.ToLower(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	var tracks []subtitleTrack
+	for _, e := range entries {
+		ext := strings.ToLower(path.Ext(e.Name()))
+		base := strings.ToLower(strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+		if e.IsDir() || !subtitleExts[ext] || base != stem && !strings.HasPrefix(base, stem+".") {
+			continue
+		}
+		lang := strings.TrimPrefix(strings.TrimPrefix(base, stem), ".")
+		label := lang
+		if label == "" {
+			label = "Subtitles"
+		}
+		tracks = append(tracks, subtitleTrack{name: path.Join(dir, e.Name()), lang: lang, label: label})
+	}
+	return tracks
+}
+
+// subtitleTracksHTML 返回播放器中可选的字幕轨道，默认不显示字幕
+func subtitleTracksHTML(vol volume, tracks []subtitleTrack) string {
+	var sb strings.Builder
+	for _, t := range tracks {
+		attrs := ""
+		if t.lang != "" && len(t.lang) <= 8 {
+			attrs = fmt.Sprintf(` srclang="%s"`, html.EscapeString(t.lang))
+		}
+		fmt.Fprintf(&sb, "\n        <track kind=\"subtitles\" src=\"/subtitle?path=%s\" label=\"%s\"%s>",
+			html.EscapeString(url.QueryEscape(vol.virtual(t.name))), html.EscapeString(t.label), attrs)
+	}
+	return sb.String()
+}
+
+// srtTimestamp 匹配 SRT 时间轴中以逗号分隔毫秒的时间
+var srtTimestamp = regexp.MustCompile(`(\d{1,2}:\d{2}:\d{2}),(\d{3})`)
+
+// srtToVTT 将 SRT 字幕转换为 WebVTT：添加文件头，时间轴中的毫秒分隔符改为点号，
+// 不是 UTF-8 的内容按 ISO-8859-1 解码
+func srtToVTT(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	if !utf8.ValidString(text) {
+		text = latin1(data)
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.Contains(line, "-->") {
+			line = srtTimestamp.ReplaceAllString(line, "$1.$2")
+		}
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// subtitleHandler 处理 GET /subtitle?path=，以 WebVTT 格式返回字幕，SRT 字幕在返回时转换
+func subtitleHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if !subtitleExts[ext] {
+		http.Error(w, "Not a subtitle file", http.StatusBadRequest)
+		return
+	}
+	f, err := vol.store.Open(name)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	if ext == ".vtt" {
+		io.Copy(w, f)
+		return
+	}
+	if err := srtToVTT(w, f); err != nil {
+		logger.Printf("Error converting subtitles %s: %v", vol.virtual(name), err)
+	}
+}
@@ -0,0 +1,412 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 标签的长度和数量限制
+const (
+	maxTagLen      = 32
+	maxTagsPerPath = 20
+)
+
+// tagStore 保存文件和文件夹的标签，键为虚拟路径，保存在元数据库的 tags bucket 中
+type tagStore struct {
+	mu    sync.Mutex
+	items map[string][]string
+}
+
+var tags *tagStore
+
+// loadTags 从元数据库加载标签
+func loadTags() *tagStore {
+	t := &tagStore{items: make(map[string][]string)}
+	dbEach(bucketTags, func(k, v []byte) error {
+		var list []string
+		if err := json.Unmarshal(v, &list); err != nil {
+			logger.Printf("Error parsing tags of %s: %v", k, err)
+			return nil
+		}
+		t.items[string(k)] = list
+		return nil
+	})
+	return t
+}
+
+// get 返回路径的标签副本
+func (t *tagStore) get(virtual string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.items[virtual]...)
+}
+
+// set 替换路径的标签，空列表表示删除所有标签
+func (t *tagStore) set(virtual string, list []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(list) == 0 {
+		if _, ok := t.items[virtual]; !ok {
+			return
+		}
+		delete(t.items, virtual)
+		dbDelete(bucketTags, []byte(virtual))
+		return
+	}
+	t.items[virtual] = list
+	dbPut(bucketTags, []byte(virtual), list)
+}
+
+// forget 删除路径及其下所有条目的标签
+func (t *tagStore) forget(virtual string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefix := virtual + "/"
+	var keys [][]byte
+	for p := range t.items {
+		if p == virtual || virtual == "" || strings.HasPrefix(p, prefix) {
+			delete(t.items, p)
+			keys = append(keys, []byte(p))
+		}
+	}
+	dbDelete(bucketTags, keys...)
+}
+
+// move 将路径及其下所有条目的标签移到新路径，替换新路径下原有的标签
+func (t *tagStore) move(from, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys [][]byte
+	moved := map[string]interface{}{}
+	for p, list := range t.items {
+		if underPath(p, to) {
+			delete(t.items, p)
+			keys = append(keys, []byte(p))
+		} else if np, ok := movedPath(p, from, to); ok {
+			delete(t.items, p)
+			keys = append(keys, []byte(p))
+			moved[np] = list
+		}
+	}
+	for p, list := range moved {
+		t.items[p] = list.([]string)
+	}
+	dbReplace(bucketTags, keys, moved)
+}
+
+// tagCount 是标签及使用它的条目数
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// counts 返回所有标签及其使用次数，按标签名排列
+func (t *tagStore) counts() []tagCount {
+	t.mu.Lock()
+	n := make(map[string]int)
+	for _, list := range t.items {
+		for _, tag := range list {
+			n[tag]++
+		}
+	}
+	t.mu.Unlock()
+	out := make([]tagCount, 0, len(n))
+	for tag, c := range n {
+		out = append(out, tagCount{Tag: tag, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+	return out
+}
+
+// find 返回带有全部指定标签的路径，按路径排列
+func (t *tagStore) find(want []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	for p, list := range t.items {
+		if hasAllTags(list, want) {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hasAllTags 判断 list 是否包含 want 中的全部标签
+func hasAllTags(list, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range list {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+var errInvalidTag = errors.New("tags may contain only letters, digits, '-', '_' and '.'")
+
+// normalizeTag 将标签转为小写并检查字符，空格替换为 -
+func normalizeTag(s string) (string, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), "-"))
+	if s == "" || len([]rune(s)) > maxTagLen {
+		return "", fmt.Errorf("tags must be 1-%d characters", maxTagLen)
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return "", errInvalidTag
+		}
+	}
+	return s, nil
+}
+
+// normalizeTags 规范化标签列表，去掉重复并排序
+func normalizeTags(in []string) ([]string, error) {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, s := range in {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		tag, err := normalizeTag(s)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	if len(out) > maxTagsPerPath {
+		return nil, fmt.Errorf("at most %d tags per file", maxTagsPerPath)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// splitTags 拆分以逗号分隔的标签输入
+func splitTags(s string) []string {
+	return strings.Split(s, ",")
+}
+
+// taggedItem 是按标签筛选的结果
+type taggedItem struct {
+	Path     string   `json:"path"`
+	IsDir    bool     `json:"is_dir"`
+	Size     int64    `json:"size"`
+	MimeType string   `json:"mime_type,omitempty"`
+	Tags     []string `json:"tags"`
+}
+
+// taggedItems 返回请求者可以访问的、带有全部指定标签的条目；已不存在的条目会被跳过
+func taggedItems(r *http.Request, want []string) []taggedItem {
+	items := []taggedItem{}
+	for _, p := range tags.find(want) {
+		vol, name, err := resolveTarget(r, p)
+		if err != nil {
+			continue
+		}
+		info, err := vol.store.Stat(name)
+		if err != nil {
+			continue
+		}
+		item := taggedItem{Path: p, IsDir: info.IsDir(), Tags: tags.get(p)}
+		if !info.IsDir() {
+			item.Size = info.Size()
+			item.MimeType = detectContentType(vol.store, name)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// wantTags 解析 ?tag= 参数（可以重复，表示同时带有这些标签）
+func wantTags(r *http.Request) ([]string, error) {
+	var want []string
+	for _, v := range r.URL.Query()["tag"] {
+		for _, s := range splitTags(v) {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			tag, err := normalizeTag(s)
+			if err != nil {
+				return nil, err
+			}
+			want = append(want, tag)
+		}
+	}
+	return want, nil
+}
+
+// apiTagsHandler 处理 /api/v1/tags：
+// GET 不带参数时返回所有标签及使用次数，?path= 返回条目的标签，?tag= 返回带有这些标签的条目；
+// PUT 或 POST ?path= 以 JSON {"tags": [...]} 替换条目的标签，空列表表示删除
+func apiTagsHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	switch r.Method {
+	case http.MethodGet:
+		if p != "" {
+			virtual, ok := tagTarget(w, r, p)
+			if ok {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"path": virtual, "tags": append([]string{}, tags.get(virtual)...)})
+			}
+			return
+		}
+		want, err := wantTags(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(want) > 0 {
+			writeJSON(w, http.StatusOK, taggedItems(r, want))
+			return
+		}
+		writeJSON(w, http.StatusOK, tags.counts())
+	case http.MethodPut, http.MethodPost:
+		virtual, ok := tagTarget(w, r, p)
+		if !ok {
+			return
+		}
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		list, err := normalizeTags(req.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tags.set(virtual, list)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"path": virtual, "tags": list})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tagTarget 检查路径存在并返回其虚拟路径，失败时已写入错误响应
+func tagTarget(w http.ResponseWriter, r *http.Request, p string) (string, bool) {
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return "", false
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == nil {
+		_, err = vol.store.Stat(name)
+	}
+	if err == errNotFound || os.IsNotExist(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, "Failed to read path", http.StatusInternalServerError)
+		return "", false
+	}
+	return vol.virtual(name), true
+}
+
+// tagsPageHandler 处理 /tags：GET 列出所有标签，?tag= 列出带有这些标签的文件和文件夹；
+// POST 表单（path、以逗号分隔的 tags、dir）设置条目的标签后返回目录列表
+func tagsPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		virtual, ok := tagTarget(w, r, r.FormValue("path"))
+		if !ok {
+			return
+		}
+		list, err := normalizeTags(splitTags(r.FormValue("tags")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tags.set(virtual, list)
+		http.Redirect(w, r, listURL(r.FormValue("dir")), http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	want, err := wantTags(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sb strings.Builder
+	title := "Tags"
+	if len(want) > 0 {
+		title = "Tagged: " + strings.Join(want, " + ")
+	}
+	fmt.Fprintf(&sb, `<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="UTF-8">
+    <link rel="icon" href="/favicon.ico">
+</head>
+<body>
+    <h1>%s</h1>
+    <form method="get" action="/tags">
+        <input type="text" name="tag" value="%s" placeholder="tag1, tag2">
+        <input type="submit" value="Filter">
+    </form>
+    <p><a href="/">&larr; Back</a></p>
+`, html.EscapeString(title), html.EscapeString(title), html.EscapeString(strings.Join(want, ", ")))
+	if len(want) == 0 {
+		sb.WriteString("    <ul>\n")
+		for _, c := range tags.counts() {
+			fmt.Fprintf(&sb, "        <li>%s (%d)</li>\n", tagLink(c.Tag), c.Count)
+		}
+		sb.WriteString("    </ul>\n")
+	} else {
+		sb.WriteString("    <ul>\n")
+		for _, item := range taggedItems(r, want) {
+			icon, size := "folder", ""
+			if !item.IsDir {
+				icon, size = fileIcon(item.Path, item.MimeType), " <small>"+formatSize(item.Size)+"</small>"
+			}
+			fmt.Fprintf(&sb, `        <li>%s<a href="/download?path=%s">%s</a>%s %s</li>
+`, iconHTML(icon), url.QueryEscape(item.Path), html.EscapeString(item.Path), size, tagLinks(item.Tags))
+		}
+		sb.WriteString("    </ul>\n")
+	}
+	sb.WriteString("</body>\n</html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// tagLink 返回指向标签筛选页面的链接
+func tagLink(tag string) string {
+	return fmt.Sprintf(`<a href="/tags?tag=%s">#%s</a>`, url.QueryEscape(tag), html.EscapeString(tag))
+}
+
+// tagLinks 返回多个标签的链接，以空格分隔
+func tagLinks(list []string) string {
+	links := make([]string, len(list))
+	for i, tag := range list {
+		links[i] = tagLink(tag)
+	}
+	return strings.Join(links, " ")
+}
+
+// tagForm 返回目录列表中编辑条目标签的表单，已有的标签显示为链接
+func tagForm(virtual, dir string, list []string) string {
+	return fmt.Sprintf(` %s <form method="post" action="/tags" style="display:inline"><input type="hidden" name="path" value="%s"><input type="hidden" name="dir" value="%s"><input type="text" name="tags" value="%s" placeholder="tags" size="12"><button type="submit">tag</button></form>`,
+		tagLinks(list), html.EscapeString(virtual), html.EscapeString(dir), html.EscapeString(strings.Join(list, ", ")))
+}
@@ -0,0 +1,374 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 追踪配置：-otlp-endpoint 为 OTLP/HTTP 接收端（如 http://localhost:4318，默认取 OTEL_EXPORTER_OTLP_ENDPOINT），为空时不追踪；
+// -trace-sample 为新追踪的采样比例，带 traceparent 的请求沿用上游的采样决定
+var (
+	otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	traceSample  = 1.0
+)
+
+// traceBatchSize 和 traceFlushInterval 控制导出批次；traceQueueSize 是待导出的最大 span 数，队列满时丢弃新的 span
+const (
+	traceBatchSize     = 256
+	traceFlushInterval = 5 * time.Second
+	traceQueueSize     = 4096
+)
+
+// span 是一个追踪区间；nil 表示未追踪，所有方法都可以在 nil 上调用
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	server   bool
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	err      string
+	once     sync.Once
+}
+
+type spanContextKey struct{}
+
+// tracer 将结束的 span 批量导出到 OTLP/HTTP 接收端（JSON 编码）
+type tracer struct {
+	endpoint string
+	headers  http.Header
+	service  string
+	queue    chan *span
+	flush    chan chan struct{}
+	lastErr  time.Time
+}
+
+// traces 在未启用追踪时为 nil
+var traces *tracer
+
+// setupTracing 在设置了 -otlp-endpoint 时开始导出 span
+func setupTracing() error {
+	if otlpEndpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(otlpEndpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-otlp-endpoint must be an http(s) URL such as http://localhost:4318")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	if traceSample < 0 || traceSample > 1 {
+		return fmt.Errorf("-trace-sample must be between 0 and 1")
+	}
+	t := &tracer{
+		endpoint: u.String(),
+		headers:  http.Header{},
+		service:  "fileserver",
+		queue:    make(chan *span, traceQueueSize),
+		flush:    make(chan chan struct{}),
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		t.service = name
+	}
+	// OTEL_EXPORTER_OTLP_HEADERS 的格式为 key1=value1,key2=value2，常用于接收端的认证
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			key, _ := url.QueryUnescape(strings.TrimSpace(k))
+			val, _ := url.QueryUnescape(strings.TrimSpace(v))
+			t.headers.Set(key, val)
+		}
+	}
+	traces = t
+	go t.run()
+	onShutdown(t.shutdown)
+	logger.Printf("Exporting traces to %s (sampling %g)", u.Redacted(), traceSample)
+	return nil
+}
+
+// startSpan 在 ctx 中的 span 下开始一个子 span；未追踪时返回 ctx 和 nil
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent, _ := ctx.Value(spanContextKey{}).(*span)
+	if traces == nil || parent == nil {
+		return ctx, nil
+	}
+	s := &span{traceID: parent.traceID, parentID: parent.spanID, name: name, start: time.Now()}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// setAttr 设置属性，值为 string、int、int64 或 bool
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// setError 将 span 标记为失败，err 为 nil 时不做任何事
+func (s *span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// finish 结束 span 并放入导出队列
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.end = time.Now()
+		select {
+		case traces.queue <- s:
+		default:
+		}
+	})
+}
+
+// parseTraceparent 解析 W3C traceparent 请求头，返回追踪 ID、上游 span ID 和是否采样
+func parseTraceparent(v string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// spanName 返回请求的 span 名称：API 路径保持完整，其他路径只取第一段，避免每个文件产生不同的名称
+func spanName(r *http.Request) string {
+	p := r.URL.Path
+	if !strings.HasPrefix(p, "/api/") {
+		if i := strings.Index(p[1:], "/"); i >= 0 {
+			p = p[:i+2]
+		}
+	} else if strings.HasPrefix(p, "/api/v1/upload/") {
+		p = "/api/v1/upload/"
+	}
+	return r.Method + " " + p
+}
+
+// statusWriter 记录响应状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tracingMiddleware 为每个采样的请求创建服务端 span，沿用请求中 traceparent 的追踪 ID
+func tracingMiddleware(next http.Handler) http.Handler {
+	if traces == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &span{name: spanName(r), server: true, start: time.Now()}
+		traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok {
+			s.traceID, s.parentID = traceID, parentID
+		} else {
+			rand.Read(s.traceID[:])
+			sampled = traceSample >= 1 || mrand.Float64() < traceSample
+		}
+		if !sampled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rand.Read(s.spanID[:])
+		s.setAttr("http.request.method", r.Method)
+		s.setAttr("url.path", r.URL.Path)
+		if id := requestID(r.Context()); id != "" {
+			s.setAttr("http.request.header.x-request-id", id)
+		}
+		if r.ContentLength > 0 {
+			s.setAttr("http.request.body.size", r.ContentLength)
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			s.setAttr("http.response.status_code", sw.status)
+			if sw.status >= 500 {
+				s.err = http.StatusText(sw.status)
+			}
+			s.finish()
+		}()
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), spanContextKey{}, s)))
+	})
+}
+
+// run 收集结束的 span，攒满一批或每隔 traceFlushInterval 导出一次
+func (t *tracer) run() {
+	var batch []*span
+	tick := time.NewTicker(traceFlushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-tick.C:
+			if len(batch) > 0 {
+				t.export(batch)
+				batch = nil
+			}
+		case done := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			if len(batch) > 0 {
+				t.export(batch)
+				batch = nil
+			}
+			close(done)
+		}
+	}
+}
+
+// shutdown 在退出前导出剩余的 span
+func (t *tracer) shutdown() {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-time.After(traceFlushInterval):
+	}
+}
+
+// otlpAttrs 将属性编码为 OTLP JSON 的 KeyValue 列表
+func otlpAttrs(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var val map[string]any
+		switch v := v.(type) {
+		case string:
+			val = map[string]any{"stringValue": v}
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			val = map[string]any{"boolValue": v}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
+
+// export 以 OTLP/HTTP JSON 发送一批 span；失败时丢弃，每分钟最多记录一次错误
+func (t *tracer) export(batch []*span) {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		kind := 1 // SPAN_KIND_INTERNAL
+		if s.server {
+			kind = 2 // SPAN_KIND_SERVER
+		}
+		js := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			js["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			js["status"] = map[string]any{"code": 2, "message": s.err} // STATUS_CODE_ERROR
+		}
+		spans = append(spans, js)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttrs(map[string]any{
+				"service.name":    t.service,
+				"service.version": version,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "file-server", "version": version},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	for k, v := range t.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	if err != nil && time.Since(t.lastErr) > time.Minute {
+		t.lastErr = time.Now()
+		logger.Printf("Error exporting %d spans: %v", len(batch), err)
+	}
+}
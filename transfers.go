@@ -0,0 +1,339 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// transferHistoryDays 是每个客户端保留的每日流量记录天数
+const transferHistoryDays = 90
+
+// transferDay 是客户端一天内的流量：Received 为上传（请求体）字节数，Sent 为下载（响应体）字节数
+type transferDay struct {
+	Date     string `json:"date"`
+	Received int64  `json:"received"`
+	Sent     int64  `json:"sent"`
+	Requests int64  `json:"requests"`
+}
+
+// clientTransfers 是一个客户端的累计流量和每日记录
+type clientTransfers struct {
+	Received int64         `json:"received"`
+	Sent     int64         `json:"sent"`
+	Requests int64         `json:"requests"`
+	LastSeen time.Time     `json:"last_seen"`
+	Days     []transferDay `json:"days"`
+}
+
+// transferStats 按客户端统计流量并保存在元数据库的 transfers bucket 中；已登录的请求按用户名统计，否则按客户端 IP。
+// changed 记录尚未写入数据库的客户端
+type transferStats struct {
+	mu      sync.Mutex
+	clients map[string]*clientTransfers
+	changed map[string]bool
+	dirty   bool
+}
+
+var transfers *transferStats
+
+// loadTransferStats 从元数据库加载流量统计
+func loadTransferStats() *transferStats {
+	t := &transferStats{clients: make(map[string]*clientTransfers), changed: make(map[string]bool)}
+	dbEach(bucketTransfers, func(k, v []byte) error {
+		c := &clientTransfers{}
+		if err := json.Unmarshal(v, c); err != nil {
+			logger.Printf("Error parsing transfer stats of %s: %v", k, err)
+			return nil
+		}
+		t.clients[string(k)] = c
+		return nil
+	})
+	return t
+}
+
+// record 将一次请求的流量计入客户端当天的记录
+func (t *transferStats) record(client string, received, sent int64) {
+	now := time.Now().UTC()
+	date := now.Format("2006-01-02")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[client]
+	if c == nil {
+		c = &clientTransfers{}
+		t.clients[client] = c
+	}
+	c.Received += received
+	c.Sent += sent
+	c.Requests++
+	c.LastSeen = now
+	if n := len(c.Days); n == 0 || c.Days[n-1].Date != date {
+		c.Days = append(c.Days, transferDay{Date: date})
+		if len(c.Days) > transferHistoryDays {
+			c.Days = append([]transferDay(nil), c.Days[len(c.Days)-transferHistoryDays:]...)
+		}
+	}
+	d := &c.Days[len(c.Days)-1]
+	d.Received += received
+	d.Sent += sent
+	d.Requests++
+	t.changed[client] = true
+	if !t.dirty {
+		t.dirty = true
+		time.AfterFunc(checksumSaveDelay, t.save)
+	}
+}
+
+// save 在一个事务中写入有变化的客户端
+func (t *transferStats) save() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = false
+	if len(t.changed) == 0 || metaDB == nil {
+		return
+	}
+	err := dbUpdate(bucketTransfers, func(b *bolt.Bucket) error {
+		for client := range t.changed {
+			data, err := json.Marshal(t.clients[client])
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(client), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		t.changed = make(map[string]bool)
+	}
+}
+
+// clientUsage 是客户端在统计时段内的流量
+type clientUsage struct {
+	Client        string    `json:"client"`
+	Received      int64     `json:"received"`
+	Sent          int64     `json:"sent"`
+	Requests      int64     `json:"requests"`
+	LastSeen      time.Time `json:"last_seen"`
+	TotalReceived int64     `json:"total_received"`
+	TotalSent     int64     `json:"total_sent"`
+}
+
+// usage 返回最近 days 天内各客户端的流量（按总字节数从大到小排列）和所有客户端的每日合计
+func (t *transferStats) usage(days int) ([]clientUsage, []transferDay) {
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clients := []clientUsage{}
+	byDate := make(map[string]*transferDay)
+	for name, c := range t.clients {
+		u := clientUsage{Client: name, LastSeen: c.LastSeen, TotalReceived: c.Received, TotalSent: c.Sent}
+		for _, d := range c.Days {
+			if d.Date < since {
+				continue
+			}
+			u.Received += d.Received
+			u.Sent += d.Sent
+			u.Requests += d.Requests
+			sum := byDate[d.Date]
+			if sum == nil {
+				sum = &transferDay{Date: d.Date}
+				byDate[d.Date] = sum
+			}
+			sum.Received += d.Received
+			sum.Sent += d.Sent
+			sum.Requests += d.Requests
+		}
+		if u.Requests > 0 {
+			clients = append(clients, u)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := clients[i].Received+clients[i].Sent, clients[j].Received+clients[j].Sent
+		if a != b {
+			return a > b
+		}
+		return clients[i].Client < clients[j].Client
+	})
+	daily := []transferDay{}
+	for _, d := range byDate {
+		daily = append(daily, *d)
+	}
+	sort.Slice(daily, func(i, j int) bool { return daily[i].Date < daily[j].Date })
+	return clients, daily
+}
+
+// totals 返回各客户端的累计流量，供 /metrics 使用
+func (t *transferStats) totals() map[string]clientTransfers {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]clientTransfers, len(t.clients))
+	for name, c := range t.clients {
+		out[name] = clientTransfers{Received: c.Received, Sent: c.Sent, Requests: c.Requests, LastSeen: c.LastSeen}
+	}
+	return out
+}
+
+// transferClient 返回用于统计的客户端标识：已登录时为用户名，否则为客户端 IP
+func transferClient(r *http.Request) string {
+	if user := currentUser(r); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// countingBody 统计读取的请求体字节数
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// countingWriter 统计写出的响应体字节数
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// transferMiddleware 在请求结束时将请求体和响应体的字节数计入客户端的流量统计，需放在认证之后以获得用户名
+func transferMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() {
+			transfers.record(transferClient(r), body.n, cw.n)
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// transferUsageRequest 检查管理员权限并解析 ?days=（默认 30，最多 transferHistoryDays）
+func transferUsageRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid days", http.StatusBadRequest)
+			return 0, false
+		}
+		days = min(n, transferHistoryDays)
+	}
+	return days, true
+}
+
+// apiTransfersHandler 处理 GET /api/v1/transfers，返回最近 ?days= 天内各客户端的上传和下载字节数及每日合计
+func apiTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	days, ok := transferUsageRequest(w, r)
+	if !ok {
+		return
+	}
+	clients, daily := transfers.usage(days)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"days": days, "clients": clients, "daily": daily})
+}
+
+// transfersPageHandler 处理 /transfers，以网页展示各客户端的流量，供管理员查看带宽的使用者
+func transfersPageHandler(w http.ResponseWriter, r *http.Request) {
+	days, ok := transferUsageRequest(w, r)
+	if !ok {
+		return
+	}
+	clients, daily := transfers.usage(days)
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Transfers</title>
+    <meta charset="UTF-8">
+</head>
+<body>
+    <h1>Transfers</h1>
+`)
+	fmt.Fprintf(&sb, "    <p>Last %d days: <a href=\"/transfers?days=1\">today</a> | <a href=\"/transfers?days=7\">7 days</a> | <a href=\"/transfers?days=30\">30 days</a> | <a href=\"/transfers?days=%d\">%d days</a> | <a href=\"/stats\">Storage</a> | <a href=\"/\">&larr; Back</a></p>\n",
+		days, transferHistoryDays, transferHistoryDays)
+	sb.WriteString("    <h2>Clients</h2>\n    <table>\n        <tr><th>Client</th><th>Uploaded</th><th>Downloaded</th><th>Requests</th><th>Last seen</th></tr>\n")
+	for _, c := range clients {
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%s</td></tr>\n",
+			html.EscapeString(c.Client), formatSize(c.Received), formatSize(c.Sent), c.Requests, c.LastSeen.Local().Format("2006-01-02 15:04"))
+	}
+	sb.WriteString("    </table>\n    <h2>Daily</h2>\n    <table>\n        <tr><th>Date</th><th>Uploaded</th><th>Downloaded</th><th>Requests</th></tr>\n")
+	for i := len(daily) - 1; i >= 0; i-- {
+		d := daily[i]
+		fmt.Fprintf(&sb, "        <tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td></tr>\n", d.Date, formatSize(d.Received), formatSize(d.Sent), d.Requests)
+	}
+	sb.WriteString(`    </table>
+</body>
+</html>`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
+
+// labelEscaper 按 Prometheus 文本格式转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler 处理 GET /metrics，以 Prometheus 文本格式输出各客户端的累计流量，仅管理员可见
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	totals := transfers.totals()
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	metric := func(name, help string, value func(clientTransfers) int64) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, client := range names {
+			fmt.Fprintf(&sb, "%s{client=\"%s\"} %d\n", name, labelEscaper.Replace(client), value(totals[client]))
+		}
+	}
+	metric("fileserver_received_bytes_total", "Request body bytes received from each client (uploads).", func(c clientTransfers) int64 { return c.Received })
+	metric("fileserver_sent_bytes_total", "Response body bytes sent to each client (downloads).", func(c clientTransfers) int64 { return c.Sent })
+	metric("fileserver_requests_total", "HTTP requests served to each client.", func(c clientTransfers) int64 { return c.Requests })
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, sb.String())
+}
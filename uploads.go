@@ -0,0 +1,204 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上传进度状态
+const (
+	uploadReceiving  = "receiving"
+	uploadProcessing = "processing"
+	uploadDone       = "done"
+	uploadFailed     = "failed"
+)
+
+// uploadProgress 是一次上传在服务器端的进度，Received 和 Total 为请求体的字节数，Rate 为最近的接收速度（字节/秒）
+type uploadProgress struct {
+	ID       string     `json:"id"`
+	Path     string     `json:"path,omitempty"`
+	User     string     `json:"user,omitempty"`
+	State    string     `json:"state"`
+	Received int64      `json:"received"`
+	Total    int64      `json:"total"`
+	Rate     float64    `json:"rate"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	rateBytes int64
+	rateTime  time.Time
+}
+
+var (
+	uploadsMu  sync.Mutex
+	uploadJobs = map[string]*uploadProgress{}
+)
+
+// validUploadID 判断客户端指定的上传 ID 是否合法：1 到 64 个字母、数字、"-" 或 "_"
+func validUploadID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadTracker 统计请求体的接收字节数，并记录响应状态以确定上传的最终结果
+type uploadTracker struct {
+	http.ResponseWriter
+	p      *uploadProgress
+	body   io.ReadCloser
+	status int
+	errMsg strings.Builder
+}
+
+// trackUpload 为上传请求登记进度。客户端可以通过请求头 X-Upload-ID 或参数 upload_id 指定 ID，
+// 以便在上传过程中查询 /api/v1/upload/<id>/progress；未指定时由服务器生成。
+// ID 在响应头 X-Upload-ID 中返回。返回的 tracker 需替换 w 使用，并在处理结束时调用 finish
+func trackUpload(w http.ResponseWriter, r *http.Request) (*uploadTracker, bool) {
+	id := r.Header.Get("X-Upload-ID")
+	if id == "" {
+		id = r.URL.Query().Get("upload_id")
+	}
+	if id == "" {
+		var err error
+		if id, err = randomHex(8); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+	} else if !validUploadID(id) {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	now := time.Now().UTC()
+	p := &uploadProgress{ID: id, User: currentUser(r), State: uploadReceiving, Total: r.ContentLength, Started: now, rateTime: now}
+	uploadsMu.Lock()
+	pruneUploadJobs()
+	if old, ok := uploadJobs[id]; ok && (old.Finished == nil || old.User != p.User) {
+		uploadsMu.Unlock()
+		http.Error(w, "Upload ID already in use", http.StatusConflict)
+		return nil, false
+	}
+	uploadJobs[id] = p
+	uploadsMu.Unlock()
+
+	t := &uploadTracker{ResponseWriter: w, p: p, body: r.Body}
+	r.Body = t
+	w.Header().Set("X-Upload-ID", id)
+	return t, true
+}
+
+func (t *uploadTracker) Read(b []byte) (int, error) {
+	n, err := t.body.Read(b)
+	uploadsMu.Lock()
+	t.p.Received += int64(n)
+	if now := time.Now(); now.Sub(t.p.rateTime) >= time.Second {
+		t.p.Rate = float64(t.p.Received-t.p.rateBytes) / now.Sub(t.p.rateTime).Seconds()
+		t.p.rateBytes, t.p.rateTime = t.p.Received, now
+	}
+	if err == io.EOF && t.p.State == uploadReceiving {
+		t.p.State = uploadProcessing // 请求体已接收完，正在扫描、解压或保存
+	}
+	uploadsMu.Unlock()
+	return n, err
+}
+
+func (t *uploadTracker) Close() error {
+	return t.body.Close()
+}
+
+func (t *uploadTracker) WriteHeader(status int) {
+	if t.status == 0 {
+		t.status = status
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *uploadTracker) Write(b []byte) (int, error) {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	if t.status >= 400 && t.errMsg.Len() < 200 {
+		t.errMsg.Write(b[:min(len(b), 200-t.errMsg.Len())])
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// processing 标记请求体已接收完毕，上传进入扫描、解压或保存阶段
+func (t *uploadTracker) processing() {
+	uploadsMu.Lock()
+	t.p.State = uploadProcessing
+	uploadsMu.Unlock()
+}
+
+// setPath 记录上传保存到的虚拟路径
+func (t *uploadTracker) setPath(virtual string) {
+	uploadsMu.Lock()
+	t.p.Path = virtual
+	uploadsMu.Unlock()
+}
+
+// finish 根据响应状态结束上传：4xx 和 5xx 为失败，其余为完成
+func (t *uploadTracker) finish() {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	now := time.Now().UTC()
+	t.p.Finished = &now
+	if elapsed := now.Sub(t.p.Started).Seconds(); elapsed > 0 {
+		t.p.Rate = float64(t.p.Received) / elapsed
+	}
+	if t.status >= 400 {
+		t.p.State = uploadFailed
+		t.p.Error = strings.TrimSpace(t.errMsg.String())
+		if t.p.Error == "" {
+			t.p.Error = fmt.Sprintf("HTTP %d", t.status)
+		}
+		return
+	}
+	t.p.State = uploadDone
+}
+
+// pruneUploadJobs 清理一小时前已结束的上传，调用时需持有 uploadsMu
+func pruneUploadJobs() {
+	for id, p := range uploadJobs {
+		if p.Finished != nil && time.Since(*p.Finished) > time.Hour {
+			delete(uploadJobs, id)
+		}
+	}
+}
+
+// apiUploadProgressHandler 处理 GET /api/v1/upload/<id>/progress，返回上传的进度
+func apiUploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/upload/"), "/progress")
+	if !ok || !validUploadID(id) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	uploadsMu.Lock()
+	p, ok := uploadJobs[id]
+	var snapshot uploadProgress
+	if ok {
+		snapshot = *p
+	}
+	uploadsMu.Unlock()
+	if !ok || (!isAdmin(r) && snapshot.User != currentUser(r)) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, snapshot)
+}
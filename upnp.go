@@ -0,0 +1,144 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/huin/goupnp/dcps/internetgateway2"
+	"github.com/jackpal/gateway"
+	natpmp "github.com/jackpal/go-nat-pmp"
+)
+
+// enableUPnP 控制是否通过 UPnP 或 NAT-PMP 向路由器申请端口映射
+var enableUPnP bool
+
+// portMappingLease 是端口映射的租期，到期前会自动续期
+const portMappingLease = time.Hour
+
+// igdClient 是 UPnP 网关中各种 WAN 连接服务的共同方法
+type igdClient interface {
+	AddPortMapping(remoteHost string, externalPort uint16, protocol string, internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32) error
+	DeletePortMapping(remoteHost string, externalPort uint16, protocol string) error
+	GetExternalIPAddress() (string, error)
+	LocalAddr() net.IP
+}
+
+// portMapping 是已建立的端口映射
+type portMapping struct {
+	method     string
+	externalIP string
+	port       int
+	renew      func() error
+	remove     func() error
+}
+
+// startPortMapping 为 HTTP 端口申请映射并记录外部地址；映射会定期续期，收到退出信号时删除
+func startPortMapping(port int) {
+	pm, err := mapPortUPnP(port)
+	if err != nil {
+		logger.Printf("UPnP port mapping failed: %v; trying NAT-PMP", err)
+		pm, err = mapPortNATPMP(port)
+	}
+	if err != nil {
+		logger.Printf("Port mapping failed: %v", err)
+		return
+	}
+	logger.Printf("Port %d mapped via %s; reachable from the internet at http://%s:%d", port, pm.method, pm.externalIP, pm.port)
+
+	go func() {
+		ticker := time.NewTicker(portMappingLease / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := pm.renew(); err != nil {
+				logger.Printf("Renewing %s port mapping failed: %v", pm.method, err)
+			}
+		}
+	}()
+
+	onShutdown(func() {
+		if err := pm.remove(); err != nil {
+			logger.Printf("Removing %s port mapping failed: %v", pm.method, err)
+		} else {
+			logger.Printf("Removed %s port mapping for port %d", pm.method, pm.port)
+		}
+	})
+}
+
+// mapPortUPnP 通过 UPnP IGD 申请端口映射
+func mapPortUPnP(port int) (*portMapping, error) {
+	var clients []igdClient
+	if cs, _, err := internetgateway2.NewWANIPConnection2Clients(); err == nil {
+		for _, c := range cs {
+			clients = append(clients, c)
+		}
+	}
+	if cs, _, err := internetgateway2.NewWANIPConnection1Clients(); err == nil {
+		for _, c := range cs {
+			clients = append(clients, c)
+		}
+	}
+	if cs, _, err := internetgateway2.NewWANPPPConnection1Clients(); err == nil {
+		for _, c := range cs {
+			clients = append(clients, c)
+		}
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no UPnP gateway found")
+	}
+
+	var lastErr error
+	for _, c := range clients {
+		c := c
+		add := func() error {
+			return c.AddPortMapping("", uint16(port), "TCP", uint16(port), c.LocalAddr().String(), true, "fileserver", uint32(portMappingLease/time.Second))
+		}
+		if lastErr = add(); lastErr != nil {
+			continue
+		}
+		ip, err := c.GetExternalIPAddress()
+		if err != nil {
+			ip = "<external-ip>"
+		}
+		return &portMapping{
+			method:     "UPnP",
+			externalIP: ip,
+			port:       port,
+			renew:      add,
+			remove:     func() error { return c.DeletePortMapping("", uint16(port), "TCP") },
+		}, nil
+	}
+	return nil, lastErr
+}
+
+// mapPortNATPMP 通过默认网关的 NAT-PMP 申请端口映射
+func mapPortNATPMP(port int) (*portMapping, error) {
+	gw, err := gateway.DiscoverGateway()
+	if err != nil {
+		return nil, fmt.Errorf("default gateway: %w", err)
+	}
+	client := natpmp.NewClient(gw)
+	res, err := client.AddPortMapping("tcp", port, port, int(portMappingLease/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	external := int(res.MappedExternalPort)
+	ip := "<external-ip>"
+	if addr, err := client.GetExternalAddress(); err == nil {
+		ip = net.IP(addr.ExternalIPAddress[:]).String()
+	}
+	return &portMapping{
+		method:     "NAT-PMP",
+		externalIP: ip,
+		port:       external,
+		renew: func() error {
+			_, err := client.AddPortMapping("tcp", port, external, int(portMappingLease/time.Second))
+			return err
+		},
+		// 租期为 0 表示删除映射
+		remove: func() error {
+			_, err := client.AddPortMapping("tcp", port, 0, 0)
+			return err
+		},
+	}, nil
+}
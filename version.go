@@ -0,0 +1,41 @@
+package fileserver
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// 构建信息，通过 -ldflags "-X file-server.version=... -X file-server.commit=... -X file-server.buildDate=..." 注入
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// buildInfo 描述当前运行的构建
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func currentBuildInfo() buildInfo {
+	return buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// versionHandler 返回 JSON 格式的构建信息
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, currentBuildInfo())
+}
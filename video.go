@@ -0,0 +1,162 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ffmpegPath 由 -ffmpeg 设置，为命令名时在 PATH 中查找；找不到时不生成视频封面
+var ffmpegPath = "ffmpeg"
+
+// posterWidth 是视频封面的宽度，高度按比例缩放
+const posterWidth = 480
+
+// posterMaxAge 是封面缓存保留的时间，超过该时间未被访问的封面由 janitor 删除
+const posterMaxAge = 30 * 24 * time.Hour
+
+// posterTimeout 是生成一张封面的最长时间
+const posterTimeout = time.Minute
+
+// ffmpegSlots 限制同时运行的 ffmpeg 进程数，避免打开视频文件夹时同时解码大量视频
+var ffmpegSlots = make(chan struct{}, 2)
+
+// posterInflight 记录正在生成的封面，同一视频的并发请求等待同一次生成
+var (
+	posterMu       sync.Mutex
+	posterInflight = make(map[string]chan struct{})
+)
+
+// ffmpegBin 返回 ffmpeg 的路径，不可用时返回空
+func ffmpegBin() string {
+	if ffmpegPath == "" {
+		return ""
+	}
+	p, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// thumbCacheDir 返回封面缓存目录，位于服务目录的元数据目录中
+func thumbCacheDir() string {
+	return filepath.Join(uploadDir, metaDirName, "thumbs")
+}
+
+// posterCachePath 返回视频封面的缓存文件；文件大小或修改时间变化后使用新的缓存文件
+func posterCachePath(virtual string, info os.FileInfo) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", virtual, info.Size(), info.ModTime().UnixNano())))
+	return filepath.Join(thumbCacheDir(), hex.EncodeToString(h[:16])+".jpg")
+}
+
+// videoPoster 返回视频封面的缓存文件，不存在时用 ffmpeg 生成
+func videoPoster(src, cached string) error {
+	for {
+		if _, err := os.Stat(cached); err == nil {
+			return nil
+		}
+		posterMu.Lock()
+		done, busy := posterInflight[cached]
+		if !busy {
+			done = make(chan struct{})
+			posterInflight[cached] = done
+		}
+		posterMu.Unlock()
+		if busy {
+			<-done
+			continue
+		}
+		err := generatePoster(src, cached)
+		posterMu.Lock()
+		delete(posterInflight, cached)
+		posterMu.Unlock()
+		close(done)
+		return err
+	}
+}
+
+// generatePoster 用 ffmpeg 截取视频第 3 秒（较短的视频取第一帧）的画面，缩放后保存为 JPEG
+func generatePoster(src, dst string) error {
+	bin := ffmpegBin()
+	if bin == "" {
+		return fmt.Errorf("ffmpeg not found (set -ffmpeg)")
+	}
+	ffmpegSlots <- struct{}{}
+	defer func() { <-ffmpegSlots }()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "poster-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var out []byte
+	for _, seek := range []string{"3", "0"} {
+		ctx, cancel := context.WithTimeout(context.Background(), posterTimeout)
+		out, err = exec.CommandContext(ctx, bin, "-hide_banner", "-loglevel", "error", "-ss", seek, "-i", src,
+			"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", posterWidth), "-q:v", "4", "-f", "mjpeg", "-y", tmp.Name()).CombinedOutput()
+		cancel()
+		if info, serr := os.Stat(tmp.Name()); err == nil && serr == nil && info.Size() > 0 {
+			return os.Rename(tmp.Name(), dst)
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no frame extracted")
+	}
+	return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+}
+
+// thumbHandler 处理 GET /thumb?path=，返回视频的封面图片，需要 ffmpeg 且文件在本地磁盘上
+func thumbHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+	vol, name, err := resolveTarget(r, p)
+	if err == errNotFound {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+		return
+	}
+	info, err := vol.store.Stat(name)
+	if err != nil || info.IsDir() {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if !strings.HasPrefix(detectContentType(vol.store, name), "video/") {
+		http.Error(w, "Not a video", http.StatusBadRequest)
+		return
+	}
+	lp, ok := vol.store.(localPather)
+	if !ok || ffmpegBin() == "" {
+		http.Error(w, "Thumbnails are not available", http.StatusNotFound)
+		return
+	}
+	cached := posterCachePath(vol.virtual(name), info)
+	if err := videoPoster(lp.LocalPath(name), cached); err != nil {
+		logger.Printf("Error generating poster for %s: %v", vol.virtual(name), err)
+		http.Error(w, "Failed to generate thumbnail", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	os.Chtimes(cached, now, now) // 记录访问时间，供 janitor 判断封面是否仍在使用
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, cached)
+}
@@ -0,0 +1,204 @@
+//go:build linux
+
+package fileserver
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask 是每个被监视目录关注的 inotify 事件
+const watchMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// watchedDir 是一个 inotify 监视对应的卷内目录
+type watchedDir struct {
+	vol  volume
+	name string
+}
+
+// inotifyWatcher 使用 inotify 递归监视本地目录，新建的子目录会自动加入监视
+type inotifyWatcher struct {
+	fd   int
+	mu   sync.Mutex
+	dirs map[int]watchedDir
+}
+
+// startWatcher 开始监视各卷中的本地目录，变化记入 changes
+func startWatcher(vols []volume) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	w := &inotifyWatcher{fd: fd, dirs: make(map[int]watchedDir)}
+	for _, vol := range vols {
+		if _, ok := vol.store.(localPather); !ok {
+			continue
+		}
+		w.addTree(vol, "")
+	}
+	logger.Printf("Watching %d directories for changes", len(w.dirs))
+	go w.run()
+	return nil
+}
+
+// hidden 判断卷内路径是否不应出现在变化中：元数据目录和被挂载点遮盖的目录
+func (w *inotifyWatcher) hidden(vol volume, name string) bool {
+	first, _, _ := strings.Cut(name, "/")
+	if first == metaDirName {
+		return true
+	}
+	if vol.prefix == "" {
+		if _, shadowed := findMount(first); shadowed {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree 监视目录及其所有子目录
+func (w *inotifyWatcher) addTree(vol volume, name string) {
+	vol.store.Walk(name, func(p string, info fs.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if p != "" && w.hidden(vol, p) {
+			return fs.SkipDir
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, localPath(vol.store, p), watchMask)
+		if err != nil {
+			logger.Printf("Error watching %s: %v", vol.virtual(p), err)
+			return fs.SkipDir
+		}
+		w.mu.Lock()
+		w.dirs[wd] = watchedDir{vol: vol, name: p}
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// renameTree 在目录被移动后更新其下所有监视的路径
+func (w *inotifyWatcher) renameTree(vol volume, oldName, newName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, d := range w.dirs {
+		if d.vol.store != vol.store {
+			continue
+		}
+		if d.name == oldName || strings.HasPrefix(d.name, oldName+"/") {
+			w.dirs[wd] = watchedDir{vol: vol, name: newName + strings.TrimPrefix(d.name, oldName)}
+		}
+	}
+}
+
+// removeTree 停止监视移出监视范围的目录
+func (w *inotifyWatcher) removeTree(vol volume, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, d := range w.dirs {
+		if d.vol.store == vol.store && (d.name == name || strings.HasPrefix(d.name, name+"/")) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// movedFrom 是等待配对 IN_MOVED_TO 的移出事件
+type movedFrom struct {
+	cookie uint32
+	vol    volume
+	name   string
+	isDir  bool
+}
+
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			logger.Printf("File watcher stopped: %v", err)
+			return
+		}
+		var pending *movedFrom
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(raw.Len)]
+			off += unix.SizeofInotifyEvent + int(raw.Len)
+			base := string(bytes.TrimRight(nameBytes, "\x00"))
+
+			if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+				logger.Printf("File watcher queue overflowed, some changes were missed")
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[int(raw.Wd)]
+			if raw.Mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, int(raw.Wd))
+			}
+			w.mu.Unlock()
+			if !ok || base == "" {
+				continue
+			}
+			name := path.Join(dir.name, base)
+			if w.hidden(dir.vol, name) {
+				continue
+			}
+			isDir := raw.Mask&unix.IN_ISDIR != 0
+			virtual := dir.vol.virtual(name)
+
+			// 未配对的移出事件视为删除（文件被移出监视范围）
+			if pending != nil && (raw.Mask&unix.IN_MOVED_TO == 0 || raw.Cookie != pending.cookie) {
+				w.flushMovedFrom(pending)
+				pending = nil
+			}
+
+			switch {
+			case raw.Mask&unix.IN_CREATE != 0:
+				changes.add(changeCreate, virtual, "", isDir)
+				if isDir {
+					w.addTree(dir.vol, name)
+				}
+			case raw.Mask&unix.IN_CLOSE_WRITE != 0:
+				changes.add(changeModify, virtual, "", false)
+			case raw.Mask&unix.IN_DELETE != 0:
+				changes.add(changeDelete, virtual, "", isDir)
+			case raw.Mask&unix.IN_MOVED_FROM != 0:
+				pending = &movedFrom{cookie: raw.Cookie, vol: dir.vol, name: name, isDir: isDir}
+			case raw.Mask&unix.IN_MOVED_TO != 0:
+				if pending != nil && pending.vol.store == dir.vol.store {
+					changes.add(changeRename, virtual, pending.vol.virtual(pending.name), isDir)
+					if isDir {
+						w.renameTree(dir.vol, pending.name, name)
+					}
+				} else {
+					if pending != nil {
+						w.flushMovedFrom(pending)
+					}
+					changes.add(changeCreate, virtual, "", isDir)
+					if isDir {
+						w.addTree(dir.vol, name)
+					}
+				}
+				pending = nil
+			}
+		}
+		if pending != nil {
+			w.flushMovedFrom(pending)
+		}
+	}
+}
+
+func (w *inotifyWatcher) flushMovedFrom(m *movedFrom) {
+	changes.add(changeDelete, m.vol.virtual(m.name), "", m.isDir)
+	if m.isDir {
+		w.removeTree(m.vol, m.name)
+	}
+}
@@ -0,0 +1,72 @@
+//go:build !linux
+
+package fileserver
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// watchPollInterval 是没有 inotify 的平台上扫描目录树的间隔
+const watchPollInterval = 2 * time.Second
+
+// snapshotEntry 是扫描时记录的文件状态
+type snapshotEntry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// startWatcher 定期扫描各卷中的本地目录并比较前后两次的结果，变化记入 changes；
+// 这种方式无法识别重命名，重命名表现为删除加创建
+func startWatcher(vols []volume) error {
+	prev := snapshotVolumes(vols)
+	logger.Printf("Watching %d entries for changes (polling every %v)", len(prev), watchPollInterval)
+	go func() {
+		for range time.Tick(watchPollInterval) {
+			cur := snapshotVolumes(vols)
+			for p, e := range cur {
+				old, ok := prev[p]
+				switch {
+				case !ok:
+					changes.add(changeCreate, p, "", e.isDir)
+				case !e.isDir && (old.size != e.size || !old.modTime.Equal(e.modTime)):
+					changes.add(changeModify, p, "", false)
+				}
+			}
+			for p, e := range prev {
+				if _, ok := cur[p]; !ok {
+					changes.add(changeDelete, p, "", e.isDir)
+				}
+			}
+			prev = cur
+		}
+	}()
+	return nil
+}
+
+// snapshotVolumes 返回各卷中所有条目的状态，按虚拟路径索引
+func snapshotVolumes(vols []volume) map[string]snapshotEntry {
+	snap := make(map[string]snapshotEntry)
+	for _, vol := range vols {
+		if _, ok := vol.store.(localPather); !ok {
+			continue
+		}
+		vol.store.Walk("", func(name string, info fs.FileInfo, err error) error {
+			if err != nil || name == "" {
+				return nil
+			}
+			first, _, _ := strings.Cut(name, "/")
+			if _, shadowed := findMount(first); first == metaDirName || (shadowed && vol.prefix == "") {
+				if info.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			snap[vol.virtual(name)] = snapshotEntry{size: info.Size(), modTime: info.ModTime(), isDir: info.IsDir()}
+			return nil
+		})
+	}
+	return snap
+}
@@ -0,0 +1,334 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// enableWebDAV 控制是否在 /dav/ 提供 WebDAV 服务
+var enableWebDAV bool
+
+// davPrefix 是 WebDAV 服务的 URL 前缀
+const davPrefix = "/dav"
+
+// newDAVHandler 创建 WebDAV 处理器，文件访问经由存储接口，并遵循挂载点、只读和用户主目录规则
+func newDAVHandler() http.Handler {
+	h := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: davFS{},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logger.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// webdav.Handler 不处理 If-Match 等前提条件，覆盖和删除前在这里检查
+		if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			if vol, rel, err := resolveVirtual(r.Context(), strings.TrimPrefix(r.URL.Path, davPrefix)); err == nil && !checkPreconditions(w, r, vol, rel) {
+				return
+			}
+		}
+		// PUT 和 MKCOL 新建条目前检查条目数上限，以 507 拒绝
+		if r.Method == http.MethodPut || r.Method == "MKCOL" {
+			if vol, rel, err := resolveVirtual(r.Context(), strings.TrimPrefix(r.URL.Path, davPrefix)); err == nil && rel != "" {
+				if _, err := checkNewEntry(vol, rel, r.Method == http.MethodPut); err != nil {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+			}
+		}
+		// PUT 前按 Content-Length 检查剩余空间，避免写到一半磁盘满留下不完整的文件
+		if r.Method == http.MethodPut {
+			name := strings.TrimPrefix(r.URL.Path, davPrefix)
+			if vol, _, err := resolveVirtual(r.Context(), name); err == nil {
+				if err := checkFreeSpace(vol, declaredSize(r)); err != nil {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+			}
+			// webdav.Handler 在复制请求体失败后仍会关闭文件，davWriteFile 据此判断上传是否完整
+			body := watchAbort(r)
+			r = r.WithContext(context.WithValue(r.Context(), davBodyKey{}, body))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+var errCrossVolume = errors.New("cannot move between different mounts")
+
+// davFS 将虚拟根目录（默认卷加上各挂载点）适配为 webdav.FileSystem
+type davFS struct{}
+
+func (davFS) resolve(ctx context.Context, name string) (volume, string, error) {
+	return resolveVirtual(ctx, name)
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	vol, rel, err := d.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if vol.readOnly || rel == "" {
+		return os.ErrPermission
+	}
+	if _, err := vol.store.Stat(rel); err == nil {
+		return os.ErrExist
+	}
+	if _, err := vol.store.Stat(path.Dir(rel)); err != nil {
+		return err
+	}
+	if err := vol.store.Mkdir(rel); err != nil {
+		return err
+	}
+	countNewEntries(1)
+	return nil
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	vol, rel, err := d.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if vol.readOnly || rel == "" {
+			return nil, os.ErrPermission
+		}
+		info, err := vol.store.Stat(rel)
+		if err == nil && info.IsDir() {
+			return nil, os.ErrPermission
+		}
+		if err == nil && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		if err != nil && flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		created := err != nil
+		// 先写入同目录下的隐藏临时文件，关闭时再替换目标，上传中断时原有文件保持不变
+		tmp, err := stagingName(rel, "dav")
+		if err != nil {
+			return nil, err
+		}
+		w, err := vol.store.Create(tmp)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ctx.Value(davBodyKey{}).(*abortReader)
+		return &davWriteFile{vol: vol, name: rel, tmp: tmp, user: contextUser(ctx), w: w, hash: sha256.New(), body: body, created: created}, nil
+	}
+
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &davDir{ctx: ctx, vol: vol, name: rel, info: info, root: name == "" || cleanName(name) == ""}, nil
+	}
+	f, err := vol.store.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	return &davReadFile{storageFile: f}, nil
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	vol, rel, err := d.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if vol.readOnly || rel == "" {
+		return os.ErrPermission
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return err
+	}
+	if err := vol.store.Delete(rel); err != nil {
+		return err
+	}
+	logger.Printf("WebDAV deleted: %s", vol.virtual(rel))
+	ev := fileEvent{Event: eventDelete, Path: vol.virtual(rel), User: contextUser(ctx)}
+	if !info.IsDir() {
+		ev.Size = info.Size()
+	}
+	emitEvent(ev)
+	return nil
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldVol, oldRel, err := d.resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	newVol, newRel, err := d.resolve(ctx, newName)
+	if err != nil {
+		return err
+	}
+	if oldVol.readOnly || newVol.readOnly || oldRel == "" || newRel == "" {
+		return os.ErrPermission
+	}
+	if oldVol.prefix != newVol.prefix {
+		return errCrossVolume
+	}
+	return renameEntry(oldVol, oldRel, newRel)
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	vol, rel, err := d.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := vol.store.Stat(rel)
+	if err != nil {
+		return nil, err
+	}
+	return davInfo{info}, nil
+}
+
+// davInfo 让 WebDAV 返回与下载和 API 相同的 ETag，客户端可以在任一接口上使用 If-Match
+type davInfo struct {
+	fs.FileInfo
+}
+
+func (i davInfo) ETag(context.Context) (string, error) { return fileETag(i.FileInfo), nil }
+
+// davReadFile 是以只读方式打开的文件
+type davReadFile struct {
+	storageFile
+}
+
+func (f *davReadFile) Stat() (fs.FileInfo, error) {
+	info, err := f.storageFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return davInfo{info}, nil
+}
+
+func (f *davReadFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *davReadFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// davDir 是打开的目录；虚拟根目录会额外列出挂载点并隐藏元数据目录
+type davDir struct {
+	ctx     context.Context
+	vol     volume
+	name    string
+	info    fs.FileInfo
+	root    bool
+	entries []fs.FileInfo
+	loaded  bool
+}
+
+func (d *davDir) Close() error                   { return nil }
+func (d *davDir) Read(p []byte) (int, error)     { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)    { return 0, os.ErrPermission }
+func (d *davDir) Seek(int64, int) (int64, error) { return 0, nil }
+func (d *davDir) Stat() (fs.FileInfo, error)     { return d.info, nil }
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.loaded {
+		entries, err := listVirtual(d.ctx, d.vol, d.name, d.root)
+		if err != nil {
+			return nil, err
+		}
+		for i, info := range entries {
+			entries[i] = davInfo{info}
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+// davBodyKey 是 PUT 请求体的 abortReader 在 context 中的键
+type davBodyKey struct{}
+
+// davWriteFile 是以写入方式打开的文件，内容写入临时文件 tmp，关闭时执行与表单上传相同的扫描和钩子，通过后替换目标并发送通知
+type davWriteFile struct {
+	vol  volume
+	name string
+	tmp  string
+	user string
+	w    io.WriteCloser
+	hash hash.Hash
+	size int64
+	body *abortReader
+	// created 表示打开时目标不存在，写入会新建条目
+	created bool
+}
+
+func (f *davWriteFile) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *davWriteFile) Close() error {
+	err := f.w.Close()
+	if err == nil && f.body != nil && f.body.err != nil {
+		err = f.body.err
+	}
+	ev := fileEvent{
+		Event:    eventUpload,
+		Path:     f.vol.virtual(f.name),
+		Size:     f.size,
+		User:     f.user,
+		Checksum: hex.EncodeToString(f.hash.Sum(nil)),
+		Time:     time.Now().UTC(),
+	}
+	if err == nil {
+		err = commitStaged(f.vol.store, f.tmp, f.name, func(tmp string) error {
+			return acceptUpload(f.vol, tmp, ev)
+		})
+	} else {
+		f.vol.store.Delete(f.tmp)
+	}
+	if err != nil {
+		if f.body != nil && f.body.aborted() {
+			logger.Printf("WebDAV upload of %s aborted by client, partial file removed", f.vol.virtual(f.name))
+		}
+		return err
+	}
+	logger.Printf("WebDAV upload saved: %s", ev.Path)
+	completeUpload(f.vol, f.name, ev, f.created)
+	return nil
+}
+
+func (f *davWriteFile) Read(p []byte) (int, error)         { return 0, os.ErrInvalid }
+func (f *davWriteFile) Seek(int64, int) (int64, error)     { return 0, os.ErrInvalid }
+func (f *davWriteFile) Readdir(int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *davWriteFile) Stat() (fs.FileInfo, error)         { return f.vol.store.Stat(f.tmp) }
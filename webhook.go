@@ -0,0 +1,120 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 文件事件类型
+const (
+	eventUpload = "upload"
+	eventDelete = "delete"
+	// 创建和撤销分享链接
+	eventShare   = "share"
+	eventUnshare = "unshare"
+	// 完整性检查发现的问题：内容与记录的校验和不符而大小和修改时间未变，或文件在服务器之外被修改
+	eventCorrupted = "corrupted"
+	eventModified  = "modified"
+)
+
+// webhook 配置，通过命令行参数设置
+var (
+	webhookURLs    stringList
+	webhookSecret  string
+	webhookRetries int
+	webhookTimeout time.Duration
+)
+
+// fileEvent 是发送给 webhook 的 JSON 负载
+type fileEvent struct {
+	Event    string `json:"event"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	User     string `json:"user,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	// Share 是分享事件对应的短代码
+	Share string    `json:"share,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// emitEvent 异步地将事件推送到所有已配置的 webhook 和通知器
+func emitEvent(ev fileEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	// 删除的文件和没有算出校验和的写入（如复制）使原有的校验和记录失效
+	if (ev.Event == eventDelete || (ev.Event == eventUpload && ev.Checksum == "")) && checksums != nil {
+		checksums.forget(ev.Path)
+	}
+	if ev.Event == eventDelete && tags != nil {
+		tags.forget(ev.Path)
+		favorites.forget(ev.Path)
+		locks.forget(ev.Path)
+	}
+	recordUploadEvent(ev)
+	recordAudit(ev)
+	dispatchNotifications(ev)
+	if len(webhookURLs) == 0 {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		logger.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+	for _, u := range webhookURLs {
+		go deliverWebhook(u, body)
+	}
+}
+
+// deliverWebhook 发送单个 webhook，失败时按指数退避重试
+func deliverWebhook(target string, body []byte) {
+	client := &http.Client{Timeout: webhookTimeout}
+	backoff := time.Second
+	for attempt := 0; attempt <= webhookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err := postWebhook(client, target, body)
+		if err == nil {
+			return
+		}
+		logger.Printf("Webhook %s attempt %d failed: %v", target, attempt+1, err)
+	}
+	logger.Printf("Giving up on webhook %s", target)
+}
+
+func postWebhook(client *http.Client, target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fileserver/"+version)
+	if webhookSecret != "" {
+		req.Header.Set("X-Fileserver-Signature", "sha256="+signPayload(webhookSecret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// signPayload 计算负载的 HMAC-SHA256 签名（十六进制）
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
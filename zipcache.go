@@ -0,0 +1,154 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zipCacheSize 是目录 ZIP 缓存的总大小上限，0 表示不缓存
+var zipCacheSize byteSize
+
+// zipCacheMu 串行化缓存的提交和淘汰
+var zipCacheMu sync.Mutex
+
+// zipCacheDir 返回缓存目录，位于服务目录的元数据目录中
+func zipCacheDir() string {
+	return filepath.Join(uploadDir, metaDirName, "zipcache")
+}
+
+// zipCacheEntry 是一次目录下载对应的缓存项：命中时 file 为已缓存的压缩包，
+// 未命中时 tmp 为正在写入的临时文件，下载完成后提交为缓存
+type zipCacheEntry struct {
+	key     string
+	path    string
+	file    *os.File
+	modTime time.Time
+	tmp     *os.File
+}
+
+// treeFingerprint 根据目录中所有条目的路径、大小和修改时间计算指纹，内容变化后指纹随之改变
+func treeFingerprint(store storage, root string) (string, error) {
+	h := sha256.New()
+	err := store.Walk(root, func(name string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == metaDirName && info.IsDir() {
+			return fs.SkipDir
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%t\n", name, info.Size(), info.ModTime().UnixNano(), info.IsDir())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openZipCache 查找目录压缩包的缓存；未命中时创建用于写入新缓存的临时文件
+func openZipCache(vol volume, name string, comp zipCompression) (*zipCacheEntry, error) {
+	fp, err := treeFingerprint(vol.store, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(vol.virtual(name) + "\x00" + string(comp) + "\x00" + fp))
+	e := &zipCacheEntry{key: hex.EncodeToString(sum[:16])}
+	e.path = filepath.Join(zipCacheDir(), e.key+".zip")
+
+	if f, err := os.Open(e.path); err == nil {
+		info, err := f.Stat()
+		if err == nil {
+			// 更新修改时间，淘汰时按最近使用排序
+			now := time.Now()
+			os.Chtimes(e.path, now, now)
+			e.file, e.modTime = f, info.ModTime()
+			return e, nil
+		}
+		f.Close()
+	}
+
+	if err := os.MkdirAll(zipCacheDir(), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(zipCacheDir(), e.key+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	e.tmp = tmp
+	return e, nil
+}
+
+// abort 丢弃未完成的缓存文件
+func (e *zipCacheEntry) abort() {
+	if e == nil || e.tmp == nil {
+		return
+	}
+	e.tmp.Close()
+	os.Remove(e.tmp.Name())
+}
+
+// commit 将完整写入的压缩包保存为缓存，并淘汰最久未使用的缓存使总大小不超过上限
+func (e *zipCacheEntry) commit() {
+	if e == nil || e.tmp == nil {
+		return
+	}
+	info, err := e.tmp.Stat()
+	if cerr := e.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || info.Size() > int64(zipCacheSize) {
+		os.Remove(e.tmp.Name())
+		return
+	}
+	zipCacheMu.Lock()
+	defer zipCacheMu.Unlock()
+	if err := os.Rename(e.tmp.Name(), e.path); err != nil {
+		logger.Printf("Error saving ZIP cache: %v", err)
+		os.Remove(e.tmp.Name())
+		return
+	}
+	evictZipCache()
+}
+
+// evictZipCache 删除最久未使用的缓存文件，调用时需持有 zipCacheMu
+func evictZipCache() {
+	entries, err := os.ReadDir(zipCacheDir())
+	if err != nil {
+		return
+	}
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, de := range entries {
+		if !strings.HasSuffix(de.Name(), ".zip") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{filepath.Join(zipCacheDir(), de.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= int64(zipCacheSize) {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"hash"
+	"io"
+	"math"
+	"net/http"
+)
+
+// zipMethodAES 是 WinZip AES 加密条目的压缩方法号，实际的压缩方法记录在 0x9901 扩展字段中
+const zipMethodAES = 99
+
+// zipPassword 返回请求为 ZIP 下载指定的密码：请求头 X-Zip-Password 或 POST 表单字段 password。
+// 不从 URL 查询参数读取，以免密码出现在访问日志和浏览器历史中
+func zipPassword(r *http.Request) string {
+	if p := r.Header.Get("X-Zip-Password"); p != "" {
+		return p
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("password")
+	}
+	return ""
+}
+
+// writeEncrypted 以 WinZip AES-256（AE-2 格式）写入文件条目。每个条目用随机盐从密码派生密钥；
+// AE-2 不保存明文的 CRC32，完整性由 HMAC-SHA1 校验
+func (zw *zipWriter) writeEncrypted(store storage, e *zipEntry) error {
+	h := e.header
+	method := h.Method
+	h.Method = zipMethodAES
+	h.Flags |= 0x1 | 0x8 // 加密，大小写在数据描述符中
+	h.CRC32 = 0
+	setRawHeaderFields(h)
+	h.Extra = append(h.Extra, 0x01, 0x99, 7, 0, 2, 0, 'A', 'E', 3, byte(method), byte(method>>8))
+	w, err := zw.CreateRaw(h)
+	if err != nil {
+		return err
+	}
+	aw, err := newAESZipWriter(w, zw.password)
+	if err != nil {
+		return err
+	}
+
+	size := int64(h.UncompressedSize64)
+	if e.data != nil {
+		_, err = aw.Write(e.data)
+	} else {
+		f, oerr := store.Open(e.name)
+		if oerr != nil {
+			return oerr
+		}
+		defer f.Close()
+		var dst io.Writer = aw
+		var fw *flate.Writer
+		if method == zip.Deflate {
+			if fw, err = flate.NewWriter(aw, zw.level); err != nil {
+				return err
+			}
+			dst = fw
+		}
+		size, err = io.CopyBuffer(dst, f, make([]byte, 32*1024))
+		if fw != nil && err == nil {
+			err = fw.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	h.CompressedSize64, h.UncompressedSize64 = uint64(aw.n), uint64(size)
+	h.CompressedSize = uint32(min(h.CompressedSize64, math.MaxUint32))
+	h.UncompressedSize = uint32(min(h.UncompressedSize64, math.MaxUint32))
+	return zw.Flush()
+}
+
+// aesZipWriter 按 WinZip AES 格式加密条目数据：先写盐和密码校验值，
+// 数据以 AES-CTR（小端计数器，从 1 开始）加密，Close 时写入 10 字节 HMAC-SHA1
+type aesZipWriter struct {
+	w       io.Writer
+	block   cipher.Block
+	mac     hash.Hash
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+	n       int64
+}
+
+func newAESZipWriter(w io.Writer, password string) (*aesZipWriter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	keys, err := pbkdf2.Key(sha1.New, password, salt, 1000, 2*32+2)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	a := &aesZipWriter{w: w, block: block, mac: hmac.New(sha1.New, keys[32:64]), used: aes.BlockSize}
+	n, err := w.Write(append(salt, keys[64:]...))
+	a.n = int64(n)
+	return a, err
+}
+
+func (a *aesZipWriter) Write(p []byte) (int, error) {
+	out := make([]byte, len(p))
+	for i, b := range p {
+		if a.used == aes.BlockSize {
+			for j := range a.counter {
+				a.counter[j]++
+				if a.counter[j] != 0 {
+					break
+				}
+			}
+			a.block.Encrypt(a.stream[:], a.counter[:])
+			a.used = 0
+		}
+		out[i] = b ^ a.stream[a.used]
+		a.used++
+	}
+	a.mac.Write(out)
+	n, err := a.w.Write(out)
+	a.n += int64(n)
+	return n, err
+}
+
+func (a *aesZipWriter) Close() error {
+	n, err := a.w.Write(a.mac.Sum(nil)[:10])
+	a.n += int64(n)
+	return err
+}